@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
@@ -13,12 +14,13 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"path"
 	"path/filepath"
 	"runtime"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/armon/circbuf"
 	"github.com/gliderlabs/ssh"
@@ -28,6 +30,7 @@ import (
 	gossh "golang.org/x/crypto/ssh"
 	"golang.org/x/xerrors"
 	"inet.af/netaddr"
+	"nhooyr.io/websocket"
 	"tailscale.com/types/key"
 
 	"cdr.dev/slog"
@@ -43,11 +46,104 @@ const (
 	ProtocolReconnectingPTY = "reconnecting-pty"
 	ProtocolSSH             = "ssh"
 	ProtocolDial            = "dial"
+	ProtocolExec            = "exec"
+	ProtocolCapabilities    = "capabilities"
+	ProtocolProcessTree     = "process-tree"
+	ProtocolSpeedtest       = "speedtest"
+	ProtocolListeningPorts  = "listening-ports"
+
+	// Operations are what clients can do over a connection. Most are named
+	// after the protocol that serves them.
+	OperationPing            = "ping"
+	OperationCapabilities    = "capabilities"
+	OperationSSH             = "ssh"
+	OperationCopy            = "copy"
+	OperationReconnectingPTY = "reconnecting-pty"
+	OperationDial            = "dial"
+	OperationExec            = "exec"
+	OperationProcessTree     = "process-tree"
+	OperationSpeedtest       = "speedtest"
+	OperationListeningPorts  = "listening-ports"
 
 	// MagicSessionErrorCode indicates that something went wrong with the session, rather than the
 	// command just returning a nonzero exit code, and is chosen as an arbitrary, high number
 	// unlikely to shadow other exit codes, which are typically 1, 2, 3, etc.
 	MagicSessionErrorCode = 229
+
+	// ReconnectStatus is the websocket status coderd closes the agent's
+	// connection with to ask it to reconnect, usually because the replica
+	// it's connected to is shutting down. The close reason is how long the
+	// agent should wait first, formatted as a time.Duration.
+	ReconnectStatus = websocket.StatusServiceRestart
+)
+
+// ReconnectDelay returns how long coderd asked the agent to wait before
+// reconnecting, if err is from the connection being closed with
+// ReconnectStatus.
+func ReconnectDelay(err error) (time.Duration, bool) {
+	var closeErr websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != ReconnectStatus {
+		return 0, false
+	}
+	delay, err := time.ParseDuration(closeErr.Reason)
+	if err != nil || delay < 0 {
+		return 0, true
+	}
+	return delay, true
+}
+
+// ReconnectingPTYLimitPolicy decides what happens to a new reconnecting PTY
+// session when the agent is already at Options.ReconnectingPTYLimit.
+type ReconnectingPTYLimitPolicy string
+
+const (
+	// ReconnectingPTYLimitReject refuses new sessions over the limit.
+	ReconnectingPTYLimitReject ReconnectingPTYLimitPolicy = "reject"
+	// ReconnectingPTYLimitEvictOldestIdle closes the session that has been
+	// idle for the longest to make room for the new one.
+	ReconnectingPTYLimitEvictOldestIdle ReconnectingPTYLimitPolicy = "evict-oldest-idle"
+)
+
+// SSHCipherPolicy restricts the algorithms the agent SSH server negotiates.
+type SSHCipherPolicy string
+
+const (
+	// SSHCipherPolicyDefault allows every algorithm enabled by default in
+	// golang.org/x/crypto/ssh, favoring throughput.
+	SSHCipherPolicyDefault SSHCipherPolicy = "default"
+	// SSHCipherPolicySecure only allows AEAD ciphers, SHA-2 MACs, and key
+	// exchanges that don't use SHA-1, at some cost to throughput.
+	SSHCipherPolicySecure SSHCipherPolicy = "secure"
+)
+
+var (
+	secureSSHCiphers = []string{
+		"aes128-gcm@openssh.com",
+		"chacha20-poly1305@openssh.com",
+	}
+	secureSSHMACs = []string{
+		"hmac-sha2-256-etm@openssh.com",
+		"hmac-sha2-256",
+	}
+	secureSSHKeyExchanges = []string{
+		"curve25519-sha256",
+		"curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256",
+		"ecdh-sha2-nistp384",
+		"ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256",
+	}
+)
+
+// StartupPhase is a step of agent startup. Phases are reported to coderd
+// as they begin so users can see how long each one took.
+type StartupPhase string
+
+const (
+	// StartupPhaseStartupScript is the first phase of a startup. Reporting
+	// it clears the phases of the agent's previous startup.
+	StartupPhaseStartupScript StartupPhase = "running_startup_script"
+	StartupPhaseReady         StartupPhase = "ready"
 )
 
 type Options struct {
@@ -57,6 +153,35 @@ type Options struct {
 	ReconnectingPTYTimeout time.Duration
 	EnvironmentVariables   map[string]string
 	Logger                 slog.Logger
+
+	// ReconnectingPTYLimit is the maximum number of reconnecting PTY
+	// sessions in the workspace. Zero means unlimited.
+	ReconnectingPTYLimit int
+	// ReconnectingPTYLimitPolicy is applied when ReconnectingPTYLimit is
+	// reached. Defaults to ReconnectingPTYLimitReject.
+	ReconnectingPTYLimitPolicy ReconnectingPTYLimitPolicy
+	// ReconnectingPTYReplayLimit caps how many bytes of recent output are
+	// replayed when a client reconnects to a PTY, whatever the client asks
+	// for. Defaults to the full scrollback.
+	ReconnectingPTYReplayLimit int
+	// SSHCipherPolicy defaults to SSHCipherPolicyDefault.
+	SSHCipherPolicy SSHCipherPolicy
+	// PostStartupPhase is optional and is called as each startup phase
+	// begins.
+	PostStartupPhase PostStartupPhase
+	// PostDirectoryExists is optional and is called with whether the
+	// directory in Metadata exists once the agent connects.
+	PostDirectoryExists PostDirectoryExists
+	// PostNetworkReport is optional and is called with what the STUN
+	// servers in Metadata observe, once the agent connects and then every
+	// NetworkReportInterval.
+	PostNetworkReport     PostNetworkReport
+	NetworkReportInterval time.Duration
+	// PostDiagnostics is optional and is called with the process uptime,
+	// clock status and network interfaces once the agent connects and then
+	// every DiagnosticsInterval.
+	PostDiagnostics     PostDiagnostics
+	DiagnosticsInterval time.Duration
 }
 
 type Metadata struct {
@@ -64,6 +189,26 @@ type Metadata struct {
 	EnvironmentVariables map[string]string  `json:"environment_variables"`
 	StartupScript        string             `json:"startup_script"`
 	Directory            string             `json:"directory"`
+	// ShellPrompt is the default PS1 of shells. It's overridden by the
+	// environment of a session and the user's shell configuration.
+	ShellPrompt string `json:"shell_prompt,omitempty"`
+	// STUNServers are "host:port" addresses the agent probes to report
+	// its public IP and NAT type.
+	STUNServers []string `json:"stun_servers,omitempty"`
+	// PTYEnvironmentDenylist are environment variables removed from
+	// reconnecting PTY sessions, such as secrets that shouldn't be visible
+	// in interactive shells. Startup scripts still receive them. Entries
+	// are path.Match patterns, like "AWS_*".
+	PTYEnvironmentDenylist []string `json:"pty_environment_denylist,omitempty"`
+	// PTYEnvironmentAllowlist restricts reconnecting PTY sessions to the
+	// environment variables matching it, when set.
+	PTYEnvironmentAllowlist []string `json:"pty_environment_allowlist,omitempty"`
+	// DisabledProtocols are channel protocols the agent refuses to serve,
+	// like ProtocolReconnectingPTY to turn off the web terminal.
+	DisabledProtocols []string `json:"disabled_protocols,omitempty"`
+	// RedactProcessArguments omits the arguments of processes in the
+	// process trees of reconnecting PTY sessions, leaving the executable.
+	RedactProcessArguments bool `json:"redact_process_arguments,omitempty"`
 }
 
 type WireguardPublicKeys struct {
@@ -72,6 +217,10 @@ type WireguardPublicKeys struct {
 }
 
 type Dialer func(ctx context.Context, logger slog.Logger) (Metadata, *peerbroker.Listener, error)
+type PostStartupPhase func(ctx context.Context, phase StartupPhase) error
+type PostDirectoryExists func(ctx context.Context, exists bool) error
+type PostNetworkReport func(ctx context.Context, report NetworkReport) error
+type PostDiagnostics func(ctx context.Context, diagnostics Diagnostics) error
 type UploadWireguardKeys func(ctx context.Context, keys WireguardPublicKeys) error
 type ListenWireguardPeers func(ctx context.Context, logger slog.Logger) (<-chan peerwg.Handshake, func(), error)
 
@@ -82,6 +231,21 @@ func New(dialer Dialer, options *Options) io.Closer {
 	if options.ReconnectingPTYTimeout == 0 {
 		options.ReconnectingPTYTimeout = 5 * time.Minute
 	}
+	if options.ReconnectingPTYLimitPolicy == "" {
+		options.ReconnectingPTYLimitPolicy = ReconnectingPTYLimitReject
+	}
+	if options.ReconnectingPTYReplayLimit <= 0 || options.ReconnectingPTYReplayLimit > reconnectingPTYBufferSize {
+		options.ReconnectingPTYReplayLimit = reconnectingPTYBufferSize
+	}
+	if options.SSHCipherPolicy == "" {
+		options.SSHCipherPolicy = SSHCipherPolicyDefault
+	}
+	if options.NetworkReportInterval == 0 {
+		options.NetworkReportInterval = 10 * time.Minute
+	}
+	if options.DiagnosticsInterval == 0 {
+		options.DiagnosticsInterval = 10 * time.Minute
+	}
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	server := &agent{
 		dialer:                 dialer,
@@ -93,6 +257,17 @@ func New(dialer Dialer, options *Options) io.Closer {
 		enableWireguard:        options.EnableWireguard,
 		postKeys:               options.UploadWireguardKeys,
 		listenWireguardPeers:   options.ListenWireguardPeers,
+
+		reconnectingPTYLimit:       options.ReconnectingPTYLimit,
+		reconnectingPTYLimitPolicy: options.ReconnectingPTYLimitPolicy,
+		reconnectingPTYReplayLimit: options.ReconnectingPTYReplayLimit,
+		sshCipherPolicy:            options.SSHCipherPolicy,
+		postStartupPhase:           options.PostStartupPhase,
+		postDirectoryExists:        options.PostDirectoryExists,
+		postNetworkReport:          options.PostNetworkReport,
+		networkReportInterval:      options.NetworkReportInterval,
+		postDiagnostics:            options.PostDiagnostics,
+		diagnosticsInterval:        options.DiagnosticsInterval,
 	}
 	server.init(ctx)
 	return server
@@ -104,6 +279,12 @@ type agent struct {
 
 	reconnectingPTYs       sync.Map
 	reconnectingPTYTimeout time.Duration
+	// reconnectingPTYMutex serializes the creation of reconnecting PTYs
+	// so the session limit can't be exceeded by concurrent requests.
+	reconnectingPTYMutex       sync.Mutex
+	reconnectingPTYLimit       int
+	reconnectingPTYLimitPolicy ReconnectingPTYLimitPolicy
+	reconnectingPTYReplayLimit int
 
 	connCloseWait sync.WaitGroup
 	closeCancel   context.CancelFunc
@@ -115,6 +296,15 @@ type agent struct {
 	metadata      atomic.Value
 	startupScript atomic.Bool
 	sshServer     *ssh.Server
+	// sshCipherPolicy restricts the algorithms sshServer negotiates.
+	sshCipherPolicy SSHCipherPolicy
+
+	postStartupPhase      PostStartupPhase
+	postDirectoryExists   PostDirectoryExists
+	postNetworkReport     PostNetworkReport
+	networkReportInterval time.Duration
+	postDiagnostics       PostDiagnostics
+	diagnosticsInterval   time.Duration
 
 	enableWireguard      bool
 	network              *peerwg.Network
@@ -152,8 +342,12 @@ func (a *agent) run(ctx context.Context) {
 	a.metadata.Store(metadata)
 
 	if a.startupScript.CAS(false, true) {
+		go a.reportNetwork(ctx)
+		go a.reportDiagnostics(ctx)
 		// The startup script has not ran yet!
 		go func() {
+			a.reportDirectoryExists(ctx, metadata.Directory)
+			a.reportStartupPhase(ctx, StartupPhaseStartupScript)
 			err := a.runStartupScript(ctx, metadata.StartupScript)
 			if errors.Is(err, context.Canceled) {
 				return
@@ -161,6 +355,7 @@ func (a *agent) run(ctx context.Context) {
 			if err != nil {
 				a.logger.Warn(ctx, "agent script failed", slog.Error(err))
 			}
+			a.reportStartupPhase(ctx, StartupPhaseReady)
 		}()
 	}
 
@@ -177,7 +372,19 @@ func (a *agent) run(ctx context.Context) {
 			if a.isClosed() {
 				return
 			}
-			a.logger.Debug(ctx, "peer listener accept exited; restarting connection", slog.Error(err))
+			if delay, ok := ReconnectDelay(err); ok {
+				// Waiting lets the replica that asked stop receiving
+				// connections, so the agent isn't refused and left backing
+				// off.
+				a.logger.Info(ctx, "coderd asked the agent to reconnect", slog.F("delay", delay))
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+			} else {
+				a.logger.Debug(ctx, "peer listener accept exited; restarting connection", slog.Error(err))
+			}
 			a.run(ctx)
 			return
 		}
@@ -188,6 +395,98 @@ func (a *agent) run(ctx context.Context) {
 	}
 }
 
+// reportStartupPhase posts a startup phase to coderd. Failures are only
+// logged, because the phases are informational.
+func (a *agent) reportStartupPhase(ctx context.Context, phase StartupPhase) {
+	if a.postStartupPhase == nil {
+		return
+	}
+	err := a.postStartupPhase(ctx, phase)
+	if err != nil {
+		a.logger.Warn(ctx, "post startup phase", slog.F("phase", phase), slog.Error(err))
+	}
+}
+
+// reportDirectoryExists tells coderd whether the directory sessions
+// start in exists, because shells fail confusingly when it doesn't.
+func (a *agent) reportDirectoryExists(ctx context.Context, directory string) {
+	if a.postDirectoryExists == nil || directory == "" {
+		return
+	}
+	info, err := os.Stat(directory)
+	exists := err == nil && info.IsDir()
+	if !exists {
+		a.logger.Warn(ctx, "agent directory does not exist", slog.F("directory", directory), slog.Error(err))
+	}
+	err = a.postDirectoryExists(ctx, exists)
+	if err != nil {
+		a.logger.Warn(ctx, "post directory exists", slog.Error(err))
+	}
+}
+
+// reportNetwork tells coderd the public IP and NAT type the agent's STUN
+// servers observe, which explains why connections relay. Both can change,
+// so the report is refreshed periodically.
+func (a *agent) reportNetwork(ctx context.Context) {
+	if a.postNetworkReport == nil {
+		return
+	}
+	ticker := time.NewTicker(a.networkReportInterval)
+	defer ticker.Stop()
+	for {
+		// Metadata is loaded each time, since it's replaced on reconnect.
+		metadata, ok := a.metadata.Load().(Metadata)
+		if ok && len(metadata.STUNServers) > 0 {
+			report, err := probeNetwork(ctx, metadata.STUNServers)
+			if err != nil {
+				a.logger.Warn(ctx, "probe network", slog.Error(err))
+			} else {
+				err = a.postNetworkReport(ctx, report)
+				if err != nil {
+					a.logger.Warn(ctx, "post network report", slog.Error(err))
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reportDiagnostics tells coderd how long the agent process has been
+// running, whether its clock is synchronized and its network interfaces.
+// Reconnecting doesn't reset the uptime, unlike the connection timestamps
+// coderd records.
+func (a *agent) reportDiagnostics(ctx context.Context) {
+	if a.postDiagnostics == nil {
+		return
+	}
+	ticker := time.NewTicker(a.diagnosticsInterval)
+	defer ticker.Stop()
+	for {
+		interfaces, err := networkInterfaces()
+		if err != nil {
+			// The rest of the diagnostics are still worth reporting.
+			a.logger.Warn(ctx, "list network interfaces", slog.Error(err))
+		}
+		err = a.postDiagnostics(ctx, Diagnostics{
+			Uptime:     time.Since(processStartedAt),
+			ClockSync:  clockSync(),
+			Interfaces: interfaces,
+		})
+		if err != nil {
+			a.logger.Warn(ctx, "post diagnostics", slog.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func (a *agent) runStartupScript(ctx context.Context, script string) error {
 	if script == "" {
 		return nil
@@ -239,14 +538,24 @@ func (a *agent) handlePeerConn(ctx context.Context, conn *peer.Conn) {
 			return
 		}
 
-		switch channel.Protocol() {
-		case ProtocolSSH:
-			go a.sshServer.HandleConn(channel.NetConn())
-		case ProtocolReconnectingPTY:
-			go a.handleReconnectingPTY(ctx, channel.Label(), channel.NetConn())
-		case ProtocolDial:
-			go a.handleDial(ctx, channel.Label(), channel.NetConn())
-		default:
+		if a.protocolDisabled(channel.Protocol()) {
+			go a.rejectChannel(ctx, channel.Protocol(), channel.Label(), channel.NetConn())
+			continue
+		}
+
+		if channel.Protocol() == ProtocolCapabilities {
+			go a.handleCapabilities(ctx, channel.NetConn())
+			continue
+		}
+		handled := false
+		for _, p := range protocols {
+			if p.protocol == channel.Protocol() {
+				go p.handle(a, ctx, channel.Label(), channel.NetConn())
+				handled = true
+				break
+			}
+		}
+		if !handled {
 			a.logger.Warn(ctx, "unhandled protocol from channel",
 				slog.F("protocol", channel.Protocol()),
 				slog.F("label", channel.Label()),
@@ -255,6 +564,79 @@ func (a *agent) handlePeerConn(ctx context.Context, conn *peer.Conn) {
 	}
 }
 
+// protocols are the channel protocols agents serve besides capabilities,
+// with their handlers and the operations they enable. Protocols is derived
+// from it.
+var protocols = []struct {
+	protocol   string
+	operations []string
+	handle     func(a *agent, ctx context.Context, label string, conn net.Conn)
+}{
+	{ProtocolSSH, []string{OperationSSH, OperationCopy}, (*agent).handleSSH},
+	{ProtocolReconnectingPTY, []string{OperationReconnectingPTY}, (*agent).handleReconnectingPTY},
+	{ProtocolDial, []string{OperationDial}, (*agent).handleDial},
+	{ProtocolExec, []string{OperationExec}, (*agent).handleExec},
+	{ProtocolProcessTree, []string{OperationProcessTree}, (*agent).handleProcessTree},
+	{ProtocolSpeedtest, []string{OperationSpeedtest}, (*agent).handleSpeedtest},
+	{ProtocolListeningPorts, []string{OperationListeningPorts}, (*agent).handleListeningPorts},
+}
+
+// Protocols are the channel protocols that can be disabled in the metadata.
+var Protocols = func() []string {
+	names := make([]string, 0, len(protocols))
+	for _, p := range protocols {
+		names = append(names, p.protocol)
+	}
+	return names
+}()
+
+func (a *agent) handleSSH(_ context.Context, label string, conn net.Conn) {
+	a.sshServer.HandleConn(&sshConn{
+		Conn:      conn,
+		sessionID: parseSSHLabel(label),
+	})
+}
+
+// protocolDisabled returns whether the metadata disables the protocol.
+func (a *agent) protocolDisabled(protocol string) bool {
+	metadata, ok := a.metadata.Load().(Metadata)
+	if !ok {
+		return false
+	}
+	for _, disabled := range metadata.DisabledProtocols {
+		if disabled == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectChannel closes a channel for a disabled protocol. Dial and exec
+// channels get the error as their response so clients can surface it.
+func (a *agent) rejectChannel(ctx context.Context, protocol, label string, conn net.Conn) {
+	defer conn.Close()
+	rejectErr := xerrors.Errorf("protocol %q is disabled", protocol)
+	a.logger.Warn(ctx, "reject channel", slog.F("protocol", protocol), slog.F("label", label), slog.Error(rejectErr))
+	switch protocol {
+	case ProtocolDial:
+		b, err := json.Marshal(dialResponse{
+			Error: rejectErr.Error(),
+		})
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write(b)
+	case ProtocolExec:
+		b, err := json.Marshal(execExit{
+			Error: rejectErr.Error(),
+		})
+		if err != nil {
+			return
+		}
+		_ = writeExecFrame(conn, execFrameExit, b)
+	}
+}
+
 func (a *agent) init(ctx context.Context) {
 	a.logger.Info(ctx, "generating host key")
 	// Clients' should ignore the host key when connecting.
@@ -275,19 +657,27 @@ func (a *agent) init(ctx context.Context) {
 			"direct-tcpip": ssh.DirectTCPIPHandler,
 			"session":      ssh.DefaultSessionHandler,
 		},
+		ConnCallback: func(ctx ssh.Context, conn net.Conn) net.Conn {
+			if conn, ok := conn.(*sshConn); ok && conn.sessionID != "" {
+				ctx.SetValue(sshSessionIDKey{}, conn.sessionID)
+			}
+			return conn
+		},
 		ConnectionFailedCallback: func(conn net.Conn, err error) {
 			sshLogger.Info(ctx, "ssh connection ended", slog.Error(err))
 		},
 		Handler: func(session ssh.Session) {
+			logger := a.sshSessionLogger(session.Context())
+			logger.Debug(ctx, "ssh session started")
 			err := a.handleSSHSession(session)
 			var exitError *exec.ExitError
 			if xerrors.As(err, &exitError) {
-				a.logger.Debug(ctx, "ssh session returned", slog.Error(exitError))
+				logger.Debug(ctx, "ssh session returned", slog.Error(exitError))
 				_ = session.Exit(exitError.ExitCode())
 				return
 			}
 			if err != nil {
-				a.logger.Warn(ctx, "ssh session failed", slog.Error(err))
+				logger.Warn(ctx, "ssh session failed", slog.Error(err))
 				// This exit code is designed to be unlikely to be confused for a legit exit code
 				// from the process.
 				_ = session.Exit(MagicSessionErrorCode)
@@ -317,9 +707,15 @@ func (a *agent) init(ctx context.Context) {
 			"cancel-tcpip-forward": forwardHandler.HandleSSHRequest,
 		},
 		ServerConfigCallback: func(ctx ssh.Context) *gossh.ServerConfig {
-			return &gossh.ServerConfig{
+			config := &gossh.ServerConfig{
 				NoClientAuth: true,
 			}
+			if a.sshCipherPolicy == SSHCipherPolicySecure {
+				config.Ciphers = secureSSHCiphers
+				config.MACs = secureSSHMACs
+				config.KeyExchanges = secureSSHKeyExchanges
+			}
+			return config
 		},
 		SubsystemHandlers: map[string]ssh.SubsystemHandler{
 			"sftp": func(session ssh.Session) {
@@ -389,7 +785,11 @@ func (a *agent) createCommand(ctx context.Context, rawCommand string, env []stri
 		// Default to $HOME if a directory is not set!
 		cmd.Dir = os.Getenv("HOME")
 	}
-	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = os.Environ()
+	if metadata.ShellPrompt != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("PS1=%s", metadata.ShellPrompt))
+	}
+	cmd.Env = append(cmd.Env, env...)
 	executablePath, err := os.Executable()
 	if err != nil {
 		return nil, xerrors.Errorf("getting os executable: %w", err)
@@ -431,7 +831,38 @@ func (a *agent) createCommand(ctx context.Context, rawCommand string, env []stri
 	return cmd, nil
 }
 
+// filterEnvironment removes the "KEY=value" entries of env whose key
+// matches a denylist pattern, or doesn't match an allowlist pattern when
+// there are any.
+func filterEnvironment(env, allowlist, denylist []string) []string {
+	if len(allowlist) == 0 && len(denylist) == 0 {
+		return env
+	}
+	matches := func(patterns []string, key string) bool {
+		for _, pattern := range patterns {
+			// Malformed patterns are rejected when coderd starts.
+			if matched, _ := path.Match(pattern, key); matched {
+				return true
+			}
+		}
+		return false
+	}
+	filtered := make([]string, 0, len(env))
+	for _, entry := range env {
+		key := strings.SplitN(entry, "=", 2)[0]
+		if len(allowlist) > 0 && !matches(allowlist, key) {
+			continue
+		}
+		if matches(denylist, key) {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered
+}
+
 func (a *agent) handleSSHSession(session ssh.Session) (retErr error) {
+	logger := a.sshSessionLogger(session.Context())
 	cmd, err := a.createCommand(session.Context(), session.RawCommand(), session.Environ())
 	if err != nil {
 		return err
@@ -459,7 +890,7 @@ func (a *agent) handleSSHSession(session ssh.Session) (retErr error) {
 		defer func() {
 			closeErr := ptty.Close()
 			if closeErr != nil {
-				a.logger.Warn(context.Background(), "failed to close tty",
+				logger.Warn(context.Background(), "failed to close tty",
 					slog.Error(closeErr))
 				if retErr == nil {
 					retErr = closeErr
@@ -474,7 +905,7 @@ func (a *agent) handleSSHSession(session ssh.Session) (retErr error) {
 			for win := range windowSize {
 				resizeErr := ptty.Resize(uint16(win.Height), uint16(win.Width))
 				if resizeErr != nil {
-					a.logger.Warn(context.Background(), "failed to resize tty", slog.Error(resizeErr))
+					logger.Warn(context.Background(), "failed to resize tty", slog.Error(resizeErr))
 				}
 			}
 		}()
@@ -489,7 +920,7 @@ func (a *agent) handleSSHSession(session ssh.Session) (retErr error) {
 		// ExitErrors just mean the command we run returned a non-zero exit code, which is normal
 		// and not something to be concerned about.  But, if it's something else, we should log it.
 		if err != nil && !xerrors.As(err, &exitErr) {
-			a.logger.Warn(context.Background(), "wait error",
+			logger.Warn(context.Background(), "wait error",
 				slog.Error(err))
 		}
 		return err
@@ -514,33 +945,65 @@ func (a *agent) handleSSHSession(session ssh.Session) (retErr error) {
 	return cmd.Wait()
 }
 
+// sshSessionIDKey is the ssh.Context key of the session ID clients may
+// send in the label of SSH channels.
+type sshSessionIDKey struct{}
+
+// sshConn is an SSH channel, with the session ID sent in its label.
+type sshConn struct {
+	net.Conn
+	sessionID string
+}
+
+// parseSSHLabel returns the session ID of an SSH channel label, which is
+// "ssh" or "ssh:<session id>".
+func parseSSHLabel(label string) string {
+	_, sessionID, _ := strings.Cut(label, ":")
+	return sessionID
+}
+
+// sshSessionLogger returns a logger that carries the session ID of the SSH
+// connection, if the client sent one.
+func (a *agent) sshSessionLogger(ctx ssh.Context) slog.Logger {
+	sessionID, ok := ctx.Value(sshSessionIDKey{}).(string)
+	if !ok {
+		return a.logger
+	}
+	return a.logger.With(slog.F("session_id", sessionID))
+}
+
 func (a *agent) handleReconnectingPTY(ctx context.Context, rawID string, conn net.Conn) {
 	defer conn.Close()
 
-	// The ID format is referenced in conn.go.
-	// <uuid>:<height>:<width>
-	idParts := strings.SplitN(rawID, ":", 4)
-	if len(idParts) != 4 {
-		a.logger.Warn(ctx, "client sent invalid id format", slog.F("raw-id", rawID))
+	ptyInit, err := parseReconnectingPTYLabel(rawID)
+	if err != nil {
+		a.logger.Warn(ctx, "client sent invalid reconnecting pty label", slog.F("label", rawID), slog.Error(err))
 		return
 	}
-	id := idParts[0]
+	// Every log of this connection carries the session ID, so it can be
+	// matched with the client's.
+	sessionID := ptyInit.SessionID
+	if sessionID == "" {
+		sessionID = uuid.NewString()
+	}
+	logger := a.logger.With(slog.F("session_id", sessionID))
+	id := ptyInit.ID
 	// Enforce a consistent format for IDs.
-	_, err := uuid.Parse(id)
+	_, err = uuid.Parse(id)
 	if err != nil {
-		a.logger.Warn(ctx, "client sent reconnection token that isn't a uuid", slog.F("id", id), slog.Error(err))
+		logger.Warn(ctx, "client sent reconnection token that isn't a uuid", slog.F("id", id), slog.Error(err))
 		return
 	}
-	// Parse the initial terminal dimensions.
-	height, err := strconv.Atoi(idParts[1])
-	if err != nil {
-		a.logger.Warn(ctx, "client sent invalid height", slog.F("id", id), slog.F("height", idParts[1]))
+	if ptyInit.ReplayLimit < 0 {
+		logger.Warn(ctx, "client sent invalid replay limit", slog.F("id", id), slog.F("replay_limit", ptyInit.ReplayLimit))
 		return
 	}
-	width, err := strconv.Atoi(idParts[2])
-	if err != nil {
-		a.logger.Warn(ctx, "client sent invalid width", slog.F("id", id), slog.F("width", idParts[2]))
-		return
+	replayLimit := ptyInit.ReplayLimit
+	takeover := ptyInit.Takeover
+	// A takeover continues the session on another device, so it gets all
+	// of the scrollback there is.
+	if replayLimit == 0 || replayLimit > a.reconnectingPTYReplayLimit || takeover {
+		replayLimit = a.reconnectingPTYReplayLimit
 	}
 
 	var rpty *reconnectingPTY
@@ -548,54 +1011,76 @@ func (a *agent) handleReconnectingPTY(ctx context.Context, rawID string, conn ne
 	if ok {
 		rpty, ok = rawRPTY.(*reconnectingPTY)
 		if !ok {
-			a.logger.Warn(ctx, "found invalid type in reconnecting pty map", slog.F("id", id))
+			logger.Warn(ctx, "found invalid type in reconnecting pty map", slog.F("id", id))
 		}
 	} else {
-		// Empty command will default to the users shell!
-		cmd, err := a.createCommand(ctx, idParts[3], nil)
+		a.reconnectingPTYMutex.Lock()
+		err = a.enforceReconnectingPTYLimit(ctx)
 		if err != nil {
-			a.logger.Warn(ctx, "create reconnecting pty command", slog.Error(err))
+			a.reconnectingPTYMutex.Unlock()
+			logger.Warn(ctx, "reject reconnecting pty", slog.F("id", id), slog.Error(err))
 			return
 		}
-		cmd.Env = append(cmd.Env, "TERM=xterm-256color")
 
-		ptty, process, err := pty.Start(cmd)
+		// Empty command will default to the users shell!
+		cmd, err := a.createCommand(ctx, ptyInit.Command, nil)
+		if err != nil {
+			a.reconnectingPTYMutex.Unlock()
+			logger.Warn(ctx, "create reconnecting pty command", slog.Error(err))
+			return
+		}
+		if metadata, ok := a.metadata.Load().(Metadata); ok {
+			cmd.Env = filterEnvironment(cmd.Env, metadata.PTYEnvironmentAllowlist, metadata.PTYEnvironmentDenylist)
+		}
+		circularBuffer, err := circbuf.NewBuffer(reconnectingPTYBufferSize)
 		if err != nil {
-			a.logger.Warn(ctx, "start reconnecting pty command", slog.F("id", id))
+			a.reconnectingPTYMutex.Unlock()
+			logger.Warn(ctx, "create circular buffer", slog.Error(err))
+			return
 		}
 
-		// Default to buffer 64KiB.
-		circularBuffer, err := circbuf.NewBuffer(64 << 10)
+		// The process group is killed and the PTY closed when the
+		// timeout completes or the parent context is canceled.
+		ctx, cancelFunc := context.WithCancel(ctx)
+		cmd.Env = append(cmd.Env, "TERM=xterm-256color")
+		ptty, process, err := pty.StartContext(ctx, cmd)
 		if err != nil {
-			a.logger.Warn(ctx, "create circular buffer", slog.Error(err))
+			cancelFunc()
+			a.reconnectingPTYMutex.Unlock()
+			logger.Warn(ctx, "start reconnecting pty command", slog.F("id", id), slog.Error(err))
 			return
 		}
 
 		a.closeMutex.Lock()
 		a.connCloseWait.Add(1)
 		a.closeMutex.Unlock()
-		ctx, cancelFunc := context.WithCancel(ctx)
 		rpty = &reconnectingPTY{
 			activeConns: make(map[string]net.Conn),
 			ptty:        ptty,
+			pid:         process.Pid(),
+			exited:      make(chan struct{}),
 			// Timeouts created with an after func can be reset!
 			timeout:        time.AfterFunc(a.reconnectingPTYTimeout, cancelFunc),
 			circularBuffer: circularBuffer,
+			cancel:         cancelFunc,
+			activity:       time.Now(),
 		}
 		a.reconnectingPTYs.Store(id, rpty)
-		go func() {
-			// CommandContext isn't respected for Windows PTYs right now,
-			// so we need to manually track the lifecycle.
-			// When the context has been completed either:
-			// 1. The timeout completed.
-			// 2. The parent context was canceled.
-			<-ctx.Done()
-			_ = process.Kill()
-		}()
+		a.reconnectingPTYMutex.Unlock()
+		// The PTY outlives the connection that started it, so its logs
+		// don't carry that connection's session ID.
+		ptyLogger := a.logger.With(slog.F("id", id))
 		go func() {
 			// If the process dies randomly, we should
 			// close the pty.
-			_ = process.Wait()
+			status, err := process.ExitStatus()
+			close(rpty.exited)
+			if err == nil {
+				ptyLogger.Debug(ctx, "reconnecting pty process exited",
+					slog.F("exit_code", status.Code),
+					slog.F("signal", status.Signal),
+				)
+			}
 			rpty.Close()
 		}()
 		go func() {
@@ -611,9 +1096,10 @@ func (a *agent) handleReconnectingPTY(ctx context.Context, rawID string, conn ne
 				_, err = rpty.circularBuffer.Write(part)
 				rpty.circularBufferMutex.Unlock()
 				if err != nil {
-					a.logger.Error(ctx, "reconnecting pty write buffer", slog.Error(err), slog.F("id", id))
+					ptyLogger.Error(ctx, "reconnecting pty write buffer", slog.Error(err))
 					break
 				}
+				rpty.touch()
 				rpty.activeConnsMutex.Lock()
 				for _, conn := range rpty.activeConns {
 					_, _ = conn.Write(part)
@@ -630,20 +1116,43 @@ func (a *agent) handleReconnectingPTY(ctx context.Context, rawID string, conn ne
 		}()
 	}
 	// Resize the PTY to initial height + width.
-	err = rpty.ptty.Resize(uint16(height), uint16(width))
+	err = rpty.ptty.Resize(ptyInit.Height, ptyInit.Width)
 	if err != nil {
 		// We can continue after this, it's not fatal!
-		a.logger.Error(ctx, "resize reconnecting pty", slog.F("id", id), slog.Error(err))
+		logger.Error(ctx, "resize reconnecting pty", slog.F("id", id), slog.Error(err))
+	}
+	connectionID := uuid.NewString()
+	if takeover {
+		detached := rpty.takeover(connectionID)
+		logger.Debug(ctx, "take over reconnecting pty", slog.F("id", id), slog.F("detached", detached))
+	}
+	// Only one connection may write to the PTY at a time, so keystrokes
+	// from multiple clients aren't interleaved. The others observe.
+	writer := rpty.acquireWriter(connectionID)
+	defer rpty.releaseWriter(connectionID)
+	logger.Debug(ctx, "attach to reconnecting pty", slog.F("id", id), slog.F("writer", writer))
+	if ptyInit.Handshake {
+		handshake, err := json.Marshal(reconnectingPTYHandshake{
+			Writer: writer,
+		})
+		if err != nil {
+			logger.Warn(ctx, "marshal reconnecting pty handshake", slog.F("id", id), slog.Error(err))
+			return
+		}
+		_, err = conn.Write(append(handshake, '\n'))
+		if err != nil {
+			logger.Warn(ctx, "write reconnecting pty handshake", slog.F("id", id), slog.Error(err))
+			return
+		}
 	}
 	// Write any previously stored data for the TTY.
 	rpty.circularBufferMutex.RLock()
-	_, err = conn.Write(rpty.circularBuffer.Bytes())
+	_, err = conn.Write(replayTail(rpty.circularBuffer.Bytes(), replayLimit))
 	rpty.circularBufferMutex.RUnlock()
 	if err != nil {
-		a.logger.Warn(ctx, "write reconnecting pty buffer", slog.F("id", id), slog.Error(err))
+		logger.Warn(ctx, "write reconnecting pty buffer", slog.F("id", id), slog.Error(err))
 		return
 	}
-	connectionID := uuid.NewString()
 	// Multiple connections to the same TTY are permitted.
 	// This could easily be used for terminal sharing, but
 	// we do it because it's a nice user experience to
@@ -651,6 +1160,7 @@ func (a *agent) handleReconnectingPTY(ctx context.Context, rawID string, conn ne
 	rpty.activeConnsMutex.Lock()
 	rpty.activeConns[connectionID] = conn
 	rpty.activeConnsMutex.Unlock()
+	rpty.attach()
 	// Resetting this timeout prevents the PTY from exiting.
 	rpty.timeout.Reset(a.reconnectingPTYTimeout)
 
@@ -677,6 +1187,7 @@ func (a *agent) handleReconnectingPTY(ctx context.Context, rawID string, conn ne
 		rpty.activeConnsMutex.Lock()
 		delete(rpty.activeConns, connectionID)
 		rpty.activeConnsMutex.Unlock()
+		rpty.detach()
 	}()
 	decoder := json.NewDecoder(conn)
 	var req ReconnectingPTYRequest
@@ -686,14 +1197,20 @@ func (a *agent) handleReconnectingPTY(ctx context.Context, rawID string, conn ne
 			return
 		}
 		if err != nil {
-			a.logger.Warn(ctx, "reconnecting pty buffer read error", slog.F("id", id), slog.Error(err))
+			logger.Warn(ctx, "reconnecting pty buffer read error", slog.F("id", id), slog.Error(err))
 			return
 		}
+		// Input from observers is dropped. They're promoted once the
+		// writer detaches.
+		if !rpty.acquireWriter(connectionID) {
+			continue
+		}
 		_, err = rpty.ptty.Input().Write([]byte(req.Data))
 		if err != nil {
-			a.logger.Warn(ctx, "write to reconnecting pty", slog.F("id", id), slog.Error(err))
+			logger.Warn(ctx, "write to reconnecting pty", slog.F("id", id), slog.Error(err))
 			return
 		}
+		rpty.touch()
 		// Check if a resize needs to happen!
 		if req.Height == 0 || req.Width == 0 {
 			continue
@@ -701,9 +1218,58 @@ func (a *agent) handleReconnectingPTY(ctx context.Context, rawID string, conn ne
 		err = rpty.ptty.Resize(req.Height, req.Width)
 		if err != nil {
 			// We can continue after this, it's not fatal!
-			a.logger.Error(ctx, "resize reconnecting pty", slog.F("id", id), slog.Error(err))
+			logger.Error(ctx, "resize reconnecting pty", slog.F("id", id), slog.Error(err))
+		}
+	}
+}
+
+// enforceReconnectingPTYLimit ensures there's room for another reconnecting
+// PTY, evicting the session that has been idle the longest if the policy
+// allows it. The caller must hold reconnectingPTYMutex.
+func (a *agent) enforceReconnectingPTYLimit(ctx context.Context) error {
+	if a.reconnectingPTYLimit <= 0 {
+		return nil
+	}
+	var (
+		count      int
+		oldestID   string
+		oldestRPTY *reconnectingPTY
+	)
+	a.reconnectingPTYs.Range(func(key, value interface{}) bool {
+		rpty, ok := value.(*reconnectingPTY)
+		if !ok {
+			return true
 		}
+		count++
+		if oldestRPTY == nil || rpty.idlerThan(oldestRPTY) {
+			oldestID, _ = key.(string)
+			oldestRPTY = rpty
+		}
+		return true
+	})
+	if count < a.reconnectingPTYLimit {
+		return nil
 	}
+	if a.reconnectingPTYLimitPolicy != ReconnectingPTYLimitEvictOldestIdle || oldestRPTY == nil {
+		return xerrors.Errorf("reconnecting pty limit of %d reached", a.reconnectingPTYLimit)
+	}
+	a.logger.Info(ctx, "evicting idle reconnecting pty",
+		slog.F("id", oldestID),
+		slog.F("idle", time.Since(oldestRPTY.lastActivity())),
+		slog.F("attached", oldestRPTY.isAttached()),
+	)
+	// Deleting now frees the slot immediately, the session cleans up
+	// the rest of its state asynchronously once the process exits.
+	a.reconnectingPTYs.Delete(oldestID)
+	oldestRPTY.cancel()
+	return nil
+}
+
+// reconnectingPTYHandshake is written as a line to reconnecting PTY
+// connections that ask for it, before any output.
+type reconnectingPTYHandshake struct {
+	// Writer is whether the connection holds the write lock of the PTY.
+	Writer bool `json:"writer"`
 }
 
 // dialResponse is written to datachannels with protocol "dial" by the agent as
@@ -793,14 +1359,177 @@ func (a *agent) Close() error {
 	return nil
 }
 
+// reconnectingPTYBufferSize is how much recent output of a reconnecting
+// PTY is retained for replay.
+const reconnectingPTYBufferSize = 64 << 10
+
+// replayTail returns at most the last limit bytes of output. The start is
+// moved forward so it never splits a UTF-8 character or an ANSI escape
+// sequence, which would garble the terminal replaying it.
+func replayTail(output []byte, limit int) []byte {
+	if len(output) <= limit {
+		return output
+	}
+	start := len(output) - limit
+	for start < len(output) && !utf8.RuneStart(output[start]) {
+		start++
+	}
+	escape := bytes.LastIndexByte(output[:start], 0x1b)
+	if escape >= 0 {
+		if end := escapeSequenceEnd(output, escape); end > start {
+			start = end
+		}
+	}
+	return output[start:]
+}
+
+// escapeSequenceEnd returns the index just after the escape sequence that
+// begins at start, or len(output) if it's unterminated.
+func escapeSequenceEnd(output []byte, start int) int {
+	i := start + 1
+	if i >= len(output) {
+		return len(output)
+	}
+	switch output[i] {
+	case '[':
+		// CSI: parameter and intermediate bytes, then a final byte.
+		for i++; i < len(output); i++ {
+			if output[i] >= 0x40 && output[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return len(output)
+	case ']', 'P', 'X', '^', '_':
+		// OSC and other strings end with BEL or ST (ESC \).
+		for i++; i < len(output); i++ {
+			if output[i] == 0x07 {
+				return i + 1
+			}
+			if output[i] == 0x1b && i+1 < len(output) && output[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return len(output)
+	default:
+		// Intermediate bytes, then a final byte.
+		for ; i < len(output); i++ {
+			if output[i] < 0x20 || output[i] > 0x2f {
+				return i + 1
+			}
+		}
+		return len(output)
+	}
+}
+
 type reconnectingPTY struct {
 	activeConnsMutex sync.Mutex
 	activeConns      map[string]net.Conn
 
+	// writer is the ID of the connection allowed to write to the PTY.
+	writerMutex sync.Mutex
+	writer      string
+
 	circularBuffer      *circbuf.Buffer
 	circularBufferMutex sync.RWMutex
 	timeout             *time.Timer
 	ptty                pty.PTY
+	// pid is of the process the PTY started, the root of its process tree.
+	pid int
+	// exited is closed once that process has exited, after which pid may
+	// belong to an unrelated process.
+	exited chan struct{}
+	// cancel kills the process of the PTY.
+	cancel context.CancelFunc
+
+	// activity is the time of the last input, output, attach or detach,
+	// and attached counts the connections to the PTY. Both decide which
+	// session is evicted when the limit is reached.
+	activityMutex sync.Mutex
+	activity      time.Time
+	attached      int
+}
+
+// acquireWriter returns whether the connection holds the write lock,
+// taking it if no other connection does.
+func (r *reconnectingPTY) acquireWriter(connectionID string) bool {
+	r.writerMutex.Lock()
+	defer r.writerMutex.Unlock()
+	if r.writer == "" {
+		r.writer = connectionID
+	}
+	return r.writer == connectionID
+}
+
+// releaseWriter gives up the write lock if the connection holds it.
+func (r *reconnectingPTY) releaseWriter(connectionID string) {
+	r.writerMutex.Lock()
+	defer r.writerMutex.Unlock()
+	if r.writer == connectionID {
+		r.writer = ""
+	}
+}
+
+// takeover detaches every connection to the PTY and hands the write lock to
+// connectionID. It returns how many connections were detached.
+func (r *reconnectingPTY) takeover(connectionID string) int {
+	r.activeConnsMutex.Lock()
+	detached := len(r.activeConns)
+	for id, conn := range r.activeConns {
+		_ = conn.Close()
+		delete(r.activeConns, id)
+	}
+	r.activeConnsMutex.Unlock()
+
+	r.writerMutex.Lock()
+	r.writer = connectionID
+	r.writerMutex.Unlock()
+	return detached
+}
+
+// touch marks the PTY as active, resetting its idle time.
+func (r *reconnectingPTY) touch() {
+	r.activityMutex.Lock()
+	r.activity = time.Now()
+	r.activityMutex.Unlock()
+}
+
+// attach records a connection attaching to the PTY.
+func (r *reconnectingPTY) attach() {
+	r.activityMutex.Lock()
+	r.activity = time.Now()
+	r.attached++
+	r.activityMutex.Unlock()
+}
+
+// detach records a connection detaching from the PTY.
+func (r *reconnectingPTY) detach() {
+	r.activityMutex.Lock()
+	r.activity = time.Now()
+	r.attached--
+	r.activityMutex.Unlock()
+}
+
+func (r *reconnectingPTY) lastActivity() time.Time {
+	r.activityMutex.Lock()
+	defer r.activityMutex.Unlock()
+	return r.activity
+}
+
+func (r *reconnectingPTY) isAttached() bool {
+	r.activityMutex.Lock()
+	defer r.activityMutex.Unlock()
+	return r.attached > 0
+}
+
+// idlerThan returns whether the PTY is a better eviction target than other.
+// PTYs without attached connections are idler than those with, and
+// otherwise the one with the least recent activity is.
+func (r *reconnectingPTY) idlerThan(other *reconnectingPTY) bool {
+	attached, otherAttached := r.isAttached(), other.isAttached()
+	if attached != otherAttached {
+		return otherAttached
+	}
+	return r.lastActivity().Before(other.lastActivity())
 }
 
 // Close ends all connections to the reconnecting
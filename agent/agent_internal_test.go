@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayTail(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name     string
+		Output   string
+		Limit    int
+		Expected string
+	}{
+		{
+			Name:     "UnderLimit",
+			Output:   "hello",
+			Limit:    10,
+			Expected: "hello",
+		},
+		{
+			Name:     "Truncated",
+			Output:   "hello world",
+			Limit:    5,
+			Expected: "world",
+		},
+		{
+			Name: "MultibyteCharacter",
+			// "é" is two bytes, so a 4 byte limit starts inside it.
+			Output:   "café ok",
+			Limit:    4,
+			Expected: " ok",
+		},
+		{
+			Name:     "CSISequence",
+			Output:   "one\x1b[31mred",
+			Limit:    5,
+			Expected: "red",
+		},
+		{
+			Name:     "CSISequenceWhole",
+			Output:   "one\x1b[31mred",
+			Limit:    8,
+			Expected: "\x1b[31mred",
+		},
+		{
+			Name:     "OSCSequence",
+			Output:   "x\x1b]0;title\x07prompt$ ",
+			Limit:    12,
+			Expected: "prompt$ ",
+		},
+		{
+			Name:     "OSCSequenceST",
+			Output:   "x\x1b]0;title\x1b\\prompt$ ",
+			Limit:    10,
+			Expected: "prompt$ ",
+		},
+		{
+			Name:     "TwoByteSequence",
+			Output:   "ab\x1b7cd",
+			Limit:    3,
+			Expected: "cd",
+		},
+		{
+			Name:     "Unterminated",
+			Output:   "ab\x1b[3",
+			Limit:    2,
+			Expected: "",
+		},
+	}
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			t.Parallel()
+			replayed := replayTail([]byte(testCase.Output), testCase.Limit)
+			require.Equal(t, testCase.Expected, string(replayed))
+			require.LessOrEqual(t, len(replayed), testCase.Limit)
+			require.True(t, utf8.Valid(replayed))
+			require.True(t, strings.HasSuffix(testCase.Output, string(replayed)))
+		})
+	}
+}
+
+func TestReconnectingPTYIdlerThan(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	older := &reconnectingPTY{activity: now.Add(-2 * time.Minute)}
+	newer := &reconnectingPTY{activity: now.Add(-time.Minute)}
+	require.True(t, older.idlerThan(newer))
+	require.False(t, newer.idlerThan(older))
+
+	// Output counts as activity.
+	older.touch()
+	require.True(t, newer.idlerThan(older))
+
+	// Sessions with attached connections are evicted last, regardless of
+	// their activity.
+	newer.attach()
+	newer.activity = now.Add(-time.Hour)
+	require.True(t, older.idlerThan(newer))
+	require.False(t, newer.idlerThan(older))
+
+	// Detaching counts as activity.
+	newer.detach()
+	require.True(t, older.idlerThan(newer))
+}
@@ -2,7 +2,10 @@ package agent_test
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,6 +16,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -20,6 +24,8 @@ import (
 
 	scp "github.com/bramvdbogaerde/go-scp"
 	"github.com/google/uuid"
+	"github.com/hashicorp/yamux"
+	"github.com/pion/stun"
 	"github.com/pion/udp"
 	"github.com/pion/webrtc/v3"
 	"github.com/pkg/sftp"
@@ -192,6 +198,68 @@ func TestAgent(t *testing.T) {
 		require.NoError(t, err)
 	})
 
+	t.Run("CopyToWorkspace", func(t *testing.T) {
+		t.Parallel()
+		conn := setupAgent(t, agent.Metadata{}, 0)
+		content := make([]byte, 4<<20)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+		tempFile := filepath.Join(t.TempDir(), "copy")
+
+		var calls int
+		var lastDone, lastTotal int64
+		done, err := conn.CopyToWorkspace(context.Background(), bytes.NewReader(content), int64(len(content)), tempFile, agent.CopyOptions{
+			Progress: func(done, total int64) {
+				calls++
+				lastDone, lastTotal = done, total
+			},
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, len(content), done)
+		require.Greater(t, calls, 1)
+		require.EqualValues(t, len(content), lastDone)
+		require.EqualValues(t, len(content), lastTotal)
+		got, err := os.ReadFile(tempFile)
+		require.NoError(t, err)
+		require.Equal(t, content, got)
+
+		var buf bytes.Buffer
+		done, err = conn.CopyFromWorkspace(context.Background(), tempFile, &buf, agent.CopyOptions{})
+		require.NoError(t, err)
+		require.EqualValues(t, len(content), done)
+		require.Equal(t, content, buf.Bytes())
+	})
+
+	t.Run("CopyToWorkspaceCanceled", func(t *testing.T) {
+		t.Parallel()
+		conn := setupAgent(t, agent.Metadata{}, 0)
+		content := make([]byte, 4<<20)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+		tempFile := filepath.Join(t.TempDir(), "copy")
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		done, err := conn.CopyToWorkspace(ctx, bytes.NewReader(content), int64(len(content)), tempFile, agent.CopyOptions{
+			Progress: func(_, _ int64) {
+				// Cancel after the first chunk has been written.
+				cancelFunc()
+			},
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		require.Less(t, done, int64(len(content)))
+
+		// Resuming from the returned offset completes the file.
+		done, err = conn.CopyToWorkspace(context.Background(), bytes.NewReader(content), int64(len(content)), tempFile, agent.CopyOptions{
+			Offset: done,
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, len(content), done)
+		got, err := os.ReadFile(tempFile)
+		require.NoError(t, err)
+		require.Equal(t, content, got)
+	})
+
 	t.Run("EnvironmentVariables", func(t *testing.T) {
 		t.Parallel()
 		key := "EXAMPLE"
@@ -232,6 +300,35 @@ func TestAgent(t *testing.T) {
 		require.Equal(t, expect, strings.TrimSpace(string(output)))
 	})
 
+	t.Run("ShellPrompt", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("PS1 isn't used by shells on Windows.")
+		}
+		prompt := "coder-prompt-test$ "
+		conn := setupAgent(t, agent.Metadata{
+			ShellPrompt: prompt,
+		}, 0)
+		netConn, err := conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+			ID:      uuid.NewString(),
+			Height:  100,
+			Width:   100,
+			Command: "/bin/sh",
+		})
+		require.NoError(t, err)
+		defer netConn.Close()
+
+		// sh doesn't read any configuration, so the prompt from the
+		// environment is displayed.
+		var output strings.Builder
+		buffer := make([]byte, 1024)
+		for !strings.Contains(output.String(), prompt) {
+			read, err := netConn.Read(buffer)
+			require.NoError(t, err)
+			_, _ = output.Write(buffer[:read])
+		}
+	})
+
 	t.Run("Coder env vars", func(t *testing.T) {
 		t.Parallel()
 
@@ -305,6 +402,41 @@ func TestAgent(t *testing.T) {
 		require.Equal(t, content, strings.TrimSpace(gotContent))
 	})
 
+	t.Run("SSHCipherPolicy", func(t *testing.T) {
+		t.Parallel()
+		// The client only offers a non-AEAD cipher and a SHA-1 MAC.
+		weakConfig := &ssh.ClientConfig{
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Config: ssh.Config{
+				Ciphers: []string{"aes128-ctr"},
+				MACs:    []string{"hmac-sha1"},
+			},
+		}
+		strongConfig := &ssh.ClientConfig{
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Config: ssh.Config{
+				Ciphers: []string{"chacha20-poly1305@openssh.com"},
+			},
+		}
+		dial := func(t *testing.T, policy agent.SSHCipherPolicy, config *ssh.ClientConfig) error {
+			conn := setupAgentWithOptions(t, agent.Metadata{}, &agent.Options{
+				SSHCipherPolicy: policy,
+			})
+			netConn, err := conn.SSH()
+			require.NoError(t, err)
+			defer netConn.Close()
+			sshConn, channels, requests, err := ssh.NewClientConn(netConn, "localhost:22", config)
+			if err != nil {
+				return err
+			}
+			return ssh.NewClient(sshConn, channels, requests).Close()
+		}
+
+		require.NoError(t, dial(t, agent.SSHCipherPolicyDefault, weakConfig))
+		require.Error(t, dial(t, agent.SSHCipherPolicySecure, weakConfig))
+		require.NoError(t, dial(t, agent.SSHCipherPolicySecure, strongConfig))
+	})
+
 	t.Run("ReconnectingPTY", func(t *testing.T) {
 		t.Parallel()
 		if runtime.GOOS == "windows" {
@@ -316,7 +448,12 @@ func TestAgent(t *testing.T) {
 
 		conn := setupAgent(t, agent.Metadata{}, 0)
 		id := uuid.NewString()
-		netConn, err := conn.ReconnectingPTY(id, 100, 100, "/bin/bash")
+		netConn, err := conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+			ID:      id,
+			Height:  100,
+			Width:   100,
+			Command: "/bin/bash",
+		})
 		require.NoError(t, err)
 		bufRead := bufio.NewReader(netConn)
 
@@ -354,7 +491,12 @@ func TestAgent(t *testing.T) {
 		expectLine(matchEchoOutput)
 
 		_ = netConn.Close()
-		netConn, err = conn.ReconnectingPTY(id, 100, 100, "/bin/bash")
+		netConn, err = conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+			ID:      id,
+			Height:  100,
+			Width:   100,
+			Command: "/bin/bash",
+		})
 		require.NoError(t, err)
 		bufRead = bufio.NewReader(netConn)
 
@@ -363,6 +505,314 @@ func TestAgent(t *testing.T) {
 		expectLine(matchEchoOutput)
 	})
 
+	t.Run("ReconnectingPTYEnvironmentDenylist", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("ConPTY appears to be inconsistent on Windows.")
+		}
+
+		conn := setupAgent(t, agent.Metadata{
+			EnvironmentVariables: map[string]string{
+				"SECRET_TOKEN": "hunter2",
+				"NORMAL":       "visible",
+			},
+			PTYEnvironmentDenylist: []string{"SECRET_*"},
+		}, 0)
+		netConn, err := conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+			ID:      uuid.NewString(),
+			Height:  100,
+			Width:   100,
+			Command: "echo secret=[$SECRET_TOKEN] normal=[$NORMAL]",
+		})
+		require.NoError(t, err)
+		defer netConn.Close()
+
+		bufRead := bufio.NewReader(netConn)
+		for {
+			line, err := bufRead.ReadString('\n')
+			require.NoError(t, err)
+			if strings.Contains(line, "normal=[") {
+				require.Contains(t, line, "secret=[] normal=[visible]")
+				break
+			}
+		}
+	})
+
+	t.Run("ReconnectingPTYLimitReject", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("ConPTY appears to be inconsistent on Windows.")
+		}
+
+		conn := setupAgentWithOptions(t, agent.Metadata{}, &agent.Options{
+			ReconnectingPTYLimit:       1,
+			ReconnectingPTYLimitPolicy: agent.ReconnectingPTYLimitReject,
+		})
+		first, err := conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+			ID:      uuid.NewString(),
+			Height:  100,
+			Width:   100,
+			Command: "/bin/bash",
+		})
+		require.NoError(t, err)
+		expectPTYEcho(t, first, "first")
+
+		second, err := conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+			ID:      uuid.NewString(),
+			Height:  100,
+			Width:   100,
+			Command: "/bin/bash",
+		})
+		require.NoError(t, err)
+		expectPTYClosed(t, second)
+
+		// The existing session is unaffected.
+		expectPTYEcho(t, first, "still-first")
+	})
+
+	t.Run("ReconnectingPTYLimitEvictOldestIdle", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("ConPTY appears to be inconsistent on Windows.")
+		}
+
+		conn := setupAgentWithOptions(t, agent.Metadata{}, &agent.Options{
+			ReconnectingPTYLimit:       2,
+			ReconnectingPTYLimitPolicy: agent.ReconnectingPTYLimitEvictOldestIdle,
+		})
+		first, err := conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+			ID:      uuid.NewString(),
+			Height:  100,
+			Width:   100,
+			Command: "/bin/bash",
+		})
+		require.NoError(t, err)
+		expectPTYEcho(t, first, "first")
+		second, err := conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+			ID:      uuid.NewString(),
+			Height:  100,
+			Width:   100,
+			Command: "/bin/bash",
+		})
+		require.NoError(t, err)
+		expectPTYEcho(t, second, "second")
+
+		// The first session has been idle the longest, so it's evicted.
+		third, err := conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+			ID:      uuid.NewString(),
+			Height:  100,
+			Width:   100,
+			Command: "/bin/bash",
+		})
+		require.NoError(t, err)
+		expectPTYEcho(t, third, "third")
+		expectPTYClosed(t, first)
+		expectPTYEcho(t, second, "still-second")
+	})
+
+	t.Run("ReconnectingPTYSingleWriter", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("ConPTY appears to be inconsistent on Windows.")
+		}
+
+		conn := setupAgent(t, agent.Metadata{}, 0)
+		id := uuid.NewString()
+		writer, err := conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+			ID:        id,
+			Height:    100,
+			Width:     100,
+			Command:   "/bin/bash",
+			Handshake: true,
+		})
+		require.NoError(t, err)
+		isWriter, err := writer.Writer()
+		require.NoError(t, err)
+		require.True(t, isWriter)
+		expectPTYEcho(t, writer, "first")
+
+		observer, err := conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+			ID:        id,
+			Height:    100,
+			Width:     100,
+			Command:   "/bin/bash",
+			Handshake: true,
+		})
+		require.NoError(t, err)
+		isWriter, err = observer.Writer()
+		require.NoError(t, err)
+		require.False(t, isWriter)
+
+		data, err := json.Marshal(agent.ReconnectingPTYRequest{
+			Data: "echo from-observer\r\n",
+		})
+		require.NoError(t, err)
+		_, err = observer.Write(data)
+		require.NoError(t, err)
+		data, err = json.Marshal(agent.ReconnectingPTYRequest{
+			Data: "echo from-writer\r\n",
+		})
+		require.NoError(t, err)
+		_, err = writer.Write(data)
+		require.NoError(t, err)
+
+		// The observer's input must never reach the shell.
+		bufRead := bufio.NewReader(writer)
+		for {
+			line, err := bufRead.ReadString('\n')
+			require.NoError(t, err)
+			require.NotContains(t, line, "from-observer")
+			if strings.Contains(line, "from-writer") && !strings.Contains(line, "echo") {
+				break
+			}
+		}
+
+		// Once the writer detaches, the observer takes over. The agent
+		// releases the lock asynchronously, so input is retried.
+		require.NoError(t, writer.Close())
+		promoted := make(chan struct{})
+		go func() {
+			bufRead := bufio.NewReader(observer)
+			for {
+				line, err := bufRead.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if strings.Contains(line, "promoted") && !strings.Contains(line, "echo") {
+					close(promoted)
+					return
+				}
+			}
+		}()
+		data, err = json.Marshal(agent.ReconnectingPTYRequest{
+			Data: "echo promoted\r\n",
+		})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			_, err := observer.Write(data)
+			if err != nil {
+				return false
+			}
+			select {
+			case <-promoted:
+				return true
+			case <-time.After(testutil.IntervalMedium):
+				return false
+			}
+		}, testutil.WaitLong, testutil.IntervalFast)
+	})
+
+	t.Run("ReconnectingPTYTakeover", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("ConPTY appears to be inconsistent on Windows.")
+		}
+
+		conn := setupAgent(t, agent.Metadata{}, 0)
+		id := uuid.NewString()
+		first, err := conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+			ID:      id,
+			Height:  100,
+			Width:   100,
+			Command: "/bin/bash",
+		})
+		require.NoError(t, err)
+		expectPTYEcho(t, first, "before-takeover")
+
+		// The replay limit is ignored, so the new device sees everything.
+		second, err := conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+			ID:          id,
+			Height:      100,
+			Width:       100,
+			ReplayLimit: 1,
+			Takeover:    true,
+			Command:     "/bin/bash",
+		})
+		require.NoError(t, err)
+		expectPTYClosed(t, first)
+
+		bufRead := bufio.NewReader(second)
+		for {
+			line, err := bufRead.ReadString('\n')
+			require.NoError(t, err)
+			if strings.Contains(line, "before-takeover") && !strings.Contains(line, "echo") {
+				break
+			}
+		}
+		expectPTYEcho(t, second, "after-takeover")
+	})
+
+	t.Run("ReconnectingPTYProcessTree", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS != "linux" {
+			t.Skip("Process trees are only supported on Linux.")
+		}
+
+		for _, redact := range []bool{false, true} {
+			redact := redact
+			t.Run(fmt.Sprintf("Redact=%t", redact), func(t *testing.T) {
+				t.Parallel()
+				ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+				defer cancel()
+
+				conn := setupAgent(t, agent.Metadata{
+					RedactProcessArguments: redact,
+				}, 0)
+				_, err := conn.ProcessTree(ctx, uuid.NewString())
+				require.ErrorIs(t, err, agent.ErrReconnectingPTYNotFound)
+
+				id := uuid.NewString()
+				ptyConn, err := conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+					ID:      id,
+					Height:  100,
+					Width:   100,
+					Command: "/bin/bash",
+				})
+				require.NoError(t, err)
+				defer ptyConn.Close()
+				// Brief pause to reduce the likelihood that we send keystrokes
+				// while the shell is simultaneously sending a prompt.
+				time.Sleep(100 * time.Millisecond)
+				data, err := json.Marshal(agent.ReconnectingPTYRequest{
+					Data: "sleep 1337\r\n",
+				})
+				require.NoError(t, err)
+				_, err = ptyConn.Write(data)
+				require.NoError(t, err)
+
+				// The shell may run bash as a child or exec it, so only the
+				// sleep is looked for.
+				var (
+					processes []agent.Process
+					sleep     agent.Process
+				)
+				require.Eventually(t, func() bool {
+					processes, err = conn.ProcessTree(ctx, id)
+					require.NoError(t, err)
+					for _, process := range processes {
+						if len(process.Command) > 0 && process.Command[0] == "sleep" {
+							sleep = process
+							return true
+						}
+					}
+					return false
+				}, testutil.WaitLong, testutil.IntervalFast)
+				require.NotEqual(t, sleep.PID, processes[0].PID)
+				require.NotEmpty(t, sleep.State)
+				var parent bool
+				for _, process := range processes {
+					parent = parent || process.PID == sleep.PPID
+				}
+				require.True(t, parent, "the parent of sleep is in the tree")
+				if redact {
+					require.Equal(t, []string{"sleep"}, sleep.Command)
+				} else {
+					require.Equal(t, []string{"sleep", "1337"}, sleep.Command)
+				}
+			})
+		}
+	})
+
 	t.Run("Dial", func(t *testing.T) {
 		t.Parallel()
 
@@ -463,6 +913,331 @@ func TestAgent(t *testing.T) {
 		require.ErrorContains(t, err, "no such file")
 		require.Nil(t, netConn)
 	})
+
+	t.Run("Exec", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("The command is POSIX shell.")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+		conn := setupAgent(t, agent.Metadata{}, 0)
+		stdout, stderr, exitCode, err := conn.Exec(ctx, []string{"sh", "-c", "cat; echo to-stderr >&2; exit 3"}, strings.NewReader("to-stdout"))
+		require.NoError(t, err)
+		require.Equal(t, "to-stdout", string(stdout))
+		require.Equal(t, "to-stderr\n", string(stderr))
+		require.Equal(t, 3, exitCode)
+	})
+
+	t.Run("ExecClosesStdin", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("The command is POSIX shell.")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+		conn := setupAgent(t, agent.Metadata{}, 0)
+		// The command exits without reading stdin, which is never closed
+		// by the writer.
+		stdinReader, stdinWriter := io.Pipe()
+		_, _, exitCode, err := conn.Exec(ctx, []string{"true"}, stdinReader)
+		require.NoError(t, err)
+		require.Equal(t, 0, exitCode)
+		_, err = stdinWriter.Write([]byte("late"))
+		require.ErrorIs(t, err, io.ErrClosedPipe)
+	})
+
+	t.Run("DisabledProtocols", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("ConPTY appears to be inconsistent on Windows.")
+		}
+
+		conn := setupAgent(t, agent.Metadata{
+			DisabledProtocols: []string{agent.ProtocolReconnectingPTY, agent.ProtocolDial},
+		}, 0)
+
+		netConn, err := conn.DialContext(context.Background(), "tcp", "127.0.0.1:22")
+		require.ErrorContains(t, err, `protocol "dial" is disabled`)
+		require.Nil(t, netConn)
+
+		ptyConn, err := conn.ReconnectingPTY(agent.ReconnectingPTYInit{
+			ID:      uuid.NewString(),
+			Height:  100,
+			Width:   100,
+			Command: "/bin/bash",
+		})
+		require.NoError(t, err)
+		expectPTYClosed(t, ptyConn)
+
+		// SSH is still served.
+		sshClient, err := conn.SSHClient()
+		require.NoError(t, err)
+		defer sshClient.Close()
+		session, err := sshClient.NewSession()
+		require.NoError(t, err)
+		output, err := session.Output("echo test")
+		require.NoError(t, err)
+		require.Equal(t, "test", strings.TrimSpace(string(output)))
+	})
+
+	t.Run("Capabilities", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+		conn := setupAgent(t, agent.Metadata{}, 0)
+		capabilities, err := conn.Capabilities(ctx)
+		require.NoError(t, err)
+		require.Equal(t, agent.CapabilitiesVersion, capabilities.Version)
+		require.ElementsMatch(t, []string{
+			agent.ProtocolSSH,
+			agent.ProtocolReconnectingPTY,
+			agent.ProtocolDial,
+			agent.ProtocolExec,
+			agent.ProtocolProcessTree,
+			agent.ProtocolSpeedtest,
+			agent.ProtocolListeningPorts,
+		}, capabilities.Protocols)
+		require.Equal(t, agent.Protocols, capabilities.Protocols)
+		require.Contains(t, capabilities.Operations, agent.OperationPing)
+		require.Contains(t, capabilities.Operations, agent.OperationCopy)
+
+		conn = setupAgent(t, agent.Metadata{
+			DisabledProtocols: []string{agent.ProtocolReconnectingPTY, agent.ProtocolExec},
+		}, 0)
+		capabilities, err = conn.Capabilities(ctx)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{
+			agent.ProtocolSSH,
+			agent.ProtocolDial,
+			agent.ProtocolProcessTree,
+			agent.ProtocolSpeedtest,
+			agent.ProtocolListeningPorts,
+		}, capabilities.Protocols)
+		require.NotContains(t, capabilities.Operations, agent.OperationReconnectingPTY)
+		require.NotContains(t, capabilities.Operations, agent.OperationExec)
+		require.Contains(t, capabilities.Operations, agent.OperationCopy)
+	})
+
+	t.Run("SupportsReconnectingPTYInit", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+		conn := setupAgent(t, agent.Metadata{}, 0)
+		require.True(t, conn.SupportsReconnectingPTYInit(ctx))
+
+		conn = setupLegacyAgent(t)
+		probeCtx, probeCancel := context.WithTimeout(ctx, testutil.IntervalMedium)
+		defer probeCancel()
+		require.False(t, conn.SupportsReconnectingPTYInit(probeCtx))
+		// The answer is remembered, so asking again doesn't wait.
+		start := time.Now()
+		require.False(t, conn.SupportsReconnectingPTYInit(ctx))
+		require.Less(t, time.Since(start), testutil.WaitShort)
+	})
+
+	t.Run("Speedtest", func(t *testing.T) {
+		t.Parallel()
+		conn := setupAgent(t, agent.Metadata{}, 0)
+
+		for _, direction := range []agent.SpeedtestDirection{agent.SpeedtestUpload, agent.SpeedtestDownload} {
+			direction := direction
+			t.Run(string(direction), func(t *testing.T) {
+				t.Parallel()
+				ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+				defer cancel()
+				results, err := conn.Speedtest(ctx, direction, 1500*time.Millisecond)
+				require.NoError(t, err)
+				// A sample each second, and one when the test ends.
+				require.Len(t, results, 2)
+
+				// A final interval too short to measure isn't reported.
+				results, err = conn.Speedtest(ctx, direction, time.Second)
+				require.NoError(t, err)
+				require.Len(t, results, 1)
+				var bytes int64
+				for _, result := range results {
+					require.Less(t, result.Start, result.End)
+					bytes += result.Bytes
+				}
+				require.Positive(t, bytes)
+				require.Positive(t, results[0].MBitsPerSecond)
+			})
+		}
+
+		t.Run("Cancel", func(t *testing.T) {
+			t.Parallel()
+			ended := newMessageSink("speedtest ended")
+			conn := setupAgentWithOptions(t, agent.Metadata{}, &agent.Options{}, ended)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			time.AfterFunc(100*time.Millisecond, cancel)
+			started := time.Now()
+			_, err := conn.Speedtest(ctx, agent.SpeedtestDownload, time.Minute)
+			require.ErrorIs(t, err, context.Canceled)
+			require.Less(t, time.Since(started), testutil.WaitShort)
+
+			// The agent stops sending instead of waiting out the duration.
+			select {
+			case <-ended.logged:
+			case <-time.After(testutil.WaitShort):
+				t.Fatal("agent didn't end the speedtest")
+			}
+		})
+	})
+
+	t.Run("ListeningPorts", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS != "linux" {
+			t.Skip("Listening ports are only supported on Linux.")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+		port := uint16(listener.Addr().(*net.TCPAddr).Port)
+		conn := setupAgent(t, agent.Metadata{}, 0)
+
+		// The agent runs in the test process, so the listener is its own.
+		ports, err := conn.ListeningPorts(ctx, false)
+		require.NoError(t, err)
+		for _, listening := range ports {
+			require.NotEqual(t, port, listening.Port)
+			require.GreaterOrEqual(t, listening.Port, uint16(1024))
+		}
+
+		comm, err := os.ReadFile("/proc/self/comm")
+		require.NoError(t, err)
+		ports, err = conn.ListeningPorts(ctx, true)
+		require.NoError(t, err)
+		require.Contains(t, ports, agent.ListeningPort{
+			Port:        port,
+			ProcessName: strings.TrimSpace(string(comm)),
+			PID:         os.Getpid(),
+		})
+	})
+
+	t.Run("Diagnostics", func(t *testing.T) {
+		t.Parallel()
+		reports := make(chan agent.Diagnostics, 1)
+		setupAgentWithOptions(t, agent.Metadata{}, &agent.Options{
+			PostDiagnostics: func(_ context.Context, diagnostics agent.Diagnostics) error {
+				select {
+				case reports <- diagnostics:
+				default:
+				}
+				return nil
+			},
+		})
+		var diagnostics agent.Diagnostics
+		select {
+		case diagnostics = <-reports:
+		case <-time.After(testutil.WaitLong):
+			t.Fatal("timed out waiting for diagnostics")
+		}
+		require.Greater(t, diagnostics.Uptime, time.Duration(0))
+		require.Contains(t, []agent.ClockSync{agent.ClockSyncSynced, agent.ClockSyncUnsynced, agent.ClockSyncUnknown}, diagnostics.ClockSync)
+		// Every host has a loopback interface.
+		var loopback bool
+		for _, iface := range diagnostics.Interfaces {
+			for _, flag := range iface.Flags {
+				loopback = loopback || flag == "loopback"
+			}
+		}
+		require.True(t, loopback, "loopback interface wasn't reported")
+	})
+
+	t.Run("NetworkReport", func(t *testing.T) {
+		t.Parallel()
+		publicAddr := &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 41641}
+		report := setupAgentNetworkReport(t, serveSTUN(t, publicAddr), serveSTUN(t, publicAddr))
+		require.Equal(t, "203.0.113.7", report.PublicIP)
+		require.Equal(t, agent.NATTypeEndpointIndependent, report.NATType)
+	})
+
+	t.Run("NetworkReportEndpointDependent", func(t *testing.T) {
+		t.Parallel()
+		// Symmetric NATs map each destination to a different port.
+		report := setupAgentNetworkReport(t,
+			serveSTUN(t, &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 41641}),
+			serveSTUN(t, &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 41642}),
+		)
+		require.Equal(t, "203.0.113.7", report.PublicIP)
+		require.Equal(t, agent.NATTypeEndpointDependent, report.NATType)
+	})
+
+	t.Run("NetworkReportUnreachable", func(t *testing.T) {
+		t.Parallel()
+		// Nothing listens here, so the only server never responds and
+		// the other is used alone.
+		unreachable, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		address := unreachable.LocalAddr().String()
+		_ = unreachable.Close()
+		report := setupAgentNetworkReport(t, address, serveSTUN(t, &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 41641}))
+		require.Equal(t, "203.0.113.7", report.PublicIP)
+		require.Equal(t, agent.NATTypeUnknown, report.NATType)
+	})
+}
+
+// setupAgentNetworkReport starts an agent with the STUN servers provided
+// and waits for its first network report.
+func setupAgentNetworkReport(t *testing.T, stunServers ...string) agent.NetworkReport {
+	reports := make(chan agent.NetworkReport, 1)
+	setupAgentWithOptions(t, agent.Metadata{
+		STUNServers: stunServers,
+	}, &agent.Options{
+		PostNetworkReport: func(_ context.Context, report agent.NetworkReport) error {
+			select {
+			case reports <- report:
+			default:
+			}
+			return nil
+		},
+	})
+	select {
+	case report := <-reports:
+		return report
+	case <-time.After(testutil.WaitLong):
+		t.Fatal("timed out waiting for network report")
+		return agent.NetworkReport{}
+	}
+}
+
+// serveSTUN answers binding requests as if they came from mapped.
+func serveSTUN(t *testing.T, mapped *net.UDPAddr) string {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			request := &stun.Message{Raw: append([]byte{}, buffer[:n]...)}
+			if request.Decode() != nil {
+				continue
+			}
+			response, err := stun.Build(request, stun.BindingSuccess, &stun.XORMappedAddress{
+				IP:   mapped.IP,
+				Port: mapped.Port,
+			}, stun.Fingerprint)
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteTo(response.Raw, from)
+		}
+	}()
+	return conn.LocalAddr().String()
 }
 
 func setupSSHCommand(t *testing.T, beforeArgs []string, afterArgs []string) *exec.Cmd {
@@ -506,19 +1281,53 @@ func setupSSHSession(t *testing.T, options agent.Metadata) *ssh.Session {
 }
 
 func setupAgent(t *testing.T, metadata agent.Metadata, ptyTimeout time.Duration) *agent.Conn {
+	return setupAgentWithOptions(t, metadata, &agent.Options{
+		ReconnectingPTYTimeout: ptyTimeout,
+	})
+}
+
+func setupAgentWithOptions(t *testing.T, metadata agent.Metadata, options *agent.Options, sinks ...slog.Sink) *agent.Conn {
+	options.Logger = slogtest.Make(t, nil).Leveled(slog.LevelDebug).AppendSinks(sinks...)
 	client, server := provisionersdk.TransportPipe()
 	closer := agent.New(func(ctx context.Context, logger slog.Logger) (agent.Metadata, *peerbroker.Listener, error) {
 		listener, err := peerbroker.Listen(server, nil)
 		return metadata, listener, err
-	}, &agent.Options{
-		Logger:                 slogtest.Make(t, nil).Leveled(slog.LevelDebug),
-		ReconnectingPTYTimeout: ptyTimeout,
-	})
+	}, options)
 	t.Cleanup(func() {
 		_ = client.Close()
 		_ = server.Close()
 		_ = closer.Close()
 	})
+	return dialAgent(t, client)
+}
+
+// setupLegacyAgent serves a peer connection like agents that predate
+// capabilities: channels of protocols it doesn't know are left open.
+func setupLegacyAgent(t *testing.T) *agent.Conn {
+	client, server := provisionersdk.TransportPipe()
+	listener, err := peerbroker.Listen(server, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+		_ = listener.Close()
+	})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		for {
+			_, err := conn.Accept(context.Background())
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return dialAgent(t, client)
+}
+
+func dialAgent(t *testing.T, client *yamux.Session) *agent.Conn {
 	api := proto.NewDRPCPeerBrokerClient(provisionersdk.Conn(client))
 	stream, err := api.NegotiateConnection(context.Background())
 	assert.NoError(t, err)
@@ -536,6 +1345,68 @@ func setupAgent(t *testing.T, metadata agent.Metadata, ptyTimeout time.Duration)
 	}
 }
 
+// messageSink closes logged once the agent logs the message.
+type messageSink struct {
+	message string
+	once    sync.Once
+	logged  chan struct{}
+}
+
+func newMessageSink(message string) *messageSink {
+	return &messageSink{
+		message: message,
+		logged:  make(chan struct{}),
+	}
+}
+
+func (s *messageSink) LogEntry(_ context.Context, e slog.SinkEntry) {
+	if e.Message == s.message {
+		s.once.Do(func() {
+			close(s.logged)
+		})
+	}
+}
+
+func (*messageSink) Sync() {}
+
+// expectPTYEcho runs echo in a reconnecting PTY and waits for the output.
+func expectPTYEcho(t *testing.T, conn net.Conn, text string) {
+	t.Helper()
+	// Brief pause to reduce the likelihood that we send keystrokes while
+	// the shell is simultaneously sending a prompt.
+	time.Sleep(100 * time.Millisecond)
+	data, err := json.Marshal(agent.ReconnectingPTYRequest{
+		Data: "echo " + text + "\r\n",
+	})
+	require.NoError(t, err)
+	_, err = conn.Write(data)
+	require.NoError(t, err)
+
+	bufRead := bufio.NewReader(conn)
+	for {
+		line, err := bufRead.ReadString('\n')
+		require.NoError(t, err)
+		if strings.Contains(line, text) && !strings.Contains(line, "echo") {
+			return
+		}
+	}
+}
+
+// expectPTYClosed waits for the agent to close a reconnecting PTY.
+func expectPTYClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(testutil.WaitLong):
+		t.Fatal("timed out waiting for reconnecting pty to close")
+	}
+}
+
 var dialTestPayload = []byte("dean-was-here123")
 
 func testDial(t *testing.T, c net.Conn) {
@@ -566,3 +1437,91 @@ func assertWritePayload(t *testing.T, w io.Writer, payload []byte) {
 	assert.NoError(t, err, "write payload")
 	assert.Equal(t, len(payload), n, "payload length does not match")
 }
+
+func TestSSHKeepalive(t *testing.T) {
+	t.Parallel()
+	t.Run("Unresponsive", func(t *testing.T) {
+		t.Parallel()
+		// The server never replies to requests, like a frozen workspace.
+		client := setupSSHKeepaliveClient(t, func(requests <-chan *ssh.Request) {
+			for request := range requests {
+				_ = request
+			}
+		})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			agent.SSHKeepalive(client, 10*time.Millisecond, 3)
+		}()
+		select {
+		case <-done:
+		case <-time.After(testutil.WaitShort):
+			t.Fatal("keepalive didn't close the client")
+		}
+		require.Error(t, client.Wait())
+	})
+
+	t.Run("Responsive", func(t *testing.T) {
+		t.Parallel()
+		client := setupSSHKeepaliveClient(t, ssh.DiscardRequests)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			agent.SSHKeepalive(client, 10*time.Millisecond, 3)
+		}()
+		select {
+		case <-done:
+			t.Fatal("keepalive closed a responsive client")
+		case <-time.After(100 * time.Millisecond):
+		}
+		_ = client.Close()
+		<-done
+	})
+}
+
+// setupSSHKeepaliveClient connects a client to an in-memory SSH server
+// that handles global requests with the function provided.
+func setupSSHKeepaliveClient(t *testing.T, handleRequests func(<-chan *ssh.Request)) *ssh.Client {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	require.NoError(t, err)
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		netConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer netConn.Close()
+		_, channels, requests, err := ssh.NewServerConn(netConn, serverConfig)
+		if err != nil {
+			return
+		}
+		go func() {
+			for channel := range channels {
+				_ = channel.Reject(ssh.Prohibited, "")
+			}
+		}()
+		handleRequests(requests)
+	}()
+
+	netConn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	sshConn, channels, requests, err := ssh.NewClientConn(netConn, "localhost:22", &ssh.ClientConfig{
+		// #nosec
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.NoError(t, err)
+	client := ssh.NewClient(sshConn, channels, requests)
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+
+	"golang.org/x/xerrors"
+
+	"cdr.dev/slog"
+	"github.com/coder/coder/peer"
+)
+
+// CapabilitiesVersion is incremented whenever the agent gains a capability,
+// so clients can compare against it for features that aren't protocols.
+const CapabilitiesVersion = 5
+
+// Capabilities describe what an agent supports, for clients to check before
+// using a feature.
+type Capabilities struct {
+	Version int `json:"version"`
+	// Protocols are the channel protocols the agent serves. Protocols
+	// disabled by the metadata are excluded.
+	Protocols []string `json:"protocols"`
+	// Operations are what clients can do over the connection, like
+	// OperationCopy. Operations of disabled protocols are excluded.
+	Operations []string `json:"operations"`
+}
+
+// Capabilities asks the agent what it supports. Agents that predate
+// capabilities never respond, so the context should have a deadline.
+func (c *Conn) Capabilities(ctx context.Context) (Capabilities, error) {
+	channel, err := c.CreateChannel(ctx, "", &peer.ChannelOptions{
+		Protocol: ProtocolCapabilities,
+	})
+	if err != nil {
+		return Capabilities{}, xerrors.Errorf("create datachannel: %w", err)
+	}
+	netConn := channel.NetConn()
+	defer netConn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = netConn.Close()
+		case <-done:
+		}
+	}()
+	var capabilities Capabilities
+	err = json.NewDecoder(netConn).Decode(&capabilities)
+	if err != nil {
+		if ctx.Err() != nil {
+			return Capabilities{}, ctx.Err()
+		}
+		return Capabilities{}, xerrors.Errorf("decode capabilities: %w", err)
+	}
+	return capabilities, nil
+}
+
+func (a *agent) handleCapabilities(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	capabilities := Capabilities{
+		Version:    CapabilitiesVersion,
+		Protocols:  []string{},
+		Operations: []string{OperationPing, OperationCapabilities},
+	}
+	for _, p := range protocols {
+		if !a.protocolDisabled(p.protocol) {
+			capabilities.Protocols = append(capabilities.Protocols, p.protocol)
+			capabilities.Operations = append(capabilities.Operations, p.operations...)
+		}
+	}
+	err := json.NewEncoder(conn).Encode(capabilities)
+	if err != nil {
+		a.logger.Debug(ctx, "write capabilities", slog.Error(err))
+	}
+}
@@ -1,12 +1,16 @@
 package agent
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/xerrors"
@@ -28,27 +32,191 @@ type ReconnectingPTYRequest struct {
 type Conn struct {
 	// Negotiator is responsible for exchanging messages.
 	Negotiator proto.DRPCPeerBrokerClient
+	// SessionID is optional and is sent with SSH connections. The agent
+	// logs it for everything related to them, to correlate them with the
+	// caller's logs.
+	SessionID string
 
 	*peer.Conn
+
+	// ptyInitSupported is whether the agent decodes every
+	// ReconnectingPTYInit field, once ptyInitKnown.
+	ptyInitMutex     sync.Mutex
+	ptyInitKnown     bool
+	ptyInitSupported bool
+}
+
+// ReconnectingPTYInit configures a connection to a reconnecting PTY.
+type ReconnectingPTYInit struct {
+	// ID identifies the PTY, so it can be reconnected to.
+	ID     string `json:"id"`
+	Height uint16 `json:"height"`
+	Width  uint16 `json:"width"`
+	// ReplayLimit caps how many bytes of recent output are replayed on
+	// reconnect. Zero replays as much as the agent allows.
+	ReplayLimit int `json:"replay_limit,omitempty"`
+	// SessionID is optional and is logged by the agent for everything
+	// related to this connection, to correlate it with the caller's logs.
+	SessionID string `json:"session_id,omitempty"`
+	// Takeover detaches every other connection to the PTY, and gives this
+	// one the write lock and all of the scrollback. It's for continuing a
+	// session on another device.
+	Takeover bool `json:"takeover,omitempty"`
+	// Handshake asks the agent to report whether this connection got the
+	// write lock in a line ahead of any output. ReconnectingPTYConn.Writer
+	// reads it.
+	Handshake bool `json:"handshake,omitempty"`
+	// Command is optional and defaults to start a shell.
+	Command string `json:"command,omitempty"`
+}
+
+// label returns the channel label for the init. Inits that only use the
+// original fields are encoded as "<id>:<height>:<width>:<command>", which
+// every agent understands. Others are encoded as JSON.
+func (ptyInit ReconnectingPTYInit) label() (string, error) {
+	if ptyInit.ReplayLimit == 0 && ptyInit.SessionID == "" && !ptyInit.Takeover && !ptyInit.Handshake {
+		return fmt.Sprintf("%s:%d:%d:%s", ptyInit.ID, ptyInit.Height, ptyInit.Width, ptyInit.Command), nil
+	}
+	label, err := json.Marshal(ptyInit)
+	if err != nil {
+		return "", err
+	}
+	return string(label), nil
+}
+
+// parseReconnectingPTYLabel decodes a label encoded by
+// ReconnectingPTYInit.label.
+func parseReconnectingPTYLabel(label string) (ReconnectingPTYInit, error) {
+	var ptyInit ReconnectingPTYInit
+	if strings.HasPrefix(label, "{") {
+		err := json.Unmarshal([]byte(label), &ptyInit)
+		if err != nil {
+			return ptyInit, xerrors.Errorf("decode: %w", err)
+		}
+		return ptyInit, nil
+	}
+	parts := strings.SplitN(label, ":", 4)
+	if len(parts) != 4 {
+		return ptyInit, xerrors.New("invalid format")
+	}
+	height, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return ptyInit, xerrors.Errorf("parse height: %w", err)
+	}
+	width, err := strconv.ParseUint(parts[2], 10, 16)
+	if err != nil {
+		return ptyInit, xerrors.Errorf("parse width: %w", err)
+	}
+	ptyInit.ID = parts[0]
+	ptyInit.Height = uint16(height)
+	ptyInit.Width = uint16(width)
+	ptyInit.Command = parts[3]
+	return ptyInit, nil
+}
+
+// SupportsReconnectingPTYInit reports whether the agent decodes every
+// ReconnectingPTYInit field. Agents that predate them only parse labels in
+// the "<id>:<height>:<width>:<command>" format. They predate capabilities
+// too and never answer, so the context should have a deadline. Reaching it
+// is remembered for the connection.
+func (c *Conn) SupportsReconnectingPTYInit(ctx context.Context) bool {
+	c.ptyInitMutex.Lock()
+	defer c.ptyInitMutex.Unlock()
+	if c.ptyInitKnown {
+		return c.ptyInitSupported
+	}
+	_, err := c.Capabilities(ctx)
+	switch {
+	case err == nil:
+		c.ptyInitKnown = true
+		c.ptyInitSupported = true
+	case xerrors.Is(err, context.DeadlineExceeded):
+		c.ptyInitKnown = true
+	}
+	return c.ptyInitSupported
 }
 
 // ReconnectingPTY returns a connection serving a TTY that can
 // be reconnected to via ID.
-//
-// The command is optional and defaults to start a shell.
-func (c *Conn) ReconnectingPTY(id string, height, width uint16, command string) (net.Conn, error) {
-	channel, err := c.CreateChannel(context.Background(), fmt.Sprintf("%s:%d:%d:%s", id, height, width, command), &peer.ChannelOptions{
+func (c *Conn) ReconnectingPTY(ptyInit ReconnectingPTYInit) (*ReconnectingPTYConn, error) {
+	label, err := ptyInit.label()
+	if err != nil {
+		return nil, xerrors.Errorf("encode init: %w", err)
+	}
+	channel, err := c.CreateChannel(context.Background(), label, &peer.ChannelOptions{
 		Protocol: ProtocolReconnectingPTY,
 	})
 	if err != nil {
 		return nil, xerrors.Errorf("pty: %w", err)
 	}
-	return channel.NetConn(), nil
+	netConn := channel.NetConn()
+	return &ReconnectingPTYConn{
+		Conn:      netConn,
+		reader:    bufio.NewReader(netConn),
+		handshake: ptyInit.Handshake,
+	}, nil
+}
+
+// ReconnectingPTYConn is a connection to a reconnecting PTY. If the
+// handshake was requested, it's consumed before any output is read.
+type ReconnectingPTYConn struct {
+	net.Conn
+
+	reader        *bufio.Reader
+	handshake     bool
+	handshakeOnce sync.Once
+	handshakeErr  error
+	writer        bool
+}
+
+// Writer returns whether the connection got the write lock of the PTY when
+// it attached. Input from other connections to the same PTY is ignored
+// until the writer disconnects. It requires ReconnectingPTYInit.Handshake.
+func (c *ReconnectingPTYConn) Writer() (bool, error) {
+	if !c.handshake {
+		return false, xerrors.New("the handshake wasn't requested")
+	}
+	c.readHandshake()
+	if c.handshakeErr != nil {
+		return false, xerrors.Errorf("read handshake: %w", c.handshakeErr)
+	}
+	return c.writer, nil
+}
+
+func (c *ReconnectingPTYConn) Read(b []byte) (int, error) {
+	if c.handshake {
+		c.readHandshake()
+		if c.handshakeErr != nil {
+			return 0, c.handshakeErr
+		}
+	}
+	return c.reader.Read(b)
+}
+
+func (c *ReconnectingPTYConn) readHandshake() {
+	c.handshakeOnce.Do(func() {
+		line, err := c.reader.ReadBytes('\n')
+		if err != nil {
+			c.handshakeErr = err
+			return
+		}
+		var handshake reconnectingPTYHandshake
+		err = json.Unmarshal(line, &handshake)
+		if err != nil {
+			c.handshakeErr = xerrors.Errorf("decode: %w", err)
+			return
+		}
+		c.writer = handshake.Writer
+	})
 }
 
 // SSH dials the built-in SSH server.
 func (c *Conn) SSH() (net.Conn, error) {
-	channel, err := c.CreateChannel(context.Background(), "ssh", &peer.ChannelOptions{
+	label := "ssh"
+	if c.SessionID != "" {
+		label += ":" + c.SessionID
+	}
+	channel, err := c.CreateChannel(context.Background(), label, &peer.ChannelOptions{
 		Protocol: ProtocolSSH,
 	})
 	if err != nil {
@@ -76,6 +244,56 @@ func (c *Conn) SSHClient() (*ssh.Client, error) {
 	return ssh.NewClient(sshConn, channels, requests), nil
 }
 
+// SSHKeepalive sends a keepalive request over the client every interval,
+// closing the client once maxMissed requests in a row go unanswered. A
+// workspace that freezes rather than disconnecting would otherwise hang
+// the session until the OS gives up on the connection. It returns when
+// the client is closed.
+func SSHKeepalive(client *ssh.Client, interval time.Duration, maxMissed int) {
+	closed := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		close(closed)
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var (
+		pending chan error
+		missed  int
+	)
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+		if pending != nil {
+			select {
+			case err := <-pending:
+				if err != nil {
+					return
+				}
+				missed = 0
+			default:
+				missed++
+				if missed >= maxMissed {
+					_ = client.Close()
+					return
+				}
+				continue
+			}
+		}
+		// Replies may be negative for servers that don't know the
+		// request, but any reply proves the server is alive.
+		pending = make(chan error, 1)
+		go func(pending chan<- error) {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			pending <- err
+		}(pending)
+	}
+}
+
 // DialContext dials an arbitrary protocol+address from inside the workspace and
 // proxies it through the provided net.Conn.
 func (c *Conn) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
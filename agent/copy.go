@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/xerrors"
+)
+
+// CopyProgress is called as a file copy makes progress. Total is -1 when
+// the size of the source is unknown.
+type CopyProgress func(done, total int64)
+
+// CopyOptions configures a file copy to or from a workspace.
+type CopyOptions struct {
+	// Offset is the number of bytes to skip at the start of the copy.
+	// The number of bytes returned from an interrupted copy can be
+	// passed here to resume it.
+	Offset int64
+	// Progress is optional and is called after each chunk is written.
+	Progress CopyProgress
+}
+
+// CopyToWorkspace writes src to the path provided inside the workspace over
+// SFTP. Size is used to report progress and may be -1 if unknown. The total
+// number of bytes at the destination is returned, even on error, so an
+// aborted copy can be resumed with CopyOptions.Offset.
+//
+// The copy is aborted when the context is canceled.
+func (c *Conn) CopyToWorkspace(ctx context.Context, src io.Reader, size int64, path string, options CopyOptions) (int64, error) {
+	client, closeClient, err := c.sftpClient(ctx)
+	if err != nil {
+		return options.Offset, err
+	}
+	defer closeClient()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if options.Offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	file, err := client.OpenFile(path, flags)
+	if err != nil {
+		return options.Offset, xerrors.Errorf("open %q: %w", path, err)
+	}
+	defer file.Close()
+
+	if options.Offset > 0 {
+		err = skipReader(src, options.Offset)
+		if err != nil {
+			return options.Offset, xerrors.Errorf("skip source to offset: %w", err)
+		}
+		_, err = file.Seek(options.Offset, io.SeekStart)
+		if err != nil {
+			return options.Offset, xerrors.Errorf("seek %q: %w", path, err)
+		}
+	}
+
+	done, err := copyWithProgress(ctx, file, src, options.Offset, size, options.Progress)
+	if err != nil {
+		return done, err
+	}
+	return done, file.Close()
+}
+
+// CopyFromWorkspace writes the file at the path provided inside the
+// workspace to dst over SFTP. The total number of bytes read from the
+// source is returned, even on error, so an aborted copy can be resumed
+// with CopyOptions.Offset.
+//
+// The copy is aborted when the context is canceled.
+func (c *Conn) CopyFromWorkspace(ctx context.Context, path string, dst io.Writer, options CopyOptions) (int64, error) {
+	client, closeClient, err := c.sftpClient(ctx)
+	if err != nil {
+		return options.Offset, err
+	}
+	defer closeClient()
+
+	file, err := client.Open(path)
+	if err != nil {
+		return options.Offset, xerrors.Errorf("open %q: %w", path, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return options.Offset, xerrors.Errorf("stat %q: %w", path, err)
+	}
+	if options.Offset > 0 {
+		_, err = file.Seek(options.Offset, io.SeekStart)
+		if err != nil {
+			return options.Offset, xerrors.Errorf("seek %q: %w", path, err)
+		}
+	}
+
+	return copyWithProgress(ctx, dst, file, options.Offset, info.Size(), options.Progress)
+}
+
+// sftpClient opens an SFTP client that is closed when the context is
+// canceled. This interrupts any in-flight reads or writes.
+func (c *Conn) sftpClient(ctx context.Context) (*sftp.Client, func(), error) {
+	sshClient, err := c.SSHClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close()
+		return nil, nil, xerrors.Errorf("sftp: %w", err)
+	}
+	closed := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-closed:
+		}
+		_ = client.Close()
+		_ = sshClient.Close()
+	}()
+	return client, func() { close(closed) }, nil
+}
+
+func copyWithProgress(ctx context.Context, dst io.Writer, src io.Reader, done, total int64, progress CopyProgress) (int64, error) {
+	buffer := make([]byte, 32<<10)
+	for {
+		if ctx.Err() != nil {
+			return done, ctx.Err()
+		}
+		read, readErr := src.Read(buffer)
+		if read > 0 {
+			written, err := dst.Write(buffer[:read])
+			done += int64(written)
+			if err != nil {
+				if ctx.Err() != nil {
+					return done, ctx.Err()
+				}
+				return done, xerrors.Errorf("write: %w", err)
+			}
+			if progress != nil {
+				progress(done, total)
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			return done, nil
+		}
+		if readErr != nil {
+			if ctx.Err() != nil {
+				return done, ctx.Err()
+			}
+			return done, xerrors.Errorf("read: %w", readErr)
+		}
+	}
+}
+
+// skipReader discards n bytes from the reader, seeking if possible.
+func skipReader(r io.Reader, n int64) error {
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekCurrent)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// processStartedAt approximates when the agent process started, since
+// package variables are initialized before main runs.
+var processStartedAt = time.Now()
+
+// ClockSync is whether the system clock of the agent is disciplined by a
+// time source like NTP.
+type ClockSync string
+
+const (
+	ClockSyncSynced   ClockSync = "synced"
+	ClockSyncUnsynced ClockSync = "unsynced"
+	// ClockSyncUnknown is reported when the platform doesn't expose the
+	// kernel's synchronization status.
+	ClockSyncUnknown ClockSync = "unknown"
+)
+
+// Diagnostics describes the agent process, independent of its connection
+// to coderd.
+type Diagnostics struct {
+	// Uptime is how long the agent process has been running. It's sent
+	// instead of a start time, so coderd can place it on its own clock.
+	Uptime     time.Duration      `json:"uptime"`
+	ClockSync  ClockSync          `json:"clock_sync"`
+	Interfaces []NetworkInterface `json:"interfaces"`
+}
+
+// NetworkInterface is a network interface of the workspace, which helps
+// explain how the agent routes to coderd and DERP.
+type NetworkInterface struct {
+	Name string `json:"name"`
+	// Addresses are in CIDR notation, like "10.0.0.2/24".
+	Addresses []string `json:"addresses"`
+	MTU       int      `json:"mtu"`
+	// Flags are the names of net.Flags set, like "up" or "loopback".
+	Flags []string `json:"flags"`
+}
+
+func networkInterfaces() ([]NetworkInterface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, xerrors.Errorf("list interfaces: %w", err)
+	}
+	networkInterfaces := make([]NetworkInterface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, xerrors.Errorf("list addresses of %q: %w", iface.Name, err)
+		}
+		networkInterface := NetworkInterface{
+			Name:      iface.Name,
+			Addresses: make([]string, 0, len(addrs)),
+			MTU:       iface.MTU,
+			Flags:     []string{},
+		}
+		for _, addr := range addrs {
+			networkInterface.Addresses = append(networkInterface.Addresses, addr.String())
+		}
+		if iface.Flags != 0 {
+			networkInterface.Flags = strings.Split(iface.Flags.String(), "|")
+		}
+		networkInterfaces = append(networkInterfaces, networkInterface)
+	}
+	return networkInterfaces, nil
+}
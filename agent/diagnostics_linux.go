@@ -0,0 +1,20 @@
+package agent
+
+import "golang.org/x/sys/unix"
+
+// timeError is TIME_ERROR from <sys/timex.h>, returned by adjtimex while
+// the clock isn't synchronized.
+const timeError = 5
+
+func clockSync() ClockSync {
+	// Zero modes only reads the kernel's clock state, so no privileges
+	// are required.
+	state, err := unix.Adjtimex(&unix.Timex{})
+	if err != nil {
+		return ClockSyncUnknown
+	}
+	if state == timeError {
+		return ClockSyncUnsynced
+	}
+	return ClockSyncSynced
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package agent
+
+func clockSync() ClockSync {
+	return ClockSyncUnknown
+}
@@ -0,0 +1,310 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os/exec"
+	"runtime"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"cdr.dev/slog"
+	"github.com/coder/coder/peer"
+)
+
+// Frames of the exec protocol are a type byte and a big-endian uint32
+// payload length, followed by the payload.
+const (
+	// Sent by the client.
+	execFrameStdin byte = iota + 1
+	execFrameStdinEOF
+	// Sent by the agent. The exit frame is always last.
+	execFrameStdout
+	execFrameStderr
+	execFrameExit
+
+	// execMaxFrameSize bounds the payload of a single frame, keeping each
+	// within a datachannel message. It also stops a peer from making the
+	// other allocate unboundedly.
+	execMaxFrameSize = 32 * 1024
+)
+
+// execRequest is the label of channels with protocol "exec".
+type execRequest struct {
+	Command []string `json:"command"`
+}
+
+// execExit is the payload of the exit frame. Error is set when the command
+// couldn't be run at all.
+type execExit struct {
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Exec runs a command in the workspace without a PTY, and returns its
+// output once it exits. Stdin is optional. The output is buffered in
+// memory, so use ExecStream for commands with large output.
+func (c *Conn) Exec(ctx context.Context, cmd []string, stdin io.Reader) (stdout, stderr []byte, exitCode int, err error) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+	exitCode, err = c.ExecStream(ctx, cmd, stdin, &stdoutBuf, &stderrBuf)
+	return stdoutBuf.Bytes(), stderrBuf.Bytes(), exitCode, err
+}
+
+// ExecStream runs a command in the workspace without a PTY, writing its
+// output to stdout and stderr as it's produced. Any of stdin, stdout and
+// stderr may be nil. The command is killed when the context is canceled.
+// Stdin is closed when ExecStream returns if it's an io.Closer, since the
+// command no longer reads it.
+//
+// The exit code is returned when the command ran. An error means it
+// couldn't be run, or the connection to it was lost.
+func (c *Conn) ExecStream(ctx context.Context, cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if len(cmd) == 0 {
+		return 0, xerrors.New("command is empty")
+	}
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	label, err := json.Marshal(execRequest{
+		Command: cmd,
+	})
+	if err != nil {
+		return 0, xerrors.Errorf("marshal request: %w", err)
+	}
+	channel, err := c.CreateChannel(ctx, string(label), &peer.ChannelOptions{
+		Protocol: ProtocolExec,
+	})
+	if err != nil {
+		return 0, xerrors.Errorf("create datachannel: %w", err)
+	}
+	netConn := channel.NetConn()
+	defer netConn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// Closing the channel is what kills the command.
+		select {
+		case <-ctx.Done():
+			_ = netConn.Close()
+		case <-done:
+		}
+	}()
+	if closer, ok := stdin.(io.Closer); ok {
+		// Unblocks the copy below if it's waiting on a read.
+		defer closer.Close()
+	}
+	go func() {
+		if stdin != nil {
+			buffer := make([]byte, execMaxFrameSize)
+			for {
+				read, err := stdin.Read(buffer)
+				select {
+				case <-ctx.Done():
+					return
+				case <-done:
+					return
+				default:
+				}
+				if read > 0 {
+					err := writeExecFrame(netConn, execFrameStdin, buffer[:read])
+					if err != nil {
+						return
+					}
+				}
+				if err != nil {
+					break
+				}
+			}
+		}
+		_ = writeExecFrame(netConn, execFrameStdinEOF, nil)
+	}()
+
+	for {
+		frameType, payload, err := readExecFrame(netConn)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			return 0, xerrors.Errorf("read frame: %w", err)
+		}
+		switch frameType {
+		case execFrameStdout:
+			_, err = stdout.Write(payload)
+		case execFrameStderr:
+			_, err = stderr.Write(payload)
+		case execFrameExit:
+			var exit execExit
+			err = json.Unmarshal(payload, &exit)
+			if err != nil {
+				return 0, xerrors.Errorf("decode exit: %w", err)
+			}
+			if exit.Error != "" {
+				return 0, xerrors.Errorf("remote exec error: %s", exit.Error)
+			}
+			return exit.ExitCode, nil
+		default:
+			return 0, xerrors.Errorf("unexpected frame type %d", frameType)
+		}
+		if err != nil {
+			return 0, xerrors.Errorf("write output: %w", err)
+		}
+	}
+}
+
+func (a *agent) handleExec(ctx context.Context, label string, conn net.Conn) {
+	defer conn.Close()
+
+	// Writes come from the stdout and stderr copies concurrently.
+	var writeMutex sync.Mutex
+	writeFrame := func(frameType byte, payload []byte) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		return writeExecFrame(conn, frameType, payload)
+	}
+	writeExit := func(exit execExit) {
+		payload, err := json.Marshal(exit)
+		if err != nil {
+			a.logger.Warn(ctx, "marshal exec exit", slog.Error(err))
+			return
+		}
+		_ = writeFrame(execFrameExit, payload)
+	}
+
+	var req execRequest
+	err := json.Unmarshal([]byte(label), &req)
+	if err != nil {
+		writeExit(execExit{Error: xerrors.Errorf("decode request: %w", err).Error()})
+		return
+	}
+	if len(req.Command) == 0 {
+		writeExit(execExit{Error: "command is empty"})
+		return
+	}
+
+	// The command is killed if the client goes away.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd, err := a.createExecCommand(ctx, req.Command)
+	if err != nil {
+		writeExit(execExit{Error: err.Error()})
+		return
+	}
+	cmd.Stdout = execFrameWriter{frameType: execFrameStdout, writeFrame: writeFrame}
+	cmd.Stderr = execFrameWriter{frameType: execFrameStderr, writeFrame: writeFrame}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		writeExit(execExit{Error: xerrors.Errorf("create stdin pipe: %w", err).Error()})
+		return
+	}
+	err = cmd.Start()
+	if err != nil {
+		writeExit(execExit{Error: xerrors.Errorf("start command: %w", err).Error()})
+		return
+	}
+	go func() {
+		defer stdin.Close()
+		for {
+			frameType, payload, err := readExecFrame(conn)
+			if err != nil {
+				cancel()
+				return
+			}
+			switch frameType {
+			case execFrameStdin:
+				// The command may exit without reading its input.
+				_, _ = stdin.Write(payload)
+			case execFrameStdinEOF:
+				_ = stdin.Close()
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		writeExit(execExit{ExitCode: exitErr.ExitCode()})
+		return
+	}
+	if err != nil {
+		writeExit(execExit{Error: xerrors.Errorf("run command: %w", err).Error()})
+		return
+	}
+	writeExit(execExit{})
+}
+
+// createExecCommand runs the arguments provided as-is, rather than as a
+// line interpreted by the user's shell.
+func (a *agent) createExecCommand(ctx context.Context, args []string) (*exec.Cmd, error) {
+	cmd, err := a.createCommand(ctx, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS == "windows" {
+		cmd.Args = append(cmd.Args[:2], args...)
+		return cmd, nil
+	}
+	// The user's shell may not be POSIX, but /bin/sh resolves the
+	// executable on the workspace's PATH all the same.
+	cmd.Path = "/bin/sh"
+	cmd.Args = append([]string{"/bin/sh", "-c", `exec "$0" "$@"`}, args...)
+	return cmd, nil
+}
+
+// execFrameWriter splits output into frames of a single type.
+type execFrameWriter struct {
+	frameType  byte
+	writeFrame func(frameType byte, payload []byte) error
+}
+
+func (w execFrameWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + execMaxFrameSize
+		if end > len(p) {
+			end = len(p)
+		}
+		err := w.writeFrame(w.frameType, p[written:end])
+		if err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+func writeExecFrame(w io.Writer, frameType byte, payload []byte) error {
+	frame := make([]byte, 5+len(payload))
+	frame[0] = frameType
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	copy(frame[5:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readExecFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:5])
+	if size > execMaxFrameSize {
+		return 0, nil, xerrors.Errorf("frame of %d bytes exceeds the maximum of %d", size, execMaxFrameSize)
+	}
+	payload := make([]byte, size)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
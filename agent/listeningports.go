@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"sort"
+
+	"golang.org/x/xerrors"
+
+	"cdr.dev/slog"
+	"github.com/coder/coder/peer"
+)
+
+// ListeningPort is a TCP port a process in the workspace listens on.
+type ListeningPort struct {
+	Port uint16 `json:"port"`
+	// ProcessName and PID are empty when the owner of the socket can't be
+	// read, like when it belongs to another user.
+	ProcessName string `json:"process_name"`
+	PID         int    `json:"pid"`
+}
+
+// listeningPortsRequest is the label of channels with protocol
+// "listening-ports".
+type listeningPortsRequest struct {
+	IncludeAll bool `json:"include_all"`
+}
+
+// listeningPortsResponse is written to channels with protocol
+// "listening-ports".
+type listeningPortsResponse struct {
+	Ports []ListeningPort `json:"ports"`
+	Error string          `json:"error,omitempty"`
+}
+
+// ListeningPorts returns the TCP ports listened on in the workspace, sorted by
+// port. Privileged ports below 1024 and the agent's own ports are omitted
+// unless includeAll is set.
+func (c *Conn) ListeningPorts(ctx context.Context, includeAll bool) ([]ListeningPort, error) {
+	label, err := json.Marshal(listeningPortsRequest{
+		IncludeAll: includeAll,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("marshal request: %w", err)
+	}
+	channel, err := c.CreateChannel(ctx, string(label), &peer.ChannelOptions{
+		Protocol: ProtocolListeningPorts,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("create datachannel: %w", err)
+	}
+	netConn := channel.NetConn()
+	defer netConn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = netConn.Close()
+		case <-done:
+		}
+	}()
+	var response listeningPortsResponse
+	err = json.NewDecoder(netConn).Decode(&response)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, xerrors.Errorf("decode listening ports: %w", err)
+	}
+	if response.Error != "" {
+		return nil, xerrors.Errorf("remote listening ports error: %s", response.Error)
+	}
+	return response.Ports, nil
+}
+
+func (a *agent) handleListeningPorts(ctx context.Context, label string, conn net.Conn) {
+	defer conn.Close()
+
+	var (
+		request  listeningPortsRequest
+		response listeningPortsResponse
+	)
+	err := json.Unmarshal([]byte(label), &request)
+	if err != nil {
+		a.logger.Warn(ctx, "decode listening ports request", slog.F("label", label), slog.Error(err))
+		response.Error = "decode request: " + err.Error()
+	} else {
+		response.Ports, err = listeningPorts(request.IncludeAll)
+		if err != nil {
+			a.logger.Warn(ctx, "read listening ports", slog.Error(err))
+			response.Error = err.Error()
+		}
+	}
+	err = json.NewEncoder(conn).Encode(response)
+	if err != nil {
+		a.logger.Debug(ctx, "write listening ports", slog.Error(err))
+	}
+}
+
+// listeningPorts returns the listening ports of the workspace with one entry
+// per port, as IPv4 and IPv6 sockets often share one.
+func listeningPorts(includeAll bool) ([]ListeningPort, error) {
+	ports, err := readListeningPorts()
+	if err != nil {
+		return nil, err
+	}
+	agentPID := os.Getpid()
+	byPort := map[uint16]ListeningPort{}
+	for _, port := range ports {
+		if !includeAll && (port.Port < 1024 || port.PID == agentPID) {
+			continue
+		}
+		// Prefer the entry whose owner is known.
+		if existing, ok := byPort[port.Port]; ok && existing.PID != 0 {
+			continue
+		}
+		byPort[port.Port] = port
+	}
+	filtered := make([]ListeningPort, 0, len(byPort))
+	for _, port := range byPort {
+		filtered = append(filtered, port)
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		return filtered[i].Port < filtered[j].Port
+	})
+	return filtered, nil
+}
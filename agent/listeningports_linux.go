@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// tcpListen is the state of listening sockets in /proc/net/tcp.
+const tcpListen = "0A"
+
+// readListeningPorts returns the listening TCP sockets of the agent's network
+// namespace from /proc/net/tcp and /proc/net/tcp6, with the processes owning
+// them.
+func readListeningPorts() ([]ListeningPort, error) {
+	inodes := map[string]uint16{}
+	for _, name := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		err := readListeningSockets(name, inodes)
+		// IPv6 may be disabled.
+		if xerrors.Is(err, os.ErrNotExist) && name == "/proc/net/tcp6" {
+			continue
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("read %s: %w", name, err)
+		}
+	}
+
+	ports := make([]ListeningPort, 0, len(inodes))
+	owners := readSocketOwners(inodes)
+	for inode, port := range inodes {
+		listeningPort := ListeningPort{
+			Port: port,
+		}
+		if pid, ok := owners[inode]; ok {
+			listeningPort.PID = pid
+			listeningPort.ProcessName = readProcComm(pid)
+		}
+		ports = append(ports, listeningPort)
+	}
+	return ports, nil
+}
+
+// readListeningSockets adds the inode and port of each listening socket in
+// the file to inodes.
+func readListeningSockets(name string, inodes map[string]uint16) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	// Skip the header.
+	scanner.Scan()
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when
+		// retrnsmt uid timeout inode ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != tcpListen {
+			continue
+		}
+		colon := strings.LastIndexByte(fields[1], ':')
+		if colon < 0 {
+			continue
+		}
+		port, err := strconv.ParseUint(fields[1][colon+1:], 16, 16)
+		if err != nil {
+			continue
+		}
+		inodes[fields[9]] = uint16(port)
+	}
+	return scanner.Err()
+}
+
+// readSocketOwners returns the PIDs of processes with the socket inodes open.
+// Processes whose descriptors can't be read are skipped.
+func readSocketOwners(inodes map[string]uint16) map[string]int {
+	owners := map[string]int{}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return owners
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+			if _, ok := inodes[inode]; !ok {
+				continue
+			}
+			// Sockets can be shared by forked processes, so the lowest PID
+			// is kept as it's usually the parent.
+			if owner, ok := owners[inode]; !ok || pid < owner {
+				owners[inode] = pid
+			}
+		}
+	}
+	return owners
+}
+
+func readProcComm(pid int) string {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return ""
+	}
+	return string(bytes.TrimSpace(data))
+}
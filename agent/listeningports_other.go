@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package agent
+
+import "golang.org/x/xerrors"
+
+func readListeningPorts() ([]ListeningPort, error) {
+	return nil, xerrors.New("listening ports are only supported on Linux")
+}
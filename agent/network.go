@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/pion/stun"
+	"golang.org/x/xerrors"
+)
+
+// NATType is the mapping behavior of the NAT in front of the agent, as
+// inferred from STUN binding responses.
+type NATType string
+
+const (
+	// NATTypeUnknown means only one STUN server responded, so the mapping
+	// behavior couldn't be compared.
+	NATTypeUnknown NATType = "unknown"
+	// NATTypeNone means the public IP is assigned to an interface of the
+	// agent.
+	NATTypeNone NATType = "none"
+	// NATTypeEndpointIndependent means every destination sees the same
+	// public address, so peer-to-peer connections usually succeed.
+	NATTypeEndpointIndependent NATType = "endpoint_independent"
+	// NATTypeEndpointDependent means the public address changes with the
+	// destination, which often forces connections to relay.
+	NATTypeEndpointDependent NATType = "endpoint_dependent"
+)
+
+// NetworkReport is what the agent observes about its network from STUN.
+type NetworkReport struct {
+	PublicIP string  `json:"public_ip"`
+	NATType  NATType `json:"nat_type"`
+}
+
+// stunBindingTimeout is how long a single STUN server has to respond.
+const stunBindingTimeout = 5 * time.Second
+
+// probeNetwork sends STUN binding requests from a single socket until two
+// servers respond. Comparing their mapped addresses reveals whether the
+// NAT maps by destination.
+func probeNetwork(ctx context.Context, servers []string) (NetworkReport, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return NetworkReport{}, xerrors.Errorf("listen udp: %w", err)
+	}
+	defer conn.Close()
+
+	mapped := make([]*net.UDPAddr, 0, 2)
+	var lastErr error
+	for _, server := range servers {
+		addr, err := stunBinding(ctx, conn, server)
+		if err != nil {
+			lastErr = xerrors.Errorf("stun binding %q: %w", server, err)
+			continue
+		}
+		mapped = append(mapped, addr)
+		if len(mapped) == 2 {
+			break
+		}
+	}
+	if len(mapped) == 0 {
+		if lastErr == nil {
+			lastErr = xerrors.New("no stun servers")
+		}
+		return NetworkReport{}, lastErr
+	}
+	return NetworkReport{
+		PublicIP: mapped[0].IP.String(),
+		NATType:  inferNATType(mapped),
+	}, nil
+}
+
+// stunBinding returns the address the STUN server saw the request from.
+func stunBinding(ctx context.Context, conn net.PacketConn, server string) (*net.UDPAddr, error) {
+	serverAddr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return nil, xerrors.Errorf("resolve: %w", err)
+	}
+	request, err := stun.Build(stun.TransactionID, stun.BindingRequest)
+	if err != nil {
+		return nil, xerrors.Errorf("build request: %w", err)
+	}
+	deadline := time.Now().Add(stunBindingTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return nil, xerrors.Errorf("set deadline: %w", err)
+	}
+	_, err = conn.WriteTo(request.Raw, serverAddr)
+	if err != nil {
+		return nil, xerrors.Errorf("write request: %w", err)
+	}
+
+	buffer := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return nil, xerrors.Errorf("read response: %w", err)
+		}
+		// Late responses from a previous server share the socket.
+		fromAddr, ok := from.(*net.UDPAddr)
+		if !ok || !fromAddr.IP.Equal(serverAddr.IP) || fromAddr.Port != serverAddr.Port {
+			continue
+		}
+		response := &stun.Message{Raw: append([]byte{}, buffer[:n]...)}
+		err = response.Decode()
+		if err != nil || response.TransactionID != request.TransactionID {
+			continue
+		}
+		var xorAddr stun.XORMappedAddress
+		if xorAddr.GetFrom(response) == nil {
+			return &net.UDPAddr{IP: xorAddr.IP, Port: xorAddr.Port}, nil
+		}
+		var mappedAddr stun.MappedAddress
+		if mappedAddr.GetFrom(response) == nil {
+			return &net.UDPAddr{IP: mappedAddr.IP, Port: mappedAddr.Port}, nil
+		}
+		return nil, xerrors.New("response has no mapped address")
+	}
+}
+
+// inferNATType compares the addresses STUN servers observed.
+func inferNATType(mapped []*net.UDPAddr) NATType {
+	if isLocalIP(mapped[0].IP) {
+		return NATTypeNone
+	}
+	if len(mapped) < 2 {
+		return NATTypeUnknown
+	}
+	if mapped[0].IP.Equal(mapped[1].IP) && mapped[0].Port == mapped[1].Port {
+		return NATTypeEndpointIndependent
+	}
+	return NATTypeEndpointDependent
+}
+
+func isLocalIP(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
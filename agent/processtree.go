@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+
+	"golang.org/x/xerrors"
+
+	"cdr.dev/slog"
+	"github.com/coder/coder/peer"
+)
+
+// ErrReconnectingPTYNotFound is returned for reconnect IDs that don't have a
+// session on the agent.
+var ErrReconnectingPTYNotFound = xerrors.New("reconnecting pty not found")
+
+// Process is a process spawned by a reconnecting PTY session.
+type Process struct {
+	PID  int `json:"pid"`
+	PPID int `json:"ppid"`
+	// State is the single character state reported by the kernel, like
+	// "R" for running or "S" for sleeping.
+	State string `json:"state"`
+	// Command is the command line of the process. Only the executable is
+	// included when the metadata redacts arguments.
+	Command []string `json:"command"`
+}
+
+// processTreeResponse is written to channels with protocol "process-tree",
+// whose label is the reconnect ID of a session.
+type processTreeResponse struct {
+	Processes []Process `json:"processes"`
+	NotFound  bool      `json:"not_found,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ProcessTree returns the processes of the reconnecting PTY session with the
+// reconnect ID. The process the session started is first, and every other
+// process comes after its parent.
+func (c *Conn) ProcessTree(ctx context.Context, id string) ([]Process, error) {
+	channel, err := c.CreateChannel(ctx, id, &peer.ChannelOptions{
+		Protocol: ProtocolProcessTree,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("create datachannel: %w", err)
+	}
+	netConn := channel.NetConn()
+	defer netConn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = netConn.Close()
+		case <-done:
+		}
+	}()
+	var response processTreeResponse
+	err = json.NewDecoder(netConn).Decode(&response)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, xerrors.Errorf("decode process tree: %w", err)
+	}
+	if response.NotFound {
+		return nil, ErrReconnectingPTYNotFound
+	}
+	if response.Error != "" {
+		return nil, xerrors.Errorf("remote process tree error: %s", response.Error)
+	}
+	return response.Processes, nil
+}
+
+func (a *agent) handleProcessTree(ctx context.Context, id string, conn net.Conn) {
+	defer conn.Close()
+
+	var response processTreeResponse
+	processes, err := a.processTree(id)
+	switch {
+	case xerrors.Is(err, ErrReconnectingPTYNotFound):
+		response.NotFound = true
+	case err != nil:
+		a.logger.Warn(ctx, "read process tree", slog.F("id", id), slog.Error(err))
+		response.Error = err.Error()
+	default:
+		response.Processes = processes
+	}
+	err = json.NewEncoder(conn).Encode(response)
+	if err != nil {
+		a.logger.Debug(ctx, "write process tree", slog.Error(err))
+	}
+}
+
+func (a *agent) processTree(id string) ([]Process, error) {
+	rawRPTY, ok := a.reconnectingPTYs.Load(id)
+	if !ok {
+		return nil, ErrReconnectingPTYNotFound
+	}
+	rpty, ok := rawRPTY.(*reconnectingPTY)
+	if !ok {
+		return nil, ErrReconnectingPTYNotFound
+	}
+	select {
+	case <-rpty.exited:
+		// The session is being cleaned up, and its PID may be reused.
+		return nil, ErrReconnectingPTYNotFound
+	default:
+	}
+	processes, err := readProcessTree(rpty.pid)
+	if err != nil {
+		return nil, err
+	}
+	if metadata, ok := a.metadata.Load().(Metadata); ok && metadata.RedactProcessArguments {
+		for i, process := range processes {
+			if len(process.Command) > 1 {
+				processes[i].Command = process.Command[:1]
+			}
+		}
+	}
+	return processes, nil
+}
@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// procStat is what's needed from /proc/<pid>/stat.
+type procStat struct {
+	state   string
+	ppid    int
+	session int
+}
+
+// readProcessTree returns root and its descendants. PTYs start their command
+// with setsid, so processes of the session whose parent exited are included
+// too, after the rest.
+func readProcessTree(root int) ([]Process, error) {
+	rootStat, err := readProcStat(root)
+	if err != nil {
+		return nil, xerrors.Errorf("read root process: %w", err)
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, xerrors.Errorf("read /proc: %w", err)
+	}
+	stats := map[int]procStat{root: rootStat}
+	children := map[int][]int{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == root {
+			continue
+		}
+		// Processes can exit while the tree is read.
+		stat, err := readProcStat(pid)
+		if err != nil {
+			continue
+		}
+		stats[pid] = stat
+		children[stat.ppid] = append(children[stat.ppid], pid)
+	}
+
+	included := map[int]bool{}
+	order := make([]int, 0)
+	var visit func(pid int)
+	visit = func(pid int) {
+		if included[pid] {
+			return
+		}
+		included[pid] = true
+		order = append(order, pid)
+		kids := children[pid]
+		sort.Ints(kids)
+		for _, child := range kids {
+			visit(child)
+		}
+	}
+	visit(root)
+	orphans := make([]int, 0)
+	for pid, stat := range stats {
+		if stat.session == root && !included[pid] {
+			orphans = append(orphans, pid)
+		}
+	}
+	sort.Ints(orphans)
+	for _, pid := range orphans {
+		visit(pid)
+	}
+
+	processes := make([]Process, 0, len(order))
+	for _, pid := range order {
+		stat := stats[pid]
+		processes = append(processes, Process{
+			PID:     pid,
+			PPID:    stat.ppid,
+			State:   stat.state,
+			Command: readProcCmdline(pid),
+		})
+	}
+	return processes, nil
+}
+
+func readProcStat(pid int) (procStat, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return procStat{}, err
+	}
+	// The command name is in parentheses and may contain spaces or
+	// parentheses itself, so fields are read from after the last one.
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return procStat{}, xerrors.Errorf("malformed stat of process %d", pid)
+	}
+	// state ppid pgrp session ...
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 4 {
+		return procStat{}, xerrors.Errorf("malformed stat of process %d", pid)
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return procStat{}, xerrors.Errorf("parse ppid of process %d: %w", pid, err)
+	}
+	session, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return procStat{}, xerrors.Errorf("parse session of process %d: %w", pid, err)
+	}
+	return procStat{
+		state:   fields[0],
+		ppid:    ppid,
+		session: session,
+	}, nil
+}
+
+// readProcCmdline returns the arguments of a process, which are empty for
+// kernel threads and zombies.
+func readProcCmdline(pid int) []string {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return []string{}
+	}
+	data = bytes.TrimSuffix(data, []byte{0})
+	if len(data) == 0 {
+		return []string{}
+	}
+	return strings.Split(string(data), "\x00")
+}
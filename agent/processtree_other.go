@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package agent
+
+import "golang.org/x/xerrors"
+
+func readProcessTree(_ int) ([]Process, error) {
+	return nil, xerrors.New("process trees are only supported on Linux")
+}
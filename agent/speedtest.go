@@ -0,0 +1,198 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"cdr.dev/slog"
+	"github.com/coder/coder/peer"
+)
+
+// SpeedtestDirection is the way data flows in a speedtest.
+type SpeedtestDirection string
+
+const (
+	// SpeedtestUpload sends data from the client to the agent.
+	SpeedtestUpload SpeedtestDirection = "upload"
+	// SpeedtestDownload sends data from the agent to the client.
+	SpeedtestDownload SpeedtestDirection = "download"
+)
+
+const (
+	// speedtestInterval is how often throughput is sampled.
+	speedtestInterval = time.Second
+	// speedtestChunkSize is the size of each write of the pattern.
+	speedtestChunkSize = 32 * 1024
+	// speedtestMaxDuration bounds speedtests, so a client can't keep the
+	// agent sending forever.
+	speedtestMaxDuration = time.Minute
+	// speedtestGracePeriod is how long the agent waits for the client to
+	// end a speedtest before ending it itself.
+	speedtestGracePeriod = 5 * time.Second
+)
+
+// SpeedtestResult is the throughput of one interval of a speedtest.
+type SpeedtestResult struct {
+	// Start and End are offsets from when the speedtest started.
+	Start          time.Duration `json:"start"`
+	End            time.Duration `json:"end"`
+	Bytes          int64         `json:"bytes"`
+	MBitsPerSecond float64       `json:"mbits_per_second"`
+}
+
+// speedtestRequest is the label of channels with protocol "speedtest".
+type speedtestRequest struct {
+	Direction SpeedtestDirection `json:"direction"`
+	Duration  time.Duration      `json:"duration"`
+}
+
+// Speedtest measures the throughput to or from the agent for the duration,
+// sampled every second. The duration is capped at a minute.
+func (c *Conn) Speedtest(ctx context.Context, direction SpeedtestDirection, duration time.Duration) ([]SpeedtestResult, error) {
+	if direction != SpeedtestUpload && direction != SpeedtestDownload {
+		return nil, xerrors.Errorf("unknown direction %q", direction)
+	}
+	if duration <= 0 || duration > speedtestMaxDuration {
+		return nil, xerrors.Errorf("duration must be positive and at most %s", speedtestMaxDuration)
+	}
+	label, err := json.Marshal(speedtestRequest{
+		Direction: direction,
+		Duration:  duration,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("marshal request: %w", err)
+	}
+	channel, err := c.CreateChannel(ctx, string(label), &peer.ChannelOptions{
+		Protocol: ProtocolSpeedtest,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("create datachannel: %w", err)
+	}
+	netConn := channel.NetConn()
+	defer netConn.Close()
+
+	var transferred atomic.Int64
+	transferDone := make(chan error, 1)
+	go func() {
+		buffer := speedtestPattern()
+		for {
+			var (
+				n   int
+				err error
+			)
+			if direction == SpeedtestUpload {
+				n, err = netConn.Write(buffer)
+			} else {
+				n, err = netConn.Read(buffer)
+			}
+			transferred.Add(int64(n))
+			if err != nil {
+				transferDone <- err
+				return
+			}
+		}
+	}()
+	// Closing the channel ends the transfer on both sides.
+	stop := func() {
+		_ = netConn.Close()
+		<-transferDone
+	}
+
+	var (
+		results   = make([]SpeedtestResult, 0)
+		start     = time.Now()
+		lastEnd   time.Duration
+		lastBytes int64
+	)
+	sample := func() {
+		end := time.Since(start)
+		bytes := transferred.Load()
+		result := SpeedtestResult{
+			Start: lastEnd,
+			End:   end,
+			Bytes: bytes - lastBytes,
+		}
+		if elapsed := (end - lastEnd).Seconds(); elapsed > 0 {
+			result.MBitsPerSecond = float64(result.Bytes*8) / elapsed / 1e6
+		}
+		results = append(results, result)
+		lastEnd, lastBytes = end, bytes
+	}
+	ticker := time.NewTicker(speedtestInterval)
+	defer ticker.Stop()
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			stop()
+			return nil, ctx.Err()
+		case err := <-transferDone:
+			return nil, xerrors.Errorf("speedtest ended early: %w", err)
+		case <-ticker.C:
+			sample()
+		case <-timer.C:
+			// The ticker fires at the same time for durations of whole
+			// seconds, which would leave a near-empty final interval.
+			if time.Since(start)-lastEnd >= speedtestInterval/2 || len(results) == 0 {
+				sample()
+			}
+			stop()
+			return results, nil
+		}
+	}
+}
+
+func (a *agent) handleSpeedtest(ctx context.Context, label string, conn net.Conn) {
+	defer conn.Close()
+
+	var req speedtestRequest
+	err := json.Unmarshal([]byte(label), &req)
+	if err != nil {
+		a.logger.Warn(ctx, "decode speedtest request", slog.F("label", label), slog.Error(err))
+		return
+	}
+	defer a.logger.Debug(ctx, "speedtest ended", slog.F("direction", req.Direction))
+	duration := req.Duration
+	if duration <= 0 || duration > speedtestMaxDuration {
+		duration = speedtestMaxDuration
+	}
+	// The client ends the speedtest by closing the channel. The deadline
+	// only ends those it abandoned.
+	ctx, cancel := context.WithTimeout(ctx, duration+speedtestGracePeriod)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	switch req.Direction {
+	case SpeedtestUpload:
+		_, _ = io.Copy(io.Discard, conn)
+	case SpeedtestDownload:
+		buffer := speedtestPattern()
+		for {
+			_, err := conn.Write(buffer)
+			if err != nil {
+				return
+			}
+		}
+	default:
+		a.logger.Warn(ctx, "unknown speedtest direction", slog.F("direction", req.Direction))
+	}
+}
+
+// speedtestPattern is a chunk of data to send in a speedtest.
+func speedtestPattern() []byte {
+	pattern := make([]byte, speedtestChunkSize)
+	for i := range pattern {
+		pattern[i] = byte(i)
+	}
+	return pattern
+}
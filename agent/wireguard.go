@@ -61,7 +61,7 @@ func (a *agent) startWireguard(ctx context.Context, addrs []netaddr.IPPrefix) er
 	}()
 
 	a.startWireguardListeners(ctx, wg, []handlerPort{
-		{port: 12212, handler: a.sshServer.HandleConn},
+		{port: 12212, protocol: ProtocolSSH, handler: a.sshServer.HandleConn},
 	})
 
 	a.network = wg
@@ -69,8 +69,9 @@ func (a *agent) startWireguard(ctx context.Context, addrs []netaddr.IPPrefix) er
 }
 
 type handlerPort struct {
-	handler func(conn net.Conn)
-	port    uint16
+	handler  func(conn net.Conn)
+	port     uint16
+	protocol string
 }
 
 func (a *agent) startWireguardListeners(ctx context.Context, network *peerwg.Network, handlers []handlerPort) {
@@ -90,6 +91,10 @@ func (a *agent) startWireguardListeners(ctx context.Context, network *peerwg.Net
 					return
 				}
 
+				if a.protocolDisabled(h.protocol) {
+					go a.rejectChannel(ctx, h.protocol, listener.Addr().String(), conn)
+					continue
+				}
 				go h.handler(conn)
 			}
 		}(h)
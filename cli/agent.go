@@ -32,12 +32,27 @@ func workspaceAgent() *cobra.Command {
 		pprofAddress string
 		noReap       bool
 		wireguard    bool
+		ptyLimit     uint8
+		ptyPolicy    string
+		sshPolicy    string
+		proxyURL     string
 	)
 	cmd := &cobra.Command{
 		Use: "agent",
 		// This command isn't useful to manually execute.
 		Hidden: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			switch agent.ReconnectingPTYLimitPolicy(ptyPolicy) {
+			case agent.ReconnectingPTYLimitReject, agent.ReconnectingPTYLimitEvictOldestIdle:
+			default:
+				return xerrors.Errorf("invalid reconnecting pty limit policy %q", ptyPolicy)
+			}
+			switch agent.SSHCipherPolicy(sshPolicy) {
+			case agent.SSHCipherPolicyDefault, agent.SSHCipherPolicySecure:
+			default:
+				return xerrors.Errorf("invalid ssh cipher policy %q", sshPolicy)
+			}
+
 			rawURL, err := cmd.Flags().GetString(varAgentURL)
 			if err != nil {
 				return xerrors.Errorf("CODER_AGENT_URL must be set: %w", err)
@@ -75,6 +90,21 @@ func workspaceAgent() *cobra.Command {
 
 			logger.Info(cmd.Context(), "starting agent", slog.F("url", coderURL), slog.F("auth", auth))
 			client := codersdk.New(coderURL)
+			// Requests are proxied using HTTP_PROXY and HTTPS_PROXY
+			// unless a proxy is specified explicitly.
+			if proxyURL != "" {
+				parsedProxyURL, err := url.Parse(proxyURL)
+				if err != nil {
+					return xerrors.Errorf("parse proxy url %q: %w", proxyURL, err)
+				}
+				defaultTransport, valid := http.DefaultTransport.(*http.Transport)
+				if !valid {
+					return xerrors.New("http default transport isn't *http.Transport")
+				}
+				transport := defaultTransport.Clone()
+				transport.Proxy = http.ProxyURL(parsedProxyURL)
+				client.HTTPClient.Transport = transport
+			}
 
 			if pprofEnabled {
 				srvClose := serveHandler(cmd.Context(), logger, nil, pprofAddress, "pprof")
@@ -182,6 +212,15 @@ func workspaceAgent() *cobra.Command {
 				EnableWireguard:      wireguard,
 				UploadWireguardKeys:  client.UploadWorkspaceAgentKeys,
 				ListenWireguardPeers: client.WireguardPeerListener,
+
+				ReconnectingPTYLimit:       int(ptyLimit),
+				ReconnectingPTYLimitPolicy: agent.ReconnectingPTYLimitPolicy(ptyPolicy),
+				SSHCipherPolicy:            agent.SSHCipherPolicy(sshPolicy),
+
+				PostStartupPhase:    client.PostWorkspaceAgentStartupPhase,
+				PostDirectoryExists: client.PostWorkspaceAgentDirectory,
+				PostNetworkReport:   client.PostWorkspaceAgentNetwork,
+				PostDiagnostics:     client.PostWorkspaceAgentDiagnostics,
 			})
 			<-cmd.Context().Done()
 			return closer.Close()
@@ -193,5 +232,9 @@ func workspaceAgent() *cobra.Command {
 	cliflag.BoolVarP(cmd.Flags(), &noReap, "no-reap", "", "", false, "Do not start a process reaper.")
 	cliflag.StringVarP(cmd.Flags(), &pprofAddress, "pprof-address", "", "CODER_AGENT_PPROF_ADDRESS", "127.0.0.1:6060", "The address to serve pprof.")
 	cliflag.BoolVarP(cmd.Flags(), &wireguard, "wireguard", "", "CODER_AGENT_WIREGUARD", true, "Whether to start the Wireguard interface.")
+	cliflag.Uint8VarP(cmd.Flags(), &ptyLimit, "reconnecting-pty-limit", "", "CODER_AGENT_RECONNECTING_PTY_LIMIT", 0, "The maximum number of reconnecting terminal sessions in the workspace. Zero means unlimited.")
+	cliflag.StringVarP(cmd.Flags(), &ptyPolicy, "reconnecting-pty-limit-policy", "", "CODER_AGENT_RECONNECTING_PTY_LIMIT_POLICY", string(agent.ReconnectingPTYLimitReject), `What to do with a new terminal session over the limit. Either "reject" or "evict-oldest-idle".`)
+	cliflag.StringVarP(cmd.Flags(), &proxyURL, "proxy-url", "", "CODER_AGENT_PROXY_URL", "", "An HTTP proxy to reach coderd through. Defaults to the HTTP_PROXY and HTTPS_PROXY environment variables.")
+	cliflag.StringVarP(cmd.Flags(), &sshPolicy, "ssh-cipher-policy", "", "CODER_AGENT_SSH_CIPHER_POLICY", string(agent.SSHCipherPolicyDefault), `Restricts the algorithms the SSH server negotiates. Either "default" or "secure", which only allows AEAD ciphers and SHA-2 MACs.`)
 	return cmd
 }
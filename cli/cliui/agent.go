@@ -38,7 +38,7 @@ func Agent(ctx context.Context, writer io.Writer, opts AgentOptions) error {
 	if agent.Status == codersdk.WorkspaceAgentConnected {
 		return nil
 	}
-	if agent.Status == codersdk.WorkspaceAgentDisconnected {
+	if agent.Status == codersdk.WorkspaceAgentDisconnected || agent.Status == codersdk.WorkspaceAgentMaintenance {
 		opts.WarnInterval = 0
 	}
 	spin := spinner.New(spinner.CharSets[78], 100*time.Millisecond, spinner.WithColor("fgHiGreen"))
@@ -78,8 +78,11 @@ func Agent(ctx context.Context, writer io.Writer, opts AgentOptions) error {
 		resourceMutex.Lock()
 		defer resourceMutex.Unlock()
 		message := "Don't panic, your workspace is booting up!"
-		if agent.Status == codersdk.WorkspaceAgentDisconnected {
+		switch agent.Status {
+		case codersdk.WorkspaceAgentDisconnected:
 			message = "The workspace agent lost connection! Wait for it to reconnect or restart your workspace."
+		case codersdk.WorkspaceAgentMaintenance:
+			message = "The workspace agent is down for maintenance. Wait for it to reconnect."
 		}
 		// This saves the cursor position, then defers clearing from the cursor
 		// position to the end of the screen.
@@ -101,6 +101,10 @@ func WorkspaceResources(writer io.Writer, resources []codersdk.WorkspaceResource
 						since := database.Now().Sub(*agent.DisconnectedAt)
 						agentStatus = Styles.Error.Render("⦾ disconnected") + " " +
 							Styles.Placeholder.Render("["+strconv.Itoa(int(since.Seconds()))+"s]")
+					case codersdk.WorkspaceAgentMaintenance:
+						since := database.Now().Sub(*agent.DisconnectedAt)
+						agentStatus = Styles.Placeholder.Render("⦾ maintenance") + " " +
+							Styles.Placeholder.Render("["+strconv.Itoa(int(since.Seconds()))+"s]")
 					case codersdk.WorkspaceAgentConnected:
 						agentStatus = Styles.Keyword.Render("⦿ connected")
 					}
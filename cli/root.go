@@ -77,6 +77,7 @@ func Core() []*cobra.Command {
 		resetPassword(),
 		schedules(),
 		show(),
+		speedtest(),
 		ssh(),
 		start(),
 		state(),
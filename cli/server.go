@@ -17,6 +17,7 @@ import (
 	"os"
 	"os/signal"
 	"os/user"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -35,6 +36,7 @@ import (
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/exp/slices"
 	"golang.org/x/oauth2"
 	xgithub "golang.org/x/oauth2/github"
 	"golang.org/x/sync/errgroup"
@@ -44,11 +46,14 @@ import (
 
 	"cdr.dev/slog"
 	"cdr.dev/slog/sloggers/sloghuman"
+	"github.com/coder/coder/agent"
 	"github.com/coder/coder/buildinfo"
 	"github.com/coder/coder/cli/cliflag"
 	"github.com/coder/coder/cli/cliui"
 	"github.com/coder/coder/cli/config"
 	"github.com/coder/coder/coderd"
+	"github.com/coder/coder/coderd/audit"
+	"github.com/coder/coder/coderd/audit/backends"
 	"github.com/coder/coder/coderd/autobuild/executor"
 	"github.com/coder/coder/coderd/database"
 	"github.com/coder/coder/coderd/database/databasefake"
@@ -70,6 +75,11 @@ import (
 // nolint:gocyclo
 func Server(newAPI func(*coderd.Options) *coderd.API) *cobra.Command {
 	var (
+		agentShellPrompt      string
+		agentPTYEnvDenylist   []string
+		agentPTYEnvAllowlist  []string
+		agentDisabledProtos   []string
+		agentRedactProcArgs   bool
 		accessURL             string
 		address               string
 		autobuildPollInterval time.Duration
@@ -82,6 +92,11 @@ func Server(newAPI func(*coderd.Options) *coderd.API) *cobra.Command {
 		// provisionerDaemonCount is a uint8 to ensure a number > 0.
 		provisionerDaemonCount           uint8
 		postgresURL                      string
+		ptyCompression                   bool
+		ptyOutputHighWater               int
+		ptyOutputStallTimeout            time.Duration
+		terminalIdleTimeout              time.Duration
+		readHeaderTimeout                time.Duration
 		oauth2GithubClientID             string
 		oauth2GithubClientSecret         string
 		oauth2GithubAllowedOrganizations []string
@@ -103,6 +118,8 @@ func Server(newAPI func(*coderd.Options) *coderd.API) *cobra.Command {
 		tlsKeyFile                       string
 		tlsMinVersion                    string
 		turnRelayAddress                 string
+		turnServers                      []string
+		turnSecret                       string
 		tunnel                           bool
 		stunServers                      []string
 		trace                            bool
@@ -270,6 +287,13 @@ func Server(newAPI func(*coderd.Options) *coderd.API) *cobra.Command {
 				return xerrors.Errorf("parse ssh keygen algorithm %s: %w", sshKeygenAlgorithmRaw, err)
 			}
 
+			if len(turnServers) > 0 && turnSecret == "" {
+				return xerrors.New("--turn-secret must be set to use --turn-server")
+			}
+			if turnSecret != "" && len(turnServers) == 0 {
+				return xerrors.New("--turn-server must be set to use --turn-secret")
+			}
+
 			turnServer, err := turnconn.New(&turn.RelayAddressGeneratorStatic{
 				RelayAddress: net.ParseIP(turnRelayAddress),
 				Address:      turnRelayAddress,
@@ -285,6 +309,11 @@ func Server(newAPI func(*coderd.Options) *coderd.API) *cobra.Command {
 					URLs: []string{stunServer},
 				})
 			}
+			for _, turnServerURL := range turnServers {
+				iceServers = append(iceServers, webrtc.ICEServer{
+					URLs: []string{turnServerURL},
+				})
+			}
 
 			// Validate provided auto-import templates.
 			var (
@@ -307,20 +336,41 @@ func Server(newAPI func(*coderd.Options) *coderd.API) *cobra.Command {
 				validatedAutoImportTemplates[i] = v
 			}
 
+			for _, pattern := range append(append([]string{}, agentPTYEnvDenylist...), agentPTYEnvAllowlist...) {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return xerrors.Errorf("agent pty environment pattern %q is malformed: %w", pattern, err)
+				}
+			}
+			for _, protocol := range agentDisabledProtos {
+				if !slices.Contains(agent.Protocols, protocol) {
+					return xerrors.Errorf("agent protocol %q is unknown, expected one of %q", protocol, agent.Protocols)
+				}
+			}
+
 			options := &coderd.Options{
-				AccessURL:            accessURLParsed,
-				ICEServers:           iceServers,
-				Logger:               logger.Named("coderd"),
-				Database:             databasefake.New(),
-				Pubsub:               database.NewPubsubInMemory(),
-				CacheDir:             cacheDir,
-				GoogleTokenValidator: googleTokenValidator,
-				SecureAuthCookie:     secureAuthCookie,
-				SSHKeygenAlgorithm:   sshKeygenAlgorithm,
-				TURNServer:           turnServer,
-				TracerProvider:       tracerProvider,
-				Telemetry:            telemetry.NewNoop(),
-				AutoImportTemplates:  validatedAutoImportTemplates,
+				AccessURL:                    accessURLParsed,
+				ICEServers:                   iceServers,
+				Logger:                       logger.Named("coderd"),
+				Database:                     databasefake.New(),
+				Pubsub:                       database.NewPubsubInMemory(),
+				CacheDir:                     cacheDir,
+				GoogleTokenValidator:         googleTokenValidator,
+				SecureAuthCookie:             secureAuthCookie,
+				SSHKeygenAlgorithm:           sshKeygenAlgorithm,
+				TURNServer:                   turnServer,
+				TURNSecret:                   turnSecret,
+				TracerProvider:               tracerProvider,
+				Telemetry:                    telemetry.NewNoop(),
+				AutoImportTemplates:          validatedAutoImportTemplates,
+				AgentShellPrompt:             agentShellPrompt,
+				AgentPTYEnvironmentDenylist:  agentPTYEnvDenylist,
+				AgentPTYEnvironmentAllowlist: agentPTYEnvAllowlist,
+				AgentDisabledProtocols:       agentDisabledProtos,
+				AgentRedactProcessArguments:  agentRedactProcArgs,
+				PTYCompression:               ptyCompression,
+				PTYOutputHighWater:           ptyOutputHighWater,
+				PTYOutputStallTimeout:        ptyOutputStallTimeout,
+				TerminalIdleTimeout:          terminalIdleTimeout,
 			}
 
 			if oauth2GithubClientSecret != "" {
@@ -387,6 +437,10 @@ func Server(newAPI func(*coderd.Options) *coderd.API) *cobra.Command {
 				}
 				defer options.Pubsub.Close()
 			}
+			options.Auditor = audit.NewExporter(audit.DefaultFilter,
+				backends.NewPostgres(options.Database, true),
+				backends.NewSlog(logger.Named("audit")),
+			)
 
 			deploymentID, err := options.Database.GetDeploymentID(ctx)
 			if errors.Is(err, sql.ErrNoRows) {
@@ -497,15 +551,15 @@ func Server(newAPI func(*coderd.Options) *coderd.API) *cobra.Command {
 			shutdownConnsCtx, shutdownConns := context.WithCancel(ctx)
 			defer shutdownConns()
 
-			// ReadHeaderTimeout is purposefully not enabled. It caused some issues with
-			// websockets over the dev tunnel.
+			// ReadHeaderTimeout is purposefully not enabled by default. It caused
+			// some issues with websockets over the dev tunnel.
 			// See: https://github.com/coder/coder/pull/3730
-			//nolint:gosec
 			server := &http.Server{
 				// These errors are typically noise like "TLS: EOF". Vault does similar:
 				// https://github.com/hashicorp/vault/blob/e2490059d0711635e529a4efcbaa1b26998d6e1c/command/server.go#L2714
-				ErrorLog: log.New(io.Discard, "", 0),
-				Handler:  coderAPI.Handler,
+				ErrorLog:          log.New(io.Discard, "", 0),
+				Handler:           coderAPI.Handler,
+				ReadHeaderTimeout: readHeaderTimeout,
 				BaseContext: func(_ net.Listener) context.Context {
 					return shutdownConnsCtx
 				},
@@ -605,6 +659,15 @@ func Server(newAPI func(*coderd.Options) *coderd.API) *cobra.Command {
 			} else {
 				cmd.Printf("Gracefully shut down API server\n")
 			}
+			// Agents are asked to move to another replica before their
+			// connections are canceled with the rest, give them 5 seconds
+			// to disconnect.
+			reconnectCtx, reconnectCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = coderAPI.ReconnectAgents(reconnectCtx)
+			reconnectCancel()
+			if err != nil {
+				cmd.Printf("Agents took longer than 5s to reconnect: %s\n", err)
+			}
 			// Cancel any remaining in-flight requests.
 			shutdownConns()
 
@@ -701,6 +764,16 @@ func Server(newAPI func(*coderd.Options) *coderd.API) *cobra.Command {
 		},
 	})
 
+	cliflag.StringVarP(root.Flags(), &agentShellPrompt, "agent-shell-prompt", "", "CODER_AGENT_SHELL_PROMPT", "",
+		`Specifies the default prompt (PS1) of shells in workspaces. "{workspace}" and "{agent}" are replaced with their names. Users' shell configuration takes precedence.`)
+	cliflag.StringArrayVarP(root.Flags(), &agentPTYEnvDenylist, "agent-pty-env-denylist", "", "CODER_AGENT_PTY_ENV_DENYLIST", []string{},
+		`Environment variables to remove from web terminal sessions in workspaces, such as secrets meant only for startup scripts. Supports glob patterns like "AWS_*".`)
+	cliflag.StringArrayVarP(root.Flags(), &agentPTYEnvAllowlist, "agent-pty-env-allowlist", "", "CODER_AGENT_PTY_ENV_ALLOWLIST", []string{},
+		`If set, web terminal sessions in workspaces only receive the environment variables matching these glob patterns.`)
+	cliflag.StringArrayVarP(root.Flags(), &agentDisabledProtos, "agent-disabled-protocols", "", "CODER_AGENT_DISABLED_PROTOCOLS", []string{},
+		`Agent protocols to refuse in every workspace: "ssh", "reconnecting-pty" (the web terminal), "dial" (port forwarding and applications), "exec" (non-interactive commands), "process-tree" (the processes of web terminal sessions), "speedtest" or "listening-ports" (the ports processes listen on).`)
+	cliflag.BoolVarP(root.Flags(), &agentRedactProcArgs, "agent-redact-process-arguments", "", "CODER_AGENT_REDACT_PROCESS_ARGUMENTS", false,
+		`Omit the arguments of processes when reporting the processes of web terminal sessions, as they may contain secrets.`)
 	cliflag.DurationVarP(root.Flags(), &autobuildPollInterval, "autobuild-poll-interval", "", "CODER_AUTOBUILD_POLL_INTERVAL", time.Minute, "Specifies the interval at which to poll for and execute automated workspace build operations.")
 	cliflag.StringVarP(root.Flags(), &accessURL, "access-url", "", "CODER_ACCESS_URL", "", "Specifies the external URL to access Coder.")
 	cliflag.StringVarP(root.Flags(), &address, "address", "a", "CODER_ADDRESS", "127.0.0.1:3000", "The address to serve the API and dashboard.")
@@ -719,6 +792,16 @@ func Server(newAPI func(*coderd.Options) *coderd.API) *cobra.Command {
 	_ = root.Flags().MarkHidden("in-memory")
 	cliflag.StringVarP(root.Flags(), &postgresURL, "postgres-url", "", "CODER_PG_CONNECTION_URL", "", "The URL of a PostgreSQL database to connect to. If empty, PostgreSQL binaries will be downloaded from Maven (https://repo1.maven.org/maven2) and store all data in the config root. Access the built-in database with \"coder server postgres-builtin-url\"")
 	cliflag.Uint8VarP(root.Flags(), &provisionerDaemonCount, "provisioner-daemons", "", "CODER_PROVISIONER_DAEMONS", 3, "The amount of provisioner daemons to create on start.")
+	cliflag.BoolVarP(root.Flags(), &ptyCompression, "pty-compression", "", "CODER_PTY_COMPRESSION", false,
+		"Specifies if web terminal connections are compressed in both directions. This helps on slow links, at the cost of memory per connection.")
+	cliflag.IntVarP(root.Flags(), &ptyOutputHighWater, "pty-output-buffer", "", "CODER_PTY_OUTPUT_BUFFER", 1<<20,
+		"Specifies how many bytes of terminal output are buffered for each web terminal before reading from the workspace pauses.")
+	cliflag.DurationVarP(root.Flags(), &ptyOutputStallTimeout, "pty-output-stall-timeout", "", "CODER_PTY_OUTPUT_STALL_TIMEOUT", 30*time.Second,
+		"Specifies how long a web terminal may leave its output buffer full before it is disconnected.")
+	cliflag.DurationVarP(root.Flags(), &terminalIdleTimeout, "terminal-idle-timeout", "", "CODER_TERMINAL_IDLE_TIMEOUT", 0,
+		"Specifies how long a web terminal may go without input before it is closed. Templates can override this. Zero disables the timeout.")
+	cliflag.DurationVarP(root.Flags(), &readHeaderTimeout, "read-header-timeout", "", "CODER_READ_HEADER_TIMEOUT", 0,
+		"Specifies how long clients may take to send the headers of a request before the connection is closed. Zero disables the timeout, which is the default because it breaks websockets over the dev tunnel.")
 	cliflag.StringVarP(root.Flags(), &oauth2GithubClientID, "oauth2-github-client-id", "", "CODER_OAUTH2_GITHUB_CLIENT_ID", "",
 		"Specifies a client ID to use for oauth2 with GitHub.")
 	cliflag.StringVarP(root.Flags(), &oauth2GithubClientSecret, "oauth2-github-client-secret", "", "CODER_OAUTH2_GITHUB_CLIENT_SECRET", "",
@@ -769,6 +852,10 @@ func Server(newAPI func(*coderd.Options) *coderd.API) *cobra.Command {
 	cliflag.BoolVarP(root.Flags(), &trace, "trace", "", "CODER_TRACE", false, "Specifies if application tracing data is collected")
 	cliflag.StringVarP(root.Flags(), &turnRelayAddress, "turn-relay-address", "", "CODER_TURN_RELAY_ADDRESS", "127.0.0.1",
 		"Specifies the address to bind TURN connections.")
+	cliflag.StringArrayVarP(root.Flags(), &turnServers, "turn-server", "", "CODER_TURN_SERVERS", []string{},
+		"Specify URLs for external TURN servers. Requires --turn-secret to generate credentials for them.")
+	cliflag.StringVarP(root.Flags(), &turnSecret, "turn-secret", "", "CODER_TURN_SECRET", "",
+		"Specifies a secret shared with external TURN servers to generate time-limited credentials (TURN REST API).")
 	cliflag.BoolVarP(root.Flags(), &secureAuthCookie, "secure-auth-cookie", "", "CODER_SECURE_AUTH_COOKIE", false, "Specifies if the 'Secure' property is set on browser session cookies")
 	cliflag.StringVarP(root.Flags(), &sshKeygenAlgorithmRaw, "ssh-keygen-algorithm", "", "CODER_SSH_KEYGEN_ALGORITHM", "ed25519", "Specifies the algorithm to use for generating ssh keys. "+
 		`Accepted values are "ed25519", "ecdsa", or "rsa4096"`)
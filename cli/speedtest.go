@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+
+	coderagent "github.com/coder/coder/agent"
+	"github.com/coder/coder/cli/cliui"
+	"github.com/coder/coder/codersdk"
+)
+
+func speedtest() *cobra.Command {
+	var (
+		direction string
+		duration  time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "speedtest <workspace>",
+		Short: "Measure the throughput between the local machine and a workspace",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+
+			speedtestDirection := coderagent.SpeedtestDirection(direction)
+			if speedtestDirection != coderagent.SpeedtestUpload && speedtestDirection != coderagent.SpeedtestDownload {
+				return xerrors.Errorf(`unknown direction %q, only "upload" and "download" are supported`, direction)
+			}
+
+			client, err := CreateClient(cmd)
+			if err != nil {
+				return err
+			}
+			workspace, agent, err := getWorkspaceAndAgent(ctx, cmd, client, codersdk.Me, args[0], false)
+			if err != nil {
+				return err
+			}
+			err = cliui.Agent(ctx, cmd.ErrOrStderr(), cliui.AgentOptions{
+				WorkspaceName: workspace.Name,
+				Fetch: func(ctx context.Context) (codersdk.WorkspaceAgent, error) {
+					return client.WorkspaceAgent(ctx, agent.ID)
+				},
+			})
+			if err != nil {
+				return xerrors.Errorf("await agent: %w", err)
+			}
+
+			conn, err := client.DialWorkspaceAgent(ctx, agent.ID, nil)
+			if err != nil {
+				return xerrors.Errorf("dial workspace agent: %w", err)
+			}
+			defer conn.Close()
+
+			_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "Running a %s %s speedtest...\n", duration, speedtestDirection)
+			results, err := conn.Speedtest(ctx, speedtestDirection, duration)
+			if err != nil {
+				return xerrors.Errorf("speedtest: %w", err)
+			}
+
+			var (
+				tw    = cliui.Table()
+				bytes int64
+			)
+			tw.AppendHeader(table.Row{"Interval", "Throughput"})
+			for _, result := range results {
+				bytes += result.Bytes
+				tw.AppendRow(table.Row{
+					fmt.Sprintf("%.2f-%.2f sec", result.Start.Seconds(), result.End.Seconds()),
+					fmt.Sprintf("%.4f Mbits/sec", result.MBitsPerSecond),
+				})
+			}
+			if len(results) > 0 {
+				elapsed := results[len(results)-1].End
+				tw.AppendFooter(table.Row{
+					fmt.Sprintf("%.2f-%.2f sec", 0.0, elapsed.Seconds()),
+					fmt.Sprintf("%.4f Mbits/sec", float64(bytes*8)/elapsed.Seconds()/1e6),
+				})
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), tw.Render())
+			return err
+		},
+	}
+	cmd.Flags().StringVarP(&direction, "direction", "d", string(coderagent.SpeedtestDownload), `Which way data is sent: "download" from the workspace, or "upload" to it.`)
+	cmd.Flags().DurationVarP(&duration, "time", "t", 5*time.Second, "How long the speedtest runs, up to a minute.")
+	return cmd
+}
@@ -0,0 +1,37 @@
+package cli_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/coder/cli/clitest"
+	"github.com/coder/coder/coderd/coderdtest"
+	"github.com/coder/coder/testutil"
+)
+
+func TestSpeedtest(t *testing.T) {
+	t.Parallel()
+	client := coderdtest.New(t, &coderdtest.Options{IncludeProvisionerD: true})
+	user := coderdtest.CreateFirstUser(t, client)
+	_, workspace := runAgent(t, client, user.UserID)
+
+	cmd, root := clitest.New(t, "speedtest", workspace.Name, "--direction", "upload", "--time", "1s")
+	clitest.SetupConfig(t, client, root)
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+	err := cmd.ExecuteContext(ctx)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "0.00-1.00 sec")
+	require.Contains(t, out.String(), "Mbits/sec")
+
+	cmd, root = clitest.New(t, "speedtest", workspace.Name, "--direction", "sideways")
+	clitest.SetupConfig(t, client, root)
+	err = cmd.ExecuteContext(ctx)
+	require.ErrorContains(t, err, `unknown direction "sideways"`)
+}
@@ -24,6 +24,7 @@ import (
 
 	"cdr.dev/slog"
 	"cdr.dev/slog/sloggers/sloghuman"
+	"github.com/coder/coder/agent"
 	"github.com/coder/coder/cli/cliflag"
 	"github.com/coder/coder/cli/cliui"
 	"github.com/coder/coder/coderd/autobuild/notify"
@@ -46,6 +47,9 @@ func ssh() *cobra.Command {
 		identityAgent  string
 		wsPollInterval time.Duration
 		wireguard      bool
+
+		keepaliveInterval  time.Duration
+		keepaliveMaxMissed uint8
 	)
 	cmd := &cobra.Command{
 		Annotations: workspaceCommand,
@@ -175,6 +179,9 @@ func ssh() *cobra.Command {
 				return err
 			}
 			defer sshClient.Close()
+			if keepaliveInterval > 0 && keepaliveMaxMissed > 0 {
+				go agent.SSHKeepalive(sshClient, keepaliveInterval, int(keepaliveMaxMissed))
+			}
 
 			sshSession, err := sshClient.NewSession()
 			if err != nil {
@@ -270,6 +277,8 @@ func ssh() *cobra.Command {
 	cliflag.DurationVarP(cmd.Flags(), &wsPollInterval, "workspace-poll-interval", "", "CODER_WORKSPACE_POLL_INTERVAL", workspacePollInterval, "Specifies how often to poll for workspace automated shutdown.")
 	cliflag.BoolVarP(cmd.Flags(), &wireguard, "wireguard", "", "CODER_SSH_WIREGUARD", false, "Whether to use Wireguard for SSH tunneling.")
 	_ = cmd.Flags().MarkHidden("wireguard")
+	cliflag.DurationVarP(cmd.Flags(), &keepaliveInterval, "keepalive-interval", "", "CODER_SSH_KEEPALIVE_INTERVAL", 15*time.Second, "Specifies how often to check that the workspace is responsive. Zero disables the check.")
+	cliflag.Uint8VarP(cmd.Flags(), &keepaliveMaxMissed, "keepalive-max-missed", "", "CODER_SSH_KEEPALIVE_MAX_MISSED", 3, "Specifies how many checks in a row may go unanswered before the session is closed.")
 
 	return cmd
 }
@@ -21,11 +21,13 @@ import (
 
 func templateCreate() *cobra.Command {
 	var (
-		directory            string
-		provisioner          string
-		parameterFile        string
-		maxTTL               time.Duration
-		minAutostartInterval time.Duration
+		directory               string
+		provisioner             string
+		parameterFile           string
+		maxTTL                  time.Duration
+		minAutostartInterval    time.Duration
+		agentInactiveDisconnect time.Duration
+		terminalIdleTimeout     time.Duration
 	)
 	cmd := &cobra.Command{
 		Use:   "create [name]",
@@ -106,6 +108,12 @@ func templateCreate() *cobra.Command {
 				MaxTTLMillis:               ptr.Ref(maxTTL.Milliseconds()),
 				MinAutostartIntervalMillis: ptr.Ref(minAutostartInterval.Milliseconds()),
 			}
+			if agentInactiveDisconnect > 0 {
+				createReq.AgentInactiveDisconnectTimeoutMillis = ptr.Ref(agentInactiveDisconnect.Milliseconds())
+			}
+			if terminalIdleTimeout > 0 {
+				createReq.TerminalIdleTimeoutMillis = ptr.Ref(terminalIdleTimeout.Milliseconds())
+			}
 
 			_, err = client.CreateTemplate(cmd.Context(), organization.ID, createReq)
 			if err != nil {
@@ -128,6 +136,8 @@ func templateCreate() *cobra.Command {
 	cmd.Flags().StringVarP(&parameterFile, "parameter-file", "", "", "Specify a file path with parameter values.")
 	cmd.Flags().DurationVarP(&maxTTL, "max-ttl", "", 24*time.Hour, "Specify a maximum TTL for workspaces created from this template.")
 	cmd.Flags().DurationVarP(&minAutostartInterval, "min-autostart-interval", "", time.Hour, "Specify a minimum autostart interval for workspaces created from this template.")
+	cmd.Flags().DurationVarP(&agentInactiveDisconnect, "agent-inactive-disconnect-timeout", "", 0, "Specify how long agents of workspaces created from this template may go without a heartbeat before they're considered disconnected. Defaults to the deployment-wide timeout.")
+	cmd.Flags().DurationVarP(&terminalIdleTimeout, "terminal-idle-timeout", "", 0, "Specify how long web terminals of workspaces created from this template may go without input before they're closed. Defaults to the deployment-wide timeout.")
 	// This is for testing!
 	err := cmd.Flags().MarkHidden("test.provisioner")
 	if err != nil {
@@ -13,11 +13,13 @@ import (
 
 func templateEdit() *cobra.Command {
 	var (
-		name                 string
-		description          string
-		icon                 string
-		maxTTL               time.Duration
-		minAutostartInterval time.Duration
+		name                    string
+		description             string
+		icon                    string
+		maxTTL                  time.Duration
+		minAutostartInterval    time.Duration
+		agentInactiveDisconnect time.Duration
+		terminalIdleTimeout     time.Duration
 	)
 
 	cmd := &cobra.Command{
@@ -40,11 +42,13 @@ func templateEdit() *cobra.Command {
 
 			// NOTE: coderd will ignore empty fields.
 			req := codersdk.UpdateTemplateMeta{
-				Name:                       name,
-				Description:                description,
-				Icon:                       icon,
-				MaxTTLMillis:               maxTTL.Milliseconds(),
-				MinAutostartIntervalMillis: minAutostartInterval.Milliseconds(),
+				Name:                                 name,
+				Description:                          description,
+				Icon:                                 icon,
+				MaxTTLMillis:                         maxTTL.Milliseconds(),
+				MinAutostartIntervalMillis:           minAutostartInterval.Milliseconds(),
+				AgentInactiveDisconnectTimeoutMillis: agentInactiveDisconnect.Milliseconds(),
+				TerminalIdleTimeoutMillis:            terminalIdleTimeout.Milliseconds(),
 			}
 
 			_, err = client.UpdateTemplateMeta(cmd.Context(), template.ID, req)
@@ -61,6 +65,8 @@ func templateEdit() *cobra.Command {
 	cmd.Flags().StringVarP(&icon, "icon", "", "", "Edit the template icon path")
 	cmd.Flags().DurationVarP(&maxTTL, "max-ttl", "", 0, "Edit the template maximum time before shutdown - workspaces created from this template cannot stay running longer than this.")
 	cmd.Flags().DurationVarP(&minAutostartInterval, "min-autostart-interval", "", 0, "Edit the template minimum autostart interval - workspaces created from this template must wait at least this long between autostarts.")
+	cmd.Flags().DurationVarP(&agentInactiveDisconnect, "agent-inactive-disconnect-timeout", "", 0, "Edit how long agents of workspaces created from this template may go without a heartbeat before they're considered disconnected.")
+	cmd.Flags().DurationVarP(&terminalIdleTimeout, "terminal-idle-timeout", "", 0, "Edit how long web terminals of workspaces created from this template may go without input before they're closed.")
 	cliui.AllowSkipPrompt(cmd)
 
 	return cmd
@@ -1,6 +1,8 @@
 package coderd
 
 import (
+	"bytes"
+	"context"
 	"crypto/x509"
 	"io"
 	"net/http"
@@ -18,9 +20,12 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"golang.org/x/xerrors"
 	"google.golang.org/api/idtoken"
+	"nhooyr.io/websocket"
+	"tailscale.com/tailcfg"
 
 	"cdr.dev/slog"
 	"github.com/coder/coder/buildinfo"
+	"github.com/coder/coder/coderd/audit"
 	"github.com/coder/coder/coderd/awsidentity"
 	"github.com/coder/coder/coderd/database"
 	"github.com/coder/coder/coderd/gitsshkey"
@@ -32,6 +37,7 @@ import (
 	"github.com/coder/coder/coderd/turnconn"
 	"github.com/coder/coder/coderd/wsconncache"
 	"github.com/coder/coder/codersdk"
+	"github.com/coder/coder/peer/peerwg"
 	"github.com/coder/coder/site"
 )
 
@@ -47,6 +53,49 @@ type Options struct {
 
 	AgentConnectionUpdateFrequency time.Duration
 	AgentInactiveDisconnectTimeout time.Duration
+	// AgentDialTimeout is how long coderd waits for a connection to
+	// a workspace agent to be established. It's short, because a connection
+	// that takes longer has usually failed, and requests wait on it.
+	AgentDialTimeout time.Duration
+	// AgentShellPrompt is the default PS1 of shells in workspaces.
+	// "{workspace}" and "{agent}" are replaced with their names.
+	AgentShellPrompt string
+	// AgentPTYEnvironmentDenylist and AgentPTYEnvironmentAllowlist filter
+	// the environment of reconnecting PTY sessions in workspaces. See
+	// agent.Metadata.
+	AgentPTYEnvironmentDenylist  []string
+	AgentPTYEnvironmentAllowlist []string
+	// AgentDisabledProtocols are agent channel protocols refused in every
+	// workspace, like "reconnecting-pty" to turn off the web terminal.
+	AgentDisabledProtocols []string
+	// AgentRedactProcessArguments omits the arguments of processes in the
+	// process trees of reconnecting PTY sessions.
+	AgentRedactProcessArguments bool
+	// Auditor exports audit logs, like workspace agents connecting and
+	// disconnecting. Logs aren't produced when it's nil.
+	Auditor *audit.Exporter
+	// AgentReconnectDelay is how long agents are asked to wait before
+	// reconnecting when this replica stops serving them, giving load
+	// balancers time to route them to another replica.
+	AgentReconnectDelay time.Duration
+	// WebsocketAcceptTimeout bounds the handshake of agent websockets, so
+	// clients that stall the upgrade don't tie up handlers.
+	WebsocketAcceptTimeout time.Duration
+	// AgentDatabaseCallTimeout bounds each database call made while serving
+	// an agent connection, so a hung query fails the connection instead of
+	// stalling it.
+	AgentDatabaseCallTimeout time.Duration
+	// PTYCompression negotiates compression on reconnecting PTY websockets,
+	// which applies to input as well as output.
+	PTYCompression bool
+	// PTYOutputHighWater is how many bytes of terminal output are buffered
+	// for a websocket client before reading from the agent pauses. Clients
+	// that leave the buffer full for PTYOutputStallTimeout are disconnected.
+	PTYOutputHighWater    int
+	PTYOutputStallTimeout time.Duration
+	// TerminalIdleTimeout closes web terminals that haven't received input
+	// for this long. Templates can override it. Zero disables the timeout.
+	TerminalIdleTimeout time.Duration
 	// APIRateLimit is the minutely throughput rate limit per user or ip.
 	// Setting a rate limit <0 will disable the rate limiter across the entire
 	// app. Specific routes may have their own limiters.
@@ -67,6 +116,16 @@ type Options struct {
 	AutoImportTemplates  []AutoImportTemplate
 	LicenseHandler       http.Handler
 	FeaturesService      FeaturesService
+
+	// TURNSecret is shared with external TURN servers in ICEServers. When
+	// set, each request for ICE servers receives fresh credentials that
+	// expire after TURNCredentialTTL.
+	TURNSecret        string
+	TURNCredentialTTL time.Duration
+
+	// DERPMap is served to wireguard peers and probed by the DERP latency
+	// endpoint. It defaults to peerwg.DerpMap.
+	DERPMap *tailcfg.DERPMap
 }
 
 // New constructs a Coder API handler.
@@ -78,6 +137,27 @@ func New(options *Options) *API {
 		// Multiply the update by two to allow for some lag-time.
 		options.AgentInactiveDisconnectTimeout = options.AgentConnectionUpdateFrequency * 2
 	}
+	if options.AgentDialTimeout == 0 {
+		options.AgentDialTimeout = 5 * time.Second
+	}
+	if options.AgentReconnectDelay == 0 {
+		options.AgentReconnectDelay = 500 * time.Millisecond
+	}
+	if options.WebsocketAcceptTimeout == 0 {
+		options.WebsocketAcceptTimeout = 10 * time.Second
+	}
+	if options.AgentDatabaseCallTimeout == 0 {
+		options.AgentDatabaseCallTimeout = 10 * time.Second
+	}
+	if options.PTYOutputHighWater == 0 {
+		options.PTYOutputHighWater = 1 << 20
+	}
+	if options.PTYOutputStallTimeout == 0 {
+		options.PTYOutputStallTimeout = 30 * time.Second
+	}
+	if options.TURNCredentialTTL == 0 {
+		options.TURNCredentialTTL = 24 * time.Hour
+	}
 	if options.APIRateLimit == 0 {
 		options.APIRateLimit = 512
 	}
@@ -99,6 +179,9 @@ func New(options *Options) *API {
 	if options.FeaturesService == nil {
 		options.FeaturesService = featuresService{}
 	}
+	if options.DERPMap == nil {
+		options.DERPMap = peerwg.DerpMap
+	}
 
 	siteCacheDir := options.CacheDir
 	if siteCacheDir != "" {
@@ -118,6 +201,9 @@ func New(options *Options) *API {
 			Authorizer: options.Authorizer,
 			Logger:     options.Logger,
 		},
+		agentConnectionSetup: newAgentConnectionSetupHistogram(options.PrometheusRegistry),
+		agentReconnect:       make(chan struct{}),
+		ptyConns:             make(map[ptySessionKey]map[*websocket.Conn]struct{}),
 	}
 	api.workspaceAgentCache = wsconncache.New(api.dialWorkspaceAgent, 0)
 	oauthConfigs := &httpmw.OAuth2Configs{
@@ -136,7 +222,8 @@ func New(options *Options) *API {
 		r.Use(
 			httpmw.RateLimitPerMinute(options.APIRateLimit),
 			tracing.HTTPMW(api.TracerProvider, "coderd.http"),
-			httpmw.ExtractAPIKey(options.Database, oauthConfigs, true),
+			// Apps shared with a workspace app token don't need a session.
+			httpmw.ExtractWorkspaceAppToken(options.Database, httpmw.ExtractAPIKey(options.Database, oauthConfigs, true)),
 			httpmw.ExtractUserParam(api.Database),
 			// Extracts the <workspace.agent> from the url
 			httpmw.ExtractWorkspaceAndAgentParam(api.Database),
@@ -177,6 +264,10 @@ func New(options *Options) *API {
 				})
 			})
 		})
+		r.Route("/derp", func(r chi.Router) {
+			r.Use(apiKeyMiddleware)
+			r.Get("/latency", api.derpLatency)
+		})
 		r.Route("/files", func(r chi.Router) {
 			r.Use(
 				apiKeyMiddleware,
@@ -206,12 +297,13 @@ func New(options *Options) *API {
 				r.Post("/templateversions", api.postTemplateVersionsByOrganization)
 				r.Route("/templates", func(r chi.Router) {
 					r.Post("/", api.postTemplateByOrganization)
-					r.Get("/", api.templatesByOrganization)
+					r.With(compressLargeHandler).Get("/", api.templatesByOrganization)
 					r.Get("/{templatename}", api.templateByOrganizationAndName)
 				})
 				r.Post("/workspaces", api.postWorkspacesByOrganization)
 				r.Route("/members", func(r chi.Router) {
 					r.Get("/roles", api.assignableOrgRoles)
+					r.Get("/roles/permissions", api.orgRolePermissions)
 					r.Route("/{user}", func(r chi.Router) {
 						r.Use(
 							httpmw.ExtractUserParam(options.Database),
@@ -240,7 +332,7 @@ func New(options *Options) *API {
 			r.Delete("/", api.deleteTemplate)
 			r.Patch("/", api.patchTemplateMeta)
 			r.Route("/versions", func(r chi.Router) {
-				r.Get("/", api.templateVersionsByTemplate)
+				r.With(compressLargeHandler).Get("/", api.templateVersionsByTemplate)
 				r.Patch("/", api.patchActiveTemplateVersion)
 				r.Get("/{templateversionname}", api.templateVersionByName)
 			})
@@ -285,11 +377,12 @@ func New(options *Options) *API {
 					apiKeyMiddleware,
 				)
 				r.Post("/", api.postUser)
-				r.Get("/", api.users)
+				r.With(compressLargeHandler).Get("/", api.users)
 				r.Post("/logout", api.postLogout)
 				// These routes query information about site wide roles.
 				r.Route("/roles", func(r chi.Router) {
 					r.Get("/", api.assignableSiteRoles)
+					r.Get("/permissions", api.siteRolePermissions)
 				})
 				r.Route("/{user}", func(r chi.Router) {
 					r.Use(httpmw.ExtractUserParam(options.Database))
@@ -339,6 +432,10 @@ func New(options *Options) *API {
 				r.Get("/iceservers", api.workspaceAgentICEServers)
 				r.Get("/wireguardlisten", api.workspaceAgentWireguardListener)
 				r.Post("/keys", api.postWorkspaceAgentKeys)
+				r.Post("/startupphase", api.postWorkspaceAgentStartupPhase)
+				r.Post("/directory", api.postWorkspaceAgentDirectory)
+				r.Post("/network", api.postWorkspaceAgentNetwork)
+				r.Post("/diagnostics", api.postWorkspaceAgentDiagnostics)
 				r.Get("/derp", api.derpMap)
 			})
 			r.Route("/{workspaceagent}", func(r chi.Router) {
@@ -348,10 +445,18 @@ func New(options *Options) *API {
 					httpmw.ExtractWorkspaceParam(options.Database),
 				)
 				r.Get("/", api.workspaceAgent)
+				r.Put("/maintenance", api.putWorkspaceAgentMaintenance)
 				r.Post("/peer", api.postWorkspaceAgentWireguardPeer)
-				r.Get("/dial", api.workspaceAgentDial)
+				r.With(httpmw.Deprecated(codersdk.Deprecation{
+					Message: "Dialing agents over WebRTC is deprecated in favor of Tailnet.",
+				})).Get("/dial", api.workspaceAgentDial)
 				r.Get("/turn", api.userWorkspaceAgentTurn)
 				r.Get("/pty", api.workspaceAgentPTY)
+				r.Get("/pty/{reconnect}/processes", api.workspaceAgentPTYProcesses)
+				r.Get("/candidatepair", api.workspaceAgentCandidatePair)
+				r.Get("/capabilities", api.workspaceAgentCapabilities)
+				r.Get("/listening-ports", api.workspaceAgentListeningPorts)
+				r.Get("/diagnostics", api.workspaceAgentDiagnosticsBundle)
 				r.Get("/iceservers", api.workspaceAgentICEServers)
 				r.Get("/derp", api.derpMap)
 			})
@@ -367,8 +472,11 @@ func New(options *Options) *API {
 		r.Route("/workspaces", func(r chi.Router) {
 			r.Use(
 				apiKeyMiddleware,
+				// Workspaces are read by integrations that may expect
+				// camelCase.
+				httpmw.AcceptCasing(),
 			)
-			r.Get("/", api.workspaces)
+			r.With(compressLargeHandler).Get("/", api.workspaces)
 			r.Route("/{workspace}", func(r chi.Router) {
 				r.Use(
 					httpmw.ExtractWorkspaceParam(options.Database),
@@ -376,7 +484,7 @@ func New(options *Options) *API {
 				r.Get("/", api.workspace)
 				r.Patch("/", api.patchWorkspace)
 				r.Route("/builds", func(r chi.Router) {
-					r.Get("/", api.workspaceBuilds)
+					r.With(compressLargeHandler).Get("/", api.workspaceBuilds)
 					r.Post("/", api.postWorkspaceBuilds)
 					r.Get("/{workspacebuildname}", api.workspaceBuildByName)
 				})
@@ -388,11 +496,13 @@ func New(options *Options) *API {
 				})
 				r.Get("/watch", api.watchWorkspace)
 				r.Put("/extend", api.putExtendWorkspace)
+				r.Post("/apptokens", api.postWorkspaceAppToken)
 			})
 		})
 		r.Route("/workspacebuilds/{workspacebuild}", func(r chi.Router) {
 			r.Use(
 				apiKeyMiddleware,
+				httpmw.AcceptCasing(),
 				httpmw.ExtractWorkspaceBuildParam(options.Database),
 				httpmw.ExtractWorkspaceParam(options.Database),
 			)
@@ -425,6 +535,21 @@ type API struct {
 	websocketWaitGroup  sync.WaitGroup
 	workspaceAgentCache *wsconncache.Cache
 	httpAuth            *HTTPAuthorizer
+
+	// agentReconnect is closed to ask the agents listening at the time to
+	// reconnect. It's replaced once their connections have closed.
+	agentReconnectMutex  sync.Mutex
+	agentReconnect       chan struct{}
+	agentListenWaitGroup sync.WaitGroup
+
+	// ptyConns are the reconnecting PTY websockets served by this replica,
+	// so a takeover can detach them with a reason.
+	ptyConnsMutex sync.Mutex
+	ptyConns      map[ptySessionKey]map[*websocket.Conn]struct{}
+
+	// agentConnectionSetup observes how long agent connections take to
+	// establish, labeled by kind and outcome.
+	agentConnectionSetup *prometheus.HistogramVec
 }
 
 // Close waits for all WebSocket connections to drain before returning.
@@ -436,7 +561,61 @@ func (api *API) Close() error {
 	return api.workspaceAgentCache.Close()
 }
 
+// ReconnectAgents asks the agents connected to this replica to reconnect,
+// waiting AgentReconnectDelay first, and returns once their connections have
+// closed or ctx is done. It's called when the replica is shutting down, after
+// it stops accepting connections but before in-flight requests are canceled,
+// so agents move to another replica instead of being dropped.
+func (api *API) ReconnectAgents(ctx context.Context) error {
+	api.agentReconnectMutex.Lock()
+	defer api.agentReconnectMutex.Unlock()
+	close(api.agentReconnect)
+	defer func() {
+		api.agentReconnect = make(chan struct{})
+	}()
+
+	closed := make(chan struct{})
+	go func() {
+		api.agentListenWaitGroup.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func compressHandler(h http.Handler) http.Handler {
+	return newCompressor().Handler(h)
+}
+
+// compressThreshold is the smallest response body compressLargeHandler
+// compresses. Smaller bodies barely shrink, so compressing them isn't worth
+// the overhead.
+const compressThreshold = 1024
+
+// compressLargeHandler is compressHandler for responses that grow with the
+// deployment, like lists of workspaces. Bodies smaller than
+// compressThreshold are written uncompressed.
+func compressLargeHandler(h http.Handler) http.Handler {
+	cmp := newCompressor()
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// Responses may or may not be compressed depending on the header.
+		rw.Header().Add("Vary", "Accept-Encoding")
+		cmp.Handler(http.HandlerFunc(func(compressed http.ResponseWriter, r *http.Request) {
+			writer := &thresholdWriter{
+				ResponseWriter: rw,
+				compressed:     compressed,
+			}
+			defer writer.Close()
+			h.ServeHTTP(writer, r)
+		})).ServeHTTP(rw, r)
+	})
+}
+
+func newCompressor() *middleware.Compressor {
 	cmp := middleware.NewCompressor(5,
 		"text/*",
 		"application/*",
@@ -452,6 +631,85 @@ func compressHandler(h http.Handler) http.Handler {
 		}
 		return zw
 	})
+	return cmp
+}
+
+var _ http.ResponseWriter = (*thresholdWriter)(nil)
+var _ http.Flusher = (*thresholdWriter)(nil)
 
-	return cmp.Handler(h)
+// thresholdWriter holds back a response until its body reaches
+// compressThreshold, then writes it to compressed. Responses that end before
+// then are written to ResponseWriter uncompressed when it's closed.
+type thresholdWriter struct {
+	http.ResponseWriter
+	compressed http.ResponseWriter
+
+	status int
+	buf    bytes.Buffer
+	// out is the writer the response goes to, once it's been chosen.
+	out http.ResponseWriter
+}
+
+func (w *thresholdWriter) WriteHeader(status int) {
+	if w.out != nil {
+		w.out.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *thresholdWriter) Write(p []byte) (int, error) {
+	if w.out == nil {
+		if w.buf.Len()+len(p) < compressThreshold {
+			return w.buf.Write(p)
+		}
+		err := w.start(w.compressed)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return w.out.Write(p)
+}
+
+// Flush sends the response compressed, since more of it may follow.
+func (w *thresholdWriter) Flush() {
+	if w.out == nil {
+		err := w.start(w.compressed)
+		if err != nil {
+			return
+		}
+	}
+	if flusher, ok := w.out.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the uncompressed writer, so writers installed before
+// compressLargeHandler, like httpapi.CasingWriter, can be found.
+func (w *thresholdWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close writes a response that didn't reach compressThreshold uncompressed.
+func (w *thresholdWriter) Close() error {
+	if w.out != nil || (w.status == 0 && w.buf.Len() == 0) {
+		return nil
+	}
+	return w.start(w.ResponseWriter)
+}
+
+// start writes the held back response to out.
+func (w *thresholdWriter) start(out http.ResponseWriter) error {
+	w.out = out
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	out.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := out.Write(w.buf.Bytes())
+	return err
 }
@@ -30,16 +30,19 @@ import (
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
 	"github.com/moby/moby/pkg/namesgenerator"
+	"github.com/pion/webrtc/v3"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/xerrors"
 	"google.golang.org/api/idtoken"
 	"google.golang.org/api/option"
+	"tailscale.com/tailcfg"
 
 	"cdr.dev/slog"
 	"cdr.dev/slog/sloggers/slogtest"
 	"github.com/coder/coder/coderd"
+	"github.com/coder/coder/coderd/audit"
 	"github.com/coder/coder/coderd/autobuild/executor"
 	"github.com/coder/coder/coderd/awsidentity"
 	"github.com/coder/coder/coderd/database"
@@ -66,11 +69,19 @@ type Options struct {
 	GithubOAuth2Config   *coderd.GithubOAuth2Config
 	OIDCConfig           *coderd.OIDCConfig
 	GoogleTokenValidator *idtoken.Validator
+	ICEServers           []webrtc.ICEServer
+	TURNSecret           string
 	SSHKeygenAlgorithm   gitsshkey.Algorithm
 	APIRateLimit         int
 	AutoImportTemplates  []coderd.AutoImportTemplate
 	AutobuildTicker      <-chan time.Time
 	AutobuildStats       chan<- executor.Stats
+	DERPMap              *tailcfg.DERPMap
+	// AgentDisabledProtocols are agent protocols refused in every workspace.
+	AgentDisabledProtocols []string
+	AgentShellPrompt       string
+	Auditor                *audit.Exporter
+	TerminalIdleTimeout    time.Duration
 
 	// IncludeProvisionerD when true means to start an in-memory provisionerD
 	IncludeProvisionerD bool
@@ -207,11 +218,19 @@ func newWithAPI(t *testing.T, options *Options) (*codersdk.Client, io.Closer, *c
 		OIDCConfig:           options.OIDCConfig,
 		GoogleTokenValidator: options.GoogleTokenValidator,
 		SSHKeygenAlgorithm:   options.SSHKeygenAlgorithm,
+		ICEServers:           options.ICEServers,
 		TURNServer:           turnServer,
+		TURNSecret:           options.TURNSecret,
+		DERPMap:              options.DERPMap,
 		APIRateLimit:         options.APIRateLimit,
 		Authorizer:           options.Authorizer,
 		Telemetry:            telemetry.NewNoop(),
 		AutoImportTemplates:  options.AutoImportTemplates,
+
+		AgentDisabledProtocols: options.AgentDisabledProtocols,
+		AgentShellPrompt:       options.AgentShellPrompt,
+		Auditor:                options.Auditor,
+		TerminalIdleTimeout:    options.TerminalIdleTimeout,
 	})
 	t.Cleanup(func() {
 		_ = coderAPI.Close()
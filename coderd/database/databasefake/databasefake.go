@@ -91,8 +91,10 @@ type data struct {
 	provisionerJobs                []database.ProvisionerJob
 	templateVersions               []database.TemplateVersion
 	templates                      []database.Template
+	workspaceAgentStartupPhases    []database.WorkspaceAgentStartupPhase
 	workspaceBuilds                []database.WorkspaceBuild
 	workspaceApps                  []database.WorkspaceApp
+	workspaceAppTokens             []database.WorkspaceAppToken
 	workspaces                     []database.Workspace
 	licenses                       []database.License
 
@@ -527,6 +529,51 @@ func (q *fakeQuerier) GetWorkspaceAppsByAgentIDs(_ context.Context, ids []uuid.U
 	return apps, nil
 }
 
+func (q *fakeQuerier) GetWorkspaceAppTokenByID(_ context.Context, id string) (database.WorkspaceAppToken, error) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	for _, token := range q.workspaceAppTokens {
+		if token.ID == id {
+			return token, nil
+		}
+	}
+	return database.WorkspaceAppToken{}, sql.ErrNoRows
+}
+
+func (q *fakeQuerier) DeleteWorkspaceAgentStartupPhasesByAgentID(_ context.Context, agentID uuid.UUID) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	phases := make([]database.WorkspaceAgentStartupPhase, 0, len(q.workspaceAgentStartupPhases))
+	for _, phase := range q.workspaceAgentStartupPhases {
+		if phase.AgentID != agentID {
+			phases = append(phases, phase)
+		}
+	}
+	q.workspaceAgentStartupPhases = phases
+	return nil
+}
+
+func (q *fakeQuerier) GetWorkspaceAgentStartupPhasesByAgentIDs(_ context.Context, ids []uuid.UUID) ([]database.WorkspaceAgentStartupPhase, error) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	phases := make([]database.WorkspaceAgentStartupPhase, 0)
+	for _, phase := range q.workspaceAgentStartupPhases {
+		for _, id := range ids {
+			if phase.AgentID == id {
+				phases = append(phases, phase)
+				break
+			}
+		}
+	}
+	sort.SliceStable(phases, func(i, j int) bool {
+		return phases[i].CreatedAt.Before(phases[j].CreatedAt)
+	})
+	return phases, nil
+}
+
 func (q *fakeQuerier) GetWorkspacesAutostart(_ context.Context) ([]database.Workspace, error) {
 	q.mutex.RLock()
 	defer q.mutex.RUnlock()
@@ -903,6 +950,8 @@ func (q *fakeQuerier) UpdateTemplateMetaByID(_ context.Context, arg database.Upd
 		tpl.Icon = arg.Icon
 		tpl.MaxTtl = arg.MaxTtl
 		tpl.MinAutostartInterval = arg.MinAutostartInterval
+		tpl.AgentInactiveDisconnectTimeout = arg.AgentInactiveDisconnectTimeout
+		tpl.TerminalIdleTimeout = arg.TerminalIdleTimeout
 		q.templates[idx] = tpl
 		return nil
 	}
@@ -1578,17 +1627,19 @@ func (q *fakeQuerier) InsertTemplate(_ context.Context, arg database.InsertTempl
 
 	//nolint:gosimple
 	template := database.Template{
-		ID:                   arg.ID,
-		CreatedAt:            arg.CreatedAt,
-		UpdatedAt:            arg.UpdatedAt,
-		OrganizationID:       arg.OrganizationID,
-		Name:                 arg.Name,
-		Provisioner:          arg.Provisioner,
-		ActiveVersionID:      arg.ActiveVersionID,
-		Description:          arg.Description,
-		MaxTtl:               arg.MaxTtl,
-		MinAutostartInterval: arg.MinAutostartInterval,
-		CreatedBy:            arg.CreatedBy,
+		ID:                             arg.ID,
+		CreatedAt:                      arg.CreatedAt,
+		UpdatedAt:                      arg.UpdatedAt,
+		OrganizationID:                 arg.OrganizationID,
+		Name:                           arg.Name,
+		Provisioner:                    arg.Provisioner,
+		ActiveVersionID:                arg.ActiveVersionID,
+		Description:                    arg.Description,
+		MaxTtl:                         arg.MaxTtl,
+		MinAutostartInterval:           arg.MinAutostartInterval,
+		CreatedBy:                      arg.CreatedBy,
+		AgentInactiveDisconnectTimeout: arg.AgentInactiveDisconnectTimeout,
+		TerminalIdleTimeout:            arg.TerminalIdleTimeout,
 	}
 	q.templates = append(q.templates, template)
 	return template, nil
@@ -1895,6 +1946,21 @@ func (q *fakeQuerier) InsertWorkspaceBuild(_ context.Context, arg database.Inser
 	return workspaceBuild, nil
 }
 
+func (q *fakeQuerier) InsertWorkspaceAgentStartupPhase(_ context.Context, arg database.InsertWorkspaceAgentStartupPhaseParams) (database.WorkspaceAgentStartupPhase, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	//nolint:gosimple
+	phase := database.WorkspaceAgentStartupPhase{
+		ID:        arg.ID,
+		AgentID:   arg.AgentID,
+		Phase:     arg.Phase,
+		CreatedAt: arg.CreatedAt,
+	}
+	q.workspaceAgentStartupPhases = append(q.workspaceAgentStartupPhases, phase)
+	return phase, nil
+}
+
 func (q *fakeQuerier) InsertWorkspaceApp(_ context.Context, arg database.InsertWorkspaceAppParams) (database.WorkspaceApp, error) {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
@@ -1914,6 +1980,24 @@ func (q *fakeQuerier) InsertWorkspaceApp(_ context.Context, arg database.InsertW
 	return workspaceApp, nil
 }
 
+func (q *fakeQuerier) InsertWorkspaceAppToken(_ context.Context, arg database.InsertWorkspaceAppTokenParams) (database.WorkspaceAppToken, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// nolint:gosimple
+	token := database.WorkspaceAppToken{
+		ID:           arg.ID,
+		HashedSecret: arg.HashedSecret,
+		UserID:       arg.UserID,
+		WorkspaceID:  arg.WorkspaceID,
+		AppName:      arg.AppName,
+		CreatedAt:    arg.CreatedAt,
+		ExpiresAt:    arg.ExpiresAt,
+	}
+	q.workspaceAppTokens = append(q.workspaceAppTokens, token)
+	return token, nil
+}
+
 func (q *fakeQuerier) UpdateAPIKeyByID(_ context.Context, arg database.UpdateAPIKeyByIDParams) error {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
@@ -2029,6 +2113,23 @@ func (q *fakeQuerier) UpdateWorkspaceAgentConnectionByID(_ context.Context, arg
 	return sql.ErrNoRows
 }
 
+func (q *fakeQuerier) UpdateWorkspaceAgentDirectoryExistsByID(_ context.Context, arg database.UpdateWorkspaceAgentDirectoryExistsByIDParams) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for index, agent := range q.provisionerJobAgents {
+		if agent.ID != arg.ID {
+			continue
+		}
+
+		agent.DirectoryExists = arg.DirectoryExists
+		agent.UpdatedAt = arg.UpdatedAt
+		q.provisionerJobAgents[index] = agent
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
 func (q *fakeQuerier) UpdateWorkspaceAgentKeysByID(_ context.Context, arg database.UpdateWorkspaceAgentKeysByIDParams) error {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
@@ -2047,6 +2148,62 @@ func (q *fakeQuerier) UpdateWorkspaceAgentKeysByID(_ context.Context, arg databa
 	return sql.ErrNoRows
 }
 
+func (q *fakeQuerier) UpdateWorkspaceAgentMaintenanceByID(_ context.Context, arg database.UpdateWorkspaceAgentMaintenanceByIDParams) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for index, agent := range q.provisionerJobAgents {
+		if agent.ID != arg.ID {
+			continue
+		}
+
+		agent.Maintenance = arg.Maintenance
+		agent.UpdatedAt = arg.UpdatedAt
+		q.provisionerJobAgents[index] = agent
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+func (q *fakeQuerier) UpdateWorkspaceAgentDiagnosticsByID(_ context.Context, arg database.UpdateWorkspaceAgentDiagnosticsByIDParams) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for index, agent := range q.provisionerJobAgents {
+		if agent.ID != arg.ID {
+			continue
+		}
+
+		agent.ProcessStartedAt = arg.ProcessStartedAt
+		agent.ClockSync = arg.ClockSync
+		agent.DiagnosticsReportedAt = arg.DiagnosticsReportedAt
+		agent.UpdatedAt = arg.DiagnosticsReportedAt.Time
+		agent.NetworkInterfaces = arg.NetworkInterfaces
+		q.provisionerJobAgents[index] = agent
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+func (q *fakeQuerier) UpdateWorkspaceAgentNetworkReportByID(_ context.Context, arg database.UpdateWorkspaceAgentNetworkReportByIDParams) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for index, agent := range q.provisionerJobAgents {
+		if agent.ID != arg.ID {
+			continue
+		}
+
+		agent.PublicIP = arg.PublicIP
+		agent.NATType = arg.NATType
+		agent.NetworkReportedAt = arg.NetworkReportedAt
+		agent.UpdatedAt = arg.NetworkReportedAt.Time
+		q.provisionerJobAgents[index] = agent
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
 func (q *fakeQuerier) UpdateProvisionerJobByID(_ context.Context, arg database.UpdateProvisionerJobByIDParams) error {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
@@ -3,7 +3,9 @@
 CREATE TYPE audit_action AS ENUM (
     'create',
     'write',
-    'delete'
+    'delete',
+    'connect',
+    'disconnect'
 );
 
 CREATE TYPE build_reason AS ENUM (
@@ -73,7 +75,8 @@ CREATE TYPE resource_type AS ENUM (
     'template',
     'template_version',
     'user',
-    'workspace'
+    'workspace',
+    'workspace_agent'
 );
 
 CREATE TYPE user_status AS ENUM (
@@ -264,7 +267,9 @@ CREATE TABLE templates (
     max_ttl bigint DEFAULT '604800000000000'::bigint NOT NULL,
     min_autostart_interval bigint DEFAULT '3600000000000'::bigint NOT NULL,
     created_by uuid NOT NULL,
-    icon character varying(256) DEFAULT ''::character varying NOT NULL
+    icon character varying(256) DEFAULT ''::character varying NOT NULL,
+    agent_inactive_disconnect_timeout bigint DEFAULT 0 NOT NULL,
+    terminal_idle_timeout bigint DEFAULT 0 NOT NULL
 );
 
 CREATE TABLE user_links (
@@ -288,6 +293,13 @@ CREATE TABLE users (
     login_type login_type DEFAULT 'password'::public.login_type NOT NULL
 );
 
+CREATE TABLE workspace_agent_startup_phases (
+    id uuid NOT NULL,
+    agent_id uuid NOT NULL,
+    phase character varying(64) NOT NULL,
+    created_at timestamp with time zone NOT NULL
+);
+
 CREATE TABLE workspace_agents (
     id uuid NOT NULL,
     created_at timestamp with time zone NOT NULL,
@@ -308,7 +320,26 @@ CREATE TABLE workspace_agents (
     directory character varying(4096) DEFAULT ''::character varying NOT NULL,
     wireguard_node_ipv6 inet DEFAULT '::'::inet NOT NULL,
     wireguard_node_public_key character varying(128) DEFAULT 'nodekey:0000000000000000000000000000000000000000000000000000000000000000'::character varying NOT NULL,
-    wireguard_disco_public_key character varying(128) DEFAULT 'discokey:0000000000000000000000000000000000000000000000000000000000000000'::character varying NOT NULL
+    wireguard_disco_public_key character varying(128) DEFAULT 'discokey:0000000000000000000000000000000000000000000000000000000000000000'::character varying NOT NULL,
+    directory_exists boolean,
+    public_ip inet,
+    nat_type character varying(64),
+    network_reported_at timestamp with time zone,
+    maintenance boolean DEFAULT false NOT NULL,
+    process_started_at timestamp with time zone,
+    clock_sync character varying(64),
+    diagnostics_reported_at timestamp with time zone,
+    network_interfaces jsonb
+);
+
+CREATE TABLE workspace_app_tokens (
+    id text NOT NULL,
+    hashed_secret bytea NOT NULL,
+    user_id uuid NOT NULL,
+    workspace_id uuid NOT NULL,
+    app_name character varying(64) NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    expires_at timestamp with time zone NOT NULL
 );
 
 CREATE TABLE workspace_apps (
@@ -435,9 +466,15 @@ ALTER TABLE ONLY user_links
 ALTER TABLE ONLY users
     ADD CONSTRAINT users_pkey PRIMARY KEY (id);
 
+ALTER TABLE ONLY workspace_agent_startup_phases
+    ADD CONSTRAINT workspace_agent_startup_phases_pkey PRIMARY KEY (id);
+
 ALTER TABLE ONLY workspace_agents
     ADD CONSTRAINT workspace_agents_pkey PRIMARY KEY (id);
 
+ALTER TABLE ONLY workspace_app_tokens
+    ADD CONSTRAINT workspace_app_tokens_pkey PRIMARY KEY (id);
+
 ALTER TABLE ONLY workspace_apps
     ADD CONSTRAINT workspace_apps_agent_id_name_key UNIQUE (agent_id, name);
 
@@ -491,6 +528,8 @@ CREATE UNIQUE INDEX templates_organization_id_name_idx ON templates USING btree
 
 CREATE UNIQUE INDEX users_username_lower_idx ON users USING btree (lower(username));
 
+CREATE INDEX workspace_agent_startup_phases_agent_id_idx ON workspace_agent_startup_phases USING btree (agent_id);
+
 CREATE UNIQUE INDEX workspaces_owner_id_lower_idx ON workspaces USING btree (owner_id, lower((name)::text)) WHERE (deleted = false);
 
 ALTER TABLE ONLY api_keys
@@ -532,9 +571,18 @@ ALTER TABLE ONLY templates
 ALTER TABLE ONLY user_links
     ADD CONSTRAINT user_links_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
 
+ALTER TABLE ONLY workspace_agent_startup_phases
+    ADD CONSTRAINT workspace_agent_startup_phases_agent_id_fkey FOREIGN KEY (agent_id) REFERENCES workspace_agents(id) ON DELETE CASCADE;
+
 ALTER TABLE ONLY workspace_agents
     ADD CONSTRAINT workspace_agents_resource_id_fkey FOREIGN KEY (resource_id) REFERENCES workspace_resources(id) ON DELETE CASCADE;
 
+ALTER TABLE ONLY workspace_app_tokens
+    ADD CONSTRAINT workspace_app_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE ONLY workspace_app_tokens
+    ADD CONSTRAINT workspace_app_tokens_workspace_id_fkey FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE;
+
 ALTER TABLE ONLY workspace_apps
     ADD CONSTRAINT workspace_apps_agent_id_fkey FOREIGN KEY (agent_id) REFERENCES workspace_agents(id) ON DELETE CASCADE;
 
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS workspace_agent_startup_phases (
+	id uuid NOT NULL,
+	agent_id uuid NOT NULL REFERENCES workspace_agents (id) ON DELETE CASCADE,
+	phase varchar(64) NOT NULL,
+	created_at timestamptz NOT NULL,
+	PRIMARY KEY (id)
+);
+
+CREATE INDEX workspace_agent_startup_phases_agent_id_idx ON workspace_agent_startup_phases USING btree (agent_id);
@@ -0,0 +1,2 @@
+ALTER TABLE ONLY workspace_agents
+	DROP COLUMN IF EXISTS directory_exists;
@@ -0,0 +1,4 @@
+-- Agents report whether their directory exists once they connect. It's
+-- NULL until then.
+ALTER TABLE ONLY workspace_agents
+	ADD COLUMN IF NOT EXISTS directory_exists boolean;
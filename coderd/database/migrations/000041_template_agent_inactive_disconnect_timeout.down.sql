@@ -0,0 +1 @@
+ALTER TABLE templates DROP COLUMN agent_inactive_disconnect_timeout;
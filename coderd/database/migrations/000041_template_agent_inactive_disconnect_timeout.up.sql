@@ -0,0 +1,2 @@
+-- Zero means the deployment-wide timeout applies.
+ALTER TABLE templates ADD COLUMN agent_inactive_disconnect_timeout BIGINT NOT NULL DEFAULT 0;
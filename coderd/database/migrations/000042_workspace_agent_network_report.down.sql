@@ -0,0 +1,4 @@
+ALTER TABLE ONLY workspace_agents
+	DROP COLUMN IF EXISTS public_ip,
+	DROP COLUMN IF EXISTS nat_type,
+	DROP COLUMN IF EXISTS network_reported_at;
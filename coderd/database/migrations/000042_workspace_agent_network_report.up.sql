@@ -0,0 +1,6 @@
+-- Agents report the public IP and NAT type their STUN servers observe.
+-- These are NULL until the first report.
+ALTER TABLE ONLY workspace_agents
+	ADD COLUMN IF NOT EXISTS public_ip inet,
+	ADD COLUMN IF NOT EXISTS nat_type character varying(64),
+	ADD COLUMN IF NOT EXISTS network_reported_at timestamp with time zone;
@@ -0,0 +1,5 @@
+-- Agents flagged for maintenance are expected to be down, so they're
+-- reported distinctly from unexpected disconnects. The flag is cleared
+-- when the agent reconnects.
+ALTER TABLE ONLY workspace_agents
+	ADD COLUMN IF NOT EXISTS maintenance boolean DEFAULT false NOT NULL;
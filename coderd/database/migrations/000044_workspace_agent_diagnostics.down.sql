@@ -0,0 +1,4 @@
+ALTER TABLE ONLY workspace_agents
+	DROP COLUMN IF EXISTS process_started_at,
+	DROP COLUMN IF EXISTS clock_sync,
+	DROP COLUMN IF EXISTS diagnostics_reported_at;
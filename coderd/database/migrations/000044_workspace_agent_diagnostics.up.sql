@@ -0,0 +1,6 @@
+-- Agents report how long their process has been running and whether their
+-- clock is synchronized. These are NULL until the first report.
+ALTER TABLE ONLY workspace_agents
+	ADD COLUMN IF NOT EXISTS process_started_at timestamp with time zone,
+	ADD COLUMN IF NOT EXISTS clock_sync character varying(64),
+	ADD COLUMN IF NOT EXISTS diagnostics_reported_at timestamp with time zone;
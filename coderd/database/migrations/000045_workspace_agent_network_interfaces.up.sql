@@ -0,0 +1,4 @@
+-- Agents report their network interfaces with their diagnostics. This is
+-- NULL until the first report.
+ALTER TABLE ONLY workspace_agents
+	ADD COLUMN IF NOT EXISTS network_interfaces jsonb;
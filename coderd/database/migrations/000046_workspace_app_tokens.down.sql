@@ -0,0 +1 @@
+DROP TABLE IF EXISTS workspace_app_tokens;
@@ -0,0 +1,12 @@
+-- Workspace app tokens grant access to a single app of a single workspace
+-- until they expire. They're formatted like API keys: ID-SECRET.
+CREATE TABLE IF NOT EXISTS workspace_app_tokens (
+	id text NOT NULL,
+	hashed_secret bytea NOT NULL,
+	user_id uuid NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	workspace_id uuid NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE,
+	app_name character varying(64) NOT NULL,
+	created_at timestamp with time zone NOT NULL,
+	expires_at timestamp with time zone NOT NULL,
+	PRIMARY KEY (id)
+);
@@ -0,0 +1 @@
+ALTER TABLE templates DROP COLUMN terminal_idle_timeout;
@@ -0,0 +1,2 @@
+-- Zero means the deployment-wide timeout applies.
+ALTER TABLE templates ADD COLUMN terminal_idle_timeout BIGINT NOT NULL DEFAULT 0;
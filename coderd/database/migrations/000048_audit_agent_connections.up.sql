@@ -0,0 +1,3 @@
+ALTER TYPE audit_action ADD VALUE IF NOT EXISTS 'connect';
+ALTER TYPE audit_action ADD VALUE IF NOT EXISTS 'disconnect';
+ALTER TYPE resource_type ADD VALUE IF NOT EXISTS 'workspace_agent';
@@ -18,9 +18,11 @@ import (
 type AuditAction string
 
 const (
-	AuditActionCreate AuditAction = "create"
-	AuditActionWrite  AuditAction = "write"
-	AuditActionDelete AuditAction = "delete"
+	AuditActionCreate     AuditAction = "create"
+	AuditActionWrite      AuditAction = "write"
+	AuditActionDelete     AuditAction = "delete"
+	AuditActionConnect    AuditAction = "connect"
+	AuditActionDisconnect AuditAction = "disconnect"
 )
 
 func (e *AuditAction) Scan(src interface{}) error {
@@ -259,6 +261,7 @@ const (
 	ResourceTypeTemplateVersion ResourceType = "template_version"
 	ResourceTypeUser            ResourceType = "user"
 	ResourceTypeWorkspace       ResourceType = "workspace"
+	ResourceTypeWorkspaceAgent  ResourceType = "workspace_agent"
 )
 
 func (e *ResourceType) Scan(src interface{}) error {
@@ -454,19 +457,21 @@ type SiteConfig struct {
 }
 
 type Template struct {
-	ID                   uuid.UUID       `db:"id" json:"id"`
-	CreatedAt            time.Time       `db:"created_at" json:"created_at"`
-	UpdatedAt            time.Time       `db:"updated_at" json:"updated_at"`
-	OrganizationID       uuid.UUID       `db:"organization_id" json:"organization_id"`
-	Deleted              bool            `db:"deleted" json:"deleted"`
-	Name                 string          `db:"name" json:"name"`
-	Provisioner          ProvisionerType `db:"provisioner" json:"provisioner"`
-	ActiveVersionID      uuid.UUID       `db:"active_version_id" json:"active_version_id"`
-	Description          string          `db:"description" json:"description"`
-	MaxTtl               int64           `db:"max_ttl" json:"max_ttl"`
-	MinAutostartInterval int64           `db:"min_autostart_interval" json:"min_autostart_interval"`
-	CreatedBy            uuid.UUID       `db:"created_by" json:"created_by"`
-	Icon                 string          `db:"icon" json:"icon"`
+	ID                             uuid.UUID       `db:"id" json:"id"`
+	CreatedAt                      time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt                      time.Time       `db:"updated_at" json:"updated_at"`
+	OrganizationID                 uuid.UUID       `db:"organization_id" json:"organization_id"`
+	Deleted                        bool            `db:"deleted" json:"deleted"`
+	Name                           string          `db:"name" json:"name"`
+	Provisioner                    ProvisionerType `db:"provisioner" json:"provisioner"`
+	ActiveVersionID                uuid.UUID       `db:"active_version_id" json:"active_version_id"`
+	Description                    string          `db:"description" json:"description"`
+	MaxTtl                         int64           `db:"max_ttl" json:"max_ttl"`
+	MinAutostartInterval           int64           `db:"min_autostart_interval" json:"min_autostart_interval"`
+	CreatedBy                      uuid.UUID       `db:"created_by" json:"created_by"`
+	Icon                           string          `db:"icon" json:"icon"`
+	AgentInactiveDisconnectTimeout int64           `db:"agent_inactive_disconnect_timeout" json:"agent_inactive_disconnect_timeout"`
+	TerminalIdleTimeout            int64           `db:"terminal_idle_timeout" json:"terminal_idle_timeout"`
 }
 
 type TemplateVersion struct {
@@ -536,6 +541,22 @@ type WorkspaceAgent struct {
 	WireguardNodeIPv6       pqtype.Inet           `db:"wireguard_node_ipv6" json:"wireguard_node_ipv6"`
 	WireguardNodePublicKey  dbtypes.NodePublic    `db:"wireguard_node_public_key" json:"wireguard_node_public_key"`
 	WireguardDiscoPublicKey dbtypes.DiscoPublic   `db:"wireguard_disco_public_key" json:"wireguard_disco_public_key"`
+	DirectoryExists         sql.NullBool          `db:"directory_exists" json:"directory_exists"`
+	PublicIP                pqtype.Inet           `db:"public_ip" json:"public_ip"`
+	NATType                 sql.NullString        `db:"nat_type" json:"nat_type"`
+	NetworkReportedAt       sql.NullTime          `db:"network_reported_at" json:"network_reported_at"`
+	Maintenance             bool                  `db:"maintenance" json:"maintenance"`
+	ProcessStartedAt        sql.NullTime          `db:"process_started_at" json:"process_started_at"`
+	ClockSync               sql.NullString        `db:"clock_sync" json:"clock_sync"`
+	DiagnosticsReportedAt   sql.NullTime          `db:"diagnostics_reported_at" json:"diagnostics_reported_at"`
+	NetworkInterfaces       pqtype.NullRawMessage `db:"network_interfaces" json:"network_interfaces"`
+}
+
+type WorkspaceAgentStartupPhase struct {
+	ID        uuid.UUID `db:"id" json:"id"`
+	AgentID   uuid.UUID `db:"agent_id" json:"agent_id"`
+	Phase     string    `db:"phase" json:"phase"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
 }
 
 type WorkspaceApp struct {
@@ -549,6 +570,16 @@ type WorkspaceApp struct {
 	RelativePath bool           `db:"relative_path" json:"relative_path"`
 }
 
+type WorkspaceAppToken struct {
+	ID           string    `db:"id" json:"id"`
+	HashedSecret []byte    `db:"hashed_secret" json:"hashed_secret"`
+	UserID       uuid.UUID `db:"user_id" json:"user_id"`
+	WorkspaceID  uuid.UUID `db:"workspace_id" json:"workspace_id"`
+	AppName      string    `db:"app_name" json:"app_name"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	ExpiresAt    time.Time `db:"expires_at" json:"expires_at"`
+}
+
 type WorkspaceBuild struct {
 	ID                uuid.UUID           `db:"id" json:"id"`
 	CreatedAt         time.Time           `db:"created_at" json:"created_at"`
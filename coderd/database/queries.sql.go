@@ -1897,7 +1897,7 @@ func (q *sqlQuerier) InsertDeploymentID(ctx context.Context, value string) error
 
 const getTemplateByID = `-- name: GetTemplateByID :one
 SELECT
-	id, created_at, updated_at, organization_id, deleted, name, provisioner, active_version_id, description, max_ttl, min_autostart_interval, created_by, icon
+	id, created_at, updated_at, organization_id, deleted, name, provisioner, active_version_id, description, max_ttl, min_autostart_interval, created_by, icon, agent_inactive_disconnect_timeout, terminal_idle_timeout
 FROM
 	templates
 WHERE
@@ -1923,13 +1923,15 @@ func (q *sqlQuerier) GetTemplateByID(ctx context.Context, id uuid.UUID) (Templat
 		&i.MinAutostartInterval,
 		&i.CreatedBy,
 		&i.Icon,
+		&i.AgentInactiveDisconnectTimeout,
+		&i.TerminalIdleTimeout,
 	)
 	return i, err
 }
 
 const getTemplateByOrganizationAndName = `-- name: GetTemplateByOrganizationAndName :one
 SELECT
-	id, created_at, updated_at, organization_id, deleted, name, provisioner, active_version_id, description, max_ttl, min_autostart_interval, created_by, icon
+	id, created_at, updated_at, organization_id, deleted, name, provisioner, active_version_id, description, max_ttl, min_autostart_interval, created_by, icon, agent_inactive_disconnect_timeout, terminal_idle_timeout
 FROM
 	templates
 WHERE
@@ -1963,12 +1965,14 @@ func (q *sqlQuerier) GetTemplateByOrganizationAndName(ctx context.Context, arg G
 		&i.MinAutostartInterval,
 		&i.CreatedBy,
 		&i.Icon,
+		&i.AgentInactiveDisconnectTimeout,
+		&i.TerminalIdleTimeout,
 	)
 	return i, err
 }
 
 const getTemplates = `-- name: GetTemplates :many
-SELECT id, created_at, updated_at, organization_id, deleted, name, provisioner, active_version_id, description, max_ttl, min_autostart_interval, created_by, icon FROM templates
+SELECT id, created_at, updated_at, organization_id, deleted, name, provisioner, active_version_id, description, max_ttl, min_autostart_interval, created_by, icon, agent_inactive_disconnect_timeout, terminal_idle_timeout FROM templates
 ORDER BY (name, id) ASC
 `
 
@@ -1995,6 +1999,8 @@ func (q *sqlQuerier) GetTemplates(ctx context.Context) ([]Template, error) {
 			&i.MinAutostartInterval,
 			&i.CreatedBy,
 			&i.Icon,
+			&i.AgentInactiveDisconnectTimeout,
+			&i.TerminalIdleTimeout,
 		); err != nil {
 			return nil, err
 		}
@@ -2011,7 +2017,7 @@ func (q *sqlQuerier) GetTemplates(ctx context.Context) ([]Template, error) {
 
 const getTemplatesWithFilter = `-- name: GetTemplatesWithFilter :many
 SELECT
-	id, created_at, updated_at, organization_id, deleted, name, provisioner, active_version_id, description, max_ttl, min_autostart_interval, created_by, icon
+	id, created_at, updated_at, organization_id, deleted, name, provisioner, active_version_id, description, max_ttl, min_autostart_interval, created_by, icon, agent_inactive_disconnect_timeout, terminal_idle_timeout
 FROM
 	templates
 WHERE
@@ -2073,6 +2079,8 @@ func (q *sqlQuerier) GetTemplatesWithFilter(ctx context.Context, arg GetTemplate
 			&i.MinAutostartInterval,
 			&i.CreatedBy,
 			&i.Icon,
+			&i.AgentInactiveDisconnectTimeout,
+			&i.TerminalIdleTimeout,
 		); err != nil {
 			return nil, err
 		}
@@ -2101,25 +2109,29 @@ INSERT INTO
 		max_ttl,
 		min_autostart_interval,
 		created_by,
-		icon
+		icon,
+		agent_inactive_disconnect_timeout,
+		terminal_idle_timeout
 	)
 VALUES
-	($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id, created_at, updated_at, organization_id, deleted, name, provisioner, active_version_id, description, max_ttl, min_autostart_interval, created_by, icon
+	($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING id, created_at, updated_at, organization_id, deleted, name, provisioner, active_version_id, description, max_ttl, min_autostart_interval, created_by, icon, agent_inactive_disconnect_timeout, terminal_idle_timeout
 `
 
 type InsertTemplateParams struct {
-	ID                   uuid.UUID       `db:"id" json:"id"`
-	CreatedAt            time.Time       `db:"created_at" json:"created_at"`
-	UpdatedAt            time.Time       `db:"updated_at" json:"updated_at"`
-	OrganizationID       uuid.UUID       `db:"organization_id" json:"organization_id"`
-	Name                 string          `db:"name" json:"name"`
-	Provisioner          ProvisionerType `db:"provisioner" json:"provisioner"`
-	ActiveVersionID      uuid.UUID       `db:"active_version_id" json:"active_version_id"`
-	Description          string          `db:"description" json:"description"`
-	MaxTtl               int64           `db:"max_ttl" json:"max_ttl"`
-	MinAutostartInterval int64           `db:"min_autostart_interval" json:"min_autostart_interval"`
-	CreatedBy            uuid.UUID       `db:"created_by" json:"created_by"`
-	Icon                 string          `db:"icon" json:"icon"`
+	ID                             uuid.UUID       `db:"id" json:"id"`
+	CreatedAt                      time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt                      time.Time       `db:"updated_at" json:"updated_at"`
+	OrganizationID                 uuid.UUID       `db:"organization_id" json:"organization_id"`
+	Name                           string          `db:"name" json:"name"`
+	Provisioner                    ProvisionerType `db:"provisioner" json:"provisioner"`
+	ActiveVersionID                uuid.UUID       `db:"active_version_id" json:"active_version_id"`
+	Description                    string          `db:"description" json:"description"`
+	MaxTtl                         int64           `db:"max_ttl" json:"max_ttl"`
+	MinAutostartInterval           int64           `db:"min_autostart_interval" json:"min_autostart_interval"`
+	CreatedBy                      uuid.UUID       `db:"created_by" json:"created_by"`
+	Icon                           string          `db:"icon" json:"icon"`
+	AgentInactiveDisconnectTimeout int64           `db:"agent_inactive_disconnect_timeout" json:"agent_inactive_disconnect_timeout"`
+	TerminalIdleTimeout            int64           `db:"terminal_idle_timeout" json:"terminal_idle_timeout"`
 }
 
 func (q *sqlQuerier) InsertTemplate(ctx context.Context, arg InsertTemplateParams) (Template, error) {
@@ -2136,6 +2148,8 @@ func (q *sqlQuerier) InsertTemplate(ctx context.Context, arg InsertTemplateParam
 		arg.MinAutostartInterval,
 		arg.CreatedBy,
 		arg.Icon,
+		arg.AgentInactiveDisconnectTimeout,
+		arg.TerminalIdleTimeout,
 	)
 	var i Template
 	err := row.Scan(
@@ -2152,6 +2166,8 @@ func (q *sqlQuerier) InsertTemplate(ctx context.Context, arg InsertTemplateParam
 		&i.MinAutostartInterval,
 		&i.CreatedBy,
 		&i.Icon,
+		&i.AgentInactiveDisconnectTimeout,
+		&i.TerminalIdleTimeout,
 	)
 	return i, err
 }
@@ -2207,21 +2223,25 @@ SET
 	max_ttl = $4,
 	min_autostart_interval = $5,
 	name = $6,
-	icon = $7
+	icon = $7,
+	agent_inactive_disconnect_timeout = $8,
+	terminal_idle_timeout = $9
 WHERE
 	id = $1
 RETURNING
-	id, created_at, updated_at, organization_id, deleted, name, provisioner, active_version_id, description, max_ttl, min_autostart_interval, created_by, icon
+	id, created_at, updated_at, organization_id, deleted, name, provisioner, active_version_id, description, max_ttl, min_autostart_interval, created_by, icon, agent_inactive_disconnect_timeout, terminal_idle_timeout
 `
 
 type UpdateTemplateMetaByIDParams struct {
-	ID                   uuid.UUID `db:"id" json:"id"`
-	UpdatedAt            time.Time `db:"updated_at" json:"updated_at"`
-	Description          string    `db:"description" json:"description"`
-	MaxTtl               int64     `db:"max_ttl" json:"max_ttl"`
-	MinAutostartInterval int64     `db:"min_autostart_interval" json:"min_autostart_interval"`
-	Name                 string    `db:"name" json:"name"`
-	Icon                 string    `db:"icon" json:"icon"`
+	ID                             uuid.UUID `db:"id" json:"id"`
+	UpdatedAt                      time.Time `db:"updated_at" json:"updated_at"`
+	Description                    string    `db:"description" json:"description"`
+	MaxTtl                         int64     `db:"max_ttl" json:"max_ttl"`
+	MinAutostartInterval           int64     `db:"min_autostart_interval" json:"min_autostart_interval"`
+	Name                           string    `db:"name" json:"name"`
+	Icon                           string    `db:"icon" json:"icon"`
+	AgentInactiveDisconnectTimeout int64     `db:"agent_inactive_disconnect_timeout" json:"agent_inactive_disconnect_timeout"`
+	TerminalIdleTimeout            int64     `db:"terminal_idle_timeout" json:"terminal_idle_timeout"`
 }
 
 func (q *sqlQuerier) UpdateTemplateMetaByID(ctx context.Context, arg UpdateTemplateMetaByIDParams) error {
@@ -2233,6 +2253,8 @@ func (q *sqlQuerier) UpdateTemplateMetaByID(ctx context.Context, arg UpdateTempl
 		arg.MinAutostartInterval,
 		arg.Name,
 		arg.Icon,
+		arg.AgentInactiveDisconnectTimeout,
+		arg.TerminalIdleTimeout,
 	)
 	return err
 }
@@ -3158,7 +3180,7 @@ func (q *sqlQuerier) UpdateUserStatus(ctx context.Context, arg UpdateUserStatusP
 
 const getWorkspaceAgentByAuthToken = `-- name: GetWorkspaceAgentByAuthToken :one
 SELECT
-	id, created_at, updated_at, name, first_connected_at, last_connected_at, disconnected_at, resource_id, auth_token, auth_instance_id, architecture, environment_variables, operating_system, startup_script, instance_metadata, resource_metadata, directory, wireguard_node_ipv6, wireguard_node_public_key, wireguard_disco_public_key
+	id, created_at, updated_at, name, first_connected_at, last_connected_at, disconnected_at, resource_id, auth_token, auth_instance_id, architecture, environment_variables, operating_system, startup_script, instance_metadata, resource_metadata, directory, wireguard_node_ipv6, wireguard_node_public_key, wireguard_disco_public_key, directory_exists, public_ip, nat_type, network_reported_at, maintenance, process_started_at, clock_sync, diagnostics_reported_at, network_interfaces
 FROM
 	workspace_agents
 WHERE
@@ -3191,13 +3213,22 @@ func (q *sqlQuerier) GetWorkspaceAgentByAuthToken(ctx context.Context, authToken
 		&i.WireguardNodeIPv6,
 		&i.WireguardNodePublicKey,
 		&i.WireguardDiscoPublicKey,
+		&i.DirectoryExists,
+		&i.PublicIP,
+		&i.NATType,
+		&i.NetworkReportedAt,
+		&i.Maintenance,
+		&i.ProcessStartedAt,
+		&i.ClockSync,
+		&i.DiagnosticsReportedAt,
+		&i.NetworkInterfaces,
 	)
 	return i, err
 }
 
 const getWorkspaceAgentByID = `-- name: GetWorkspaceAgentByID :one
 SELECT
-	id, created_at, updated_at, name, first_connected_at, last_connected_at, disconnected_at, resource_id, auth_token, auth_instance_id, architecture, environment_variables, operating_system, startup_script, instance_metadata, resource_metadata, directory, wireguard_node_ipv6, wireguard_node_public_key, wireguard_disco_public_key
+	id, created_at, updated_at, name, first_connected_at, last_connected_at, disconnected_at, resource_id, auth_token, auth_instance_id, architecture, environment_variables, operating_system, startup_script, instance_metadata, resource_metadata, directory, wireguard_node_ipv6, wireguard_node_public_key, wireguard_disco_public_key, directory_exists, public_ip, nat_type, network_reported_at, maintenance, process_started_at, clock_sync, diagnostics_reported_at, network_interfaces
 FROM
 	workspace_agents
 WHERE
@@ -3228,13 +3259,22 @@ func (q *sqlQuerier) GetWorkspaceAgentByID(ctx context.Context, id uuid.UUID) (W
 		&i.WireguardNodeIPv6,
 		&i.WireguardNodePublicKey,
 		&i.WireguardDiscoPublicKey,
+		&i.DirectoryExists,
+		&i.PublicIP,
+		&i.NATType,
+		&i.NetworkReportedAt,
+		&i.Maintenance,
+		&i.ProcessStartedAt,
+		&i.ClockSync,
+		&i.DiagnosticsReportedAt,
+		&i.NetworkInterfaces,
 	)
 	return i, err
 }
 
 const getWorkspaceAgentByInstanceID = `-- name: GetWorkspaceAgentByInstanceID :one
 SELECT
-	id, created_at, updated_at, name, first_connected_at, last_connected_at, disconnected_at, resource_id, auth_token, auth_instance_id, architecture, environment_variables, operating_system, startup_script, instance_metadata, resource_metadata, directory, wireguard_node_ipv6, wireguard_node_public_key, wireguard_disco_public_key
+	id, created_at, updated_at, name, first_connected_at, last_connected_at, disconnected_at, resource_id, auth_token, auth_instance_id, architecture, environment_variables, operating_system, startup_script, instance_metadata, resource_metadata, directory, wireguard_node_ipv6, wireguard_node_public_key, wireguard_disco_public_key, directory_exists, public_ip, nat_type, network_reported_at, maintenance, process_started_at, clock_sync, diagnostics_reported_at, network_interfaces
 FROM
 	workspace_agents
 WHERE
@@ -3267,13 +3307,22 @@ func (q *sqlQuerier) GetWorkspaceAgentByInstanceID(ctx context.Context, authInst
 		&i.WireguardNodeIPv6,
 		&i.WireguardNodePublicKey,
 		&i.WireguardDiscoPublicKey,
+		&i.DirectoryExists,
+		&i.PublicIP,
+		&i.NATType,
+		&i.NetworkReportedAt,
+		&i.Maintenance,
+		&i.ProcessStartedAt,
+		&i.ClockSync,
+		&i.DiagnosticsReportedAt,
+		&i.NetworkInterfaces,
 	)
 	return i, err
 }
 
 const getWorkspaceAgentsByResourceIDs = `-- name: GetWorkspaceAgentsByResourceIDs :many
 SELECT
-	id, created_at, updated_at, name, first_connected_at, last_connected_at, disconnected_at, resource_id, auth_token, auth_instance_id, architecture, environment_variables, operating_system, startup_script, instance_metadata, resource_metadata, directory, wireguard_node_ipv6, wireguard_node_public_key, wireguard_disco_public_key
+	id, created_at, updated_at, name, first_connected_at, last_connected_at, disconnected_at, resource_id, auth_token, auth_instance_id, architecture, environment_variables, operating_system, startup_script, instance_metadata, resource_metadata, directory, wireguard_node_ipv6, wireguard_node_public_key, wireguard_disco_public_key, directory_exists, public_ip, nat_type, network_reported_at, maintenance, process_started_at, clock_sync, diagnostics_reported_at, network_interfaces
 FROM
 	workspace_agents
 WHERE
@@ -3310,6 +3359,15 @@ func (q *sqlQuerier) GetWorkspaceAgentsByResourceIDs(ctx context.Context, ids []
 			&i.WireguardNodeIPv6,
 			&i.WireguardNodePublicKey,
 			&i.WireguardDiscoPublicKey,
+			&i.DirectoryExists,
+			&i.PublicIP,
+			&i.NATType,
+			&i.NetworkReportedAt,
+			&i.Maintenance,
+			&i.ProcessStartedAt,
+			&i.ClockSync,
+			&i.DiagnosticsReportedAt,
+			&i.NetworkInterfaces,
 		); err != nil {
 			return nil, err
 		}
@@ -3325,7 +3383,7 @@ func (q *sqlQuerier) GetWorkspaceAgentsByResourceIDs(ctx context.Context, ids []
 }
 
 const getWorkspaceAgentsCreatedAfter = `-- name: GetWorkspaceAgentsCreatedAfter :many
-SELECT id, created_at, updated_at, name, first_connected_at, last_connected_at, disconnected_at, resource_id, auth_token, auth_instance_id, architecture, environment_variables, operating_system, startup_script, instance_metadata, resource_metadata, directory, wireguard_node_ipv6, wireguard_node_public_key, wireguard_disco_public_key FROM workspace_agents WHERE created_at > $1
+SELECT id, created_at, updated_at, name, first_connected_at, last_connected_at, disconnected_at, resource_id, auth_token, auth_instance_id, architecture, environment_variables, operating_system, startup_script, instance_metadata, resource_metadata, directory, wireguard_node_ipv6, wireguard_node_public_key, wireguard_disco_public_key, directory_exists, public_ip, nat_type, network_reported_at, maintenance, process_started_at, clock_sync, diagnostics_reported_at, network_interfaces FROM workspace_agents WHERE created_at > $1
 `
 
 func (q *sqlQuerier) GetWorkspaceAgentsCreatedAfter(ctx context.Context, createdAt time.Time) ([]WorkspaceAgent, error) {
@@ -3358,6 +3416,15 @@ func (q *sqlQuerier) GetWorkspaceAgentsCreatedAfter(ctx context.Context, created
 			&i.WireguardNodeIPv6,
 			&i.WireguardNodePublicKey,
 			&i.WireguardDiscoPublicKey,
+			&i.DirectoryExists,
+			&i.PublicIP,
+			&i.NATType,
+			&i.NetworkReportedAt,
+			&i.Maintenance,
+			&i.ProcessStartedAt,
+			&i.ClockSync,
+			&i.DiagnosticsReportedAt,
+			&i.NetworkInterfaces,
 		); err != nil {
 			return nil, err
 		}
@@ -3394,7 +3461,7 @@ INSERT INTO
 		wireguard_disco_public_key
 	)
 VALUES
-	($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17) RETURNING id, created_at, updated_at, name, first_connected_at, last_connected_at, disconnected_at, resource_id, auth_token, auth_instance_id, architecture, environment_variables, operating_system, startup_script, instance_metadata, resource_metadata, directory, wireguard_node_ipv6, wireguard_node_public_key, wireguard_disco_public_key
+	($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17) RETURNING id, created_at, updated_at, name, first_connected_at, last_connected_at, disconnected_at, resource_id, auth_token, auth_instance_id, architecture, environment_variables, operating_system, startup_script, instance_metadata, resource_metadata, directory, wireguard_node_ipv6, wireguard_node_public_key, wireguard_disco_public_key, directory_exists, public_ip, nat_type, network_reported_at, maintenance, process_started_at, clock_sync, diagnostics_reported_at, network_interfaces
 `
 
 type InsertWorkspaceAgentParams struct {
@@ -3459,6 +3526,15 @@ func (q *sqlQuerier) InsertWorkspaceAgent(ctx context.Context, arg InsertWorkspa
 		&i.WireguardNodeIPv6,
 		&i.WireguardNodePublicKey,
 		&i.WireguardDiscoPublicKey,
+		&i.DirectoryExists,
+		&i.PublicIP,
+		&i.NATType,
+		&i.NetworkReportedAt,
+		&i.Maintenance,
+		&i.ProcessStartedAt,
+		&i.ClockSync,
+		&i.DiagnosticsReportedAt,
+		&i.NetworkInterfaces,
 	)
 	return i, err
 }
@@ -3494,6 +3570,27 @@ func (q *sqlQuerier) UpdateWorkspaceAgentConnectionByID(ctx context.Context, arg
 	return err
 }
 
+const updateWorkspaceAgentDirectoryExistsByID = `-- name: UpdateWorkspaceAgentDirectoryExistsByID :exec
+UPDATE
+	workspace_agents
+SET
+	directory_exists = $2,
+	updated_at = $3
+WHERE
+	id = $1
+`
+
+type UpdateWorkspaceAgentDirectoryExistsByIDParams struct {
+	ID              uuid.UUID    `db:"id" json:"id"`
+	DirectoryExists sql.NullBool `db:"directory_exists" json:"directory_exists"`
+	UpdatedAt       time.Time    `db:"updated_at" json:"updated_at"`
+}
+
+func (q *sqlQuerier) UpdateWorkspaceAgentDirectoryExistsByID(ctx context.Context, arg UpdateWorkspaceAgentDirectoryExistsByIDParams) error {
+	_, err := q.db.ExecContext(ctx, updateWorkspaceAgentDirectoryExistsByID, arg.ID, arg.DirectoryExists, arg.UpdatedAt)
+	return err
+}
+
 const updateWorkspaceAgentKeysByID = `-- name: UpdateWorkspaceAgentKeysByID :exec
 UPDATE
 	workspace_agents
@@ -3522,6 +3619,165 @@ func (q *sqlQuerier) UpdateWorkspaceAgentKeysByID(ctx context.Context, arg Updat
 	return err
 }
 
+const updateWorkspaceAgentMaintenanceByID = `-- name: UpdateWorkspaceAgentMaintenanceByID :exec
+UPDATE
+	workspace_agents
+SET
+	maintenance = $2,
+	updated_at = $3
+WHERE
+	id = $1
+`
+
+type UpdateWorkspaceAgentMaintenanceByIDParams struct {
+	ID          uuid.UUID `db:"id" json:"id"`
+	Maintenance bool      `db:"maintenance" json:"maintenance"`
+	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+}
+
+func (q *sqlQuerier) UpdateWorkspaceAgentMaintenanceByID(ctx context.Context, arg UpdateWorkspaceAgentMaintenanceByIDParams) error {
+	_, err := q.db.ExecContext(ctx, updateWorkspaceAgentMaintenanceByID, arg.ID, arg.Maintenance, arg.UpdatedAt)
+	return err
+}
+
+const updateWorkspaceAgentNetworkReportByID = `-- name: UpdateWorkspaceAgentNetworkReportByID :exec
+UPDATE
+	workspace_agents
+SET
+	public_ip = $2,
+	nat_type = $3,
+	network_reported_at = $4,
+	updated_at = $4
+WHERE
+	id = $1
+`
+
+type UpdateWorkspaceAgentNetworkReportByIDParams struct {
+	ID                uuid.UUID      `db:"id" json:"id"`
+	PublicIP          pqtype.Inet    `db:"public_ip" json:"public_ip"`
+	NATType           sql.NullString `db:"nat_type" json:"nat_type"`
+	NetworkReportedAt sql.NullTime   `db:"network_reported_at" json:"network_reported_at"`
+}
+
+func (q *sqlQuerier) UpdateWorkspaceAgentNetworkReportByID(ctx context.Context, arg UpdateWorkspaceAgentNetworkReportByIDParams) error {
+	_, err := q.db.ExecContext(ctx, updateWorkspaceAgentNetworkReportByID,
+		arg.ID,
+		arg.PublicIP,
+		arg.NATType,
+		arg.NetworkReportedAt,
+	)
+	return err
+}
+
+const updateWorkspaceAgentDiagnosticsByID = `-- name: UpdateWorkspaceAgentDiagnosticsByID :exec
+UPDATE
+	workspace_agents
+SET
+	process_started_at = $2,
+	clock_sync = $3,
+	diagnostics_reported_at = $4,
+	updated_at = $4,
+	network_interfaces = $5
+WHERE
+	id = $1
+`
+
+type UpdateWorkspaceAgentDiagnosticsByIDParams struct {
+	ID                    uuid.UUID             `db:"id" json:"id"`
+	ProcessStartedAt      sql.NullTime          `db:"process_started_at" json:"process_started_at"`
+	ClockSync             sql.NullString        `db:"clock_sync" json:"clock_sync"`
+	DiagnosticsReportedAt sql.NullTime          `db:"diagnostics_reported_at" json:"diagnostics_reported_at"`
+	NetworkInterfaces     pqtype.NullRawMessage `db:"network_interfaces" json:"network_interfaces"`
+}
+
+func (q *sqlQuerier) UpdateWorkspaceAgentDiagnosticsByID(ctx context.Context, arg UpdateWorkspaceAgentDiagnosticsByIDParams) error {
+	_, err := q.db.ExecContext(ctx, updateWorkspaceAgentDiagnosticsByID,
+		arg.ID,
+		arg.ProcessStartedAt,
+		arg.ClockSync,
+		arg.DiagnosticsReportedAt,
+		arg.NetworkInterfaces,
+	)
+	return err
+}
+
+const deleteWorkspaceAgentStartupPhasesByAgentID = `-- name: DeleteWorkspaceAgentStartupPhasesByAgentID :exec
+DELETE FROM workspace_agent_startup_phases WHERE agent_id = $1
+`
+
+func (q *sqlQuerier) DeleteWorkspaceAgentStartupPhasesByAgentID(ctx context.Context, agentID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteWorkspaceAgentStartupPhasesByAgentID, agentID)
+	return err
+}
+
+const getWorkspaceAgentStartupPhasesByAgentIDs = `-- name: GetWorkspaceAgentStartupPhasesByAgentIDs :many
+SELECT id, agent_id, phase, created_at FROM workspace_agent_startup_phases WHERE agent_id = ANY($1 :: uuid [ ]) ORDER BY created_at ASC
+`
+
+func (q *sqlQuerier) GetWorkspaceAgentStartupPhasesByAgentIDs(ctx context.Context, ids []uuid.UUID) ([]WorkspaceAgentStartupPhase, error) {
+	rows, err := q.db.QueryContext(ctx, getWorkspaceAgentStartupPhasesByAgentIDs, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WorkspaceAgentStartupPhase
+	for rows.Next() {
+		var i WorkspaceAgentStartupPhase
+		if err := rows.Scan(
+			&i.ID,
+			&i.AgentID,
+			&i.Phase,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const insertWorkspaceAgentStartupPhase = `-- name: InsertWorkspaceAgentStartupPhase :one
+INSERT INTO
+	workspace_agent_startup_phases (
+		id,
+		agent_id,
+		phase,
+		created_at
+	)
+VALUES
+	($1, $2, $3, $4) RETURNING id, agent_id, phase, created_at
+`
+
+type InsertWorkspaceAgentStartupPhaseParams struct {
+	ID        uuid.UUID `db:"id" json:"id"`
+	AgentID   uuid.UUID `db:"agent_id" json:"agent_id"`
+	Phase     string    `db:"phase" json:"phase"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+func (q *sqlQuerier) InsertWorkspaceAgentStartupPhase(ctx context.Context, arg InsertWorkspaceAgentStartupPhaseParams) (WorkspaceAgentStartupPhase, error) {
+	row := q.db.QueryRowContext(ctx, insertWorkspaceAgentStartupPhase,
+		arg.ID,
+		arg.AgentID,
+		arg.Phase,
+		arg.CreatedAt,
+	)
+	var i WorkspaceAgentStartupPhase
+	err := row.Scan(
+		&i.ID,
+		&i.AgentID,
+		&i.Phase,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const getWorkspaceAppByAgentIDAndName = `-- name: GetWorkspaceAppByAgentIDAndName :one
 SELECT id, created_at, agent_id, name, icon, command, url, relative_path FROM workspace_apps WHERE agent_id = $1 AND name = $2
 `
@@ -3707,6 +3963,73 @@ func (q *sqlQuerier) InsertWorkspaceApp(ctx context.Context, arg InsertWorkspace
 	return i, err
 }
 
+const getWorkspaceAppTokenByID = `-- name: GetWorkspaceAppTokenByID :one
+SELECT id, hashed_secret, user_id, workspace_id, app_name, created_at, expires_at FROM workspace_app_tokens WHERE id = $1
+`
+
+func (q *sqlQuerier) GetWorkspaceAppTokenByID(ctx context.Context, id string) (WorkspaceAppToken, error) {
+	row := q.db.QueryRowContext(ctx, getWorkspaceAppTokenByID, id)
+	var i WorkspaceAppToken
+	err := row.Scan(
+		&i.ID,
+		&i.HashedSecret,
+		&i.UserID,
+		&i.WorkspaceID,
+		&i.AppName,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const insertWorkspaceAppToken = `-- name: InsertWorkspaceAppToken :one
+INSERT INTO
+    workspace_app_tokens (
+        id,
+        hashed_secret,
+        user_id,
+        workspace_id,
+        app_name,
+        created_at,
+        expires_at
+    )
+VALUES
+    ($1, $2, $3, $4, $5, $6, $7) RETURNING id, hashed_secret, user_id, workspace_id, app_name, created_at, expires_at
+`
+
+type InsertWorkspaceAppTokenParams struct {
+	ID           string    `db:"id" json:"id"`
+	HashedSecret []byte    `db:"hashed_secret" json:"hashed_secret"`
+	UserID       uuid.UUID `db:"user_id" json:"user_id"`
+	WorkspaceID  uuid.UUID `db:"workspace_id" json:"workspace_id"`
+	AppName      string    `db:"app_name" json:"app_name"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	ExpiresAt    time.Time `db:"expires_at" json:"expires_at"`
+}
+
+func (q *sqlQuerier) InsertWorkspaceAppToken(ctx context.Context, arg InsertWorkspaceAppTokenParams) (WorkspaceAppToken, error) {
+	row := q.db.QueryRowContext(ctx, insertWorkspaceAppToken,
+		arg.ID,
+		arg.HashedSecret,
+		arg.UserID,
+		arg.WorkspaceID,
+		arg.AppName,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+	)
+	var i WorkspaceAppToken
+	err := row.Scan(
+		&i.ID,
+		&i.HashedSecret,
+		&i.UserID,
+		&i.WorkspaceID,
+		&i.AppName,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
 const getLatestWorkspaceBuildByWorkspaceID = `-- name: GetLatestWorkspaceBuildByWorkspaceID :one
 SELECT
 	id, created_at, updated_at, workspace_id, template_version_id, name, build_number, transition, initiator_id, provisioner_state, job_id, deadline, reason
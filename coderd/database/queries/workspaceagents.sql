@@ -72,6 +72,15 @@ SET
 WHERE
 	id = $1;
 
+-- name: UpdateWorkspaceAgentDirectoryExistsByID :exec
+UPDATE
+	workspace_agents
+SET
+	directory_exists = $2,
+	updated_at = $3
+WHERE
+	id = $1;
+
 -- name: UpdateWorkspaceAgentKeysByID :exec
 UPDATE
 	workspace_agents
@@ -81,3 +90,35 @@ SET
 	updated_at = $4
 WHERE
 	id = $1;
+
+-- name: UpdateWorkspaceAgentMaintenanceByID :exec
+UPDATE
+	workspace_agents
+SET
+	maintenance = $2,
+	updated_at = $3
+WHERE
+	id = $1;
+
+-- name: UpdateWorkspaceAgentNetworkReportByID :exec
+UPDATE
+	workspace_agents
+SET
+	public_ip = $2,
+	nat_type = $3,
+	network_reported_at = $4,
+	updated_at = $4
+WHERE
+	id = $1;
+
+-- name: UpdateWorkspaceAgentDiagnosticsByID :exec
+UPDATE
+	workspace_agents
+SET
+	process_started_at = $2,
+	clock_sync = $3,
+	diagnostics_reported_at = $4,
+	updated_at = $4,
+	network_interfaces = $5
+WHERE
+	id = $1;
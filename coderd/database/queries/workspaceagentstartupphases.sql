@@ -0,0 +1,16 @@
+-- name: DeleteWorkspaceAgentStartupPhasesByAgentID :exec
+DELETE FROM workspace_agent_startup_phases WHERE agent_id = $1;
+
+-- name: GetWorkspaceAgentStartupPhasesByAgentIDs :many
+SELECT * FROM workspace_agent_startup_phases WHERE agent_id = ANY(@ids :: uuid [ ]) ORDER BY created_at ASC;
+
+-- name: InsertWorkspaceAgentStartupPhase :one
+INSERT INTO
+	workspace_agent_startup_phases (
+		id,
+		agent_id,
+		phase,
+		created_at
+	)
+VALUES
+	($1, $2, $3, $4) RETURNING *;
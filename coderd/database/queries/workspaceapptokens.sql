@@ -0,0 +1,16 @@
+-- name: GetWorkspaceAppTokenByID :one
+SELECT * FROM workspace_app_tokens WHERE id = $1;
+
+-- name: InsertWorkspaceAppToken :one
+INSERT INTO
+    workspace_app_tokens (
+        id,
+        hashed_secret,
+        user_id,
+        workspace_id,
+        app_name,
+        created_at,
+        expires_at
+    )
+VALUES
+    ($1, $2, $3, $4, $5, $6, $7) RETURNING *;
@@ -0,0 +1,140 @@
+package coderd
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+	"tailscale.com/tailcfg"
+
+	"github.com/coder/coder/coderd/httpapi"
+	"github.com/coder/coder/coderd/rbac"
+	"github.com/coder/coder/codersdk"
+)
+
+// derpProbeTimeout bounds how long each DERP region has to respond.
+const derpProbeTimeout = 5 * time.Second
+
+func (api *API) derpMap(rw http.ResponseWriter, r *http.Request) {
+	httpapi.Write(rw, http.StatusOK, api.DERPMap)
+}
+
+// derpLatency probes every DERP region from coderd, so operators can
+// validate a DERP configuration independent of any agent.
+func (api *API) derpLatency(rw http.ResponseWriter, r *http.Request) {
+	// The probes reach out to every region, so only site-wide readers may
+	// trigger them.
+	if !api.Authorize(r, rbac.ActionRead, rbac.ResourceWildcard) {
+		httpapi.Forbidden(rw)
+		return
+	}
+
+	httpapi.Write(rw, http.StatusOK, probeDERPRegions(r.Context(), api.DERPMap, derpProbeTimeout))
+}
+
+// probeDERPRegions probes all regions concurrently. Reachable regions are
+// sorted by latency, followed by unreachable regions by ID.
+func probeDERPRegions(ctx context.Context, derpMap *tailcfg.DERPMap, timeout time.Duration) []codersdk.DERPRegionLatency {
+	latencies := make([]codersdk.DERPRegionLatency, 0, len(derpMap.Regions))
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, region := range derpMap.Regions {
+		region := region
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			latency := codersdk.DERPRegionLatency{
+				RegionID:   region.RegionID,
+				RegionCode: region.RegionCode,
+				RegionName: region.RegionName,
+			}
+			probeCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			duration, err := probeDERPRegion(probeCtx, region)
+			if err != nil {
+				latency.Error = err.Error()
+			} else {
+				latency.Reachable = true
+				latency.LatencyMS = float64(duration) / float64(time.Millisecond)
+			}
+			mu.Lock()
+			latencies = append(latencies, latency)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(latencies, func(i, j int) bool {
+		a, b := latencies[i], latencies[j]
+		if a.Reachable != b.Reachable {
+			return a.Reachable
+		}
+		if a.Reachable && a.LatencyMS != b.LatencyMS {
+			return a.LatencyMS < b.LatencyMS
+		}
+		return a.RegionID < b.RegionID
+	})
+	return latencies
+}
+
+// probeDERPRegion returns the round trip to the first node of the region
+// that answers its probe endpoint.
+func probeDERPRegion(ctx context.Context, region *tailcfg.DERPRegion) (time.Duration, error) {
+	var lastErr error
+	for _, node := range region.Nodes {
+		if node.STUNOnly {
+			continue
+		}
+		duration, err := probeDERPNode(ctx, node)
+		if err == nil {
+			return duration, nil
+		}
+		lastErr = xerrors.Errorf("node %q: %w", node.Name, err)
+	}
+	if lastErr == nil {
+		lastErr = xerrors.New("region has no DERP nodes")
+	}
+	return 0, lastErr
+}
+
+func probeDERPNode(ctx context.Context, node *tailcfg.DERPNode) (time.Duration, error) {
+	port := node.DERPPort
+	if port == 0 {
+		port = 443
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+net.JoinHostPort(node.HostName, strconv.Itoa(port))+"/derp/probe", nil)
+	if err != nil {
+		return 0, xerrors.Errorf("create request: %w", err)
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			// Each probe gets a fresh connection so the latency includes
+			// the handshake a client would pay.
+			DisableKeepAlives: true,
+			TLSClientConfig: &tls.Config{
+				MinVersion: tls.VersionTLS12,
+				//nolint:gosec // Only for nodes marked as test fixtures.
+				InsecureSkipVerify: node.InsecureForTests,
+			},
+		},
+	}
+	start := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, xerrors.Errorf("probe: %w", err)
+	}
+	_ = res.Body.Close()
+	duration := time.Since(start)
+	if res.StatusCode != http.StatusOK {
+		return 0, xerrors.Errorf("probe returned status %d", res.StatusCode)
+	}
+	return duration, nil
+}
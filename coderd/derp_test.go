@@ -0,0 +1,88 @@
+package coderd_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"tailscale.com/tailcfg"
+
+	"github.com/coder/coder/coderd/coderdtest"
+	"github.com/coder/coder/testutil"
+)
+
+func TestDERPLatency(t *testing.T) {
+	t.Parallel()
+	fastNode := derpProbeNode(t, 0)
+	slowNode := derpProbeNode(t, 100*time.Millisecond)
+
+	// Nothing listens on the port once the listener is closed.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	tcpAddr, ok := listener.Addr().(*net.TCPAddr)
+	require.True(t, ok)
+	unreachablePort := tcpAddr.Port
+	_ = listener.Close()
+
+	client := coderdtest.New(t, &coderdtest.Options{
+		DERPMap: &tailcfg.DERPMap{
+			Regions: map[int]*tailcfg.DERPRegion{
+				1: {RegionID: 1, RegionCode: "slow", Nodes: []*tailcfg.DERPNode{slowNode}},
+				2: {RegionID: 2, RegionCode: "fast", Nodes: []*tailcfg.DERPNode{fastNode}},
+				3: {RegionID: 3, RegionCode: "down", Nodes: []*tailcfg.DERPNode{{
+					Name:             "3a",
+					RegionID:         3,
+					HostName:         "127.0.0.1",
+					DERPPort:         unreachablePort,
+					InsecureForTests: true,
+				}}},
+			},
+		},
+	})
+	_ = coderdtest.CreateFirstUser(t, client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	latencies, err := client.DERPLatency(ctx)
+	require.NoError(t, err)
+	require.Len(t, latencies, 3)
+	require.Equal(t, "fast", latencies[0].RegionCode)
+	require.True(t, latencies[0].Reachable)
+	require.Equal(t, "slow", latencies[1].RegionCode)
+	require.True(t, latencies[1].Reachable)
+	require.GreaterOrEqual(t, latencies[1].LatencyMS, float64(100))
+	require.Equal(t, "down", latencies[2].RegionCode)
+	require.False(t, latencies[2].Reachable)
+	require.NotEmpty(t, latencies[2].Error)
+}
+
+// derpProbeNode starts a fake DERP node that answers probes after delay.
+func derpProbeNode(t *testing.T, delay time.Duration) *tailcfg.DERPNode {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/derp/probe" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		time.Sleep(delay)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(srvURL.Port())
+	require.NoError(t, err)
+	return &tailcfg.DERPNode{
+		Name:             srvURL.Host,
+		HostName:         srvURL.Hostname(),
+		DERPPort:         port,
+		InsecureForTests: true,
+	}
+}
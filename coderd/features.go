@@ -20,7 +20,7 @@ type FeaturesService interface {
 
 type featuresService struct{}
 
-func (featuresService) EntitlementsAPI(rw http.ResponseWriter, _ *http.Request) {
+func (featuresService) EntitlementsAPI(rw http.ResponseWriter, r *http.Request) {
 	features := make(map[string]codersdk.Feature)
 	for _, f := range codersdk.FeatureNames {
 		features[f] = codersdk.Feature{
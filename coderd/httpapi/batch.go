@@ -0,0 +1,42 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/coder/coder/codersdk"
+)
+
+// BatchSuccess is the result of a batch item that succeeded.
+func BatchSuccess[T any](status int, result T) codersdk.BatchResult[T] {
+	return codersdk.BatchResult[T]{
+		Status: status,
+		Result: &result,
+	}
+}
+
+// BatchFailure is the result of a batch item that failed.
+func BatchFailure[T any](status int, response codersdk.Response) codersdk.BatchResult[T] {
+	return codersdk.BatchResult[T]{
+		Status: status,
+		Error:  &response,
+	}
+}
+
+// WriteBatch outputs the results of a batch request. The response is a 200
+// when every item succeeded, and a 207 Multi-Status otherwise so clients
+// know to inspect each result.
+func WriteBatch[T any](rw http.ResponseWriter, results []codersdk.BatchResult[T]) {
+	if results == nil {
+		results = []codersdk.BatchResult[T]{}
+	}
+	status := http.StatusOK
+	for _, result := range results {
+		if result.Error != nil {
+			status = http.StatusMultiStatus
+			break
+		}
+	}
+	Write(rw, status, codersdk.BatchResponse[T]{
+		Results: results,
+	})
+}
@@ -0,0 +1,187 @@
+package httpapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"golang.org/x/xerrors"
+
+	"github.com/coder/coder/codersdk"
+)
+
+var _ http.ResponseWriter = (*CasingWriter)(nil)
+var _ http.Hijacker = (*CasingWriter)(nil)
+
+// CasingWriter rewrites the top-level field names of responses written to it
+// by Write and WriteCacheable to Casing. Other writes pass through as-is.
+type CasingWriter struct {
+	http.ResponseWriter
+	Casing codersdk.Casing
+}
+
+func (w *CasingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, xerrors.Errorf("%T is not a http.Hijacker", w.ResponseWriter)
+	}
+	return hijacker.Hijack()
+}
+
+func (w *CasingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the writer CasingWriter wraps.
+func (w *CasingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// casingOf returns the casing of the CasingWriter rw is or wraps, if any.
+// Writers installed after it are unwrapped with their Unwrap method.
+func casingOf(rw http.ResponseWriter) (codersdk.Casing, bool) {
+	for {
+		switch w := rw.(type) {
+		case *CasingWriter:
+			return w.Casing, true
+		case interface{ Unwrap() http.ResponseWriter }:
+			rw = w.Unwrap()
+		default:
+			return "", false
+		}
+	}
+}
+
+// recase rewrites the top-level field names of an encoded response. Objects
+// nested in fields are left as-is. The fields of each object in a top-level
+// array are rewritten, since list endpoints respond with one.
+func recase(data []byte, casing codersdk.Casing) ([]byte, error) {
+	var convert func(string) string
+	switch casing {
+	case codersdk.CasingSnake:
+		convert = snakeCase
+	case codersdk.CasingCamel:
+		convert = camelCase
+	default:
+		return nil, xerrors.Errorf("unknown casing %q", casing)
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	switch data[0] {
+	case '{':
+		err := recaseObject(&buf, data, convert)
+		if err != nil {
+			return nil, err
+		}
+	case '[':
+		var elements []json.RawMessage
+		err := json.Unmarshal(data, &elements)
+		if err != nil {
+			return nil, xerrors.Errorf("decode array: %w", err)
+		}
+		buf.WriteByte('[')
+		for i, element := range elements {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if len(element) == 0 || element[0] != '{' {
+				buf.Write(element)
+				continue
+			}
+			err = recaseObject(&buf, element, convert)
+			if err != nil {
+				return nil, err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		return data, nil
+	}
+	// Match the trailing newline of json.Encoder.
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// recaseObject writes the object with its field names converted. The order
+// of fields is kept.
+func recaseObject(buf *bytes.Buffer, data []byte, convert func(string) string) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	_, err := dec.Token()
+	if err != nil {
+		return xerrors.Errorf("read object start: %w", err)
+	}
+	buf.WriteByte('{')
+	first := true
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return xerrors.Errorf("read field name: %w", err)
+		}
+		name, ok := token.(string)
+		if !ok {
+			return xerrors.Errorf("unexpected field name %v", token)
+		}
+		var value json.RawMessage
+		err = dec.Decode(&value)
+		if err != nil {
+			return xerrors.Errorf("read field %q: %w", name, err)
+		}
+		encodedName, err := json.Marshal(convert(name))
+		if err != nil {
+			return xerrors.Errorf("encode field name: %w", err)
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		buf.Write(encodedName)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// camelCase converts snake_case to camelCase. Names that are already
+// camelCase are unchanged.
+func camelCase(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] == "" {
+			continue
+		}
+		runes := []rune(parts[i])
+		runes[0] = unicode.ToUpper(runes[0])
+		parts[i] = string(runes)
+	}
+	return strings.Join(parts, "")
+}
+
+// snakeCase converts camelCase to snake_case. Names that are already
+// snake_case are unchanged, and acronyms are kept together.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var builder strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			startsWord := i > 0 && (!unicode.IsUpper(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1])))
+			if startsWord && runes[i-1] != '_' {
+				builder.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
@@ -25,6 +25,9 @@ func TestStripCoderCookies(t *testing.T) {
 	}, {
 		"session_token=ok; oauth_state=wow; oauth_redirect=/",
 		"",
+	}, {
+		"coder_app_token=ok; wow=test",
+		"wow=test",
 	}} {
 		tc := tc
 		t.Run(tc.Input, func(t *testing.T) {
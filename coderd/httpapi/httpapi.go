@@ -2,9 +2,12 @@ package httpapi
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
 	"strings"
@@ -71,52 +74,150 @@ func InternalServerError(rw http.ResponseWriter, err error) {
 	})
 }
 
+// Deprecated marks a response as coming from a deprecated endpoint. It must
+// be called before the response is written. Any codersdk.Response written
+// afterwards includes the deprecation.
+func Deprecated(rw http.ResponseWriter, deprecation codersdk.Deprecation) {
+	rw.Header().Set(codersdk.DeprecationHeader, "true")
+	if deprecation.Message != "" {
+		rw.Header().Set(codersdk.DeprecationMessageHeader, deprecation.Message)
+	}
+	if deprecation.Sunset != nil {
+		rw.Header().Set(codersdk.SunsetHeader, deprecation.Sunset.UTC().Format(http.TimeFormat))
+	}
+}
+
 // Write outputs a standardized format to an HTTP response body.
 func Write(rw http.ResponseWriter, status int, response interface{}) {
-	buf := &bytes.Buffer{}
-	enc := json.NewEncoder(buf)
-	enc.SetEscapeHTML(true)
-	err := enc.Encode(response)
+	body, err := encode(rw, withDeprecation(rw, response))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.WriteHeader(status)
+	_, err = rw.Write(body)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
+}
+
+// WriteCacheable is like Write, but tags the response with an ETag derived
+// from its body. If the request's If-None-Match already holds that ETag, an
+// empty 304 is written instead so clients can reuse what they have.
+func WriteCacheable(rw http.ResponseWriter, r *http.Request, status int, response interface{}) {
+	body, err := encode(rw, response)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	rw.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
 	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
 	rw.WriteHeader(status)
-	_, err = rw.Write(buf.Bytes())
+	_, err = rw.Write(body)
 	if err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
+// withDeprecation adds the deprecation set by Deprecated, if any, to
+// codersdk.Response bodies.
+func withDeprecation(rw http.ResponseWriter, response interface{}) interface{} {
+	if resp, ok := response.(codersdk.Response); ok && resp.Deprecation == nil {
+		if deprecation, ok := codersdk.DeprecationFromHeader(rw.Header()); ok {
+			resp.Deprecation = &deprecation
+			return resp
+		}
+	}
+	return response
+}
+
+// encode marshals a response body, in the casing of a CasingWriter if rw
+// is or wraps one.
+func encode(rw http.ResponseWriter, response interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(true)
+	err := enc.Encode(response)
+	if err != nil {
+		return nil, err
+	}
+	casing, ok := casingOf(rw)
+	if !ok {
+		return buf.Bytes(), nil
+	}
+	return recase(buf.Bytes(), casing)
+}
+
+// etagMatches reports whether an If-None-Match header value includes etag.
+// Weak comparison is used, as RFC 7232 requires for If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxReadBytes is the largest request body Read decodes, so clients can't
+// exhaust memory before the body is validated.
+const MaxReadBytes = 4 << 20
+
 // Read decodes JSON from the HTTP request into the value provided.
 // It uses go-validator to validate the incoming request body.
 func Read(rw http.ResponseWriter, r *http.Request, value interface{}) bool {
-	err := json.NewDecoder(r.Body).Decode(value)
+	return ReadLimit(rw, r, value, MaxReadBytes)
+}
+
+// ReadLimit is like Read, but refuses request bodies larger than limit bytes
+// with a 413 instead of MaxReadBytes.
+func ReadLimit(rw http.ResponseWriter, r *http.Request, value interface{}, limit int64) bool {
+	r.Body = http.MaxBytesReader(rw, r.Body, limit)
+	tooLarge := func(err error) bool {
+		var maxBytesErr *http.MaxBytesError
+		if !errors.As(err, &maxBytesErr) {
+			return false
+		}
+		Write(rw, http.StatusRequestEntityTooLarge, codersdk.Response{
+			Message: fmt.Sprintf("Request body must be at most %d bytes.", maxBytesErr.Limit),
+		})
+		return true
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(value)
 	if err != nil {
+		if tooLarge(err) {
+			return false
+		}
 		Write(rw, http.StatusBadRequest, codersdk.Response{
 			Message: "Request body must be valid JSON.",
 			Detail:  err.Error(),
 		})
 		return false
 	}
-	err = validate.Struct(value)
-	var validationErrors validator.ValidationErrors
-	if errors.As(err, &validationErrors) {
-		apiErrors := make([]codersdk.ValidationError, 0, len(validationErrors))
-		for _, validationError := range validationErrors {
-			apiErrors = append(apiErrors, codersdk.ValidationError{
-				Field:  validationError.Field(),
-				Detail: fmt.Sprintf("Validation failed for tag %q with value: \"%v\"", validationError.Tag(), validationError.Value()),
-			})
+	// Only whitespace may follow the document, so "{}{}" isn't read as "{}".
+	_, err = decoder.Token()
+	if !errors.Is(err, io.EOF) {
+		if tooLarge(err) {
+			return false
 		}
 		Write(rw, http.StatusBadRequest, codersdk.Response{
-			Message:     "Validation failed.",
-			Validations: apiErrors,
+			Message: "Request body must be a single JSON value.",
 		})
 		return false
 	}
+	apiErrors, err := Validate(value)
 	if err != nil {
 		Write(rw, http.StatusInternalServerError, codersdk.Response{
 			Message: "Internal error validating request body payload.",
@@ -124,9 +225,50 @@ func Read(rw http.ResponseWriter, r *http.Request, value interface{}) bool {
 		})
 		return false
 	}
+	if len(apiErrors) > 0 {
+		Write(rw, http.StatusBadRequest, codersdk.Response{
+			Message:     "Validation failed.",
+			Validations: apiErrors,
+		})
+		return false
+	}
 	return true
 }
 
+// ValidateOnly decodes and validates the request body exactly like Read,
+// but responds immediately instead of handing the value back. Forms use it
+// to check a payload incrementally without committing anything.
+func ValidateOnly(rw http.ResponseWriter, r *http.Request, value interface{}) {
+	if !Read(rw, r, value) {
+		return
+	}
+	Write(rw, http.StatusOK, codersdk.Response{
+		Message: "Validation passed.",
+	})
+}
+
+// Validate runs go-validator against the value provided. Failures are
+// returned in the form Read responds with, and an error is only returned
+// if the value couldn't be validated at all.
+func Validate(value interface{}) ([]codersdk.ValidationError, error) {
+	err := validate.Struct(value)
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		apiErrors := make([]codersdk.ValidationError, 0, len(validationErrors))
+		for _, validationError := range validationErrors {
+			apiErrors = append(apiErrors, codersdk.ValidationError{
+				Field:  validationError.Field(),
+				Detail: fmt.Sprintf("Validation failed for tag %q with value: \"%v\"", validationError.Tag(), validationError.Value()),
+			})
+		}
+		return apiErrors, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
 const websocketCloseMaxLen = 123
 
 // WebsocketCloseSprintf formats a websocket close message and ensures it is
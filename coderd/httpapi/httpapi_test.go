@@ -66,6 +66,27 @@ func TestWrite(t *testing.T) {
 	})
 }
 
+func TestWriteCacheable(t *testing.T) {
+	t.Parallel()
+	rw := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	httpapi.WriteCacheable(rw, r, http.StatusOK, []string{"a"})
+	require.Equal(t, http.StatusOK, rw.Code)
+	etag := rw.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	rw = httptest.NewRecorder()
+	r.Header.Set("If-None-Match", etag)
+	httpapi.WriteCacheable(rw, r, http.StatusOK, []string{"a"})
+	require.Equal(t, http.StatusNotModified, rw.Code)
+	require.Zero(t, rw.Body.Len())
+
+	rw = httptest.NewRecorder()
+	httpapi.WriteCacheable(rw, r, http.StatusOK, []string{"b"})
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.NotEqual(t, etag, rw.Header().Get("ETag"))
+}
+
 func TestRead(t *testing.T) {
 	t.Parallel()
 	t.Run("EmptyStruct", func(t *testing.T) {
@@ -114,6 +135,74 @@ func TestRead(t *testing.T) {
 		require.Equal(t, "value", v.Validations[0].Field)
 		require.Equal(t, "Validation failed for tag \"required\" with value: \"\"", v.Validations[0].Detail)
 	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		t.Parallel()
+		rw := httptest.NewRecorder()
+		body := `"` + strings.Repeat("a", httpapi.MaxReadBytes) + `"`
+		r := httptest.NewRequest("POST", "/", bytes.NewBufferString(body))
+		var v string
+		require.False(t, httpapi.Read(rw, r, &v))
+		require.Equal(t, http.StatusRequestEntityTooLarge, rw.Code)
+
+		rw = httptest.NewRecorder()
+		r = httptest.NewRequest("POST", "/", bytes.NewBufferString(`{"value":"hi"}`))
+		require.False(t, httpapi.ReadLimit(rw, r, &v, 8))
+		require.Equal(t, http.StatusRequestEntityTooLarge, rw.Code)
+	})
+
+	t.Run("TrailingData", func(t *testing.T) {
+		t.Parallel()
+		for _, body := range []string{"{}{}", "{} []", "{}]"} {
+			rw := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/", bytes.NewBufferString(body))
+			v := struct{}{}
+			require.False(t, httpapi.Read(rw, r, &v), body)
+			require.Equal(t, http.StatusBadRequest, rw.Code, body)
+		}
+
+		rw := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", bytes.NewBufferString("{}\n"))
+		v := struct{}{}
+		require.True(t, httpapi.Read(rw, r, &v))
+	})
+}
+
+func TestValidateOnly(t *testing.T) {
+	t.Parallel()
+	type toValidate struct {
+		Name  string `json:"name" validate:"required,username"`
+		Value string `json:"value" validate:"required"`
+	}
+	t.Run("MatchesRead", func(t *testing.T) {
+		t.Parallel()
+		const body = `{"name":"-invalid-"}`
+		readRW := httptest.NewRecorder()
+		var read toValidate
+		require.False(t, httpapi.Read(readRW, httptest.NewRequest("POST", "/", bytes.NewBufferString(body)), &read))
+
+		validateRW := httptest.NewRecorder()
+		httpapi.ValidateOnly(validateRW, httptest.NewRequest("POST", "/", bytes.NewBufferString(body)), &toValidate{})
+		require.Equal(t, readRW.Code, validateRW.Code)
+		require.Equal(t, readRW.Body.String(), validateRW.Body.String())
+
+		var v codersdk.Response
+		err := json.NewDecoder(validateRW.Body).Decode(&v)
+		require.NoError(t, err)
+		require.Len(t, v.Validations, 2)
+		validations, err := httpapi.Validate(&read)
+		require.NoError(t, err)
+		require.Equal(t, v.Validations, validations)
+	})
+	t.Run("Passes", func(t *testing.T) {
+		t.Parallel()
+		rw := httptest.NewRecorder()
+		httpapi.ValidateOnly(rw, httptest.NewRequest("POST", "/", bytes.NewBufferString(`{"name":"valid","value":"hi"}`)), &toValidate{})
+		require.Equal(t, http.StatusOK, rw.Code)
+		validations, err := httpapi.Validate(&toValidate{Name: "valid", Value: "hi"})
+		require.NoError(t, err)
+		require.Empty(t, validations)
+	})
 }
 
 func WebsocketCloseMsg(t *testing.T) {
@@ -135,3 +224,68 @@ func WebsocketCloseMsg(t *testing.T) {
 		assert.LessOrEqual(t, len(trunc), 123)
 	})
 }
+
+func TestWriteBatch(t *testing.T) {
+	t.Parallel()
+
+	// Items that aren't positive are invalid.
+	batch := func(items []int) []codersdk.BatchResult[int] {
+		results := make([]codersdk.BatchResult[int], 0, len(items))
+		for _, item := range items {
+			if item <= 0 {
+				results = append(results, httpapi.BatchFailure[int](http.StatusBadRequest, codersdk.Response{
+					Message: "Item must be positive.",
+				}))
+				continue
+			}
+			results = append(results, httpapi.BatchSuccess(http.StatusOK, item*2))
+		}
+		return results
+	}
+
+	t.Run("AllSucceed", func(t *testing.T) {
+		t.Parallel()
+		rw := httptest.NewRecorder()
+		httpapi.WriteBatch(rw, batch([]int{1, 2}))
+		require.Equal(t, http.StatusOK, rw.Code)
+
+		var resp codersdk.BatchResponse[int]
+		err := json.NewDecoder(rw.Body).Decode(&resp)
+		require.NoError(t, err)
+		require.Len(t, resp.Results, 2)
+		require.Empty(t, resp.Failed())
+	})
+
+	t.Run("PartialFailure", func(t *testing.T) {
+		t.Parallel()
+		rw := httptest.NewRecorder()
+		httpapi.WriteBatch(rw, batch([]int{1, -1, 3}))
+		require.Equal(t, http.StatusMultiStatus, rw.Code)
+
+		var resp codersdk.BatchResponse[int]
+		err := json.NewDecoder(rw.Body).Decode(&resp)
+		require.NoError(t, err)
+		require.Len(t, resp.Results, 3)
+
+		require.Equal(t, http.StatusOK, resp.Results[0].Status)
+		require.Nil(t, resp.Results[0].Error)
+		require.Equal(t, 2, *resp.Results[0].Result)
+
+		require.Equal(t, http.StatusBadRequest, resp.Results[1].Status)
+		require.Nil(t, resp.Results[1].Result)
+		require.Equal(t, "Item must be positive.", resp.Results[1].Error.Message)
+
+		require.Equal(t, http.StatusOK, resp.Results[2].Status)
+		require.Equal(t, 6, *resp.Results[2].Result)
+
+		require.Len(t, resp.Failed(), 1)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+		rw := httptest.NewRecorder()
+		httpapi.WriteBatch[int](rw, nil)
+		require.Equal(t, http.StatusOK, rw.Code)
+		require.JSONEq(t, `{"results":[]}`, rw.Body.String())
+	})
+}
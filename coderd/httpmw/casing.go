@@ -0,0 +1,36 @@
+package httpmw
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/coder/coder/coderd/httpapi"
+	"github.com/coder/coder/codersdk"
+)
+
+// AcceptCasing rewrites the top-level field names of responses from the
+// routes it wraps to the casing requested by the client, for integrations
+// that expect a convention other than snake_case. Responses are unchanged
+// when no casing is requested.
+func AcceptCasing() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			casing := codersdk.Casing(r.Header.Get(codersdk.AcceptCasingHeader))
+			if casing == "" {
+				casing = codersdk.Casing(r.URL.Query().Get(codersdk.CasingQueryParam))
+			}
+			switch casing {
+			case "":
+			case codersdk.CasingSnake, codersdk.CasingCamel:
+				rw = &httpapi.CasingWriter{ResponseWriter: rw, Casing: casing}
+			default:
+				httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+					Message: fmt.Sprintf("Unsupported casing %q.", casing),
+					Detail:  fmt.Sprintf("Casing must be %q or %q.", codersdk.CasingSnake, codersdk.CasingCamel),
+				})
+				return
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
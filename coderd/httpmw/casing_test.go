@@ -0,0 +1,106 @@
+package httpmw_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/coder/coderd/httpapi"
+	"github.com/coder/coder/coderd/httpmw"
+	"github.com/coder/coder/codersdk"
+)
+
+func TestAcceptCasing(t *testing.T) {
+	t.Parallel()
+	rtr := chi.NewRouter()
+	rtr.Use(httpmw.AcceptCasing())
+	rtr.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+		httpapi.Write(rw, http.StatusOK, []codersdk.CreateFirstUserResponse{{
+			UserID:         uuid.New(),
+			OrganizationID: uuid.New(),
+		}})
+	})
+	fieldNames := func(t *testing.T, r *http.Request) []string {
+		rec := httptest.NewRecorder()
+		rtr.ServeHTTP(rec, r)
+		res := rec.Result()
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		var body []map[string]json.RawMessage
+		err := json.NewDecoder(res.Body).Decode(&body)
+		require.NoError(t, err)
+		require.Len(t, body, 1)
+		names := make([]string, 0, len(body[0]))
+		for name := range body[0] {
+			names = append(names, name)
+		}
+		return names
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		names := fieldNames(t, httptest.NewRequest("GET", "/", nil))
+		require.ElementsMatch(t, []string{"user_id", "organization_id"}, names)
+	})
+
+	t.Run("Camel", func(t *testing.T) {
+		t.Parallel()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(codersdk.AcceptCasingHeader, string(codersdk.CasingCamel))
+		names := fieldNames(t, r)
+		require.ElementsMatch(t, []string{"userId", "organizationId"}, names)
+	})
+
+	t.Run("SnakeQueryParam", func(t *testing.T) {
+		t.Parallel()
+		names := fieldNames(t, httptest.NewRequest("GET", "/?casing=snake_case", nil))
+		require.ElementsMatch(t, []string{"user_id", "organization_id"}, names)
+	})
+
+	t.Run("Wrapped", func(t *testing.T) {
+		t.Parallel()
+		// Writers installed after AcceptCasing are unwrapped to find the
+		// casing.
+		rtr := chi.NewRouter()
+		rtr.Use(httpmw.AcceptCasing())
+		rtr.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(middleware.NewWrapResponseWriter(rw, r.ProtoMajor), r)
+			})
+		})
+		rtr.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+			httpapi.Write(rw, http.StatusOK, codersdk.CreateFirstUserResponse{
+				UserID:         uuid.New(),
+				OrganizationID: uuid.New(),
+			})
+		})
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(codersdk.AcceptCasingHeader, string(codersdk.CasingCamel))
+		rec := httptest.NewRecorder()
+		rtr.ServeHTTP(rec, r)
+		res := rec.Result()
+		defer res.Body.Close()
+		var body map[string]json.RawMessage
+		err := json.NewDecoder(res.Body).Decode(&body)
+		require.NoError(t, err)
+		require.Contains(t, body, "userId")
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		t.Parallel()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(codersdk.AcceptCasingHeader, "kebab-case")
+		rec := httptest.NewRecorder()
+		rtr.ServeHTTP(rec, r)
+		res := rec.Result()
+		defer res.Body.Close()
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+}
@@ -0,0 +1,19 @@
+package httpmw
+
+import (
+	"net/http"
+
+	"github.com/coder/coder/coderd/httpapi"
+	"github.com/coder/coder/codersdk"
+)
+
+// Deprecated marks all routes it wraps as deprecated. Clients are warned
+// by the response headers, but requests are otherwise served as usual.
+func Deprecated(deprecation codersdk.Deprecation) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			httpapi.Deprecated(rw, deprecation)
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package httpmw_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/coder/coderd/httpapi"
+	"github.com/coder/coder/coderd/httpmw"
+	"github.com/coder/coder/codersdk"
+)
+
+func TestDeprecated(t *testing.T) {
+	t.Parallel()
+	sunset := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	rtr := chi.NewRouter()
+	rtr.Use(httpmw.Deprecated(codersdk.Deprecation{
+		Message: "Use /new instead.",
+		Sunset:  &sunset,
+	}))
+	rtr.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+		httpapi.Write(rw, http.StatusOK, codersdk.Response{
+			Message: "Hello!",
+		})
+	})
+
+	rec := httptest.NewRecorder()
+	rtr.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	res := rec.Result()
+	defer res.Body.Close()
+
+	deprecation, ok := codersdk.DeprecationFromHeader(res.Header)
+	require.True(t, ok)
+	require.Equal(t, "Use /new instead.", deprecation.Message)
+	require.NotNil(t, deprecation.Sunset)
+	require.True(t, sunset.Equal(*deprecation.Sunset))
+
+	var resp codersdk.Response
+	err := json.NewDecoder(res.Body).Decode(&resp)
+	require.NoError(t, err)
+	require.Equal(t, "Hello!", resp.Message)
+	require.NotNil(t, resp.Deprecation)
+	require.Equal(t, "Use /new instead.", resp.Deprecation.Message)
+}
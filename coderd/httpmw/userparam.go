@@ -50,7 +50,16 @@ func ExtractUserParam(db database.Store) func(http.Handler) http.Handler {
 			}
 
 			if userQuery == "me" {
-				user, err = db.GetUserByID(r.Context(), APIKey(r).UserID)
+				// Requests authenticated by a workspace app token don't
+				// have an API key to resolve "me" with.
+				apiKey, ok := r.Context().Value(apiKeyContextKey{}).(database.APIKey)
+				if !ok {
+					httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+						Message: "\"me\" can only be used when signed in.",
+					})
+					return
+				}
+				user, err = db.GetUserByID(r.Context(), apiKey.UserID)
 				if xerrors.Is(err, sql.ErrNoRows) {
 					httpapi.ResourceNotFound(rw)
 					return
@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/google/uuid"
+	"golang.org/x/xerrors"
 
 	"github.com/coder/coder/coderd/database"
 	"github.com/coder/coder/coderd/httpapi"
@@ -27,6 +29,9 @@ func WorkspaceAgent(r *http.Request) database.WorkspaceAgent {
 
 // ExtractWorkspaceAgent requires authentication using a valid agent token.
 func ExtractWorkspaceAgent(db database.Store) func(http.Handler) http.Handler {
+	builds := &workspaceAgentBuilds{
+		builds: map[uuid.UUID]database.WorkspaceBuild{},
+	}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 			cookie, err := r.Cookie(codersdk.SessionTokenKey)
@@ -59,8 +64,81 @@ func ExtractWorkspaceAgent(db database.Store) func(http.Handler) http.Handler {
 				return
 			}
 
+			// Tokens are only valid for agents of the latest build, so an
+			// agent that outlives its build can't keep using the API.
+			build, err := builds.get(r.Context(), db, agent)
+			if err == nil {
+				err = EnsureLatestWorkspaceBuild(r.Context(), db, build)
+			}
+			if errors.Is(err, ErrWorkspaceBuildOutdated) || errors.Is(err, sql.ErrNoRows) {
+				httpapi.Write(rw, http.StatusUnauthorized, codersdk.Response{
+					Message: "Agent token belongs to an outdated workspace build.",
+					Detail:  err.Error(),
+				})
+				return
+			}
+			if err != nil {
+				httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+					Message: "Internal error fetching workspace agent build.",
+					Detail:  err.Error(),
+				})
+				return
+			}
+
 			ctx := context.WithValue(r.Context(), workspaceAgentContextKey{}, agent)
 			next.ServeHTTP(rw, r.WithContext(ctx))
 		})
 	}
 }
+
+// ErrWorkspaceBuildOutdated is returned by EnsureLatestWorkspaceBuild for
+// builds that have been superseded.
+var ErrWorkspaceBuildOutdated = xerrors.New("build is outdated")
+
+// EnsureLatestWorkspaceBuild returns ErrWorkspaceBuildOutdated if the build
+// isn't the latest of its workspace. Agents are only served for resources
+// of the latest build.
+func EnsureLatestWorkspaceBuild(ctx context.Context, db database.Store, build database.WorkspaceBuild) error {
+	latestBuild, err := db.GetLatestWorkspaceBuildByWorkspaceID(ctx, build.WorkspaceID)
+	if err != nil {
+		return xerrors.Errorf("get latest workspace build: %w", err)
+	}
+	if build.ID != latestBuild.ID {
+		return ErrWorkspaceBuildOutdated
+	}
+	return nil
+}
+
+// workspaceAgentBuildsLimit bounds the agents whose builds are remembered.
+const workspaceAgentBuildsLimit = 10000
+
+// workspaceAgentBuilds remembers the build of each agent, which never
+// changes, so authenticating an agent only queries for the latest build.
+type workspaceAgentBuilds struct {
+	mutex  sync.Mutex
+	builds map[uuid.UUID]database.WorkspaceBuild
+}
+
+func (b *workspaceAgentBuilds) get(ctx context.Context, db database.Store, agent database.WorkspaceAgent) (database.WorkspaceBuild, error) {
+	b.mutex.Lock()
+	build, ok := b.builds[agent.ID]
+	b.mutex.Unlock()
+	if ok {
+		return build, nil
+	}
+	resource, err := db.GetWorkspaceResourceByID(ctx, agent.ResourceID)
+	if err != nil {
+		return database.WorkspaceBuild{}, xerrors.Errorf("get workspace resource: %w", err)
+	}
+	build, err = db.GetWorkspaceBuildByJobID(ctx, resource.JobID)
+	if err != nil {
+		return database.WorkspaceBuild{}, xerrors.Errorf("get workspace build: %w", err)
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if len(b.builds) >= workspaceAgentBuildsLimit {
+		b.builds = map[uuid.UUID]database.WorkspaceBuild{}
+	}
+	b.builds[agent.ID] = build
+	return build, nil
+}
@@ -28,6 +28,29 @@ func TestWorkspaceAgent(t *testing.T) {
 		})
 		return r, token
 	}
+	// insertBuild inserts a build of the workspace with an agent that
+	// authenticates with token.
+	insertBuild := func(t *testing.T, db database.Store, workspaceID uuid.UUID, buildNumber int32, token uuid.UUID) {
+		jobID := uuid.New()
+		_, err := db.InsertWorkspaceBuild(context.Background(), database.InsertWorkspaceBuildParams{
+			ID:          uuid.New(),
+			WorkspaceID: workspaceID,
+			BuildNumber: buildNumber,
+			JobID:       jobID,
+		})
+		require.NoError(t, err)
+		resource, err := db.InsertWorkspaceResource(context.Background(), database.InsertWorkspaceResourceParams{
+			ID:    uuid.New(),
+			JobID: jobID,
+		})
+		require.NoError(t, err)
+		_, err = db.InsertWorkspaceAgent(context.Background(), database.InsertWorkspaceAgentParams{
+			ID:         uuid.New(),
+			ResourceID: resource.ID,
+			AuthToken:  token,
+		})
+		require.NoError(t, err)
+	}
 
 	t.Run("None", func(t *testing.T) {
 		t.Parallel()
@@ -58,17 +81,64 @@ func TestWorkspaceAgent(t *testing.T) {
 			rw.WriteHeader(http.StatusOK)
 		})
 		r, token := setup(db)
-		_, err := db.InsertWorkspaceAgent(context.Background(), database.InsertWorkspaceAgentParams{
-			ID:        uuid.New(),
-			AuthToken: token,
+		insertBuild(t, db, uuid.New(), 1, token)
+		rw := httptest.NewRecorder()
+		rtr.ServeHTTP(rw, r)
+
+		res := rw.Result()
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	})
+
+	t.Run("OutdatedBuild", func(t *testing.T) {
+		t.Parallel()
+		db := databasefake.New()
+		rtr := chi.NewRouter()
+		rtr.Use(
+			httpmw.ExtractWorkspaceAgent(db),
+		)
+		rtr.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+			_ = httpmw.WorkspaceAgent(r)
+			rw.WriteHeader(http.StatusOK)
 		})
-		require.NoError(t, err)
-		require.NoError(t, err)
+		r, token := setup(db)
+		workspaceID := uuid.New()
+		insertBuild(t, db, workspaceID, 1, token)
+		insertBuild(t, db, workspaceID, 2, uuid.New())
 		rw := httptest.NewRecorder()
 		rtr.ServeHTTP(rw, r)
 
 		res := rw.Result()
 		defer res.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("BuildSuperseded", func(t *testing.T) {
+		t.Parallel()
+		db := databasefake.New()
+		rtr := chi.NewRouter()
+		rtr.Use(
+			httpmw.ExtractWorkspaceAgent(db),
+		)
+		rtr.Get("/", func(rw http.ResponseWriter, r *http.Request) {
+			_ = httpmw.WorkspaceAgent(r)
+			rw.WriteHeader(http.StatusOK)
+		})
+		r, token := setup(db)
+		workspaceID := uuid.New()
+		insertBuild(t, db, workspaceID, 1, token)
+		rw := httptest.NewRecorder()
+		rtr.ServeHTTP(rw, r)
+		res := rw.Result()
+		_ = res.Body.Close()
 		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		// The agent's build is remembered, but the latest build isn't.
+		insertBuild(t, db, workspaceID, 2, uuid.New())
+		rw = httptest.NewRecorder()
+		rtr.ServeHTTP(rw, r)
+		res = rw.Result()
+		_ = res.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
 	})
 }
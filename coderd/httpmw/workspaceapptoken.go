@@ -0,0 +1,109 @@
+package httpmw
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/coder/coder/coderd/database"
+	"github.com/coder/coder/coderd/httpapi"
+	"github.com/coder/coder/codersdk"
+)
+
+type workspaceAppTokenContextKey struct{}
+
+// WorkspaceAppToken returns the workspace app token the request was
+// authenticated with, if it was authenticated with one at all.
+func WorkspaceAppToken(r *http.Request) (database.WorkspaceAppToken, bool) {
+	token, ok := r.Context().Value(workspaceAppTokenContextKey{}).(database.WorkspaceAppToken)
+	return token, ok
+}
+
+// ExtractWorkspaceAppToken authenticates requests that carry a workspace app
+// token as the user who created it. Requests without one are authenticated
+// by fallback instead.
+//
+// The token is scoped to a single app, but this middleware only checks that
+// it's valid. Handlers must compare it against the app being accessed.
+func ExtractWorkspaceAppToken(db database.Store, fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fallbackHandler := fallback(next)
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			tokenValue := r.URL.Query().Get(codersdk.WorkspaceAppTokenKey)
+			if tokenValue == "" {
+				cookie, err := r.Cookie(codersdk.WorkspaceAppTokenKey)
+				if err == nil {
+					tokenValue = cookie.Value
+				}
+			}
+			if tokenValue == "" {
+				fallbackHandler.ServeHTTP(rw, r)
+				return
+			}
+
+			// Tokens are formatted like API keys: ID-SECRET
+			tokenID, tokenSecret, ok := strings.Cut(tokenValue, "-")
+			if !ok || len(tokenID) != 10 || len(tokenSecret) != 22 {
+				httpapi.Write(rw, http.StatusUnauthorized, codersdk.Response{
+					Message: "Invalid workspace app token format.",
+				})
+				return
+			}
+			token, err := db.GetWorkspaceAppTokenByID(r.Context(), tokenID)
+			if errors.Is(err, sql.ErrNoRows) {
+				httpapi.Write(rw, http.StatusUnauthorized, codersdk.Response{
+					Message: "Workspace app token is invalid.",
+				})
+				return
+			}
+			if err != nil {
+				httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+					Message: internalErrorMessage,
+					Detail:  fmt.Sprintf("Internal error fetching workspace app token by id. %s", err.Error()),
+				})
+				return
+			}
+			hashed := sha256.Sum256([]byte(tokenSecret))
+			if subtle.ConstantTimeCompare(token.HashedSecret, hashed[:]) != 1 {
+				httpapi.Write(rw, http.StatusUnauthorized, codersdk.Response{
+					Message: "Workspace app token is invalid.",
+				})
+				return
+			}
+			if token.ExpiresAt.Before(database.Now()) {
+				httpapi.Write(rw, http.StatusUnauthorized, codersdk.Response{
+					Message: "Workspace app token has expired.",
+					Detail:  fmt.Sprintf("Workspace app token expired at %q.", token.ExpiresAt.String()),
+				})
+				return
+			}
+
+			// Access through the token can't exceed that of its creator, so
+			// requests are authorized with their roles.
+			roles, err := db.GetAuthorizationUserRoles(r.Context(), token.UserID)
+			if err != nil {
+				httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+					Message: internalErrorMessage,
+					Detail:  fmt.Sprintf("Internal error fetching user's roles. %s", err.Error()),
+				})
+				return
+			}
+			if roles.Status != database.UserStatusActive {
+				httpapi.Write(rw, http.StatusUnauthorized, codersdk.Response{
+					Message: "The creator of this workspace app token is no longer active.",
+				})
+				return
+			}
+
+			ctx := r.Context()
+			ctx = context.WithValue(ctx, workspaceAppTokenContextKey{}, token)
+			ctx = context.WithValue(ctx, userRolesKey{}, roles)
+			next.ServeHTTP(rw, r.WithContext(ctx))
+		})
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"path"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -588,6 +590,25 @@ func (server *provisionerdServer) CompleteJob(ctx context.Context, completed *pr
 		return nil, xerrors.Errorf("you don't have permission to update this job")
 	}
 
+	// Invalid agent directories fail the job here, rather than failing
+	// shells in the workspace later on.
+	err = validateAgents(completed)
+	if err != nil {
+		failedJob := &proto.FailedJob{
+			JobId: completed.JobId,
+			Error: err.Error(),
+		}
+		if workspaceBuild := completed.GetWorkspaceBuild(); workspaceBuild != nil {
+			// The state is kept so the resources can be deleted.
+			failedJob.Type = &proto.FailedJob_WorkspaceBuild_{
+				WorkspaceBuild: &proto.FailedJob_WorkspaceBuild{
+					State: workspaceBuild.State,
+				},
+			}
+		}
+		return server.FailJob(ctx, failedJob)
+	}
+
 	telemetrySnapshot := &telemetry.Snapshot{}
 	// Items are added to this snapshot as they complete!
 	defer server.Telemetry.Report(telemetrySnapshot)
@@ -787,6 +808,11 @@ func insertWorkspaceResource(ctx context.Context, db database.Store, jobID uuid.
 			}
 		}
 
+		directory, err := normalizeAgentDirectory(prAgent.Directory, prAgent.OperatingSystem)
+		if err != nil {
+			return xerrors.Errorf("agent %q: %w", prAgent.Name, err)
+		}
+
 		agentID := uuid.New()
 		dbAgent, err := db.InsertWorkspaceAgent(ctx, database.InsertWorkspaceAgentParams{
 			ID:                   agentID,
@@ -798,7 +824,7 @@ func insertWorkspaceResource(ctx context.Context, db database.Store, jobID uuid.
 			AuthInstanceID:       instanceID,
 			Architecture:         prAgent.Architecture,
 			EnvironmentVariables: env,
-			Directory:            prAgent.Directory,
+			Directory:            directory,
 			OperatingSystem:      prAgent.OperatingSystem,
 			StartupScript: sql.NullString{
 				String: prAgent.StartupScript,
@@ -960,3 +986,60 @@ func convertWorkspaceTransition(transition database.WorkspaceTransition) (sdkpro
 		return 0, xerrors.Errorf("unrecognized transition: %q", transition)
 	}
 }
+
+// validateAgents ensures every agent in the completed job has a directory
+// that normalizeAgentDirectory accepts, and apps with URLs that can be
+// routed to.
+func validateAgents(completed *proto.CompletedJob) error {
+	resources := make([]*sdkproto.Resource, 0)
+	resources = append(resources, completed.GetWorkspaceBuild().GetResources()...)
+	resources = append(resources, completed.GetTemplateImport().GetStartResources()...)
+	resources = append(resources, completed.GetTemplateImport().GetStopResources()...)
+	resources = append(resources, completed.GetTemplateDryRun().GetResources()...)
+	for _, resource := range resources {
+		for _, agent := range resource.Agents {
+			_, err := normalizeAgentDirectory(agent.Directory, agent.OperatingSystem)
+			if err != nil {
+				return xerrors.Errorf("agent %q: %w", agent.Name, err)
+			}
+			for _, app := range agent.Apps {
+				_, _, err = workspaceAppRouting(app.Url, app.RelativePath)
+				if err != nil {
+					return xerrors.Errorf("agent %q: app %q: %w", agent.Name, app.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeAgentDirectory cleans the directory an agent starts sessions in.
+// Directories must be absolute and can't traverse upwards, otherwise shells
+// fail to start with confusing errors. An empty directory is the home
+// directory of the agent's user.
+func normalizeAgentDirectory(directory, operatingSystem string) (string, error) {
+	if directory == "" {
+		return "", nil
+	}
+	slashed := directory
+	if operatingSystem == "windows" {
+		slashed = strings.ReplaceAll(directory, `\`, "/")
+		// Drive letters are stripped so the rest is an absolute path.
+		if len(slashed) < 3 || slashed[1] != ':' || slashed[2] != '/' {
+			return "", xerrors.Errorf("directory %q must be an absolute path", directory)
+		}
+		slashed = slashed[2:]
+	}
+	if !path.IsAbs(slashed) {
+		return "", xerrors.Errorf("directory %q must be an absolute path", directory)
+	}
+	for _, element := range strings.Split(slashed, "/") {
+		if element == ".." {
+			return "", xerrors.Errorf("directory %q must not contain %q", directory, "..")
+		}
+	}
+	if operatingSystem == "windows" {
+		return directory[:2] + strings.ReplaceAll(path.Clean(slashed), "/", `\`), nil
+	}
+	return path.Clean(slashed), nil
+}
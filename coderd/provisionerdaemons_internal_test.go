@@ -0,0 +1,86 @@
+package coderd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeAgentDirectory(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name            string
+		Directory       string
+		OperatingSystem string
+		Expected        string
+		ExpectedError   bool
+	}{
+		{
+			Name:            "Empty",
+			Directory:       "",
+			OperatingSystem: "linux",
+			Expected:        "",
+		},
+		{
+			Name:            "Absolute",
+			Directory:       "/home/coder/project",
+			OperatingSystem: "linux",
+			Expected:        "/home/coder/project",
+		},
+		{
+			Name:            "Cleaned",
+			Directory:       "/home//coder/./project/",
+			OperatingSystem: "linux",
+			Expected:        "/home/coder/project",
+		},
+		{
+			Name:            "Relative",
+			Directory:       "project",
+			OperatingSystem: "linux",
+			ExpectedError:   true,
+		},
+		{
+			Name:            "Home",
+			Directory:       "~/project",
+			OperatingSystem: "darwin",
+			ExpectedError:   true,
+		},
+		{
+			Name:            "Traversal",
+			Directory:       "/home/coder/../root",
+			OperatingSystem: "linux",
+			ExpectedError:   true,
+		},
+		{
+			Name:            "WindowsAbsolute",
+			Directory:       `C:\Users\coder\project\`,
+			OperatingSystem: "windows",
+			Expected:        `C:\Users\coder\project`,
+		},
+		{
+			Name:            "WindowsRelative",
+			Directory:       `Users\coder`,
+			OperatingSystem: "windows",
+			ExpectedError:   true,
+		},
+		{
+			Name:            "WindowsTraversal",
+			Directory:       `C:\Users\..\Windows`,
+			OperatingSystem: "windows",
+			ExpectedError:   true,
+		},
+	}
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			t.Parallel()
+			directory, err := normalizeAgentDirectory(testCase.Directory, testCase.OperatingSystem)
+			if testCase.ExpectedError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testCase.Expected, directory)
+		})
+	}
+}
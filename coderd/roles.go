@@ -20,7 +20,7 @@ func (api *API) assignableSiteRoles(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	roles := rbac.SiteRoles()
-	httpapi.Write(rw, http.StatusOK, assignableRoles(actorRoles.Roles, roles))
+	httpapi.WriteCacheable(rw, r, http.StatusOK, assignableRoles(actorRoles.Roles, roles))
 }
 
 // assignableSiteRoles returns all site wide roles that can be assigned.
@@ -34,7 +34,28 @@ func (api *API) assignableOrgRoles(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	roles := rbac.OrganizationRoles(organization.ID)
-	httpapi.Write(rw, http.StatusOK, assignableRoles(actorRoles.Roles, roles))
+	httpapi.WriteCacheable(rw, r, http.StatusOK, assignableRoles(actorRoles.Roles, roles))
+}
+
+// siteRolePermissions returns all site wide roles with their permissions.
+func (api *API) siteRolePermissions(rw http.ResponseWriter, r *http.Request) {
+	if !api.Authorize(r, rbac.ActionRead, rbac.ResourceRoleAssignment) {
+		httpapi.Forbidden(rw)
+		return
+	}
+
+	httpapi.Write(rw, http.StatusOK, rolesWithPermissions(rbac.SiteRoles()))
+}
+
+// orgRolePermissions returns all organization roles with their permissions.
+func (api *API) orgRolePermissions(rw http.ResponseWriter, r *http.Request) {
+	organization := httpmw.OrganizationParam(r)
+	if !api.Authorize(r, rbac.ActionRead, rbac.ResourceOrgRoleAssignment.InOrg(organization.ID)) {
+		httpapi.Forbidden(rw)
+		return
+	}
+
+	httpapi.Write(rw, http.StatusOK, rolesWithPermissions(rbac.OrganizationRoles(organization.ID)))
 }
 
 func (api *API) checkPermissions(rw http.ResponseWriter, r *http.Request) {
@@ -88,6 +109,39 @@ func convertRole(role rbac.Role) codersdk.Role {
 	}
 }
 
+func rolesWithPermissions(roles []rbac.Role) []codersdk.RoleWithPermissions {
+	converted := make([]codersdk.RoleWithPermissions, 0)
+	for _, role := range roles {
+		// Roles without a display name are hidden from the UI.
+		if role.DisplayName == "" {
+			continue
+		}
+		permissions := convertPermissions(codersdk.RoleScopeSite, role.Site)
+		for _, orgPermissions := range role.Org {
+			permissions = append(permissions, convertPermissions(codersdk.RoleScopeOrganization, orgPermissions)...)
+		}
+		permissions = append(permissions, convertPermissions(codersdk.RoleScopeUser, role.User)...)
+		converted = append(converted, codersdk.RoleWithPermissions{
+			Role:        convertRole(role),
+			Permissions: permissions,
+		})
+	}
+	return converted
+}
+
+func convertPermissions(scope codersdk.RoleScope, permissions []rbac.Permission) []codersdk.RolePermission {
+	converted := make([]codersdk.RolePermission, 0, len(permissions))
+	for _, permission := range permissions {
+		converted = append(converted, codersdk.RolePermission{
+			Negate:       permission.Negate,
+			ResourceType: permission.ResourceType,
+			Action:       string(permission.Action),
+			Scope:        scope,
+		})
+	}
+	return converted
+}
+
 func assignableRoles(actorRoles []string, roles []rbac.Role) []codersdk.AssignableRoles {
 	assignable := make([]codersdk.AssignableRoles, 0)
 	for _, role := range roles {
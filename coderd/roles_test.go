@@ -232,6 +232,63 @@ func TestListRoles(t *testing.T) {
 	}
 }
 
+func TestListRolePermissions(t *testing.T) {
+	t.Parallel()
+
+	client := coderdtest.New(t, nil)
+	admin := coderdtest.CreateFirstUser(t, client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	t.Cleanup(cancel)
+
+	findRole := func(t *testing.T, roles []codersdk.RoleWithPermissions, name string) codersdk.RoleWithPermissions {
+		t.Helper()
+		for _, role := range roles {
+			if role.Name == name {
+				return role
+			}
+		}
+		require.FailNow(t, "role not found", name)
+		return codersdk.RoleWithPermissions{}
+	}
+
+	t.Run("Site", func(t *testing.T) {
+		t.Parallel()
+		roles, err := client.ListSiteRolePermissions(ctx)
+		require.NoError(t, err)
+
+		templateAdmin := findRole(t, roles, rbac.RoleTemplateAdmin())
+		require.Equal(t, "Template Admin", templateAdmin.DisplayName)
+		for _, action := range []string{"create", "read", "update", "delete"} {
+			require.Contains(t, templateAdmin.Permissions, codersdk.RolePermission{
+				ResourceType: "template",
+				Action:       action,
+				Scope:        codersdk.RoleScopeSite,
+			})
+		}
+
+		owner := findRole(t, roles, rbac.RoleOwner())
+		require.Equal(t, []codersdk.RolePermission{{
+			ResourceType: "*",
+			Action:       "*",
+			Scope:        codersdk.RoleScopeSite,
+		}}, owner.Permissions)
+	})
+
+	t.Run("Organization", func(t *testing.T) {
+		t.Parallel()
+		roles, err := client.ListOrganizationRolePermissions(ctx, admin.OrganizationID)
+		require.NoError(t, err)
+
+		orgAdmin := findRole(t, roles, rbac.RoleOrgAdmin(admin.OrganizationID))
+		require.Contains(t, orgAdmin.Permissions, codersdk.RolePermission{
+			ResourceType: "*",
+			Action:       "*",
+			Scope:        codersdk.RoleScopeOrganization,
+		})
+	})
+}
+
 func convertRole(roleName string) codersdk.Role {
 	role, _ := rbac.RoleByName(roleName)
 	return codersdk.Role{
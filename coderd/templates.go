@@ -213,22 +213,52 @@ func (api *API) postTemplateByOrganization(rw http.ResponseWriter, r *http.Reque
 		minAutostartInterval = time.Duration(*createTemplate.MinAutostartIntervalMillis) * time.Millisecond
 	}
 
+	var agentInactiveDisconnectTimeout time.Duration
+	if createTemplate.AgentInactiveDisconnectTimeoutMillis != nil {
+		agentInactiveDisconnectTimeout = time.Duration(*createTemplate.AgentInactiveDisconnectTimeoutMillis) * time.Millisecond
+	}
+	if agentInactiveDisconnectTimeout < 0 {
+		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+			Message: "Invalid create template request.",
+			Validations: []codersdk.ValidationError{
+				{Field: "agent_inactive_disconnect_timeout_ms", Detail: "Must be a positive integer."},
+			},
+		})
+		return
+	}
+
+	var terminalIdleTimeout time.Duration
+	if createTemplate.TerminalIdleTimeoutMillis != nil {
+		terminalIdleTimeout = time.Duration(*createTemplate.TerminalIdleTimeoutMillis) * time.Millisecond
+	}
+	if terminalIdleTimeout < 0 {
+		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+			Message: "Invalid create template request.",
+			Validations: []codersdk.ValidationError{
+				{Field: "terminal_idle_timeout_ms", Detail: "Must be a positive integer."},
+			},
+		})
+		return
+	}
+
 	var dbTemplate database.Template
 	var template codersdk.Template
 	err = api.Database.InTx(func(db database.Store) error {
 		now := database.Now()
 		dbTemplate, err = db.InsertTemplate(r.Context(), database.InsertTemplateParams{
-			ID:                   uuid.New(),
-			CreatedAt:            now,
-			UpdatedAt:            now,
-			OrganizationID:       organization.ID,
-			Name:                 createTemplate.Name,
-			Provisioner:          importJob.Provisioner,
-			ActiveVersionID:      templateVersion.ID,
-			Description:          createTemplate.Description,
-			MaxTtl:               int64(maxTTL),
-			MinAutostartInterval: int64(minAutostartInterval),
-			CreatedBy:            apiKey.UserID,
+			ID:                             uuid.New(),
+			CreatedAt:                      now,
+			UpdatedAt:                      now,
+			OrganizationID:                 organization.ID,
+			Name:                           createTemplate.Name,
+			Provisioner:                    importJob.Provisioner,
+			ActiveVersionID:                templateVersion.ID,
+			Description:                    createTemplate.Description,
+			MaxTtl:                         int64(maxTTL),
+			MinAutostartInterval:           int64(minAutostartInterval),
+			CreatedBy:                      apiKey.UserID,
+			AgentInactiveDisconnectTimeout: int64(agentInactiveDisconnectTimeout),
+			TerminalIdleTimeout:            int64(terminalIdleTimeout),
 		})
 		if err != nil {
 			return xerrors.Errorf("insert template: %s", err)
@@ -415,6 +445,12 @@ func (api *API) patchTemplateMeta(rw http.ResponseWriter, r *http.Request) {
 	if req.MinAutostartIntervalMillis < 0 {
 		validErrs = append(validErrs, codersdk.ValidationError{Field: "min_autostart_interval_ms", Detail: "Must be a positive integer."})
 	}
+	if req.AgentInactiveDisconnectTimeoutMillis < 0 {
+		validErrs = append(validErrs, codersdk.ValidationError{Field: "agent_inactive_disconnect_timeout_ms", Detail: "Must be a positive integer."})
+	}
+	if req.TerminalIdleTimeoutMillis < 0 {
+		validErrs = append(validErrs, codersdk.ValidationError{Field: "terminal_idle_timeout_ms", Detail: "Must be a positive integer."})
+	}
 	if req.MaxTTLMillis > maxTTLDefault.Milliseconds() {
 		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
 			Message: "Invalid create template request.",
@@ -453,7 +489,9 @@ func (api *API) patchTemplateMeta(rw http.ResponseWriter, r *http.Request) {
 			req.Description == template.Description &&
 			req.Icon == template.Icon &&
 			req.MaxTTLMillis == time.Duration(template.MaxTtl).Milliseconds() &&
-			req.MinAutostartIntervalMillis == time.Duration(template.MinAutostartInterval).Milliseconds() {
+			req.MinAutostartIntervalMillis == time.Duration(template.MinAutostartInterval).Milliseconds() &&
+			req.AgentInactiveDisconnectTimeoutMillis == time.Duration(template.AgentInactiveDisconnectTimeout).Milliseconds() &&
+			req.TerminalIdleTimeoutMillis == time.Duration(template.TerminalIdleTimeout).Milliseconds() {
 			return nil
 		}
 
@@ -463,6 +501,8 @@ func (api *API) patchTemplateMeta(rw http.ResponseWriter, r *http.Request) {
 		icon := req.Icon
 		maxTTL := time.Duration(req.MaxTTLMillis) * time.Millisecond
 		minAutostartInterval := time.Duration(req.MinAutostartIntervalMillis) * time.Millisecond
+		agentInactiveDisconnectTimeout := time.Duration(req.AgentInactiveDisconnectTimeoutMillis) * time.Millisecond
+		terminalIdleTimeout := time.Duration(req.TerminalIdleTimeoutMillis) * time.Millisecond
 
 		if name == "" {
 			name = template.Name
@@ -473,15 +513,23 @@ func (api *API) patchTemplateMeta(rw http.ResponseWriter, r *http.Request) {
 		if minAutostartInterval == 0 {
 			minAutostartInterval = time.Duration(template.MinAutostartInterval)
 		}
+		if agentInactiveDisconnectTimeout == 0 {
+			agentInactiveDisconnectTimeout = time.Duration(template.AgentInactiveDisconnectTimeout)
+		}
+		if terminalIdleTimeout == 0 {
+			terminalIdleTimeout = time.Duration(template.TerminalIdleTimeout)
+		}
 
 		if err := s.UpdateTemplateMetaByID(r.Context(), database.UpdateTemplateMetaByIDParams{
-			ID:                   template.ID,
-			UpdatedAt:            database.Now(),
-			Name:                 name,
-			Description:          desc,
-			Icon:                 icon,
-			MaxTtl:               int64(maxTTL),
-			MinAutostartInterval: int64(minAutostartInterval),
+			ID:                             template.ID,
+			UpdatedAt:                      database.Now(),
+			Name:                           name,
+			Description:                    desc,
+			Icon:                           icon,
+			MaxTtl:                         int64(maxTTL),
+			MinAutostartInterval:           int64(minAutostartInterval),
+			AgentInactiveDisconnectTimeout: int64(agentInactiveDisconnectTimeout),
+			TerminalIdleTimeout:            int64(terminalIdleTimeout),
 		}); err != nil {
 			return err
 		}
@@ -697,19 +745,21 @@ func convertTemplates(templates []database.Template, workspaceCounts []database.
 
 func convertTemplate(template database.Template, workspaceOwnerCount uint32, createdByName string) codersdk.Template {
 	return codersdk.Template{
-		ID:                         template.ID,
-		CreatedAt:                  template.CreatedAt,
-		UpdatedAt:                  template.UpdatedAt,
-		OrganizationID:             template.OrganizationID,
-		Name:                       template.Name,
-		Provisioner:                codersdk.ProvisionerType(template.Provisioner),
-		ActiveVersionID:            template.ActiveVersionID,
-		WorkspaceOwnerCount:        workspaceOwnerCount,
-		Description:                template.Description,
-		Icon:                       template.Icon,
-		MaxTTLMillis:               time.Duration(template.MaxTtl).Milliseconds(),
-		MinAutostartIntervalMillis: time.Duration(template.MinAutostartInterval).Milliseconds(),
-		CreatedByID:                template.CreatedBy,
-		CreatedByName:              createdByName,
+		ID:                                   template.ID,
+		CreatedAt:                            template.CreatedAt,
+		UpdatedAt:                            template.UpdatedAt,
+		OrganizationID:                       template.OrganizationID,
+		Name:                                 template.Name,
+		Provisioner:                          codersdk.ProvisionerType(template.Provisioner),
+		ActiveVersionID:                      template.ActiveVersionID,
+		WorkspaceOwnerCount:                  workspaceOwnerCount,
+		Description:                          template.Description,
+		Icon:                                 template.Icon,
+		MaxTTLMillis:                         time.Duration(template.MaxTtl).Milliseconds(),
+		MinAutostartIntervalMillis:           time.Duration(template.MinAutostartInterval).Milliseconds(),
+		CreatedByID:                          template.CreatedBy,
+		CreatedByName:                        createdByName,
+		AgentInactiveDisconnectTimeoutMillis: time.Duration(template.AgentInactiveDisconnectTimeout).Milliseconds(),
+		TerminalIdleTimeoutMillis:            time.Duration(template.TerminalIdleTimeout).Milliseconds(),
 	}
 }
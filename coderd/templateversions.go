@@ -290,7 +290,7 @@ func (api *API) templateVersionDryRunResources(rw http.ResponseWriter, r *http.R
 		return
 	}
 
-	api.provisionerJobResources(rw, r, job)
+	api.provisionerJobResources(rw, r, job, api.AgentInactiveDisconnectTimeout)
 }
 
 func (api *API) templateVersionDryRunLogs(rw http.ResponseWriter, r *http.Request) {
@@ -811,7 +811,7 @@ func (api *API) templateVersionResources(rw http.ResponseWriter, r *http.Request
 		})
 		return
 	}
-	api.provisionerJobResources(rw, r, job)
+	api.provisionerJobResources(rw, r, job, api.AgentInactiveDisconnectTimeout)
 }
 
 // templateVersionLogs returns the logs returned by the provisioner for the given
@@ -1,9 +1,14 @@
 package turnconn
 
 import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // SHA1 is required by the TURN REST API.
+	"encoding/base64"
 	"io"
 	"net"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/pion/logging"
 	"github.com/pion/turn/v2"
@@ -32,6 +37,19 @@ var (
 	}
 )
 
+// RESTCredentials generates time-limited credentials for a TURN server that
+// shares the secret provided. This is the TURN REST API scheme implemented
+// by coturn's "use-auth-secret" option: the username is the expiry as a Unix
+// timestamp joined with an identifier, and the credential is the base64
+// encoded HMAC-SHA1 of the username.
+// See: https://datatracker.ietf.org/doc/html/draft-uberti-behave-turn-rest-00
+func RESTCredentials(secret string, expiry time.Time, id string) (username string, credential string) {
+	username = strconv.FormatInt(expiry.Unix(), 10) + ":" + id
+	mac := hmac.New(sha1.New, []byte(secret))
+	_, _ = mac.Write([]byte(username))
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
 // New constructs a new TURN server binding to the relay address provided.
 // The relay address is used to broadcast the location of an accepted connection.
 func New(relayAddress *turn.RelayAddressGeneratorStatic) (*Server, error) {
@@ -72,6 +72,11 @@ func TestTURNConn(t *testing.T) {
 
 	_, err = client.Ping()
 	require.NoError(t, err)
+
+	// Only TCP is allowed, so the connection must be relayed.
+	pair, err := client.SelectedCandidatePair()
+	require.NoError(t, err)
+	require.Equal(t, webrtc.ICECandidateTypeRelay, pair.Local.Typ)
 }
 
 func exchange(t *testing.T, client, server *peer.Conn) {
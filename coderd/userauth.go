@@ -40,7 +40,7 @@ type GithubOAuth2Config struct {
 	AllowTeams         []GithubOAuth2Team
 }
 
-func (api *API) userAuthMethods(rw http.ResponseWriter, _ *http.Request) {
+func (api *API) userAuthMethods(rw http.ResponseWriter, r *http.Request) {
 	httpapi.Write(rw, http.StatusOK, codersdk.AuthMethods{
 		Password: true,
 		Github:   api.GithubOAuth2Config != nil,
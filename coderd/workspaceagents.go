@@ -1,6 +1,7 @@
 package coderd
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
 	"encoding/json"
@@ -8,11 +9,18 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/hashicorp/yamux"
+	"github.com/pion/webrtc/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/tabbed/pqtype"
 	"golang.org/x/xerrors"
 	"inet.af/netaddr"
@@ -21,6 +29,7 @@ import (
 
 	"cdr.dev/slog"
 	"github.com/coder/coder/agent"
+	"github.com/coder/coder/coderd/audit"
 	"github.com/coder/coder/coderd/database"
 	"github.com/coder/coder/coderd/database/dbtypes"
 	"github.com/coder/coder/coderd/httpapi"
@@ -28,6 +37,7 @@ import (
 	"github.com/coder/coder/coderd/rbac"
 	"github.com/coder/coder/coderd/tracing"
 	"github.com/coder/coder/coderd/turnconn"
+	"github.com/coder/coder/coderd/wsconncache"
 	"github.com/coder/coder/codersdk"
 	"github.com/coder/coder/peer"
 	"github.com/coder/coder/peer/peerwg"
@@ -51,7 +61,23 @@ func (api *API) workspaceAgent(rw http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	apiAgent, err := convertWorkspaceAgent(workspaceAgent, convertApps(dbApps), api.AgentInactiveDisconnectTimeout)
+	dbPhases, err := api.Database.GetWorkspaceAgentStartupPhasesByAgentIDs(r.Context(), []uuid.UUID{workspaceAgent.ID})
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error fetching workspace agent startup phases.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+	inactiveTimeout, err := api.agentInactiveDisconnectTimeout(r.Context(), workspace)
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error fetching workspace template.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+	apiAgent, err := convertWorkspaceAgent(workspaceAgent, convertApps(dbApps), convertStartupPhases(dbPhases, workspaceAgent.ID), inactiveTimeout)
 	if err != nil {
 		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
 			Message: "Internal error reading workspace agent.",
@@ -59,6 +85,7 @@ func (api *API) workspaceAgent(rw http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	apiAgent.ShellPrompt = api.renderShellPrompt(workspace, workspaceAgent)
 
 	httpapi.Write(rw, http.StatusOK, apiAgent)
 }
@@ -75,7 +102,24 @@ func (api *API) workspaceAgentDial(rw http.ResponseWriter, r *http.Request) {
 		httpapi.ResourceNotFound(rw)
 		return
 	}
-	apiAgent, err := convertWorkspaceAgent(workspaceAgent, nil, api.AgentInactiveDisconnectTimeout)
+	start := time.Now()
+	established := false
+	var err error
+	defer func() {
+		if !established {
+			api.observeAgentConnection(r.Context(), agentConnectionDial, workspaceAgent.ID, start, err)
+		}
+	}()
+
+	inactiveTimeout, err := api.agentInactiveDisconnectTimeout(r.Context(), workspace)
+	if err != nil {
+		httpapi.Write(rw, agentDatabaseErrorStatus(err, http.StatusInternalServerError), codersdk.Response{
+			Message: "Internal error fetching workspace template.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+	apiAgent, err := convertWorkspaceAgent(workspaceAgent, nil, nil, inactiveTimeout)
 	if err != nil {
 		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
 			Message: "Internal error reading workspace agent.",
@@ -84,13 +128,19 @@ func (api *API) workspaceAgentDial(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if apiAgent.Status != codersdk.WorkspaceAgentConnected {
+		err = xerrors.Errorf("agent isn't connected: %s", apiAgent.Status)
 		httpapi.Write(rw, http.StatusPreconditionFailed, codersdk.Response{
 			Message: fmt.Sprintf("Agent isn't connected! Status: %s.", apiAgent.Status),
 		})
 		return
 	}
 
-	conn, err := websocket.Accept(rw, r, nil)
+	// The session ID is logged by the agent for SSH connections over this
+	// dial, so users can reference it in bug reports.
+	sessionID := uuid.NewString()
+	rw.Header().Set(codersdk.AgentSessionIDHeader, sessionID)
+
+	conn, err := acceptWebsocket(rw, r, nil, api.WebsocketAcceptTimeout)
 	if err != nil {
 		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
 			Message: "Failed to accept websocket.",
@@ -113,9 +163,14 @@ func (api *API) workspaceAgentDial(rw http.ResponseWriter, r *http.Request) {
 	// end span so we don't get long lived trace data
 	tracing.EndHTTPSpan(r, 200)
 
+	established = true
+	api.observeAgentConnection(ctx, agentConnectionDial, workspaceAgent.ID, start, nil)
+
+	logger := api.Logger.Named("peerbroker-proxy-dial").With(slog.F("session_id", sessionID))
+	logger.Debug(ctx, "dial workspace agent", slog.F("agent_id", workspaceAgent.ID))
 	err = peerbroker.ProxyListen(ctx, session, peerbroker.ProxyOptions{
 		ChannelID: workspaceAgent.ID.String(),
-		Logger:    api.Logger.Named("peerbroker-proxy-dial"),
+		Logger:    logger,
 		Pubsub:    api.Pubsub,
 	})
 	if err != nil {
@@ -126,7 +181,7 @@ func (api *API) workspaceAgentDial(rw http.ResponseWriter, r *http.Request) {
 
 func (api *API) workspaceAgentMetadata(rw http.ResponseWriter, r *http.Request) {
 	workspaceAgent := httpmw.WorkspaceAgent(r)
-	apiAgent, err := convertWorkspaceAgent(workspaceAgent, nil, api.AgentInactiveDisconnectTimeout)
+	apiAgent, err := convertWorkspaceAgent(workspaceAgent, nil, nil, api.AgentInactiveDisconnectTimeout)
 	if err != nil {
 		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
 			Message: "Internal error reading workspace agent.",
@@ -144,52 +199,201 @@ func (api *API) workspaceAgentMetadata(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	apiAgent.ShellPrompt, err = api.agentShellPrompt(r.Context(), workspaceAgent)
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error fetching workspace agent shell prompt.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
 	httpapi.Write(rw, http.StatusOK, agent.Metadata{
-		WireguardAddresses:   []netaddr.IPPrefix{ipp},
-		EnvironmentVariables: apiAgent.EnvironmentVariables,
-		StartupScript:        apiAgent.StartupScript,
-		Directory:            apiAgent.Directory,
+		WireguardAddresses:      []netaddr.IPPrefix{ipp},
+		EnvironmentVariables:    apiAgent.EnvironmentVariables,
+		StartupScript:           apiAgent.StartupScript,
+		Directory:               apiAgent.Directory,
+		ShellPrompt:             apiAgent.ShellPrompt,
+		STUNServers:             stunServers(api.ICEServers),
+		PTYEnvironmentDenylist:  api.AgentPTYEnvironmentDenylist,
+		PTYEnvironmentAllowlist: api.AgentPTYEnvironmentAllowlist,
+		DisabledProtocols:       api.AgentDisabledProtocols,
+		RedactProcessArguments:  api.AgentRedactProcessArguments,
 	})
 }
 
+// auditAgentConnection exports an audit log for an agent connection.
+// Failures are logged rather than ending the connection.
+func (api *API) auditAgentConnection(ctx context.Context, alog database.AuditLog) {
+	if api.Auditor == nil {
+		return
+	}
+	exportCtx, cancel := api.agentDatabaseContext(ctx)
+	defer cancel()
+	err := api.Auditor.Export(exportCtx, alog)
+	if err != nil {
+		api.Logger.Warn(ctx, "export agent audit log",
+			slog.F("agent_id", alog.ResourceID),
+			slog.F("action", alog.Action),
+			slog.Error(err),
+		)
+	}
+}
+
+// agentProtocolDisabled returns whether agents are configured to refuse
+// channels of the protocol.
+func (api *API) agentProtocolDisabled(protocol string) bool {
+	for _, disabled := range api.AgentDisabledProtocols {
+		if disabled == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// stunServers returns the "host:port" of every STUN URL in the ICE servers
+// provided, for agents to probe their network with.
+func stunServers(iceServers []webrtc.ICEServer) []string {
+	servers := make([]string, 0)
+	for _, server := range iceServers {
+		for _, serverURL := range server.URLs {
+			if !strings.HasPrefix(serverURL, "stun:") {
+				continue
+			}
+			// Query parameters, such as a transport, aren't relevant.
+			hostPort := strings.SplitN(strings.TrimPrefix(serverURL, "stun:"), "?", 2)[0]
+			if _, _, err := net.SplitHostPort(hostPort); err != nil {
+				hostPort = net.JoinHostPort(strings.Trim(hostPort, "[]"), "3478")
+			}
+			servers = append(servers, hostPort)
+		}
+	}
+	return servers
+}
+
+// agentShellPrompt renders AgentShellPrompt for the agent provided.
+func (api *API) agentShellPrompt(ctx context.Context, workspaceAgent database.WorkspaceAgent) (string, error) {
+	if api.AgentShellPrompt == "" {
+		return "", nil
+	}
+	resource, err := api.Database.GetWorkspaceResourceByID(ctx, workspaceAgent.ResourceID)
+	if err != nil {
+		return "", xerrors.Errorf("get workspace resource: %w", err)
+	}
+	build, err := api.Database.GetWorkspaceBuildByJobID(ctx, resource.JobID)
+	if err != nil {
+		return "", xerrors.Errorf("get workspace build: %w", err)
+	}
+	workspace, err := api.Database.GetWorkspaceByID(ctx, build.WorkspaceID)
+	if err != nil {
+		return "", xerrors.Errorf("get workspace: %w", err)
+	}
+	return api.renderShellPrompt(workspace, workspaceAgent), nil
+}
+
+// renderShellPrompt replaces the placeholders of AgentShellPrompt.
+func (api *API) renderShellPrompt(workspace database.Workspace, workspaceAgent database.WorkspaceAgent) string {
+	if api.AgentShellPrompt == "" {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{workspace}", workspace.Name,
+		"{agent}", workspaceAgent.Name,
+	).Replace(api.AgentShellPrompt)
+}
+
+// agentInactiveDisconnectTimeout returns how long agents of the workspace
+// may go without a heartbeat before they're considered disconnected. The
+// workspace's template can override the deployment-wide value. Agent dials
+// wait on this, so the template is fetched with agentDatabaseContext.
+func (api *API) agentInactiveDisconnectTimeout(ctx context.Context, workspace database.Workspace) (time.Duration, error) {
+	ctx, cancel := api.agentDatabaseContext(ctx)
+	defer cancel()
+	template, err := api.Database.GetTemplateByID(ctx, workspace.TemplateID)
+	if err != nil {
+		return 0, xerrors.Errorf("get template: %w", err)
+	}
+	return templateAgentInactiveDisconnectTimeout(template, api.AgentInactiveDisconnectTimeout), nil
+}
+
+// templateAgentInactiveDisconnectTimeout returns the template's timeout, or
+// fallback if the template doesn't set one.
+func templateAgentInactiveDisconnectTimeout(template database.Template, fallback time.Duration) time.Duration {
+	if template.AgentInactiveDisconnectTimeout > 0 {
+		return time.Duration(template.AgentInactiveDisconnectTimeout)
+	}
+	return fallback
+}
+
 func (api *API) workspaceAgentListen(rw http.ResponseWriter, r *http.Request) {
 	api.websocketWaitMutex.Lock()
 	api.websocketWaitGroup.Add(1)
 	api.websocketWaitMutex.Unlock()
 	defer api.websocketWaitGroup.Done()
 
+	api.agentReconnectMutex.Lock()
+	api.agentListenWaitGroup.Add(1)
+	reconnect := api.agentReconnect
+	api.agentReconnectMutex.Unlock()
+	defer api.agentListenWaitGroup.Done()
+
 	workspaceAgent := httpmw.WorkspaceAgent(r)
-	resource, err := api.Database.GetWorkspaceResourceByID(r.Context(), workspaceAgent.ResourceID)
+	start := time.Now()
+	established := false
+	var err error
+	defer func() {
+		if !established {
+			api.observeAgentConnection(r.Context(), agentConnectionListen, workspaceAgent.ID, start, err)
+		}
+	}()
+
+	dbCtx, dbCancel := api.agentDatabaseContext(r.Context())
+	resource, err := api.Database.GetWorkspaceResourceByID(dbCtx, workspaceAgent.ResourceID)
+	dbCancel()
 	if err != nil {
-		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+		httpapi.Write(rw, agentDatabaseErrorStatus(err, http.StatusBadRequest), codersdk.Response{
 			Message: "Failed to accept websocket.",
 			Detail:  err.Error(),
 		})
 		return
 	}
 
-	build, err := api.Database.GetWorkspaceBuildByJobID(r.Context(), resource.JobID)
+	dbCtx, dbCancel = api.agentDatabaseContext(r.Context())
+	build, err := api.Database.GetWorkspaceBuildByJobID(dbCtx, resource.JobID)
+	dbCancel()
 	if err != nil {
-		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+		httpapi.Write(rw, agentDatabaseErrorStatus(err, http.StatusBadRequest), codersdk.Response{
 			Message: "Internal error fetching workspace build job.",
 			Detail:  err.Error(),
 		})
 		return
 	}
+	dbCtx, dbCancel = api.agentDatabaseContext(r.Context())
+	workspace, err := api.Database.GetWorkspaceByID(dbCtx, build.WorkspaceID)
+	dbCancel()
+	if err != nil {
+		httpapi.Write(rw, agentDatabaseErrorStatus(err, http.StatusBadRequest), codersdk.Response{
+			Message: "Internal error fetching workspace.",
+			Detail:  err.Error(),
+		})
+		return
+	}
 	// Ensure the resource is still valid!
 	// We only accept agents for resources on the latest build.
-	ensureLatestBuild := func() error {
-		latestBuild, err := api.Database.GetLatestWorkspaceBuildByWorkspaceID(r.Context(), build.WorkspaceID)
-		if err != nil {
-			return err
-		}
-		if build.ID != latestBuild.ID {
-			return xerrors.New("build is outdated")
-		}
-		return nil
+	ensureLatestBuild := func(ctx context.Context) error {
+		ctx, cancel := api.agentDatabaseContext(ctx)
+		defer cancel()
+		return httpmw.EnsureLatestWorkspaceBuild(ctx, api.Database, build)
 	}
 
-	err = ensureLatestBuild()
+	err = ensureLatestBuild(r.Context())
+	if xerrors.Is(err, context.DeadlineExceeded) {
+		httpapi.Write(rw, http.StatusGatewayTimeout, codersdk.Response{
+			Message: "Timed out fetching the latest workspace build.",
+			Detail:  err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		api.Logger.Debug(r.Context(), "agent tried to connect from non-latest built",
 			slog.F("resource", resource),
@@ -202,9 +406,9 @@ func (api *API) workspaceAgentListen(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conn, err := websocket.Accept(rw, r, &websocket.AcceptOptions{
+	conn, err := acceptWebsocket(rw, r, &websocket.AcceptOptions{
 		CompressionMode: websocket.CompressionDisabled,
-	})
+	}, api.WebsocketAcceptTimeout)
 	if err != nil {
 		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
 			Message: "Failed to accept websocket.",
@@ -248,6 +452,8 @@ func (api *API) workspaceAgentListen(rw http.ResponseWriter, r *http.Request) {
 	}
 	disconnectedAt := workspaceAgent.DisconnectedAt
 	updateConnectionTimes := func() error {
+		ctx, cancel := api.agentDatabaseContext(ctx)
+		defer cancel()
 		err = api.Database.UpdateWorkspaceAgentConnectionByID(ctx, database.UpdateWorkspaceAgentConnectionByIDParams{
 			ID:               workspaceAgent.ID,
 			FirstConnectedAt: firstConnectedAt,
@@ -275,10 +481,71 @@ func (api *API) workspaceAgentListen(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if workspaceAgent.Maintenance {
+		// Maintenance is over once the agent is back.
+		err = func() error {
+			ctx, cancel := api.agentDatabaseContext(ctx)
+			defer cancel()
+			return api.Database.UpdateWorkspaceAgentMaintenanceByID(ctx, database.UpdateWorkspaceAgentMaintenanceByIDParams{
+				ID:          workspaceAgent.ID,
+				Maintenance: false,
+				UpdatedAt:   database.Now(),
+			})
+		}()
+		if err != nil {
+			api.Logger.Warn(ctx, "clear workspace agent maintenance", slog.F("agent_id", workspaceAgent.ID), slog.Error(err))
+		}
+	}
+
 	// end span so we don't get long lived trace data
 	tracing.EndHTTPSpan(r, 200)
 
-	api.Logger.Info(ctx, "accepting agent", slog.F("resource", resource), slog.F("agent", workspaceAgent))
+	established = true
+	api.observeAgentConnection(ctx, agentConnectionListen, workspaceAgent.ID, start, nil)
+
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ip = net.IPv4(0, 0, 0, 0)
+	}
+	bitlen := len(ip) * 8
+	// The agent is the actor, so logs are attributed to the workspace owner.
+	auditLog := database.AuditLog{
+		ID:             uuid.New(),
+		Time:           lastConnectedAt.Time,
+		UserID:         workspace.OwnerID,
+		OrganizationID: workspace.OrganizationID,
+		Ip: pqtype.Inet{
+			IPNet: net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bitlen, bitlen),
+			},
+			Valid: true,
+		},
+		UserAgent:      r.UserAgent(),
+		ResourceType:   database.ResourceTypeWorkspaceAgent,
+		ResourceID:     workspaceAgent.ID,
+		ResourceTarget: workspaceAgent.Name,
+		Action:         database.AuditActionConnect,
+		StatusCode:     http.StatusSwitchingProtocols,
+	}
+	auditDiff := audit.Map{
+		"workspace_id": build.WorkspaceID,
+		"build_id":     build.ID,
+		"connected_at": lastConnectedAt.Time,
+	}
+	auditLog.Diff, _ = json.Marshal(auditDiff)
+	api.auditAgentConnection(ctx, auditLog)
+	defer func() {
+		auditLog.ID = uuid.New()
+		auditLog.Time = database.Now()
+		auditLog.Action = database.AuditActionDisconnect
+		auditDiff["disconnected_at"] = auditLog.Time
+		auditLog.Diff, _ = json.Marshal(auditDiff)
+		// The request context is canceled when the connection closes
+		// abnormally, which mustn't drop the log.
+		api.auditAgentConnection(context.Background(), auditLog)
+	}()
 
 	ticker := time.NewTicker(api.AgentConnectionUpdateFrequency)
 	defer ticker.Stop()
@@ -286,6 +553,9 @@ func (api *API) workspaceAgentListen(rw http.ResponseWriter, r *http.Request) {
 		select {
 		case <-session.CloseChan():
 			return
+		case <-reconnect:
+			_ = conn.Close(agent.ReconnectStatus, api.AgentReconnectDelay.String())
+			return
 		case <-ticker.C:
 			lastConnectedAt = sql.NullTime{
 				Time:  database.Now(),
@@ -296,7 +566,7 @@ func (api *API) workspaceAgentListen(rw http.ResponseWriter, r *http.Request) {
 				_ = conn.Close(websocket.StatusAbnormalClosure, err.Error())
 				return
 			}
-			err = ensureLatestBuild()
+			err = ensureLatestBuild(ctx)
 			if err != nil {
 				// Disconnect agents that are no longer valid.
 				_ = conn.Close(websocket.StatusGoingAway, "")
@@ -306,8 +576,87 @@ func (api *API) workspaceAgentListen(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (api *API) workspaceAgentICEServers(rw http.ResponseWriter, _ *http.Request) {
-	httpapi.Write(rw, http.StatusOK, api.ICEServers)
+// Kinds of agent connections whose establishment is observed.
+const (
+	// agentConnectionListen is an agent connecting to coderd.
+	agentConnectionListen = "listen"
+	// agentConnectionDial is a client proxying through coderd to an agent.
+	agentConnectionDial = "dial"
+	// agentConnectionCoderd is coderd dialing an agent itself.
+	agentConnectionCoderd = "coderd"
+)
+
+func newAgentConnectionSetupHistogram(registerer prometheus.Registerer) *prometheus.HistogramVec {
+	return promauto.With(registerer).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "coderd",
+		Subsystem: "agents",
+		Name:      "connection_setup_ms",
+		Help:      "Time taken to establish workspace agent connections in milliseconds",
+		Buckets:   []float64{1, 5, 10, 25, 50, 100, 500, 1000, 5000, 10000, 30000},
+	}, []string{"kind", "outcome"})
+}
+
+// observeAgentConnection logs and records the outcome of establishing an
+// agent connection that began at start.
+func (api *API) observeAgentConnection(ctx context.Context, kind string, agentID uuid.UUID, start time.Time, err error) {
+	duration := time.Since(start)
+	fields := []slog.Field{
+		slog.F("kind", kind),
+		slog.F("agent_id", agentID),
+		slog.F("duration", duration),
+	}
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+		api.Logger.Warn(ctx, "agent connection failed", append(fields, slog.Error(err))...)
+	} else {
+		api.Logger.Info(ctx, "agent connection established", fields...)
+	}
+	api.agentConnectionSetup.WithLabelValues(kind, outcome).Observe(float64(duration.Milliseconds()))
+}
+
+// agentDatabaseContext returns a context for a single database call made
+// while serving an agent connection. It's bounded by AgentDatabaseCallTimeout
+// so a hung query can't hold the connection open indefinitely.
+func (api *API) agentDatabaseContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, api.AgentDatabaseCallTimeout)
+}
+
+// agentDatabaseErrorStatus returns the status for a failed database call
+// made with agentDatabaseContext.
+func agentDatabaseErrorStatus(err error, status int) int {
+	if xerrors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return status
+}
+
+func (api *API) workspaceAgentICEServers(rw http.ResponseWriter, r *http.Request) {
+	httpapi.Write(rw, http.StatusOK, api.iceServers())
+}
+
+// iceServers returns the configured ICE servers. If a TURN secret is set,
+// TURN servers are given newly generated credentials on each call.
+func (api *API) iceServers() []webrtc.ICEServer {
+	if api.TURNSecret == "" {
+		return api.ICEServers
+	}
+	expiry := database.Now().Add(api.TURNCredentialTTL)
+	servers := make([]webrtc.ICEServer, 0, len(api.ICEServers))
+	for _, server := range api.ICEServers {
+		for _, serverURL := range server.URLs {
+			if !strings.HasPrefix(serverURL, "turn:") && !strings.HasPrefix(serverURL, "turns:") {
+				continue
+			}
+			// A unique identifier ensures every caller receives
+			// distinct credentials.
+			server.Username, server.Credential = turnconn.RESTCredentials(api.TURNSecret, expiry, uuid.NewString())
+			server.CredentialType = webrtc.ICECredentialTypePassword
+			break
+		}
+		servers = append(servers, server)
+	}
+	return servers
 }
 
 // userWorkspaceAgentTurn is a user connecting to a remote workspace agent
@@ -353,9 +702,9 @@ func (api *API) workspaceAgentTurn(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	wsConn, err := websocket.Accept(rw, r, &websocket.AcceptOptions{
+	wsConn, err := acceptWebsocket(rw, r, &websocket.AcceptOptions{
 		CompressionMode: websocket.CompressionDisabled,
-	})
+	}, api.WebsocketAcceptTimeout)
 	if err != nil {
 		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
 			Message: "Failed to accept websocket.",
@@ -390,7 +739,24 @@ func (api *API) workspaceAgentPTY(rw http.ResponseWriter, r *http.Request) {
 		httpapi.ResourceNotFound(rw)
 		return
 	}
-	apiAgent, err := convertWorkspaceAgent(workspaceAgent, nil, api.AgentInactiveDisconnectTimeout)
+	if api.agentProtocolDisabled(agent.ProtocolReconnectingPTY) {
+		httpapi.Write(rw, http.StatusForbidden, codersdk.Response{
+			Message: "The web terminal is disabled by the deployment.",
+		})
+		return
+	}
+	dbCtx, dbCancel := api.agentDatabaseContext(r.Context())
+	template, err := api.Database.GetTemplateByID(dbCtx, workspace.TemplateID)
+	dbCancel()
+	if err != nil {
+		httpapi.Write(rw, agentDatabaseErrorStatus(err, http.StatusInternalServerError), codersdk.Response{
+			Message: "Internal error fetching workspace template.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+	inactiveTimeout := templateAgentInactiveDisconnectTimeout(template, api.AgentInactiveDisconnectTimeout)
+	apiAgent, err := convertWorkspaceAgent(workspaceAgent, nil, nil, inactiveTimeout)
 	if err != nil {
 		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
 			Message: "Internal error reading workspace agent.",
@@ -423,10 +789,55 @@ func (api *API) workspaceAgentPTY(rw http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		width = 80
 	}
+	var replayLimit int
+	if raw := r.URL.Query().Get("replay_limit"); raw != "" {
+		replayLimit, err = strconv.Atoi(raw)
+		if err != nil || replayLimit < 0 {
+			httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+				Message: "Query param 'replay_limit' must be a non-negative integer.",
+				Validations: []codersdk.ValidationError{
+					{Field: "replay_limit", Detail: "invalid integer"},
+				},
+			})
+			return
+		}
+	}
+	var takeover bool
+	if raw := r.URL.Query().Get("takeover"); raw != "" {
+		takeover, err = strconv.ParseBool(raw)
+		if err != nil {
+			httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+				Message: "Query param 'takeover' must be a boolean.",
+				Validations: []codersdk.ValidationError{
+					{Field: "takeover", Detail: "invalid boolean"},
+				},
+			})
+			return
+		}
+	}
 
-	conn, err := websocket.Accept(rw, r, &websocket.AcceptOptions{
-		CompressionMode: websocket.CompressionDisabled,
-	})
+	// The session ID is logged by coderd and the agent for everything
+	// related to this terminal, so users can reference it in bug reports.
+	// Clients may pick it so they can show it, otherwise it's returned in a
+	// header.
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		sessionID = uuid.NewString()
+	} else if _, err := uuid.Parse(sessionID); err != nil {
+		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+			Message: "Query param 'session_id' must be a valid UUID.",
+			Validations: []codersdk.ValidationError{
+				{Field: "session_id", Detail: "invalid UUID"},
+			},
+		})
+		return
+	}
+	rw.Header().Set(codersdk.AgentSessionIDHeader, sessionID)
+
+	if api.PTYCompression {
+		ptyOfferClientNoContextTakeover(r.Header)
+	}
+	conn, err := acceptWebsocket(rw, r, ptyAcceptOptions(api.PTYCompression), api.WebsocketAcceptTimeout)
 	if err != nil {
 		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
 			Message: "Failed to accept websocket.",
@@ -438,115 +849,973 @@ func (api *API) workspaceAgentPTY(rw http.ResponseWriter, r *http.Request) {
 	_, wsNetConn := websocketNetConn(r.Context(), conn, websocket.MessageBinary)
 	defer wsNetConn.Close() // Also closes conn.
 
+	// The agent detaches the other clients of the session on a takeover
+	// too, but only this replica can tell its clients why.
+	sessionKey := ptySessionKey{agentID: workspaceAgent.ID, reconnect: reconnect}
+	api.trackPTYConn(sessionKey, conn, takeover)
+	defer api.untrackPTYConn(sessionKey, conn)
+
 	agentConn, release, err := api.workspaceAgentCache.Acquire(r, workspaceAgent.ID)
 	if err != nil {
 		_ = conn.Close(websocket.StatusInternalError, httpapi.WebsocketCloseSprintf("dial workspace agent: %s", err))
 		return
 	}
 	defer release()
-	ptNetConn, err := agentConn.ReconnectingPTY(reconnect.String(), uint16(height), uint16(width), r.URL.Query().Get("command"))
+	ptyInit := agent.ReconnectingPTYInit{
+		ID:          reconnect.String(),
+		Height:      uint16(height),
+		Width:       uint16(width),
+		ReplayLimit: replayLimit,
+		Takeover:    takeover,
+		Command:     r.URL.Query().Get("command"),
+	}
+	// Agents that predate session IDs drop PTYs whose init carries one.
+	probeCtx, probeCancel := context.WithTimeout(r.Context(), api.AgentDialTimeout)
+	if agentConn.SupportsReconnectingPTYInit(probeCtx) {
+		ptyInit.SessionID = sessionID
+	}
+	probeCancel()
+	ptNetConn, err := agentConn.ReconnectingPTY(ptyInit)
 	if err != nil {
+		api.Logger.Warn(r.Context(), "dial reconnecting pty", slog.F("session_id", sessionID), slog.Error(err))
 		_ = conn.Close(websocket.StatusInternalError, httpapi.WebsocketCloseSprintf("dial: %s", err))
 		return
 	}
 	defer ptNetConn.Close()
-	// Pipe the ends together!
+	// Pipe the ends together! Output must stop being written before the
+	// websocket closes, otherwise closing races with compression state
+	// that an in-flight write is still using.
+	output := newPTYOutputBuffer(api.PTYOutputHighWater, api.PTYOutputStallTimeout)
+	outputDone := make(chan struct{})
 	go func() {
-		_, _ = io.Copy(wsNetConn, ptNetConn)
+		defer close(outputDone)
+		_ = output.writeTo(wsNetConn)
 	}()
-	_, _ = io.Copy(ptNetConn, wsNetConn)
+	go func() {
+		err := output.readFrom(ptNetConn)
+		if xerrors.Is(err, errPTYOutputStalled) {
+			_ = conn.Close(websocket.StatusPolicyViolation, "Terminal output was not read in time.")
+		}
+	}()
+	var input io.Reader = wsNetConn
+	if idleTimeout := templateTerminalIdleTimeout(template, api.TerminalIdleTimeout); idleTimeout > 0 {
+		idle := time.AfterFunc(idleTimeout, func() {
+			_ = conn.Close(websocket.StatusPolicyViolation, httpapi.WebsocketCloseSprintf("Terminal closed after %s idle timeout.", idleTimeout))
+		})
+		defer idle.Stop()
+		input = &idleTimeoutReader{Reader: wsNetConn, timer: idle, timeout: idleTimeout}
+	}
+	_, _ = io.Copy(ptNetConn, input)
+	_ = ptNetConn.Close()
+	<-outputDone
 }
 
-func (*API) derpMap(rw http.ResponseWriter, _ *http.Request) {
-	httpapi.Write(rw, http.StatusOK, peerwg.DerpMap)
+// ptySessionKey identifies a reconnecting PTY session. Reconnect IDs are
+// only unique to an agent.
+type ptySessionKey struct {
+	agentID   uuid.UUID
+	reconnect uuid.UUID
 }
 
-type WorkspaceKeysRequest struct {
-	Public key.NodePublic  `json:"public"`
-	Disco  key.DiscoPublic `json:"disco"`
+// trackPTYConn registers a websocket serving a reconnecting PTY session.
+// On a takeover, the session's other websockets are closed first.
+func (api *API) trackPTYConn(session ptySessionKey, conn *websocket.Conn, takeover bool) {
+	api.ptyConnsMutex.Lock()
+	defer api.ptyConnsMutex.Unlock()
+	conns, ok := api.ptyConns[session]
+	if !ok {
+		conns = make(map[*websocket.Conn]struct{})
+		api.ptyConns[session] = conns
+	}
+	if takeover {
+		for other := range conns {
+			delete(conns, other)
+			// Closing waits for the client to acknowledge, which an
+			// unresponsive one may never do.
+			go func(other *websocket.Conn) {
+				_ = other.Close(websocket.StatusPolicyViolation, codersdk.ReconnectingPTYTakenOverReason)
+			}(other)
+		}
+	}
+	conns[conn] = struct{}{}
 }
 
-func (api *API) postWorkspaceAgentKeys(rw http.ResponseWriter, r *http.Request) {
-	var (
-		ctx            = r.Context()
-		workspaceAgent = httpmw.WorkspaceAgent(r)
-		keys           WorkspaceKeysRequest
-	)
-	if !httpapi.Read(rw, r, &keys) {
-		return
+func (api *API) untrackPTYConn(session ptySessionKey, conn *websocket.Conn) {
+	api.ptyConnsMutex.Lock()
+	defer api.ptyConnsMutex.Unlock()
+	delete(api.ptyConns[session], conn)
+	if len(api.ptyConns[session]) == 0 {
+		delete(api.ptyConns, session)
 	}
+}
 
-	err := api.Database.UpdateWorkspaceAgentKeysByID(ctx, database.UpdateWorkspaceAgentKeysByIDParams{
-		ID:                      workspaceAgent.ID,
-		WireguardNodePublicKey:  dbtypes.NodePublic(keys.Public),
-		WireguardDiscoPublicKey: dbtypes.DiscoPublic(keys.Disco),
-		UpdatedAt:               database.Now(),
-	})
-	if err != nil {
-		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
-			Message: "Internal error setting agent keys.",
-			Detail:  err.Error(),
-		})
-		return
+// templateTerminalIdleTimeout returns the template's timeout, or fallback if
+// the template doesn't set one.
+func templateTerminalIdleTimeout(template database.Template, fallback time.Duration) time.Duration {
+	if template.TerminalIdleTimeout > 0 {
+		return time.Duration(template.TerminalIdleTimeout)
 	}
+	return fallback
+}
 
-	rw.WriteHeader(http.StatusNoContent)
+// idleTimeoutReader resets the timer whenever input is read, so it only
+// fires once the client has been idle for the timeout.
+type idleTimeoutReader struct {
+	io.Reader
+	timer   *time.Timer
+	timeout time.Duration
 }
 
-func (api *API) postWorkspaceAgentWireguardPeer(rw http.ResponseWriter, r *http.Request) {
-	var (
-		req            peerwg.Handshake
-		workspaceAgent = httpmw.WorkspaceAgentParam(r)
-		workspace      = httpmw.WorkspaceParam(r)
-	)
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
 
-	if !api.Authorize(r, rbac.ActionCreate, workspace.ExecutionRBAC()) {
-		httpapi.ResourceNotFound(rw)
-		return
+var errPTYOutputStalled = xerrors.New("client stopped reading terminal output")
+
+// ptyOutputBuffer queues terminal output between the agent and a
+// websocket client. Once highWater bytes are queued, output stops being
+// read from the agent until the client catches up. A client that doesn't
+// free any space within the stall timeout is disconnected, so a terminal
+// that stops reading can't hold server memory.
+type ptyOutputBuffer struct {
+	highWater    int
+	stallTimeout time.Duration
+
+	mu       sync.Mutex
+	chunks   [][]byte
+	buffered int
+	// eof is set once the source ends, and closed once the destination
+	// fails.
+	eof    bool
+	closed bool
+
+	// queued and drained wake the writer and reader respectively.
+	queued  chan struct{}
+	drained chan struct{}
+}
+
+func newPTYOutputBuffer(highWater int, stallTimeout time.Duration) *ptyOutputBuffer {
+	return &ptyOutputBuffer{
+		highWater:    highWater,
+		stallTimeout: stallTimeout,
+		queued:       make(chan struct{}, 1),
+		drained:      make(chan struct{}, 1),
 	}
+}
 
-	if !httpapi.Read(rw, r, &req) {
-		return
+// readFrom queues output from src until it ends. It returns
+// errPTYOutputStalled if the buffer stays full past the stall timeout.
+func (b *ptyOutputBuffer) readFrom(src io.Reader) error {
+	defer func() {
+		b.mu.Lock()
+		b.eof = true
+		b.mu.Unlock()
+		b.wake(b.queued)
+	}()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			waitErr := b.waitForSpace()
+			if waitErr != nil {
+				return waitErr
+			}
+			b.mu.Lock()
+			b.chunks = append(b.chunks, append([]byte(nil), buf[:n]...))
+			b.buffered += n
+			b.mu.Unlock()
+			b.wake(b.queued)
+		}
+		if xerrors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 	}
+}
 
-	if req.Recipient != workspaceAgent.ID {
-		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
-			Message: "Invalid recipient.",
-		})
-		return
+func (b *ptyOutputBuffer) waitForSpace() error {
+	full, err := b.full()
+	if err != nil || !full {
+		return err
+	}
+	timer := time.NewTimer(b.stallTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-b.drained:
+		case <-timer.C:
+			return errPTYOutputStalled
+		}
+		full, err = b.full()
+		if err != nil || !full {
+			return err
+		}
 	}
+}
 
-	raw, err := req.MarshalText()
-	if err != nil {
-		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
-			Message: "Internal error marshaling wireguard peer message.",
-			Detail:  err.Error(),
-		})
-		return
+func (b *ptyOutputBuffer) full() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false, io.ErrClosedPipe
 	}
+	return b.buffered >= b.highWater, nil
+}
 
-	err = api.Pubsub.Publish("wireguard_peers", raw)
-	if err != nil {
-		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
-			Message: "Internal error publishing wireguard peer message.",
-			Detail:  err.Error(),
-		})
-		return
+// writeTo writes queued output to dst until the source ends.
+func (b *ptyOutputBuffer) writeTo(dst io.Writer) error {
+	for {
+		b.mu.Lock()
+		chunks, eof := b.chunks, b.eof
+		b.chunks = nil
+		b.mu.Unlock()
+		if len(chunks) == 0 {
+			if eof {
+				return nil
+			}
+			<-b.queued
+			continue
+		}
+		for _, chunk := range chunks {
+			_, err := dst.Write(chunk)
+			b.mu.Lock()
+			b.buffered -= len(chunk)
+			if err != nil {
+				b.closed = true
+			}
+			b.mu.Unlock()
+			b.wake(b.drained)
+			if err != nil {
+				return err
+			}
+		}
 	}
+}
 
-	rw.WriteHeader(http.StatusNoContent)
+// wake signals a waiter on ch without blocking. The channel holds one
+// signal, so a wake-up before the wait isn't lost.
+func (*ptyOutputBuffer) wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
 }
 
-func (api *API) workspaceAgentWireguardListener(rw http.ResponseWriter, r *http.Request) {
-	api.websocketWaitMutex.Lock()
-	api.websocketWaitGroup.Add(1)
+// ptyCompressionThreshold is the smallest message coderd compresses on PTY
+// websockets. Echoed keystrokes and small redraws are sent as-is, since
+// deflating them costs more than it saves.
+const ptyCompressionThreshold = 512
+
+// ptyAcceptOptions configures the reconnecting PTY websocket. Compression
+// keeps its context between output messages, because terminal output
+// repeats prompts and log lines across many small writes.
+//
+// Ideally only output would be compressed. permessage-deflate is negotiated
+// for both directions though, and nhooyr.io/websocket inflates compressed
+// client frames itself without a way to refuse them, so clients that
+// negotiated it may still compress input. ptyOfferClientNoContextTakeover
+// keeps that cheap.
+func ptyAcceptOptions(compress bool) *websocket.AcceptOptions {
+	if !compress {
+		return &websocket.AcceptOptions{
+			CompressionMode: websocket.CompressionDisabled,
+		}
+	}
+	return &websocket.AcceptOptions{
+		CompressionMode:      websocket.CompressionContextTakeover,
+		CompressionThreshold: ptyCompressionThreshold,
+	}
+}
+
+// ptyOfferClientNoContextTakeover adds client_no_context_takeover to the
+// permessage-deflate offers of a websocket request, which the websocket
+// library only accepts when the client offers it. Clients then compress each
+// input message on its own, so coderd doesn't keep a window of keystrokes
+// for every terminal.
+func ptyOfferClientNoContextTakeover(header http.Header) {
+	const key = "Sec-WebSocket-Extensions"
+	values := header.Values(key)
+	offers := make([]string, 0, len(values))
+	for _, value := range values {
+		extensions := strings.Split(value, ",")
+		for i, extension := range extensions {
+			params := strings.Split(extension, ";")
+			if strings.TrimSpace(params[0]) != "permessage-deflate" {
+				continue
+			}
+			offered := false
+			for _, param := range params[1:] {
+				if strings.TrimSpace(param) == "client_no_context_takeover" {
+					offered = true
+				}
+			}
+			if !offered {
+				extensions[i] = extension + "; client_no_context_takeover"
+			}
+		}
+		offers = append(offers, strings.Join(extensions, ","))
+	}
+	header.Del(key)
+	for _, offer := range offers {
+		header.Add(key, offer)
+	}
+}
+
+// workspaceAgentCandidatePair reports whether coderd's connection to the
+// agent is peer-to-peer or relayed through TURN.
+func (api *API) workspaceAgentCandidatePair(rw http.ResponseWriter, r *http.Request) {
+	workspaceAgent := httpmw.WorkspaceAgentParam(r)
+	workspace := httpmw.WorkspaceParam(r)
+	if !api.Authorize(r, rbac.ActionCreate, workspace.ExecutionRBAC()) {
+		httpapi.ResourceNotFound(rw)
+		return
+	}
+	inactiveTimeout, err := api.agentInactiveDisconnectTimeout(r.Context(), workspace)
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error fetching workspace template.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+	apiAgent, err := convertWorkspaceAgent(workspaceAgent, nil, nil, inactiveTimeout)
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error reading workspace agent.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+	if apiAgent.Status != codersdk.WorkspaceAgentConnected {
+		httpapi.Write(rw, http.StatusPreconditionRequired, codersdk.Response{
+			Message: fmt.Sprintf("Agent state is %q, it must be in the %q state.", apiAgent.Status, codersdk.WorkspaceAgentConnected),
+		})
+		return
+	}
+
+	pair, err := api.workspaceAgentSelectedCandidatePair(r, workspaceAgent.ID)
+	if err != nil {
+		httpapi.Write(rw, agentDialErrorStatus(err), codersdk.Response{
+			Message: "Failed to read the candidate pair of the workspace agent connection.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	httpapi.Write(rw, http.StatusOK, pair)
+}
+
+// workspaceAgentSelectedCandidatePair dials the agent if coderd isn't
+// connected to it already, and returns the ICE candidate pair the
+// connection uses.
+func (api *API) workspaceAgentSelectedCandidatePair(r *http.Request, agentID uuid.UUID) (codersdk.WorkspaceAgentCandidatePair, error) {
+	agentConn, release, err := api.workspaceAgentCache.Acquire(r, agentID)
+	if err != nil {
+		return codersdk.WorkspaceAgentCandidatePair{}, xerrors.Errorf("dial workspace agent: %w", err)
+	}
+	defer release()
+	// A pair is only selected once traffic has flowed.
+	_, err = agentConn.Ping()
+	if err != nil {
+		return codersdk.WorkspaceAgentCandidatePair{}, xerrors.Errorf("ping workspace agent: %w", err)
+	}
+	pair, err := agentConn.SelectedCandidatePair()
+	if err != nil {
+		return codersdk.WorkspaceAgentCandidatePair{}, xerrors.Errorf("read selected candidate pair: %w", err)
+	}
+	return codersdk.WorkspaceAgentCandidatePair{
+		Local:  convertICECandidate(pair.Local),
+		Remote: convertICECandidate(pair.Remote),
+	}, nil
+}
+
+func (api *API) workspaceAgentDiagnosticsBundle(rw http.ResponseWriter, r *http.Request) {
+	workspaceAgent := httpmw.WorkspaceAgentParam(r)
+	workspace := httpmw.WorkspaceParam(r)
+	if !api.Authorize(r, rbac.ActionRead, workspace) {
+		httpapi.ResourceNotFound(rw)
+		return
+	}
+	dbPhases, err := api.Database.GetWorkspaceAgentStartupPhasesByAgentIDs(r.Context(), []uuid.UUID{workspaceAgent.ID})
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error fetching workspace agent startup phases.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+	inactiveTimeout, err := api.agentInactiveDisconnectTimeout(r.Context(), workspace)
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error fetching workspace template.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+	apiAgent, err := convertWorkspaceAgent(workspaceAgent, nil, convertStartupPhases(dbPhases, workspaceAgent.ID), inactiveTimeout)
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error reading workspace agent.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	bundle := codersdk.WorkspaceAgentDiagnosticsBundle{
+		AgentID:     workspaceAgent.ID,
+		CollectedAt: database.Now(),
+		Connection: codersdk.WorkspaceAgentConnectionHistory{
+			Status:           apiAgent.Status,
+			FirstConnectedAt: apiAgent.FirstConnectedAt,
+			LastConnectedAt:  apiAgent.LastConnectedAt,
+			DisconnectedAt:   apiAgent.DisconnectedAt,
+			StartupPhases:    apiAgent.StartupPhases,
+		},
+		Network:     apiAgent.Network,
+		Diagnostics: apiAgent.Diagnostics,
+		Errors:      map[string]string{},
+	}
+	if bundle.Network == nil {
+		bundle.Errors["network"] = "The agent hasn't reported its network."
+	}
+	if bundle.Diagnostics == nil {
+		bundle.Errors["diagnostics"] = "The agent hasn't reported its diagnostics."
+	}
+
+	switch {
+	case !api.Authorize(r, rbac.ActionCreate, workspace.ExecutionRBAC()):
+		bundle.Errors["candidate_pair"] = "You don't have permission to connect to the workspace."
+	case apiAgent.Status != codersdk.WorkspaceAgentConnected:
+		bundle.Errors["candidate_pair"] = fmt.Sprintf("Agent state is %q, it must be in the %q state.", apiAgent.Status, codersdk.WorkspaceAgentConnected)
+	default:
+		pair, err := api.workspaceAgentSelectedCandidatePair(r, workspaceAgent.ID)
+		if err != nil {
+			bundle.Errors["candidate_pair"] = err.Error()
+		} else {
+			bundle.CandidatePair = &pair
+		}
+	}
+
+	// The probes reach out to every region, so they're limited the same
+	// as the DERP latency endpoint.
+	if api.Authorize(r, rbac.ActionRead, rbac.ResourceWildcard) {
+		bundle.DERPLatency = probeDERPRegions(r.Context(), api.DERPMap, derpProbeTimeout)
+	} else {
+		bundle.Errors["derp_latency"] = "You don't have permission to probe DERP regions."
+	}
+
+	httpapi.Write(rw, http.StatusOK, bundle)
+}
+
+// dialConnectedAgent acquires a connection to the agent of the request for
+// a call that agents may not answer. The returned context is bounded by
+// AgentDialTimeout, and release must be called when done. If the agent isn't
+// connected or can't be dialed, a response is written and false returned.
+func (api *API) dialConnectedAgent(rw http.ResponseWriter, r *http.Request) (context.Context, *wsconncache.Conn, func(), bool) {
+	workspaceAgent := httpmw.WorkspaceAgentParam(r)
+	workspace := httpmw.WorkspaceParam(r)
+	inactiveTimeout, err := api.agentInactiveDisconnectTimeout(r.Context(), workspace)
+	if err != nil {
+		httpapi.Write(rw, agentDatabaseErrorStatus(err, http.StatusInternalServerError), codersdk.Response{
+			Message: "Internal error fetching workspace template.",
+			Detail:  err.Error(),
+		})
+		return nil, nil, nil, false
+	}
+	apiAgent, err := convertWorkspaceAgent(workspaceAgent, nil, nil, inactiveTimeout)
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error reading workspace agent.",
+			Detail:  err.Error(),
+		})
+		return nil, nil, nil, false
+	}
+	if apiAgent.Status != codersdk.WorkspaceAgentConnected {
+		httpapi.Write(rw, http.StatusPreconditionRequired, codersdk.Response{
+			Message: fmt.Sprintf("Agent state is %q, it must be in the %q state.", apiAgent.Status, codersdk.WorkspaceAgentConnected),
+		})
+		return nil, nil, nil, false
+	}
+
+	agentConn, releaseConn, err := api.workspaceAgentCache.Acquire(r, workspaceAgent.ID)
+	if err != nil {
+		httpapi.Write(rw, agentDialErrorStatus(err), codersdk.Response{
+			Message: "Failed to dial workspace agent.",
+			Detail:  err.Error(),
+		})
+		return nil, nil, nil, false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), api.AgentDialTimeout)
+	return ctx, agentConn, func() {
+		cancel()
+		releaseConn()
+	}, true
+}
+
+func (api *API) workspaceAgentCapabilities(rw http.ResponseWriter, r *http.Request) {
+	workspace := httpmw.WorkspaceParam(r)
+	if !api.Authorize(r, rbac.ActionCreate, workspace.ExecutionRBAC()) {
+		httpapi.ResourceNotFound(rw)
+		return
+	}
+	// Agents that predate capabilities never respond.
+	ctx, agentConn, release, ok := api.dialConnectedAgent(rw, r)
+	if !ok {
+		return
+	}
+	defer release()
+	capabilities, err := agentConn.Capabilities(ctx)
+	if err != nil {
+		httpapi.Write(rw, http.StatusBadGateway, codersdk.Response{
+			Message: "Failed to fetch workspace agent capabilities.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	httpapi.Write(rw, http.StatusOK, codersdk.WorkspaceAgentCapabilities{
+		Version:    capabilities.Version,
+		Protocols:  capabilities.Protocols,
+		Operations: capabilities.Operations,
+	})
+}
+
+// workspaceAgentPTYProcesses returns the process tree of a reconnecting PTY
+// session, for troubleshooting terminals.
+func (api *API) workspaceAgentPTYProcesses(rw http.ResponseWriter, r *http.Request) {
+	workspace := httpmw.WorkspaceParam(r)
+	if !api.Authorize(r, rbac.ActionCreate, workspace.ExecutionRBAC()) {
+		httpapi.ResourceNotFound(rw)
+		return
+	}
+	reconnect, err := uuid.Parse(chi.URLParam(r, "reconnect"))
+	if err != nil {
+		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+			Message: "Reconnect ID must be a valid UUID.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+	// Agents that predate process trees never respond.
+	ctx, agentConn, release, ok := api.dialConnectedAgent(rw, r)
+	if !ok {
+		return
+	}
+	defer release()
+	processes, err := agentConn.ProcessTree(ctx, reconnect.String())
+	if xerrors.Is(err, agent.ErrReconnectingPTYNotFound) {
+		httpapi.Write(rw, http.StatusNotFound, codersdk.Response{
+			Message: "The workspace agent has no terminal session with that reconnect ID.",
+		})
+		return
+	}
+	if err != nil {
+		httpapi.Write(rw, http.StatusBadGateway, codersdk.Response{
+			Message: "Failed to fetch the processes of the terminal session.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	apiProcesses := make([]codersdk.WorkspaceAgentProcess, 0, len(processes))
+	for _, process := range processes {
+		apiProcesses = append(apiProcesses, codersdk.WorkspaceAgentProcess{
+			PID:     process.PID,
+			PPID:    process.PPID,
+			State:   process.State,
+			Command: process.Command,
+		})
+	}
+	httpapi.Write(rw, http.StatusOK, apiProcesses)
+}
+
+// workspaceAgentListeningPorts returns the TCP ports listened on in the
+// workspace, so the dashboard can offer to forward them.
+func (api *API) workspaceAgentListeningPorts(rw http.ResponseWriter, r *http.Request) {
+	workspace := httpmw.WorkspaceParam(r)
+	if !api.Authorize(r, rbac.ActionCreate, workspace.ExecutionRBAC()) {
+		httpapi.ResourceNotFound(rw)
+		return
+	}
+	var (
+		includeAll bool
+		err        error
+	)
+	if raw := r.URL.Query().Get("include_all"); raw != "" {
+		includeAll, err = strconv.ParseBool(raw)
+		if err != nil {
+			httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+				Message: "Query param 'include_all' must be a boolean.",
+				Validations: []codersdk.ValidationError{
+					{Field: "include_all", Detail: "invalid boolean"},
+				},
+			})
+			return
+		}
+	}
+	// Agents that predate listening ports never respond.
+	ctx, agentConn, release, ok := api.dialConnectedAgent(rw, r)
+	if !ok {
+		return
+	}
+	defer release()
+	ports, err := agentConn.ListeningPorts(ctx, includeAll)
+	if err != nil {
+		httpapi.Write(rw, http.StatusBadGateway, codersdk.Response{
+			Message: "Failed to fetch the listening ports of the workspace agent.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	apiPorts := make([]codersdk.WorkspaceAgentListeningPort, 0, len(ports))
+	for _, port := range ports {
+		apiPorts = append(apiPorts, codersdk.WorkspaceAgentListeningPort{
+			Port:        port.Port,
+			ProcessName: port.ProcessName,
+			PID:         port.PID,
+		})
+	}
+	httpapi.Write(rw, http.StatusOK, apiPorts)
+}
+
+func convertICECandidate(candidate *webrtc.ICECandidate) codersdk.WorkspaceAgentCandidate {
+	if candidate == nil {
+		return codersdk.WorkspaceAgentCandidate{}
+	}
+	return codersdk.WorkspaceAgentCandidate{
+		Type:     candidate.Typ.String(),
+		Protocol: candidate.Protocol.String(),
+		Address:  candidate.Address,
+		Port:     candidate.Port,
+	}
+}
+
+type WorkspaceKeysRequest struct {
+	Public key.NodePublic  `json:"public"`
+	Disco  key.DiscoPublic `json:"disco"`
+}
+
+func (api *API) postWorkspaceAgentKeys(rw http.ResponseWriter, r *http.Request) {
+	var (
+		ctx            = r.Context()
+		workspaceAgent = httpmw.WorkspaceAgent(r)
+		keys           WorkspaceKeysRequest
+	)
+	if !httpapi.Read(rw, r, &keys) {
+		return
+	}
+
+	err := api.Database.UpdateWorkspaceAgentKeysByID(ctx, database.UpdateWorkspaceAgentKeysByIDParams{
+		ID:                      workspaceAgent.ID,
+		WireguardNodePublicKey:  dbtypes.NodePublic(keys.Public),
+		WireguardDiscoPublicKey: dbtypes.DiscoPublic(keys.Disco),
+		UpdatedAt:               database.Now(),
+	})
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error setting agent keys.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) postWorkspaceAgentStartupPhase(rw http.ResponseWriter, r *http.Request) {
+	var (
+		ctx            = r.Context()
+		workspaceAgent = httpmw.WorkspaceAgent(r)
+		req            codersdk.PostWorkspaceAgentStartupPhaseRequest
+	)
+	if !httpapi.Read(rw, r, &req) {
+		return
+	}
+	switch req.Phase {
+	case agent.StartupPhaseStartupScript, agent.StartupPhaseReady:
+	default:
+		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+			Message: fmt.Sprintf("Unknown startup phase %q.", req.Phase),
+			Validations: []codersdk.ValidationError{
+				{Field: "phase", Detail: "unknown phase"},
+			},
+		})
+		return
+	}
+
+	err := api.Database.InTx(func(db database.Store) error {
+		// The agent restarted, so the previous timeline is replaced.
+		if req.Phase == agent.StartupPhaseStartupScript {
+			err := db.DeleteWorkspaceAgentStartupPhasesByAgentID(ctx, workspaceAgent.ID)
+			if err != nil {
+				return xerrors.Errorf("delete startup phases: %w", err)
+			}
+		}
+		_, err := db.InsertWorkspaceAgentStartupPhase(ctx, database.InsertWorkspaceAgentStartupPhaseParams{
+			ID:        uuid.New(),
+			AgentID:   workspaceAgent.ID,
+			Phase:     string(req.Phase),
+			CreatedAt: database.Now(),
+		})
+		if err != nil {
+			return xerrors.Errorf("insert startup phase: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error inserting startup phase.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) postWorkspaceAgentDirectory(rw http.ResponseWriter, r *http.Request) {
+	var (
+		workspaceAgent = httpmw.WorkspaceAgent(r)
+		req            codersdk.PostWorkspaceAgentDirectoryRequest
+	)
+	if !httpapi.Read(rw, r, &req) {
+		return
+	}
+
+	err := api.Database.UpdateWorkspaceAgentDirectoryExistsByID(r.Context(), database.UpdateWorkspaceAgentDirectoryExistsByIDParams{
+		ID: workspaceAgent.ID,
+		DirectoryExists: sql.NullBool{
+			Bool:  req.Exists,
+			Valid: true,
+		},
+		UpdatedAt: database.Now(),
+	})
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error updating workspace agent directory.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) postWorkspaceAgentNetwork(rw http.ResponseWriter, r *http.Request) {
+	var (
+		workspaceAgent = httpmw.WorkspaceAgent(r)
+		req            codersdk.PostWorkspaceAgentNetworkRequest
+	)
+	if !httpapi.Read(rw, r, &req) {
+		return
+	}
+	switch req.NATType {
+	case agent.NATTypeUnknown, agent.NATTypeNone, agent.NATTypeEndpointIndependent, agent.NATTypeEndpointDependent:
+	default:
+		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+			Message: fmt.Sprintf("Unknown NAT type %q.", req.NATType),
+			Validations: []codersdk.ValidationError{
+				{Field: "nat_type", Detail: "unknown NAT type"},
+			},
+		})
+		return
+	}
+	// The validator already ensures this parses.
+	publicIP := net.ParseIP(req.PublicIP)
+	bits := 128
+	if publicIP.To4() != nil {
+		publicIP = publicIP.To4()
+		bits = 32
+	}
+
+	err := api.Database.UpdateWorkspaceAgentNetworkReportByID(r.Context(), database.UpdateWorkspaceAgentNetworkReportByIDParams{
+		ID: workspaceAgent.ID,
+		PublicIP: pqtype.Inet{
+			IPNet: net.IPNet{
+				IP:   publicIP,
+				Mask: net.CIDRMask(bits, bits),
+			},
+			Valid: true,
+		},
+		NATType: sql.NullString{
+			String: string(req.NATType),
+			Valid:  true,
+		},
+		NetworkReportedAt: sql.NullTime{
+			Time:  database.Now(),
+			Valid: true,
+		},
+	})
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error updating workspace agent network.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) putWorkspaceAgentMaintenance(rw http.ResponseWriter, r *http.Request) {
+	var (
+		workspaceAgent = httpmw.WorkspaceAgentParam(r)
+		workspace      = httpmw.WorkspaceParam(r)
+		req            codersdk.UpdateWorkspaceAgentMaintenanceRequest
+	)
+	if !api.Authorize(r, rbac.ActionUpdate, workspace) {
+		httpapi.ResourceNotFound(rw)
+		return
+	}
+	if !httpapi.Read(rw, r, &req) {
+		return
+	}
+
+	err := api.Database.UpdateWorkspaceAgentMaintenanceByID(r.Context(), database.UpdateWorkspaceAgentMaintenanceByIDParams{
+		ID:          workspaceAgent.ID,
+		Maintenance: req.Maintenance,
+		UpdatedAt:   database.Now(),
+	})
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error updating workspace agent maintenance.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) postWorkspaceAgentDiagnostics(rw http.ResponseWriter, r *http.Request) {
+	var (
+		workspaceAgent = httpmw.WorkspaceAgent(r)
+		req            codersdk.PostWorkspaceAgentDiagnosticsRequest
+	)
+	if !httpapi.Read(rw, r, &req) {
+		return
+	}
+	switch req.ClockSync {
+	case agent.ClockSyncSynced, agent.ClockSyncUnsynced, agent.ClockSyncUnknown:
+	default:
+		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+			Message: fmt.Sprintf("Unknown clock sync status %q.", req.ClockSync),
+			Validations: []codersdk.ValidationError{
+				{Field: "clock_sync", Detail: "unknown clock sync status"},
+			},
+		})
+		return
+	}
+
+	if req.Interfaces == nil {
+		req.Interfaces = []codersdk.WorkspaceAgentNetworkInterface{}
+	}
+	interfaces, err := json.Marshal(req.Interfaces)
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error encoding network interfaces.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	// The start time is derived on coderd's clock, since the agent's clock
+	// may be the one that's wrong.
+	now := database.Now()
+	err = api.Database.UpdateWorkspaceAgentDiagnosticsByID(r.Context(), database.UpdateWorkspaceAgentDiagnosticsByIDParams{
+		ID: workspaceAgent.ID,
+		ProcessStartedAt: sql.NullTime{
+			Time:  now.Add(-time.Duration(req.UptimeSeconds) * time.Second),
+			Valid: true,
+		},
+		ClockSync: sql.NullString{
+			String: string(req.ClockSync),
+			Valid:  true,
+		},
+		DiagnosticsReportedAt: sql.NullTime{
+			Time:  now,
+			Valid: true,
+		},
+		NetworkInterfaces: pqtype.NullRawMessage{
+			RawMessage: interfaces,
+			Valid:      true,
+		},
+	})
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error updating workspace agent diagnostics.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) postWorkspaceAgentWireguardPeer(rw http.ResponseWriter, r *http.Request) {
+	var (
+		req            peerwg.Handshake
+		workspaceAgent = httpmw.WorkspaceAgentParam(r)
+		workspace      = httpmw.WorkspaceParam(r)
+	)
+
+	if !api.Authorize(r, rbac.ActionCreate, workspace.ExecutionRBAC()) {
+		httpapi.ResourceNotFound(rw)
+		return
+	}
+
+	if !httpapi.Read(rw, r, &req) {
+		return
+	}
+
+	if req.Recipient != workspaceAgent.ID {
+		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+			Message: "Invalid recipient.",
+		})
+		return
+	}
+
+	raw, err := req.MarshalText()
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error marshaling wireguard peer message.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	err = api.Pubsub.Publish("wireguard_peers", raw)
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error publishing wireguard peer message.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) workspaceAgentWireguardListener(rw http.ResponseWriter, r *http.Request) {
+	api.websocketWaitMutex.Lock()
+	api.websocketWaitGroup.Add(1)
 	api.websocketWaitMutex.Unlock()
 	defer api.websocketWaitGroup.Done()
 
-	ctx := r.Context()
+	// Canceling the context stops the reader below, which ends the
+	// subscription and closes the connection.
+	ctx, cancelFunc := context.WithCancel(r.Context())
+	defer cancelFunc()
 	workspaceAgent := httpmw.WorkspaceAgent(r)
 
-	conn, err := websocket.Accept(rw, r, nil)
+	conn, err := acceptWebsocket(rw, r, nil, api.WebsocketAcceptTimeout)
 	if err != nil {
 		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
 			Message: "Failed to accept websocket.",
@@ -557,7 +1826,12 @@ func (api *API) workspaceAgentWireguardListener(rw http.ResponseWriter, r *http.
 	defer conn.Close(websocket.StatusNormalClosure, "")
 
 	agentIDBytes, _ := workspaceAgent.ID.MarshalText()
-	subCancel, err := api.Pubsub.Subscribe("wireguard_peers", func(ctx context.Context, message []byte) {
+	subCancel, err := api.Pubsub.Subscribe("wireguard_peers", func(_ context.Context, message []byte) {
+		// The connection is gone, so messages can't be delivered.
+		if ctx.Err() != nil {
+			return
+		}
+
 		// Since we subscribe to all peer broadcasts, we do a light check to
 		// make sure we're the intended recipient without fully decoding the
 		// message.
@@ -572,7 +1846,11 @@ func (api *API) workspaceAgentWireguardListener(rw http.ResponseWriter, r *http.
 			return
 		}
 
-		_ = conn.Write(ctx, websocket.MessageBinary, message)
+		err = conn.Write(ctx, websocket.MessageBinary, message)
+		if err != nil {
+			api.Logger.Debug(ctx, "write wireguard peer message", slog.Error(err))
+			cancelFunc()
+		}
 	})
 	if err != nil {
 		api.Logger.Error(ctx, "pubsub listen", slog.Error(err))
@@ -588,10 +1866,65 @@ func (api *API) workspaceAgentWireguardListener(rw http.ResponseWriter, r *http.
 	_, _, _ = conn.Reader(ctx)
 }
 
+var (
+	// ErrAgentNegotiate occurs when the connection couldn't be negotiated
+	// with the agent. The agent may be offline.
+	ErrAgentNegotiate = xerrors.New("negotiate with agent")
+	// ErrAgentRelayUnavailable occurs when the TURN relay couldn't be used
+	// and a direct connection wasn't established.
+	ErrAgentRelayUnavailable = xerrors.New("relay unavailable")
+	// ErrAgentICETimeout occurs when ICE didn't find a working candidate
+	// pair in time. Retrying may succeed.
+	ErrAgentICETimeout = xerrors.New("ice timeout")
+)
+
+// agentDialErrorStatus returns the response status for an error dialing a
+// workspace agent, so clients can tell an offline agent and a missing relay
+// from a timeout that's worth retrying.
+func agentDialErrorStatus(err error) int {
+	switch {
+	case xerrors.Is(err, ErrAgentNegotiate):
+		return http.StatusNotFound
+	case xerrors.Is(err, ErrAgentRelayUnavailable):
+		return http.StatusConflict
+	case xerrors.Is(err, ErrAgentICETimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// agentDialError pairs one of the ErrAgent* failure modes with the error
+// that caused it, so both can be inspected with errors.Is.
+type agentDialError struct {
+	mode error
+	err  error
+}
+
+func (e *agentDialError) Error() string {
+	return fmt.Sprintf("%s: %s", e.mode, e.err)
+}
+
+func (e *agentDialError) Is(target error) bool {
+	return target == e.mode
+}
+
+func (e *agentDialError) Unwrap() error {
+	return e.err
+}
+
 // dialWorkspaceAgent connects to a workspace agent by ID. Only rely on
 // r.Context() for cancellation if it's use is safe or r.Hijack() has
 // not been performed.
-func (api *API) dialWorkspaceAgent(r *http.Request, agentID uuid.UUID) (*agent.Conn, error) {
+//
+// Errors returned match ErrAgentNegotiate, ErrAgentRelayUnavailable or
+// ErrAgentICETimeout with errors.Is when the failure mode is known.
+func (api *API) dialWorkspaceAgent(r *http.Request, agentID uuid.UUID) (_ *agent.Conn, err error) {
+	start := time.Now()
+	defer func() {
+		api.observeAgentConnection(r.Context(), agentConnectionCoderd, agentID, start, err)
+	}()
+
 	client, server := provisionersdk.TransportPipe()
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	go func() {
@@ -608,16 +1941,32 @@ func (api *API) dialWorkspaceAgent(r *http.Request, agentID uuid.UUID) (*agent.C
 	stream, err := peerClient.NegotiateConnection(ctx)
 	if err != nil {
 		cancelFunc()
-		return nil, xerrors.Errorf("negotiate: %w", err)
+		return nil, &agentDialError{mode: ErrAgentNegotiate, err: err}
 	}
 	options := &peer.ConnOptions{
 		Logger: api.Logger.Named("agent-dialer"),
 	}
 	options.SettingEngine.SetSrflxAcceptanceMinWait(0)
 	options.SettingEngine.SetRelayAcceptanceMinWait(0)
+	// The relay may fail while the connection is still able to
+	// establish directly, so the error is only reported if it doesn't.
+	var (
+		relayErrMutex sync.Mutex
+		relayErr      error
+	)
 	// Use the ProxyDialer for the TURN server.
 	// This is required for connections where P2P is not enabled.
 	options.SettingEngine.SetICEProxyDialer(turnconn.ProxyDialer(func() (c net.Conn, err error) {
+		defer func() {
+			if err != nil {
+				relayErrMutex.Lock()
+				relayErr = err
+				relayErrMutex.Unlock()
+			}
+		}()
+		if api.TURNServer == nil {
+			return nil, xerrors.New("no turn server is configured")
+		}
 		clientPipe, serverPipe := net.Pipe()
 		go func() {
 			<-ctx.Done()
@@ -641,7 +1990,7 @@ func (api *API) dialWorkspaceAgent(r *http.Request, agentID uuid.UUID) (*agent.C
 		api.TURNServer.Accept(clientPipe, remoteAddress, localAddress)
 		return serverPipe, nil
 	}))
-	peerConn, err := peerbroker.Dial(stream, append(api.ICEServers, turnconn.Proxy), options)
+	peerConn, err := peerbroker.Dial(stream, append(api.iceServers(), turnconn.Proxy), options)
 	if err != nil {
 		cancelFunc()
 		return nil, xerrors.Errorf("dial: %w", err)
@@ -650,6 +1999,44 @@ func (api *API) dialWorkspaceAgent(r *http.Request, agentID uuid.UUID) (*agent.C
 		<-peerConn.Closed()
 		cancelFunc()
 	}()
+
+	// Negotiation happens asynchronously, so wait for the connection
+	// to be usable to report why it failed.
+	pinged := make(chan error, 1)
+	go func() {
+		_, err := peerConn.Ping()
+		pinged <- err
+	}()
+	timer := time.NewTimer(api.AgentDialTimeout)
+	defer timer.Stop()
+	var mode error
+	select {
+	case err = <-pinged:
+		if err != nil {
+			// A failed connection means ICE gave up, otherwise the
+			// negotiation stream ended the connection.
+			mode = ErrAgentNegotiate
+			if xerrors.Is(err, peer.ErrFailed) {
+				mode = ErrAgentICETimeout
+			}
+		}
+	case <-timer.C:
+		err = xerrors.Errorf("connection not established after %s", api.AgentDialTimeout)
+		mode = ErrAgentICETimeout
+	}
+	if mode != nil {
+		if mode == ErrAgentICETimeout {
+			relayErrMutex.Lock()
+			if relayErr != nil {
+				mode = ErrAgentRelayUnavailable
+				err = relayErr
+			}
+			relayErrMutex.Unlock()
+		}
+		_ = peerConn.CloseWithError(err)
+		return nil, &agentDialError{mode: mode, err: err}
+	}
+
 	return &agent.Conn{
 		Negotiator: peerClient,
 		Conn:       peerConn,
@@ -659,16 +2046,63 @@ func (api *API) dialWorkspaceAgent(r *http.Request, agentID uuid.UUID) (*agent.C
 func convertApps(dbApps []database.WorkspaceApp) []codersdk.WorkspaceApp {
 	apps := make([]codersdk.WorkspaceApp, 0)
 	for _, dbApp := range dbApps {
+		// URLs are validated when the app is inserted, so an error here
+		// means the row predates validation and routing is omitted.
+		routing, port, _ := workspaceAppRouting(dbApp.Url.String, dbApp.RelativePath)
 		apps = append(apps, codersdk.WorkspaceApp{
 			ID:      dbApp.ID,
 			Name:    dbApp.Name,
 			Command: dbApp.Command.String,
 			Icon:    dbApp.Icon,
+			URL:     dbApp.Url.String,
+			Port:    port,
+			Routing: routing,
 		})
 	}
 	return apps
 }
 
+// workspaceAppRouting determines how an app is proxied to from its internal
+// URL. Apps with a relative path are served beneath a path, apps that own
+// the root of an explicit port are forwarded by port, and everything else
+// gets its own subdomain.
+func workspaceAppRouting(rawURL string, relativePath bool) (codersdk.WorkspaceAppRouting, uint16, error) {
+	if rawURL == "" {
+		return "", 0, nil
+	}
+	appURL, err := url.Parse(rawURL)
+	if err != nil {
+		return "", 0, xerrors.Errorf("parse url %q: %w", rawURL, err)
+	}
+	var port uint16
+	switch appURL.Scheme {
+	case "http":
+		port = 80
+	case "https":
+		port = 443
+	default:
+		return "", 0, xerrors.Errorf("url %q must use http or https", rawURL)
+	}
+	if appURL.Hostname() == "" {
+		return "", 0, xerrors.Errorf("url %q must have a host", rawURL)
+	}
+	if appURL.Port() != "" {
+		parsed, err := strconv.ParseUint(appURL.Port(), 10, 16)
+		if err != nil || parsed == 0 {
+			return "", 0, xerrors.Errorf("url %q has an invalid port", rawURL)
+		}
+		port = uint16(parsed)
+	}
+	switch {
+	case relativePath:
+		return codersdk.WorkspaceAppRoutingPath, port, nil
+	case appURL.Port() != "" && (appURL.Path == "" || appURL.Path == "/") && appURL.RawQuery == "":
+		return codersdk.WorkspaceAppRoutingPort, port, nil
+	default:
+		return codersdk.WorkspaceAppRoutingSubdomain, port, nil
+	}
+}
+
 func inetToNetaddr(inet pqtype.Inet) netaddr.IPPrefix {
 	if !inet.Valid {
 		return netaddr.IPPrefixFrom(netaddr.IPv6Unspecified(), 128)
@@ -682,7 +2116,27 @@ func inetToNetaddr(inet pqtype.Inet) netaddr.IPPrefix {
 	return ipp
 }
 
-func convertWorkspaceAgent(dbAgent database.WorkspaceAgent, apps []codersdk.WorkspaceApp, agentInactiveDisconnectTimeout time.Duration) (codersdk.WorkspaceAgent, error) {
+// convertStartupPhases returns the startup phases of the agent provided,
+// computing how long each one lasted. Phases must be ordered by creation.
+func convertStartupPhases(dbPhases []database.WorkspaceAgentStartupPhase, agentID uuid.UUID) []codersdk.WorkspaceAgentStartupPhase {
+	phases := make([]codersdk.WorkspaceAgentStartupPhase, 0)
+	for _, dbPhase := range dbPhases {
+		if dbPhase.AgentID != agentID {
+			continue
+		}
+		if len(phases) > 0 {
+			previous := &phases[len(phases)-1]
+			previous.DurationMillis = dbPhase.CreatedAt.Sub(previous.StartedAt).Milliseconds()
+		}
+		phases = append(phases, codersdk.WorkspaceAgentStartupPhase{
+			Phase:     dbPhase.Phase,
+			StartedAt: dbPhase.CreatedAt,
+		})
+	}
+	return phases
+}
+
+func convertWorkspaceAgent(dbAgent database.WorkspaceAgent, apps []codersdk.WorkspaceApp, phases []codersdk.WorkspaceAgentStartupPhase, agentInactiveDisconnectTimeout time.Duration) (codersdk.WorkspaceAgent, error) {
 	var envs map[string]string
 	if dbAgent.EnvironmentVariables.Valid {
 		err := json.Unmarshal(dbAgent.EnvironmentVariables.RawMessage, &envs)
@@ -704,6 +2158,7 @@ func convertWorkspaceAgent(dbAgent database.WorkspaceAgent, apps []codersdk.Work
 		EnvironmentVariables: envs,
 		Directory:            dbAgent.Directory,
 		Apps:                 apps,
+		StartupPhases:        phases,
 		IPv6:                 inetToNetaddr(dbAgent.WireguardNodeIPv6),
 		WireguardPublicKey:   key.NodePublic(dbAgent.WireguardNodePublicKey),
 		DiscoPublicKey:       key.DiscoPublic(dbAgent.WireguardDiscoPublicKey),
@@ -712,6 +2167,30 @@ func convertWorkspaceAgent(dbAgent database.WorkspaceAgent, apps []codersdk.Work
 	if dbAgent.FirstConnectedAt.Valid {
 		workspaceAgent.FirstConnectedAt = &dbAgent.FirstConnectedAt.Time
 	}
+	if dbAgent.DirectoryExists.Valid {
+		workspaceAgent.DirectoryExists = &dbAgent.DirectoryExists.Bool
+	}
+	if dbAgent.NetworkReportedAt.Valid {
+		workspaceAgent.Network = &codersdk.WorkspaceAgentNetwork{
+			PublicIP:   dbAgent.PublicIP.IPNet.IP.String(),
+			NATType:    dbAgent.NATType.String,
+			ReportedAt: dbAgent.NetworkReportedAt.Time,
+		}
+	}
+	if dbAgent.DiagnosticsReportedAt.Valid {
+		workspaceAgent.Diagnostics = &codersdk.WorkspaceAgentDiagnostics{
+			ProcessStartedAt: dbAgent.ProcessStartedAt.Time,
+			ClockSync:        dbAgent.ClockSync.String,
+			Interfaces:       []codersdk.WorkspaceAgentNetworkInterface{},
+			ReportedAt:       dbAgent.DiagnosticsReportedAt.Time,
+		}
+		if dbAgent.NetworkInterfaces.Valid {
+			err := json.Unmarshal(dbAgent.NetworkInterfaces.RawMessage, &workspaceAgent.Diagnostics.Interfaces)
+			if err != nil {
+				return codersdk.WorkspaceAgent{}, xerrors.Errorf("unmarshal network interfaces: %w", err)
+			}
+		}
+	}
 	if dbAgent.LastConnectedAt.Valid {
 		workspaceAgent.LastConnectedAt = &dbAgent.LastConnectedAt.Time
 	}
@@ -735,6 +2214,9 @@ func convertWorkspaceAgent(dbAgent database.WorkspaceAgent, apps []codersdk.Work
 		// and last connected at has been properly set.
 		workspaceAgent.Status = codersdk.WorkspaceAgentConnected
 	}
+	if workspaceAgent.Status == codersdk.WorkspaceAgentDisconnected && dbAgent.Maintenance {
+		workspaceAgent.Status = codersdk.WorkspaceAgentMaintenance
+	}
 
 	return workspaceAgent, nil
 }
@@ -767,6 +2249,78 @@ func (c *wsNetConn) Close() error {
 	return c.Conn.Close()
 }
 
+// acceptWebsocket is websocket.Accept, closing the connection if the
+// handshake doesn't complete within the timeout. Otherwise a client that
+// stops reading during the upgrade ties up the handler.
+func acceptWebsocket(rw http.ResponseWriter, r *http.Request, opts *websocket.AcceptOptions, timeout time.Duration) (*websocket.Conn, error) {
+	writer := &acceptTimeoutWriter{
+		ResponseWriter: rw,
+		deadline:       time.Now().Add(timeout),
+	}
+	conn, err := websocket.Accept(writer, r, opts)
+	if err != nil {
+		return nil, err
+	}
+	if writer.conn != nil {
+		_ = writer.conn.SetWriteDeadline(time.Time{})
+	}
+	return conn, nil
+}
+
+// acceptTimeoutWriter holds back the switching protocols response until the
+// connection is hijacked, so it can be written with a deadline.
+type acceptTimeoutWriter struct {
+	http.ResponseWriter
+	deadline time.Time
+
+	switchingProtocols bool
+	conn               net.Conn
+}
+
+func (w *acceptTimeoutWriter) WriteHeader(statusCode int) {
+	if statusCode == http.StatusSwitchingProtocols {
+		w.switchingProtocols = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *acceptTimeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, xerrors.New("response writer does not implement http.Hijacker")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.conn = conn
+	if !w.switchingProtocols {
+		return conn, brw, nil
+	}
+
+	_ = conn.SetWriteDeadline(w.deadline)
+	_, err = fmt.Fprintf(brw, "HTTP/1.1 %d %s\r\n", http.StatusSwitchingProtocols, http.StatusText(http.StatusSwitchingProtocols))
+	if err == nil {
+		err = w.Header().Write(brw)
+	}
+	if err == nil {
+		_, err = brw.WriteString("\r\n")
+	}
+	if err == nil {
+		err = brw.Flush()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, xerrors.Errorf("write handshake response: %w", err)
+	}
+	// The status is otherwise recorded for logs and metrics by WriteHeader.
+	if statusWriter, ok := w.ResponseWriter.(*httpapi.StatusWriter); ok {
+		statusWriter.Status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, nil
+}
+
 // websocketNetConn wraps websocket.NetConn and returns a context that
 // is tied to the parent context and the lifetime of the conn. Any error
 // during read or write will cancel the context, but not close the
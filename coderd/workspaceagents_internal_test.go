@@ -0,0 +1,637 @@
+package coderd
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"golang.org/x/xerrors"
+	"nhooyr.io/websocket"
+
+	"cdr.dev/slog"
+	"cdr.dev/slog/sloggers/slogtest"
+	"github.com/coder/coder/coderd/database"
+	"github.com/coder/coder/coderd/database/databasefake"
+	"github.com/coder/coder/coderd/httpmw"
+	"github.com/coder/coder/coderd/turnconn"
+	"github.com/coder/coder/codersdk"
+	"github.com/coder/coder/peer/peerwg"
+	"github.com/coder/coder/testutil"
+)
+
+func TestDialWorkspaceAgentErrors(t *testing.T) {
+	t.Parallel()
+	t.Run("Negotiate", func(t *testing.T) {
+		t.Parallel()
+		api := &API{Options: &Options{
+			Logger:           slogtest.Make(t, nil).Leveled(slog.LevelDebug),
+			Pubsub:           &failingPubsub{Pubsub: database.NewPubsubInMemory()},
+			AgentDialTimeout: time.Minute,
+		}, agentConnectionSetup: newAgentConnectionSetupHistogram(prometheus.NewRegistry())}
+		_, err := api.dialWorkspaceAgent(httptest.NewRequest("GET", "/", nil), uuid.New())
+		require.ErrorIs(t, err, ErrAgentNegotiate)
+	})
+	t.Run("RelayUnavailable", func(t *testing.T) {
+		t.Parallel()
+		api := &API{Options: &Options{
+			Logger:           slogtest.Make(t, nil).Leveled(slog.LevelDebug),
+			Pubsub:           database.NewPubsubInMemory(),
+			AgentDialTimeout: 2 * time.Second,
+		}, agentConnectionSetup: newAgentConnectionSetupHistogram(prometheus.NewRegistry())}
+		_, err := api.dialWorkspaceAgent(httptest.NewRequest("GET", "/", nil), uuid.New())
+		require.ErrorIs(t, err, ErrAgentRelayUnavailable)
+	})
+	t.Run("ICETimeout", func(t *testing.T) {
+		t.Parallel()
+		turnServer, err := turnconn.New(nil)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = turnServer.Close()
+		})
+		api := &API{Options: &Options{
+			Logger:           slogtest.Make(t, nil).Leveled(slog.LevelDebug),
+			Pubsub:           database.NewPubsubInMemory(),
+			TURNServer:       turnServer,
+			AgentDialTimeout: 2 * time.Second,
+		}, agentConnectionSetup: newAgentConnectionSetupHistogram(prometheus.NewRegistry())}
+		_, err = api.dialWorkspaceAgent(httptest.NewRequest("GET", "/", nil), uuid.New())
+		require.ErrorIs(t, err, ErrAgentICETimeout)
+	})
+}
+
+func TestAgentDialErrorStatus(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		err    error
+		status int
+	}{
+		{&agentDialError{mode: ErrAgentNegotiate, err: io.EOF}, http.StatusNotFound},
+		{&agentDialError{mode: ErrAgentRelayUnavailable, err: io.EOF}, http.StatusConflict},
+		{xerrors.Errorf("dial: %w", &agentDialError{mode: ErrAgentICETimeout, err: io.EOF}), http.StatusGatewayTimeout},
+		{io.EOF, http.StatusBadGateway},
+	} {
+		require.Equal(t, tc.status, agentDialErrorStatus(tc.err), tc.err.Error())
+	}
+}
+
+func TestTemplateAgentInactiveDisconnectTimeout(t *testing.T) {
+	t.Parallel()
+	const globalTimeout = time.Minute
+	now := database.Now()
+	workspaceAgent := database.WorkspaceAgent{
+		ID:               uuid.New(),
+		FirstConnectedAt: sql.NullTime{Time: now.Add(-time.Hour), Valid: true},
+		// Past the global threshold, but within the template's.
+		LastConnectedAt: sql.NullTime{Time: now.Add(-2 * globalTimeout), Valid: true},
+	}
+
+	timeout := templateAgentInactiveDisconnectTimeout(database.Template{}, globalTimeout)
+	require.Equal(t, globalTimeout, timeout)
+	apiAgent, err := convertWorkspaceAgent(workspaceAgent, nil, nil, timeout)
+	require.NoError(t, err)
+	require.Equal(t, codersdk.WorkspaceAgentDisconnected, apiAgent.Status)
+
+	timeout = templateAgentInactiveDisconnectTimeout(database.Template{
+		AgentInactiveDisconnectTimeout: int64(5 * globalTimeout),
+	}, globalTimeout)
+	require.Equal(t, 5*globalTimeout, timeout)
+	apiAgent, err = convertWorkspaceAgent(workspaceAgent, nil, nil, timeout)
+	require.NoError(t, err)
+	require.Equal(t, codersdk.WorkspaceAgentConnected, apiAgent.Status)
+}
+
+func TestConvertWorkspaceAgentMaintenance(t *testing.T) {
+	t.Parallel()
+	now := database.Now()
+	workspaceAgent := database.WorkspaceAgent{
+		ID:               uuid.New(),
+		FirstConnectedAt: sql.NullTime{Time: now.Add(-time.Hour), Valid: true},
+		LastConnectedAt:  sql.NullTime{Time: now.Add(-time.Minute), Valid: true},
+		DisconnectedAt:   sql.NullTime{Time: now, Valid: true},
+	}
+	apiAgent, err := convertWorkspaceAgent(workspaceAgent, nil, nil, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, codersdk.WorkspaceAgentDisconnected, apiAgent.Status)
+
+	workspaceAgent.Maintenance = true
+	apiAgent, err = convertWorkspaceAgent(workspaceAgent, nil, nil, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, codersdk.WorkspaceAgentMaintenance, apiAgent.Status)
+
+	// Connected agents are reported as such regardless.
+	workspaceAgent.DisconnectedAt = sql.NullTime{}
+	apiAgent, err = convertWorkspaceAgent(workspaceAgent, nil, nil, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, codersdk.WorkspaceAgentConnected, apiAgent.Status)
+}
+
+func TestWorkspaceAppRouting(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		Name          string
+		URL           string
+		RelativePath  bool
+		Routing       codersdk.WorkspaceAppRouting
+		Port          uint16
+		ExpectedError bool
+	}{{
+		Name: "Command",
+	}, {
+		Name:         "Path",
+		URL:          "http://localhost:8080/app",
+		RelativePath: true,
+		Routing:      codersdk.WorkspaceAppRoutingPath,
+		Port:         8080,
+	}, {
+		Name:    "Subdomain",
+		URL:     "https://localhost/app",
+		Routing: codersdk.WorkspaceAppRoutingSubdomain,
+		Port:    443,
+	}, {
+		Name:    "Port",
+		URL:     "http://127.0.0.1:3000",
+		Routing: codersdk.WorkspaceAppRoutingPort,
+		Port:    3000,
+	}, {
+		Name:          "InvalidScheme",
+		URL:           "ftp://localhost:21",
+		ExpectedError: true,
+	}, {
+		Name:          "InvalidPort",
+		URL:           "http://localhost:99999",
+		ExpectedError: true,
+	}, {
+		Name:          "NoHost",
+		URL:           "http:///app",
+		ExpectedError: true,
+	}, {
+		Name:          "Malformed",
+		URL:           "http://local host",
+		ExpectedError: true,
+	}}
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.Name, func(t *testing.T) {
+			t.Parallel()
+			routing, port, err := workspaceAppRouting(testCase.URL, testCase.RelativePath)
+			if testCase.ExpectedError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testCase.Routing, routing)
+			require.Equal(t, testCase.Port, port)
+		})
+	}
+}
+
+func TestPTYAcceptOptionsCompression(t *testing.T) {
+	t.Parallel()
+	// Logs and prompts are highly repetitive, which is what compression
+	// is meant to take advantage of.
+	output := bytes.Repeat([]byte("2022-08-01 12:00:00 INFO building workspace image\r\n"), 2048)
+
+	transfer := func(t *testing.T, compress bool) int64 {
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if compress {
+				ptyOfferClientNoContextTakeover(r.Header)
+			}
+			conn, err := websocket.Accept(rw, r, ptyAcceptOptions(compress))
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, wsNetConn := websocketNetConn(r.Context(), conn, websocket.MessageBinary)
+			defer wsNetConn.Close()
+			// Terminal output arrives in many small writes.
+			for remaining := output; len(remaining) > 0; {
+				chunk := remaining
+				if len(chunk) > 4096 {
+					chunk = chunk[:4096]
+				}
+				remaining = remaining[len(chunk):]
+				_, err = wsNetConn.Write(chunk)
+				if !assert.NoError(t, err) {
+					return
+				}
+			}
+		}))
+		t.Cleanup(srv.Close)
+
+		var read atomic.Int64
+		client := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+					if err != nil {
+						return nil, err
+					}
+					return &countingConn{Conn: conn, read: &read}, nil
+				},
+			},
+		}
+		conn, res, err := websocket.Dial(ctx, srv.URL, &websocket.DialOptions{
+			HTTPClient:      client,
+			CompressionMode: websocket.CompressionContextTakeover,
+		})
+		require.NoError(t, err)
+		if compress {
+			// Input is compressed without context, so coderd keeps no window
+			// of it.
+			require.Contains(t, res.Header.Get("Sec-WebSocket-Extensions"), "client_no_context_takeover")
+		}
+		data, err := io.ReadAll(websocket.NetConn(ctx, conn, websocket.MessageBinary))
+		require.NoError(t, err)
+		require.Equal(t, output, data)
+		return read.Load()
+	}
+
+	uncompressed := transfer(t, false)
+	compressed := transfer(t, true)
+	require.Greater(t, uncompressed, int64(len(output)))
+	require.Less(t, compressed*10, uncompressed)
+}
+
+func TestPTYOfferClientNoContextTakeover(t *testing.T) {
+	t.Parallel()
+	header := http.Header{}
+	header.Add("Sec-WebSocket-Extensions", "permessage-deflate; client_max_window_bits, x-webkit-deflate-frame")
+	header.Add("Sec-WebSocket-Extensions", "permessage-deflate; client_no_context_takeover")
+	ptyOfferClientNoContextTakeover(header)
+	require.Equal(t, []string{
+		"permessage-deflate; client_max_window_bits; client_no_context_takeover, x-webkit-deflate-frame",
+		"permessage-deflate; client_no_context_takeover",
+	}, header.Values("Sec-WebSocket-Extensions"))
+}
+
+// countingConn counts the bytes read from the underlying connection.
+type countingConn struct {
+	net.Conn
+	read *atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func TestAcceptWebsocketTimeout(t *testing.T) {
+	t.Parallel()
+	const timeout = 100 * time.Millisecond
+
+	t.Run("Stalled", func(t *testing.T) {
+		t.Parallel()
+		type result struct {
+			err     error
+			elapsed time.Duration
+		}
+		results := make(chan result, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			// Larger than the socket buffers, so the handshake response
+			// can't be written unless the client reads it.
+			rw.Header().Set("X-Padding", strings.Repeat("a", 8<<20))
+			start := time.Now()
+			_, err := acceptWebsocket(rw, r, nil, timeout)
+			results <- result{err: err, elapsed: time.Since(start)}
+		}))
+		t.Cleanup(srv.Close)
+
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		// The client sends the upgrade, then never reads the response.
+		_, err = io.WriteString(conn, "GET / HTTP/1.1\r\n"+
+			"Host: "+srv.Listener.Addr().String()+"\r\n"+
+			"Connection: Upgrade\r\n"+
+			"Upgrade: websocket\r\n"+
+			"Sec-WebSocket-Version: 13\r\n"+
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+		require.NoError(t, err)
+
+		select {
+		case res := <-results:
+			require.Error(t, res.err)
+			require.GreaterOrEqual(t, res.elapsed, timeout)
+		case <-time.After(testutil.WaitShort):
+			t.Fatal("handler didn't give up on the handshake")
+		}
+		// The connection is closed rather than left to the client.
+		err = conn.SetReadDeadline(time.Now().Add(testutil.WaitShort))
+		require.NoError(t, err)
+		_, err = io.Copy(io.Discard, conn)
+		require.NoError(t, err)
+	})
+
+	t.Run("Completes", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			conn, err := acceptWebsocket(rw, r, nil, timeout)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer conn.Close(websocket.StatusNormalClosure, "")
+			// The deadline doesn't outlive the handshake.
+			time.Sleep(2 * timeout)
+			assert.NoError(t, conn.Write(ctx, websocket.MessageText, []byte("hello")))
+		}))
+		t.Cleanup(srv.Close)
+
+		conn, res, err := websocket.Dial(ctx, srv.URL, nil)
+		require.NoError(t, err)
+		defer conn.Close(websocket.StatusNormalClosure, "")
+		require.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+		_, data, err := conn.Read(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(data))
+	})
+}
+
+func TestPTYOutputBuffer(t *testing.T) {
+	t.Parallel()
+	t.Run("Passthrough", func(t *testing.T) {
+		t.Parallel()
+		output := bytes.Repeat([]byte("terminal output\r\n"), 16384)
+		buffer := newPTYOutputBuffer(4096, testutil.WaitShort)
+		var received bytes.Buffer
+		writeErr := make(chan error, 1)
+		go func() {
+			writeErr <- buffer.writeTo(&received)
+		}()
+		require.NoError(t, buffer.readFrom(bytes.NewReader(output)))
+		require.NoError(t, <-writeErr)
+		require.Equal(t, output, received.Bytes())
+	})
+
+	t.Run("StalledClient", func(t *testing.T) {
+		t.Parallel()
+		const highWater = 64 * 1024
+		buffer := newPTYOutputBuffer(highWater, 50*time.Millisecond)
+		client := &stalledWriter{unblock: make(chan struct{})}
+		writeErr := make(chan error, 1)
+		go func() {
+			writeErr <- buffer.writeTo(client)
+		}()
+
+		// A program like "yes" never stops producing output.
+		err := buffer.readFrom(endlessReader{})
+		require.ErrorIs(t, err, errPTYOutputStalled)
+		buffer.mu.Lock()
+		buffered := buffer.buffered
+		buffer.mu.Unlock()
+		require.LessOrEqual(t, buffered, highWater+32*1024)
+
+		close(client.unblock)
+		require.ErrorIs(t, <-writeErr, io.ErrClosedPipe)
+	})
+}
+
+// stalledWriter blocks writes until unblock is closed, like a websocket
+// client that stopped reading.
+type stalledWriter struct {
+	unblock chan struct{}
+}
+
+func (w *stalledWriter) Write([]byte) (int, error) {
+	<-w.unblock
+	return 0, io.ErrClosedPipe
+}
+
+type endlessReader struct{}
+
+func (endlessReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 'y'
+	}
+	return len(b), nil
+}
+
+func TestReconnectAgentsContext(t *testing.T) {
+	t.Parallel()
+	api := &API{agentReconnect: make(chan struct{})}
+	// An agent connection that never closes.
+	api.agentListenWaitGroup.Add(1)
+	t.Cleanup(api.agentListenWaitGroup.Done)
+	reconnect := api.agentReconnect
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := api.ReconnectAgents(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NotEqual(t, reconnect, api.agentReconnect)
+	select {
+	case <-reconnect:
+	default:
+		t.Fatal("agents weren't asked to reconnect")
+	}
+}
+
+func TestWorkspaceAgentListenDatabaseTimeout(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	db := &blockingStore{Store: databasefake.New()}
+	api := &API{Options: &Options{
+		Logger:                   slogtest.Make(t, nil).Leveled(slog.LevelDebug),
+		Database:                 db,
+		Pubsub:                   database.NewPubsubInMemory(),
+		AgentDatabaseCallTimeout: 50 * time.Millisecond,
+	}, agentConnectionSetup: newAgentConnectionSetupHistogram(prometheus.NewRegistry())}
+	workspaceAgent := insertWorkspaceAgent(ctx, t, db)
+	rtr := chi.NewRouter()
+	rtr.With(httpmw.ExtractWorkspaceAgent(db)).Get("/api/v2/workspaceagents/me/listen", api.workspaceAgentListen)
+	srv := httptest.NewServer(rtr)
+	t.Cleanup(srv.Close)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v2/workspaceagents/me/listen", nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{
+		Name:  codersdk.SessionTokenKey,
+		Value: workspaceAgent.AuthToken.String(),
+	})
+	start := time.Now()
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Less(t, time.Since(start), testutil.WaitShort)
+	require.Equal(t, http.StatusGatewayTimeout, res.StatusCode)
+}
+
+// insertWorkspaceAgent inserts an agent belonging to the latest build of a
+// new workspace, so it passes httpmw.ExtractWorkspaceAgent.
+func insertWorkspaceAgent(ctx context.Context, t *testing.T, db database.Store) database.WorkspaceAgent {
+	t.Helper()
+	workspace, err := db.InsertWorkspace(ctx, database.InsertWorkspaceParams{
+		ID:   uuid.New(),
+		Name: "workspace",
+	})
+	require.NoError(t, err)
+	build, err := db.InsertWorkspaceBuild(ctx, database.InsertWorkspaceBuildParams{
+		ID:          uuid.New(),
+		WorkspaceID: workspace.ID,
+		BuildNumber: 1,
+		JobID:       uuid.New(),
+	})
+	require.NoError(t, err)
+	resource, err := db.InsertWorkspaceResource(ctx, database.InsertWorkspaceResourceParams{
+		ID:    uuid.New(),
+		JobID: build.JobID,
+	})
+	require.NoError(t, err)
+	workspaceAgent, err := db.InsertWorkspaceAgent(ctx, database.InsertWorkspaceAgentParams{
+		ID:         uuid.New(),
+		ResourceID: resource.ID,
+		AuthToken:  uuid.New(),
+	})
+	require.NoError(t, err)
+	return workspaceAgent
+}
+
+func TestAgentInactiveDisconnectTimeoutDatabaseTimeout(t *testing.T) {
+	t.Parallel()
+	api := &API{Options: &Options{
+		Database:                 &blockingStore{Store: databasefake.New()},
+		AgentDatabaseCallTimeout: 50 * time.Millisecond,
+	}}
+	// Dials pass the request context, which isn't bounded.
+	start := time.Now()
+	_, err := api.agentInactiveDisconnectTimeout(context.Background(), database.Workspace{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), testutil.WaitShort)
+	require.Equal(t, http.StatusGatewayTimeout, agentDatabaseErrorStatus(err, http.StatusInternalServerError))
+}
+
+// blockingStore hangs when fetching workspaces and templates until the
+// caller gives up. Agent authentication doesn't fetch either, so only the
+// handler blocks.
+type blockingStore struct {
+	database.Store
+}
+
+func (*blockingStore) GetWorkspaceByID(ctx context.Context, _ uuid.UUID) (database.Workspace, error) {
+	<-ctx.Done()
+	return database.Workspace{}, ctx.Err()
+}
+
+func (*blockingStore) GetTemplateByID(ctx context.Context, _ uuid.UUID) (database.Template, error) {
+	<-ctx.Done()
+	return database.Template{}, ctx.Err()
+}
+
+// failingPubsub fails every subscription, so negotiation with an agent
+// can't begin.
+type failingPubsub struct {
+	database.Pubsub
+}
+
+func (*failingPubsub) Subscribe(string, database.Listener) (func(), error) {
+	return nil, xerrors.New("subscribe failed")
+}
+
+func TestWorkspaceAgentWireguardListenerClosed(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	db := databasefake.New()
+	pubsub := &countingPubsub{Pubsub: database.NewPubsubInMemory()}
+	api := &API{Options: &Options{
+		Logger:   slogtest.Make(t, nil).Leveled(slog.LevelDebug),
+		Database: db,
+		Pubsub:   pubsub,
+	}}
+	workspaceAgent := insertWorkspaceAgent(ctx, t, db)
+	rtr := chi.NewRouter()
+	rtr.With(httpmw.ExtractWorkspaceAgent(db)).Get("/api/v2/workspaceagents/me/wireguardlisten", api.workspaceAgentWireguardListener)
+	srv := httptest.NewServer(rtr)
+	t.Cleanup(srv.Close)
+
+	// The raw connection is kept so the client can disappear without
+	// closing the websocket.
+	var (
+		rawConnMutex sync.Mutex
+		rawConn      net.Conn
+	)
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			rawConnMutex.Lock()
+			rawConn = conn
+			rawConnMutex.Unlock()
+			return conn, err
+		},
+	}
+	serverURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	client := codersdk.New(serverURL)
+	client.HTTPClient.Transport = transport
+	client.SessionToken = workspaceAgent.AuthToken.String()
+	_, closeListener, err := client.WireguardPeerListener(ctx, slogtest.Make(t, nil))
+	require.NoError(t, err)
+	defer closeListener()
+	require.Eventually(t, func() bool {
+		return pubsub.subscriptions.Load() == 1
+	}, testutil.WaitShort, testutil.IntervalFast)
+
+	message, err := peerwg.Handshake{Recipient: workspaceAgent.ID}.MarshalText()
+	require.NoError(t, err)
+	rawConnMutex.Lock()
+	_ = rawConn.Close()
+	rawConnMutex.Unlock()
+
+	require.Eventually(t, func() bool {
+		err := pubsub.Publish("wireguard_peers", message)
+		return assert.NoError(t, err) && pubsub.subscriptions.Load() == 0
+	}, testutil.WaitShort, testutil.IntervalFast)
+	deliveries := pubsub.deliveries.Load()
+	for i := 0; i < 10; i++ {
+		err = pubsub.Publish("wireguard_peers", message)
+		require.NoError(t, err)
+	}
+	require.Equal(t, deliveries, pubsub.deliveries.Load())
+}
+
+// countingPubsub tracks active subscriptions and how many messages have
+// been delivered to them.
+type countingPubsub struct {
+	database.Pubsub
+	subscriptions atomic.Int64
+	deliveries    atomic.Int64
+}
+
+func (p *countingPubsub) Subscribe(event string, listener database.Listener) (func(), error) {
+	cancel, err := p.Pubsub.Subscribe(event, func(ctx context.Context, message []byte) {
+		p.deliveries.Inc()
+		listener(ctx, message)
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.subscriptions.Inc()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.subscriptions.Dec()
+			cancel()
+		})
+	}, nil
+}
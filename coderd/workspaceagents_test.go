@@ -3,22 +3,39 @@ package coderd_test
 import (
 	"bufio"
 	"context"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // SHA1 is required by the TURN REST API.
+	"encoding/base64"
 	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pion/webrtc/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+	"tailscale.com/tailcfg"
 
 	"cdr.dev/slog"
 	"cdr.dev/slog/sloggers/slogtest"
 	"github.com/coder/coder/agent"
+	"github.com/coder/coder/coderd"
+	"github.com/coder/coder/coderd/audit"
 	"github.com/coder/coder/coderd/coderdtest"
+	"github.com/coder/coder/coderd/database"
 	"github.com/coder/coder/codersdk"
 	"github.com/coder/coder/peer"
+	"github.com/coder/coder/peerbroker"
 	"github.com/coder/coder/provisioner/echo"
 	"github.com/coder/coder/provisionersdk/proto"
 	"github.com/coder/coder/testutil"
@@ -71,6 +88,231 @@ func TestWorkspaceAgent(t *testing.T) {
 	})
 }
 
+func TestWorkspaceAgentStartupPhase(t *testing.T) {
+	t.Parallel()
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	err := agentClient.PostWorkspaceAgentStartupPhase(ctx, "unknown")
+	require.Error(t, err)
+
+	// The agent restarting begins a new timeline.
+	for _, phase := range []agent.StartupPhase{
+		agent.StartupPhaseStartupScript,
+		agent.StartupPhaseReady,
+		agent.StartupPhaseStartupScript,
+		agent.StartupPhaseReady,
+	} {
+		err = agentClient.PostWorkspaceAgentStartupPhase(ctx, phase)
+		require.NoError(t, err)
+	}
+
+	resources, err := client.WorkspaceResourcesByBuild(ctx, workspace.LatestBuild.ID)
+	require.NoError(t, err)
+	workspaceAgent, err := client.WorkspaceAgent(ctx, resources[0].Agents[0].ID)
+	require.NoError(t, err)
+	require.Len(t, workspaceAgent.StartupPhases, 2)
+	require.Equal(t, string(agent.StartupPhaseStartupScript), workspaceAgent.StartupPhases[0].Phase)
+	require.Equal(t, string(agent.StartupPhaseReady), workspaceAgent.StartupPhases[1].Phase)
+	require.GreaterOrEqual(t, workspaceAgent.StartupPhases[0].DurationMillis, int64(0))
+	require.Zero(t, workspaceAgent.StartupPhases[1].DurationMillis)
+	require.Equal(t, workspaceAgent.StartupPhases, resources[0].Agents[0].StartupPhases)
+}
+
+func TestWorkspaceAgentNetwork(t *testing.T) {
+	t.Parallel()
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	resources, err := client.WorkspaceResourcesByBuild(ctx, workspace.LatestBuild.ID)
+	require.NoError(t, err)
+	require.Nil(t, resources[0].Agents[0].Network)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	err = agentClient.PostWorkspaceAgentNetwork(ctx, agent.NetworkReport{
+		PublicIP: "203.0.113.7",
+		NATType:  "unknown-nat",
+	})
+	require.Error(t, err)
+	err = agentClient.PostWorkspaceAgentNetwork(ctx, agent.NetworkReport{
+		PublicIP: "203.0.113.7",
+		NATType:  agent.NATTypeEndpointDependent,
+	})
+	require.NoError(t, err)
+
+	workspaceAgent, err := client.WorkspaceAgent(ctx, resources[0].Agents[0].ID)
+	require.NoError(t, err)
+	require.NotNil(t, workspaceAgent.Network)
+	require.Equal(t, "203.0.113.7", workspaceAgent.Network.PublicIP)
+	require.Equal(t, string(agent.NATTypeEndpointDependent), workspaceAgent.Network.NATType)
+	require.False(t, workspaceAgent.Network.ReportedAt.IsZero())
+}
+
+func TestWorkspaceAgentDiagnostics(t *testing.T) {
+	t.Parallel()
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	resources, err := client.WorkspaceResourcesByBuild(ctx, workspace.LatestBuild.ID)
+	require.NoError(t, err)
+	require.Nil(t, resources[0].Agents[0].Diagnostics)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	err = agentClient.PostWorkspaceAgentDiagnostics(ctx, agent.Diagnostics{
+		Uptime:    time.Hour,
+		ClockSync: "drifting",
+	})
+	require.Error(t, err)
+	interfaces := []agent.NetworkInterface{{
+		Name:      "eth0",
+		Addresses: []string{"10.0.0.2/24", "fe80::1/64"},
+		MTU:       1500,
+		Flags:     []string{"up", "broadcast"},
+	}}
+	err = agentClient.PostWorkspaceAgentDiagnostics(ctx, agent.Diagnostics{
+		Uptime:     time.Hour,
+		ClockSync:  agent.ClockSyncUnsynced,
+		Interfaces: interfaces,
+	})
+	require.NoError(t, err)
+
+	workspaceAgent, err := client.WorkspaceAgent(ctx, resources[0].Agents[0].ID)
+	require.NoError(t, err)
+	require.NotNil(t, workspaceAgent.Diagnostics)
+	require.Equal(t, string(agent.ClockSyncUnsynced), workspaceAgent.Diagnostics.ClockSync)
+	// The start time is derived from the uptime on coderd's clock.
+	require.WithinDuration(t, workspaceAgent.Diagnostics.ReportedAt.Add(-time.Hour), workspaceAgent.Diagnostics.ProcessStartedAt, time.Second)
+	require.Equal(t, []codersdk.WorkspaceAgentNetworkInterface{{
+		Name:      "eth0",
+		Addresses: []string{"10.0.0.2/24", "fe80::1/64"},
+		MTU:       1500,
+		Flags:     []string{"up", "broadcast"},
+	}}, workspaceAgent.Diagnostics.Interfaces)
+}
+
+func TestWorkspaceAgentDirectory(t *testing.T) {
+	t.Parallel()
+	setup := func(t *testing.T, directory string) (*codersdk.Client, codersdk.Workspace, string) {
+		client := coderdtest.New(t, &coderdtest.Options{
+			IncludeProvisionerD: true,
+		})
+		user := coderdtest.CreateFirstUser(t, client)
+		workspace, authToken := setupWorkspaceAgent(t, client, user, func(protoAgent *proto.Agent) {
+			protoAgent.Directory = directory
+			protoAgent.OperatingSystem = "linux"
+		})
+		return client, workspace, authToken
+	}
+	t.Run("Absolute", func(t *testing.T) {
+		t.Parallel()
+		client, workspace, _ := setup(t, "/home/coder/project/")
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+		resources, err := client.WorkspaceResourcesByBuild(ctx, workspace.LatestBuild.ID)
+		require.NoError(t, err)
+		require.Equal(t, "/home/coder/project", resources[0].Agents[0].Directory)
+		require.Nil(t, resources[0].Agents[0].DirectoryExists)
+	})
+	t.Run("Relative", func(t *testing.T) {
+		t.Parallel()
+		client, workspace, _ := setup(t, "coder/project")
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+		build, err := client.WorkspaceBuild(ctx, workspace.LatestBuild.ID)
+		require.NoError(t, err)
+		require.Equal(t, codersdk.ProvisionerJobFailed, build.Job.Status)
+		require.Contains(t, build.Job.Error, "must be an absolute path")
+	})
+	t.Run("Traversal", func(t *testing.T) {
+		t.Parallel()
+		client, workspace, _ := setup(t, "/home/coder/../root")
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+		build, err := client.WorkspaceBuild(ctx, workspace.LatestBuild.ID)
+		require.NoError(t, err)
+		require.Equal(t, codersdk.ProvisionerJobFailed, build.Job.Status)
+	})
+	t.Run("Missing", func(t *testing.T) {
+		t.Parallel()
+		client, workspace, authToken := setup(t, "/does/not/exist")
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		agentClient := codersdk.New(client.URL)
+		agentClient.SessionToken = authToken
+		err := agentClient.PostWorkspaceAgentDirectory(ctx, false)
+		require.NoError(t, err)
+
+		resources, err := client.WorkspaceResourcesByBuild(ctx, workspace.LatestBuild.ID)
+		require.NoError(t, err)
+		require.NotNil(t, resources[0].Agents[0].DirectoryExists)
+		require.False(t, *resources[0].Agents[0].DirectoryExists)
+	})
+}
+
+func TestWorkspaceAgentMaintenance(t *testing.T) {
+	t.Parallel()
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	connectAndDisconnect := func() uuid.UUID {
+		agentCloser := agent.New(agentClient.ListenWorkspaceAgent, &agent.Options{
+			Logger: slogtest.Make(t, nil).Named("agent").Leveled(slog.LevelDebug),
+		})
+		resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+		err := agentCloser.Close()
+		require.NoError(t, err)
+		agentID := resources[0].Agents[0].ID
+		require.Eventually(t, func() bool {
+			workspaceAgent, err := client.WorkspaceAgent(ctx, agentID)
+			return assert.NoError(t, err) && workspaceAgent.Status == codersdk.WorkspaceAgentDisconnected
+		}, testutil.WaitLong, testutil.IntervalFast)
+		return agentID
+	}
+
+	agentID := connectAndDisconnect()
+	err := client.UpdateWorkspaceAgentMaintenance(ctx, agentID, codersdk.UpdateWorkspaceAgentMaintenanceRequest{
+		Maintenance: true,
+	})
+	require.NoError(t, err)
+	workspaceAgent, err := client.WorkspaceAgent(ctx, agentID)
+	require.NoError(t, err)
+	require.Equal(t, codersdk.WorkspaceAgentMaintenance, workspaceAgent.Status)
+
+	// Reconnecting clears the flag, so the next disconnect is unexpected.
+	connectAndDisconnect()
+}
+
 func TestWorkspaceAgentListen(t *testing.T) {
 	t.Parallel()
 
@@ -129,6 +371,69 @@ func TestWorkspaceAgentListen(t *testing.T) {
 		require.NoError(t, err)
 	})
 
+	t.Run("Reconnect", func(t *testing.T) {
+		t.Parallel()
+
+		const reconnectDelay = time.Second
+		var api *coderd.API
+		client := coderdtest.New(t, &coderdtest.Options{
+			IncludeProvisionerD: true,
+			APIBuilder: func(options *coderd.Options) *coderd.API {
+				options.AgentReconnectDelay = reconnectDelay
+				api = coderd.New(options)
+				return api
+			},
+		})
+		user := coderdtest.CreateFirstUser(t, client)
+		workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+		agentClient := codersdk.New(client.URL)
+		agentClient.SessionToken = authToken
+		connected := make(chan struct{}, 1)
+		agentCloser := agent.New(func(ctx context.Context, logger slog.Logger) (agent.Metadata, *peerbroker.Listener, error) {
+			metadata, listener, err := agentClient.ListenWorkspaceAgent(ctx, logger)
+			if err == nil {
+				connected <- struct{}{}
+			}
+			return metadata, listener, err
+		}, &agent.Options{
+			Logger: slogtest.Make(t, nil).Named("agent").Leveled(slog.LevelDebug),
+		})
+		defer func() {
+			_ = agentCloser.Close()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		select {
+		case <-connected:
+		case <-ctx.Done():
+			t.Fatal("agent didn't connect")
+		}
+		start := time.Now()
+		err := api.ReconnectAgents(ctx)
+		require.NoError(t, err)
+		select {
+		case <-connected:
+		case <-ctx.Done():
+			t.Fatal("agent didn't reconnect")
+		}
+		// The agent waits as long as it was asked to, but no longer.
+		elapsed := time.Since(start)
+		require.GreaterOrEqual(t, elapsed, reconnectDelay)
+		require.Less(t, elapsed, reconnectDelay+testutil.WaitShort)
+
+		resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+		conn, err := client.DialWorkspaceAgent(ctx, resources[0].Agents[0].ID, nil)
+		require.NoError(t, err)
+		defer func() {
+			_ = conn.Close()
+		}()
+		_, err = conn.Ping()
+		require.NoError(t, err)
+	})
+
 	t.Run("FailNonLatestBuild", func(t *testing.T) {
 		t.Parallel()
 
@@ -202,80 +507,179 @@ func TestWorkspaceAgentListen(t *testing.T) {
 		_, _, err = agentClient.ListenWorkspaceAgent(ctx, slogtest.Make(t, nil))
 		require.Error(t, err)
 		require.ErrorContains(t, err, "build is outdated")
+
+		// The token is rejected by other endpoints too.
+		_, err = agentClient.AgentGitSSHKey(ctx)
+		var apiErr *codersdk.Error
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusUnauthorized, apiErr.StatusCode())
+	})
+
+	t.Run("Audit", func(t *testing.T) {
+		t.Parallel()
+
+		logs := make(agentAuditRecorder, 16)
+		client := coderdtest.New(t, &coderdtest.Options{
+			IncludeProvisionerD: true,
+			Auditor:             audit.NewExporter(audit.DefaultFilter, logs),
+		})
+		user := coderdtest.CreateFirstUser(t, client)
+		workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+		agentClient := codersdk.New(client.URL)
+		agentClient.SessionToken = authToken
+		agentCloser := agent.New(agentClient.ListenWorkspaceAgent, &agent.Options{
+			Logger: slogtest.Make(t, nil).Named("agent").Leveled(slog.LevelDebug),
+		})
+		defer func() {
+			_ = agentCloser.Close()
+		}()
+		resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+		agentID := resources[0].Agents[0].ID
+
+		connected := logs.next(t)
+		require.Equal(t, database.AuditActionConnect, connected.Action)
+		require.Equal(t, database.ResourceTypeWorkspaceAgent, connected.ResourceType)
+		require.Equal(t, agentID, connected.ResourceID)
+		require.Equal(t, user.UserID, connected.UserID)
+		require.Equal(t, user.OrganizationID, connected.OrganizationID)
+		require.True(t, connected.Ip.Valid)
+		var connectedDiff map[string]string
+		require.NoError(t, json.Unmarshal(connected.Diff, &connectedDiff))
+		require.Equal(t, workspace.ID.String(), connectedDiff["workspace_id"])
+		require.Equal(t, workspace.LatestBuild.ID.String(), connectedDiff["build_id"])
+		require.NotContains(t, connectedDiff, "disconnected_at")
+
+		// Closing the agent drops its connection without a clean
+		// handshake with coderd.
+		_ = agentCloser.Close()
+		disconnected := logs.next(t)
+		require.Equal(t, database.AuditActionDisconnect, disconnected.Action)
+		require.Equal(t, agentID, disconnected.ResourceID)
+		require.NotEqual(t, connected.ID, disconnected.ID)
+		var disconnectedDiff map[string]string
+		require.NoError(t, json.Unmarshal(disconnected.Diff, &disconnectedDiff))
+		require.Equal(t, connectedDiff["connected_at"], disconnectedDiff["connected_at"])
+		require.NotEmpty(t, disconnectedDiff["disconnected_at"])
 	})
 }
 
-func TestWorkspaceAgentTURN(t *testing.T) {
+// sessionSink records the session IDs the agent logged.
+type sessionSink struct {
+	mu         sync.Mutex
+	sessionIDs []string
+}
+
+func (s *sessionSink) LogEntry(_ context.Context, e slog.SinkEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, field := range e.Fields {
+		if sessionID, ok := field.Value.(string); ok && field.Name == "session_id" {
+			s.sessionIDs = append(s.sessionIDs, sessionID)
+		}
+	}
+}
+
+func (*sessionSink) Sync() {}
+
+func (s *sessionSink) has(sessionID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, logged := range s.sessionIDs {
+		if logged == sessionID {
+			return true
+		}
+	}
+	return false
+}
+
+// agentAuditRecorder is an audit backend that records exported logs.
+type agentAuditRecorder chan database.AuditLog
+
+func (agentAuditRecorder) Decision() audit.FilterDecision {
+	return audit.FilterDecisionExport
+}
+
+func (r agentAuditRecorder) Export(_ context.Context, alog database.AuditLog) error {
+	r <- alog
+	return nil
+}
+
+func (r agentAuditRecorder) next(t *testing.T) database.AuditLog {
+	t.Helper()
+	select {
+	case alog := <-r:
+		return alog
+	case <-time.After(testutil.WaitLong):
+		t.Fatal("timed out waiting for agent audit log")
+		return database.AuditLog{}
+	}
+}
+
+func TestWorkspaceAgentDialDeprecated(t *testing.T) {
 	t.Parallel()
 	client := coderdtest.New(t, &coderdtest.Options{
 		IncludeProvisionerD: true,
 	})
-
 	user := coderdtest.CreateFirstUser(t, client)
-	authToken := uuid.NewString()
-	version := coderdtest.CreateTemplateVersion(t, client, user.OrganizationID, &echo.Responses{
-		Parse:           echo.ParseComplete,
-		ProvisionDryRun: echo.ProvisionComplete,
-		Provision: []*proto.Provision_Response{{
-			Type: &proto.Provision_Response_Complete{
-				Complete: &proto.Provision_Complete{
-					Resources: []*proto.Resource{{
-						Name: "example",
-						Type: "aws_instance",
-						Agents: []*proto.Agent{{
-							Id: uuid.NewString(),
-							Auth: &proto.Agent_Token{
-								Token: authToken,
-							},
-						}},
-					}},
-				},
-			},
-		}},
-	})
-	template := coderdtest.CreateTemplate(t, client, user.OrganizationID, version.ID)
-	coderdtest.AwaitTemplateVersionJob(t, client, version.ID)
-	workspace := coderdtest.CreateWorkspace(t, client, user.OrganizationID, template.ID)
-	coderdtest.AwaitWorkspaceBuildJob(t, client, workspace.LatestBuild.ID)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
 
 	agentClient := codersdk.New(client.URL)
 	agentClient.SessionToken = authToken
 	agentCloser := agent.New(agentClient.ListenWorkspaceAgent, &agent.Options{
-		Logger: slogtest.Make(t, nil),
+		Logger: slogtest.Make(t, nil).Named("agent").Leveled(slog.LevelDebug),
 	})
 	defer func() {
 		_ = agentCloser.Close()
 	}()
-	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
 
 	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
 	defer cancel()
 
-	opts := &peer.ConnOptions{
-		Logger: slogtest.Make(t, nil).Named("client"),
-	}
-	// Force a TURN connection!
-	opts.SettingEngine.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeTCP4})
-	conn, err := client.DialWorkspaceAgent(ctx, resources[0].Agents[0].ID, opts)
+	warnings := &deprecationSink{}
+	client.Logger = slog.Make(warnings)
+	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+	conn, err := client.DialWorkspaceAgent(ctx, resources[0].Agents[0].ID, nil)
 	require.NoError(t, err)
 	defer func() {
 		_ = conn.Close()
 	}()
-	_, err = conn.Ping()
-	require.NoError(t, err)
+	require.Contains(t, warnings.paths(), "/api/v2/workspaceagents/"+resources[0].Agents[0].ID.String()+"/dial")
 }
 
-func TestWorkspaceAgentPTY(t *testing.T) {
-	t.Parallel()
-	if runtime.GOOS == "windows" {
-		// This might be our implementation, or ConPTY itself.
-		// It's difficult to find extensive tests for it, so
-		// it seems like it could be either.
-		t.Skip("ConPTY appears to be inconsistent on Windows.")
+// deprecationSink records the paths the client warned were deprecated.
+type deprecationSink struct {
+	mu         sync.Mutex
+	deprecated []string
+}
+
+func (s *deprecationSink) LogEntry(_ context.Context, e slog.SinkEntry) {
+	if e.Message != "request to deprecated endpoint" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, field := range e.Fields {
+		if path, ok := field.Value.(string); ok && field.Name == "path" {
+			s.deprecated = append(s.deprecated, path)
+		}
 	}
+}
+
+func (*deprecationSink) Sync() {}
+
+func (s *deprecationSink) paths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.deprecated...)
+}
+
+func TestWorkspaceAgentTURN(t *testing.T) {
+	t.Parallel()
 	client := coderdtest.New(t, &coderdtest.Options{
 		IncludeProvisionerD: true,
 	})
+
 	user := coderdtest.CreateFirstUser(t, client)
 	authToken := uuid.NewString()
 	version := coderdtest.CreateTemplateVersion(t, client, user.OrganizationID, &echo.Responses{
@@ -316,12 +720,349 @@ func TestWorkspaceAgentPTY(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
 	defer cancel()
 
-	conn, err := client.WorkspaceAgentReconnectingPTY(ctx, resources[0].Agents[0].ID, uuid.New(), 80, 80, "/bin/bash")
-	require.NoError(t, err)
-	defer conn.Close()
-
-	// First attempt to resize the TTY.
-	// The websocket will close if it fails!
+	opts := &peer.ConnOptions{
+		Logger: slogtest.Make(t, nil).Named("client"),
+	}
+	// Force a TURN connection!
+	opts.SettingEngine.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeTCP4})
+	conn, err := client.DialWorkspaceAgent(ctx, resources[0].Agents[0].ID, opts)
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err = conn.Ping()
+	require.NoError(t, err)
+}
+
+func TestWorkspaceAgentICEServers(t *testing.T) {
+	t.Parallel()
+	const turnSecret = "turn-secret"
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+		ICEServers: []webrtc.ICEServer{{
+			URLs: []string{"stun:stun.example.com:3478"},
+		}, {
+			URLs: []string{"turn:turn.example.com:3478"},
+		}},
+		TURNSecret: turnSecret,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	_, authToken := setupWorkspaceAgent(t, client, user)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	iceServers := func() []webrtc.ICEServer {
+		res, err := agentClient.Request(ctx, http.MethodGet, "/api/v2/workspaceagents/me/iceservers", nil)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var servers []webrtc.ICEServer
+		err = json.NewDecoder(res.Body).Decode(&servers)
+		require.NoError(t, err)
+		require.Len(t, servers, 2)
+		// STUN servers don't require credentials.
+		require.Empty(t, servers[0].Username)
+		return servers
+	}
+	first := iceServers()[1]
+	second := iceServers()[1]
+	require.NotEqual(t, first.Username, second.Username)
+	require.NotEqual(t, first.Credential, second.Credential)
+
+	for _, server := range []webrtc.ICEServer{first, second} {
+		parts := strings.SplitN(server.Username, ":", 2)
+		require.Len(t, parts, 2)
+		expiry, err := strconv.ParseInt(parts[0], 10, 64)
+		require.NoError(t, err)
+		require.Greater(t, expiry, time.Now().Unix())
+		mac := hmac.New(sha1.New, []byte(turnSecret))
+		_, _ = mac.Write([]byte(server.Username))
+		require.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), server.Credential)
+	}
+}
+
+func TestWorkspaceAgentCandidatePair(t *testing.T) {
+	t.Parallel()
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	agentCloser := agent.New(agentClient.ListenWorkspaceAgent, &agent.Options{
+		Logger: slogtest.Make(t, nil),
+	})
+	defer func() {
+		_ = agentCloser.Close()
+	}()
+	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	pair, err := client.WorkspaceAgentCandidatePair(ctx, resources[0].Agents[0].ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, pair.Local.Type)
+	require.NotEmpty(t, pair.Remote.Type)
+}
+
+func TestWorkspaceAgentCapabilities(t *testing.T) {
+	t.Parallel()
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD:    true,
+		AgentDisabledProtocols: []string{agent.ProtocolReconnectingPTY},
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	agentCloser := agent.New(agentClient.ListenWorkspaceAgent, &agent.Options{
+		Logger: slogtest.Make(t, nil),
+	})
+	defer func() {
+		_ = agentCloser.Close()
+	}()
+	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	capabilities, err := client.WorkspaceAgentCapabilities(ctx, resources[0].Agents[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, agent.CapabilitiesVersion, capabilities.Version)
+	require.Contains(t, capabilities.Protocols, agent.ProtocolSSH)
+	require.NotContains(t, capabilities.Protocols, agent.ProtocolReconnectingPTY)
+	require.Contains(t, capabilities.Operations, agent.OperationExec)
+	require.NotContains(t, capabilities.Operations, agent.OperationReconnectingPTY)
+}
+
+func TestWorkspaceAgentShellPrompt(t *testing.T) {
+	t.Parallel()
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+		AgentShellPrompt:    "{workspace}.{agent}$ ",
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user, func(protoAgent *proto.Agent) {
+		protoAgent.Name = "dev"
+	})
+	resources, err := client.WorkspaceResourcesByBuild(context.Background(), workspace.LatestBuild.ID)
+	require.NoError(t, err)
+	expected := workspace.Name + ".dev$ "
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	workspaceAgent, err := client.WorkspaceAgent(ctx, resources[0].Agents[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, expected, workspaceAgent.ShellPrompt)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	metadata, listener, err := agentClient.ListenWorkspaceAgent(ctx, slogtest.Make(t, nil))
+	require.NoError(t, err)
+	defer listener.Close()
+	require.Equal(t, expected, metadata.ShellPrompt)
+}
+
+func TestWorkspaceAgentDiagnosticsBundle(t *testing.T) {
+	t.Parallel()
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+		DERPMap: &tailcfg.DERPMap{
+			Regions: map[int]*tailcfg.DERPRegion{
+				1: {RegionID: 1, RegionCode: "test", Nodes: []*tailcfg.DERPNode{derpProbeNode(t, 0)}},
+			},
+		},
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	agentCloser := agent.New(agentClient.ListenWorkspaceAgent, &agent.Options{
+		Logger: slogtest.Make(t, nil),
+	})
+	defer func() {
+		_ = agentCloser.Close()
+	}()
+	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	// The agent reports these itself, but they depend on the network.
+	err := agentClient.PostWorkspaceAgentNetwork(ctx, agent.NetworkReport{
+		PublicIP: "203.0.113.7",
+		NATType:  agent.NATTypeEndpointDependent,
+	})
+	require.NoError(t, err)
+	err = agentClient.PostWorkspaceAgentDiagnostics(ctx, agent.Diagnostics{
+		Uptime:    time.Hour,
+		ClockSync: agent.ClockSyncSynced,
+		Interfaces: []agent.NetworkInterface{{
+			Name:      "eth0",
+			Addresses: []string{"10.0.0.2/24"},
+			MTU:       1500,
+			Flags:     []string{"up"},
+		}},
+	})
+	require.NoError(t, err)
+
+	bundle, err := client.WorkspaceAgentDiagnosticsBundle(ctx, resources[0].Agents[0].ID)
+	require.NoError(t, err)
+	require.Empty(t, bundle.Errors)
+	require.Equal(t, resources[0].Agents[0].ID, bundle.AgentID)
+	require.Equal(t, codersdk.WorkspaceAgentConnected, bundle.Connection.Status)
+	require.NotNil(t, bundle.Connection.FirstConnectedAt)
+	require.NotNil(t, bundle.Network)
+	require.Equal(t, string(agent.NATTypeEndpointDependent), bundle.Network.NATType)
+	require.NotNil(t, bundle.Diagnostics)
+	require.Len(t, bundle.Diagnostics.Interfaces, 1)
+	require.Equal(t, "eth0", bundle.Diagnostics.Interfaces[0].Name)
+	require.NotNil(t, bundle.CandidatePair)
+	require.NotEmpty(t, bundle.CandidatePair.Local.Type)
+	require.Len(t, bundle.DERPLatency, 1)
+	require.True(t, bundle.DERPLatency[0].Reachable)
+}
+
+func TestWorkspaceAgentConnectionSetupMetric(t *testing.T) {
+	t.Parallel()
+	registry := prometheus.NewRegistry()
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+		APIBuilder: func(options *coderd.Options) *coderd.API {
+			options.PrometheusRegistry = registry
+			return coderd.New(options)
+		},
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	agentCloser := agent.New(agentClient.ListenWorkspaceAgent, &agent.Options{
+		Logger: slogtest.Make(t, nil),
+	})
+	defer func() {
+		_ = agentCloser.Close()
+	}()
+	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	// Fetching the candidate pair makes coderd dial the agent.
+	start := time.Now()
+	_, err := client.WorkspaceAgentCandidatePair(ctx, resources[0].Agents[0].ID)
+	require.NoError(t, err)
+	elapsed := time.Since(start)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	var (
+		observed    bool
+		sampleCount uint64
+		sampleSum   float64
+	)
+	for _, family := range families {
+		if family.GetName() != "coderd_agents_connection_setup_ms" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["kind"] == "coderd" && labels["outcome"] == "success" {
+				observed = true
+				sampleCount = metric.GetHistogram().GetSampleCount()
+				sampleSum = metric.GetHistogram().GetSampleSum()
+			}
+		}
+	}
+	require.True(t, observed, "no successful coderd dial was observed")
+	require.EqualValues(t, 1, sampleCount)
+	require.LessOrEqual(t, sampleSum, float64(elapsed.Milliseconds()))
+}
+
+func TestWorkspaceAgentPTY(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		// This might be our implementation, or ConPTY itself.
+		// It's difficult to find extensive tests for it, so
+		// it seems like it could be either.
+		t.Skip("ConPTY appears to be inconsistent on Windows.")
+	}
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	authToken := uuid.NewString()
+	version := coderdtest.CreateTemplateVersion(t, client, user.OrganizationID, &echo.Responses{
+		Parse:           echo.ParseComplete,
+		ProvisionDryRun: echo.ProvisionComplete,
+		Provision: []*proto.Provision_Response{{
+			Type: &proto.Provision_Response_Complete{
+				Complete: &proto.Provision_Complete{
+					Resources: []*proto.Resource{{
+						Name: "example",
+						Type: "aws_instance",
+						Agents: []*proto.Agent{{
+							Id: uuid.NewString(),
+							Auth: &proto.Agent_Token{
+								Token: authToken,
+							},
+						}},
+					}},
+				},
+			},
+		}},
+	})
+	template := coderdtest.CreateTemplate(t, client, user.OrganizationID, version.ID)
+	coderdtest.AwaitTemplateVersionJob(t, client, version.ID)
+	workspace := coderdtest.CreateWorkspace(t, client, user.OrganizationID, template.ID)
+	coderdtest.AwaitWorkspaceBuildJob(t, client, workspace.LatestBuild.ID)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	agentLogs := &sessionSink{}
+	agentCloser := agent.New(agentClient.ListenWorkspaceAgent, &agent.Options{
+		Logger: slogtest.Make(t, nil).Leveled(slog.LevelDebug).AppendSinks(agentLogs),
+	})
+	defer func() {
+		_ = agentCloser.Close()
+	}()
+	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	conn, err := client.WorkspaceAgentReconnectingPTY(ctx, codersdk.WorkspaceAgentReconnectingPTYOpts{
+		AgentID:   resources[0].Agents[0].ID,
+		Reconnect: uuid.New(),
+		Height:    80,
+		Width:     80,
+		Command:   "/bin/bash",
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The agent logs the session with the ID coderd returned.
+	require.NotEmpty(t, conn.SessionID)
+	require.Eventually(t, func() bool {
+		return agentLogs.has(conn.SessionID)
+	}, testutil.WaitLong, testutil.IntervalFast)
+
+	// First attempt to resize the TTY.
+	// The websocket will close if it fails!
 	data, err := json.Marshal(agent.ReconnectingPTYRequest{
 		Height: 250,
 		Width:  250,
@@ -361,3 +1102,501 @@ func TestWorkspaceAgentPTY(t *testing.T) {
 	expectLine(matchEchoCommand)
 	expectLine(matchEchoOutput)
 }
+
+func TestWorkspaceAgentSSHSessionID(t *testing.T) {
+	t.Parallel()
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	agentLogs := &sessionSink{}
+	agentCloser := agent.New(agentClient.ListenWorkspaceAgent, &agent.Options{
+		Logger: slogtest.Make(t, nil).Leveled(slog.LevelDebug).AppendSinks(agentLogs),
+	})
+	defer func() {
+		_ = agentCloser.Close()
+	}()
+	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	conn, err := client.DialWorkspaceAgent(ctx, resources[0].Agents[0].ID, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NotEmpty(t, conn.SessionID)
+
+	sshClient, err := conn.SSHClient()
+	require.NoError(t, err)
+	defer sshClient.Close()
+	session, err := sshClient.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	require.NoError(t, session.Run("exit 0"))
+
+	// The agent logs the session with the ID coderd returned.
+	require.Eventually(t, func() bool {
+		return agentLogs.has(conn.SessionID)
+	}, testutil.WaitLong, testutil.IntervalFast)
+}
+
+func TestWorkspaceAgentPTYSessionID(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("ConPTY appears to be inconsistent on Windows.")
+	}
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	agentLogs := &sessionSink{}
+	agentCloser := agent.New(agentClient.ListenWorkspaceAgent, &agent.Options{
+		Logger: slogtest.Make(t, nil).Leveled(slog.LevelDebug).AppendSinks(agentLogs),
+	})
+	defer func() {
+		_ = agentCloser.Close()
+	}()
+	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	t.Run("ClientSupplied", func(t *testing.T) {
+		t.Parallel()
+		sessionID := uuid.NewString()
+		conn, err := client.WorkspaceAgentReconnectingPTY(ctx, codersdk.WorkspaceAgentReconnectingPTYOpts{
+			AgentID:   resources[0].Agents[0].ID,
+			Reconnect: uuid.New(),
+			Height:    80,
+			Width:     80,
+			SessionID: sessionID,
+			Command:   "/bin/bash",
+		})
+		require.NoError(t, err)
+		defer conn.Close()
+		require.Equal(t, sessionID, conn.SessionID)
+		require.Eventually(t, func() bool {
+			return agentLogs.has(sessionID)
+		}, testutil.WaitLong, testutil.IntervalFast)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		_, err := client.WorkspaceAgentReconnectingPTY(ctx, codersdk.WorkspaceAgentReconnectingPTYOpts{
+			AgentID:   resources[0].Agents[0].ID,
+			Reconnect: uuid.New(),
+			SessionID: "not-a-uuid",
+		})
+		var apiErr *codersdk.Error
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusBadRequest, apiErr.StatusCode())
+	})
+}
+
+func TestWorkspaceAgentPTYIdleTimeout(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("ConPTY appears to be inconsistent on Windows.")
+	}
+	const idleTimeout = time.Second
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+		// The template's timeout takes precedence.
+		TerminalIdleTimeout: testutil.WaitLong,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
+	template, err := client.UpdateTemplateMeta(context.Background(), workspace.TemplateID, codersdk.UpdateTemplateMeta{
+		TerminalIdleTimeoutMillis: idleTimeout.Milliseconds(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, idleTimeout.Milliseconds(), template.TerminalIdleTimeoutMillis)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	agentCloser := agent.New(agentClient.ListenWorkspaceAgent, &agent.Options{
+		Logger: slogtest.Make(t, nil),
+	})
+	defer func() {
+		_ = agentCloser.Close()
+	}()
+	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	idle, err := client.WorkspaceAgentReconnectingPTY(ctx, codersdk.WorkspaceAgentReconnectingPTYOpts{
+		AgentID:   resources[0].Agents[0].ID,
+		Reconnect: uuid.New(),
+		Height:    80,
+		Width:     80,
+		Command:   "/bin/bash",
+	})
+	require.NoError(t, err)
+	defer idle.Close()
+	active, err := client.WorkspaceAgentReconnectingPTY(ctx, codersdk.WorkspaceAgentReconnectingPTYOpts{
+		AgentID:   resources[0].Agents[0].ID,
+		Reconnect: uuid.New(),
+		Height:    80,
+		Width:     80,
+		Command:   "/bin/bash",
+	})
+	require.NoError(t, err)
+	defer active.Close()
+	activeOutput := bufio.NewReader(active)
+
+	idleClosed := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, idle)
+		idleClosed <- err
+	}()
+
+	// Input keeps the active terminal open well past the timeout.
+	resize, err := json.Marshal(agent.ReconnectingPTYRequest{
+		Height: 80,
+		Width:  80,
+	})
+	require.NoError(t, err)
+	ticker := time.NewTicker(idleTimeout / 5)
+	defer ticker.Stop()
+	deadline := time.After(3 * idleTimeout)
+input:
+	for {
+		select {
+		case <-ticker.C:
+			_, err = active.Write(resize)
+			require.NoError(t, err)
+		case <-deadline:
+			break input
+		}
+	}
+
+	select {
+	case err := <-idleClosed:
+		require.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+	case <-ctx.Done():
+		t.Fatal("idle terminal wasn't closed")
+	}
+
+	data, err := json.Marshal(agent.ReconnectingPTYRequest{
+		Data: "echo active\r\n",
+	})
+	require.NoError(t, err)
+	_, err = active.Write(data)
+	require.NoError(t, err)
+	for {
+		line, err := activeOutput.ReadString('\n')
+		require.NoError(t, err)
+		if strings.Contains(line, "active") && !strings.Contains(line, "echo") {
+			break
+		}
+	}
+}
+
+func TestWorkspaceAgentPTYTakeover(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("ConPTY appears to be inconsistent on Windows.")
+	}
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	agentCloser := agent.New(agentClient.ListenWorkspaceAgent, &agent.Options{
+		Logger: slogtest.Make(t, nil),
+	})
+	defer func() {
+		_ = agentCloser.Close()
+	}()
+	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	expectOutput := func(reader *bufio.Reader, text string) {
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			if strings.Contains(line, text) && !strings.Contains(line, "echo") {
+				return
+			}
+		}
+	}
+	reconnect := uuid.New()
+	first, err := client.WorkspaceAgentReconnectingPTY(ctx, codersdk.WorkspaceAgentReconnectingPTYOpts{
+		AgentID:   resources[0].Agents[0].ID,
+		Reconnect: reconnect,
+		Height:    80,
+		Width:     80,
+		Command:   "/bin/bash",
+	})
+	require.NoError(t, err)
+	defer first.Close()
+	// Brief pause to reduce the likelihood that we send keystrokes while
+	// the shell is simultaneously sending a prompt.
+	time.Sleep(100 * time.Millisecond)
+	data, err := json.Marshal(agent.ReconnectingPTYRequest{
+		Data: "echo first-device\r\n",
+	})
+	require.NoError(t, err)
+	_, err = first.Write(data)
+	require.NoError(t, err)
+	expectOutput(bufio.NewReader(first), "first-device")
+
+	firstClosed := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, first)
+		firstClosed <- err
+	}()
+	second, err := client.WorkspaceAgentReconnectingPTY(ctx, codersdk.WorkspaceAgentReconnectingPTYOpts{
+		AgentID:   resources[0].Agents[0].ID,
+		Reconnect: reconnect,
+		Height:    80,
+		Width:     80,
+		Takeover:  true,
+		Command:   "/bin/bash",
+	})
+	require.NoError(t, err)
+	defer second.Close()
+
+	select {
+	case err := <-firstClosed:
+		var closeErr websocket.CloseError
+		require.ErrorAs(t, err, &closeErr)
+		require.Equal(t, websocket.StatusPolicyViolation, closeErr.Code)
+		require.Equal(t, codersdk.ReconnectingPTYTakenOverReason, closeErr.Reason)
+	case <-ctx.Done():
+		t.Fatal("first terminal wasn't detached")
+	}
+
+	// The session continues on the second device, scrollback included.
+	secondOutput := bufio.NewReader(second)
+	expectOutput(secondOutput, "first-device")
+	data, err = json.Marshal(agent.ReconnectingPTYRequest{
+		Data: "echo second-device\r\n",
+	})
+	require.NoError(t, err)
+	_, err = second.Write(data)
+	require.NoError(t, err)
+	expectOutput(secondOutput, "second-device")
+}
+
+func TestWorkspaceAgentPTYProcesses(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS != "linux" {
+		t.Skip("Process trees are only supported on Linux.")
+	}
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	agentCloser := agent.New(agentClient.ListenWorkspaceAgent, &agent.Options{
+		Logger: slogtest.Make(t, nil),
+	})
+	defer func() {
+		_ = agentCloser.Close()
+	}()
+	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+	agentID := resources[0].Agents[0].ID
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	_, err := client.WorkspaceAgentReconnectingPTYProcesses(ctx, agentID, uuid.New())
+	require.True(t, codersdk.IsNotFound(err), "unknown sessions aren't found: %v", err)
+
+	reconnect := uuid.New()
+	conn, err := client.WorkspaceAgentReconnectingPTY(ctx, codersdk.WorkspaceAgentReconnectingPTYOpts{
+		AgentID:   agentID,
+		Reconnect: reconnect,
+		Height:    80,
+		Width:     80,
+		Command:   "/bin/bash",
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	// Brief pause to reduce the likelihood that we send keystrokes while
+	// the shell is simultaneously sending a prompt.
+	time.Sleep(100 * time.Millisecond)
+	data, err := json.Marshal(agent.ReconnectingPTYRequest{
+		Data: "sleep 1337\r\n",
+	})
+	require.NoError(t, err)
+	_, err = conn.Write(data)
+	require.NoError(t, err)
+
+	var (
+		processes []codersdk.WorkspaceAgentProcess
+		sleep     codersdk.WorkspaceAgentProcess
+	)
+	require.Eventually(t, func() bool {
+		processes, err = client.WorkspaceAgentReconnectingPTYProcesses(ctx, agentID, reconnect)
+		require.NoError(t, err)
+		for _, process := range processes {
+			if len(process.Command) > 0 && process.Command[0] == "sleep" {
+				sleep = process
+				return true
+			}
+		}
+		return false
+	}, testutil.WaitLong, testutil.IntervalFast)
+	// The session's shell is first, and spawned the sleep.
+	require.NotEqual(t, sleep.PID, processes[0].PID)
+	require.Equal(t, []string{"sleep", "1337"}, sleep.Command)
+	var parent bool
+	for _, process := range processes {
+		parent = parent || process.PID == sleep.PPID
+	}
+	require.True(t, parent, "the parent of sleep is in the tree")
+}
+
+func TestWorkspaceAgentListeningPorts(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS != "linux" {
+		t.Skip("Listening ports are only supported on Linux.")
+	}
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD: true,
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	workspace, authToken := setupWorkspaceAgent(t, client, user)
+
+	agentClient := codersdk.New(client.URL)
+	agentClient.SessionToken = authToken
+	agentCloser := agent.New(agentClient.ListenWorkspaceAgent, &agent.Options{
+		Logger: slogtest.Make(t, nil),
+	})
+	defer func() {
+		_ = agentCloser.Close()
+	}()
+	resources := coderdtest.AwaitWorkspaceAgents(t, client, workspace.LatestBuild.ID)
+	agentID := resources[0].Agents[0].ID
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+	// The agent runs in the test process, so the listener is one of its
+	// own ports.
+	hasPort := func(ports []codersdk.WorkspaceAgentListeningPort) bool {
+		for _, listening := range ports {
+			if listening.Port == port {
+				require.Equal(t, os.Getpid(), listening.PID)
+				return true
+			}
+		}
+		return false
+	}
+	ports, err := client.WorkspaceAgentListeningPorts(ctx, agentID, false)
+	require.NoError(t, err)
+	require.False(t, hasPort(ports), "the agent's own ports are omitted")
+	ports, err = client.WorkspaceAgentListeningPorts(ctx, agentID, true)
+	require.NoError(t, err)
+	require.True(t, hasPort(ports), "all ports are included")
+}
+
+func TestWorkspaceAgentPTYDisabled(t *testing.T) {
+	t.Parallel()
+	client := coderdtest.New(t, &coderdtest.Options{
+		IncludeProvisionerD:    true,
+		AgentDisabledProtocols: []string{agent.ProtocolReconnectingPTY},
+	})
+	user := coderdtest.CreateFirstUser(t, client)
+	version := coderdtest.CreateTemplateVersion(t, client, user.OrganizationID, &echo.Responses{
+		Parse:           echo.ParseComplete,
+		ProvisionDryRun: echo.ProvisionComplete,
+		Provision: []*proto.Provision_Response{{
+			Type: &proto.Provision_Response_Complete{
+				Complete: &proto.Provision_Complete{
+					Resources: []*proto.Resource{{
+						Name: "example",
+						Type: "aws_instance",
+						Agents: []*proto.Agent{{
+							Id: uuid.NewString(),
+							Auth: &proto.Agent_Token{
+								Token: uuid.NewString(),
+							},
+						}},
+					}},
+				},
+			},
+		}},
+	})
+	template := coderdtest.CreateTemplate(t, client, user.OrganizationID, version.ID)
+	coderdtest.AwaitTemplateVersionJob(t, client, version.ID)
+	workspace := coderdtest.CreateWorkspace(t, client, user.OrganizationID, template.ID)
+	coderdtest.AwaitWorkspaceBuildJob(t, client, workspace.LatestBuild.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	resources, err := client.WorkspaceResourcesByBuild(ctx, workspace.LatestBuild.ID)
+	require.NoError(t, err)
+	_, err = client.WorkspaceAgentReconnectingPTY(ctx, codersdk.WorkspaceAgentReconnectingPTYOpts{
+		AgentID:   resources[0].Agents[0].ID,
+		Reconnect: uuid.New(),
+		Height:    80,
+		Width:     80,
+		Command:   "/bin/bash",
+	})
+	var apiErr *codersdk.Error
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusForbidden, apiErr.StatusCode())
+}
+
+// setupWorkspaceAgent creates a workspace with a single agent that
+// authenticates with the returned token, and waits for it to build.
+// mutators customize the agent.
+func setupWorkspaceAgent(t *testing.T, client *codersdk.Client, user codersdk.CreateFirstUserResponse, mutators ...func(*proto.Agent)) (codersdk.Workspace, string) {
+	t.Helper()
+	authToken := uuid.NewString()
+	protoAgent := &proto.Agent{
+		Id: uuid.NewString(),
+		Auth: &proto.Agent_Token{
+			Token: authToken,
+		},
+	}
+	for _, mutate := range mutators {
+		mutate(protoAgent)
+	}
+	version := coderdtest.CreateTemplateVersion(t, client, user.OrganizationID, &echo.Responses{
+		Parse:           echo.ParseComplete,
+		ProvisionDryRun: echo.ProvisionComplete,
+		Provision: []*proto.Provision_Response{{
+			Type: &proto.Provision_Response_Complete{
+				Complete: &proto.Provision_Complete{
+					Resources: []*proto.Resource{{
+						Name:   "example",
+						Type:   "aws_instance",
+						Agents: []*proto.Agent{protoAgent},
+					}},
+				},
+			},
+		}},
+	})
+	template := coderdtest.CreateTemplate(t, client, user.OrganizationID, version.ID)
+	coderdtest.AwaitTemplateVersionJob(t, client, version.ID)
+	workspace := coderdtest.CreateWorkspace(t, client, user.OrganizationID, template.ID)
+	coderdtest.AwaitWorkspaceBuildJob(t, client, workspace.LatestBuild.ID)
+	return workspace, authToken
+}
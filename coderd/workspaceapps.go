@@ -1,6 +1,7 @@
 package coderd
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -8,9 +9,13 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
 
+	"github.com/coder/coder/agent"
 	"github.com/coder/coder/coderd/database"
 	"github.com/coder/coder/coderd/httpapi"
 	"github.com/coder/coder/coderd/httpmw"
@@ -24,15 +29,28 @@ import (
 // through a relative URL path.
 func (api *API) workspaceAppsProxyPath(rw http.ResponseWriter, r *http.Request) {
 	workspace := httpmw.WorkspaceParam(r)
-	agent := httpmw.WorkspaceAgentParam(r)
+	workspaceAgent := httpmw.WorkspaceAgentParam(r)
 
+	// App tokens are only valid for the app they were created for.
+	appToken, usingAppToken := httpmw.WorkspaceAppToken(r)
+	if usingAppToken && (appToken.WorkspaceID != workspace.ID || appToken.AppName != chi.URLParam(r, "workspaceapp")) {
+		httpapi.ResourceNotFound(rw)
+		return
+	}
 	if !api.Authorize(r, rbac.ActionCreate, workspace.ExecutionRBAC()) {
 		httpapi.ResourceNotFound(rw)
 		return
 	}
+	// Applications are proxied through dial channels to the agent.
+	if api.agentProtocolDisabled(agent.ProtocolDial) {
+		httpapi.Write(rw, http.StatusForbidden, codersdk.Response{
+			Message: "Workspace applications are disabled by the deployment.",
+		})
+		return
+	}
 
 	app, err := api.Database.GetWorkspaceAppByAgentIDAndName(r.Context(), database.GetWorkspaceAppByAgentIDAndNameParams{
-		AgentID: agent.ID,
+		AgentID: workspaceAgent.ID,
 		Name:    chi.URLParam(r, "workspaceapp"),
 	})
 	if errors.Is(err, sql.ErrNoRows) {
@@ -76,6 +94,24 @@ func (api *API) workspaceAppsProxyPath(rw http.ResponseWriter, r *http.Request)
 		api.siteHandler.ServeHTTP(w, r)
 	}
 	path := chi.URLParam(r, "*")
+	if usingAppToken && r.URL.Query().Has(codersdk.WorkspaceAppTokenKey) {
+		// The token is moved to a cookie so requests the app makes for
+		// its own resources are authorized too.
+		http.SetCookie(rw, &http.Cookie{
+			Name:     codersdk.WorkspaceAppTokenKey,
+			Value:    r.URL.Query().Get(codersdk.WorkspaceAppTokenKey),
+			Path:     strings.TrimSuffix(r.URL.EscapedPath(), path),
+			Expires:  appToken.ExpiresAt,
+			HttpOnly: true,
+			Secure:   api.SecureAuthCookie,
+			SameSite: http.SameSiteLaxMode,
+		})
+		q := r.URL.Query()
+		q.Del(codersdk.WorkspaceAppTokenKey)
+		r.URL.RawQuery = q.Encode()
+		http.Redirect(rw, r, r.URL.String(), http.StatusTemporaryRedirect)
+		return
+	}
 	if !strings.HasSuffix(r.URL.Path, "/") && path == "" {
 		// Web applications typically request paths relative to the
 		// root URL. This allows for routing behind a proxy or subpath.
@@ -96,9 +132,9 @@ func (api *API) workspaceAppsProxyPath(rw http.ResponseWriter, r *http.Request)
 	}
 	r.URL.Path = path
 
-	conn, release, err := api.workspaceAgentCache.Acquire(r, agent.ID)
+	conn, release, err := api.workspaceAgentCache.Acquire(r, workspaceAgent.ID)
 	if err != nil {
-		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+		httpapi.Write(rw, agentDialErrorStatus(err), codersdk.Response{
 			Message: "Failed to dial workspace agent.",
 			Detail:  err.Error(),
 		})
@@ -119,3 +155,131 @@ func (api *API) workspaceAppsProxyPath(rw http.ResponseWriter, r *http.Request)
 
 	proxy.ServeHTTP(rw, r)
 }
+
+// Workspace app tokens are short-lived by default, since they're shared
+// with people outside of the deployment.
+const (
+	defaultWorkspaceAppTokenLifetime = time.Hour
+	maxWorkspaceAppTokenLifetime     = 7 * 24 * time.Hour
+)
+
+// postWorkspaceAppToken mints a token granting access to a single app of
+// the workspace, for sharing the app with people who can't otherwise access
+// the workspace.
+func (api *API) postWorkspaceAppToken(rw http.ResponseWriter, r *http.Request) {
+	workspace := httpmw.WorkspaceParam(r)
+	apiKey := httpmw.APIKey(r)
+
+	// The token grants the same access to the app as its creator has.
+	if !api.Authorize(r, rbac.ActionCreate, workspace.ExecutionRBAC()) {
+		httpapi.ResourceNotFound(rw)
+		return
+	}
+
+	var req codersdk.CreateWorkspaceAppTokenRequest
+	if !httpapi.Read(rw, r, &req) {
+		return
+	}
+	lifetime := time.Duration(req.LifetimeSeconds) * time.Second
+	if lifetime == 0 {
+		lifetime = defaultWorkspaceAppTokenLifetime
+	}
+	if lifetime < 0 || lifetime > maxWorkspaceAppTokenLifetime {
+		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+			Message: "Invalid workspace app token lifetime.",
+			Validations: []codersdk.ValidationError{{
+				Field:  "lifetime_seconds",
+				Detail: fmt.Sprintf("Must be positive and at most %s.", maxWorkspaceAppTokenLifetime),
+			}},
+		})
+		return
+	}
+
+	exists, err := api.workspaceHasApp(r, workspace, req.AppName)
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error fetching workspace applications.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+	if !exists {
+		httpapi.Write(rw, http.StatusBadRequest, codersdk.Response{
+			Message: fmt.Sprintf("Workspace does not have an application named %q.", req.AppName),
+			Validations: []codersdk.ValidationError{{
+				Field:  "app_name",
+				Detail: "Must be the name of an application in the workspace.",
+			}},
+		})
+		return
+	}
+
+	tokenID, tokenSecret, err := generateAPIKeyIDSecret()
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error generating workspace app token.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+	hashed := sha256.Sum256([]byte(tokenSecret))
+	now := database.Now()
+	token, err := api.Database.InsertWorkspaceAppToken(r.Context(), database.InsertWorkspaceAppTokenParams{
+		ID:           tokenID,
+		HashedSecret: hashed[:],
+		UserID:       apiKey.UserID,
+		WorkspaceID:  workspace.ID,
+		AppName:      req.AppName,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(lifetime),
+	})
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error inserting workspace app token.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+
+	httpapi.Write(rw, http.StatusCreated, codersdk.WorkspaceAppToken{
+		// This format is consumed by the workspace app token middleware.
+		Token:     fmt.Sprintf("%s-%s", tokenID, tokenSecret),
+		AppName:   token.AppName,
+		ExpiresAt: token.ExpiresAt,
+	})
+}
+
+// workspaceHasApp returns whether an agent of the latest build of the
+// workspace has an app with the name provided.
+func (api *API) workspaceHasApp(r *http.Request, workspace database.Workspace, name string) (bool, error) {
+	build, err := api.Database.GetLatestWorkspaceBuildByWorkspaceID(r.Context(), workspace.ID)
+	if err != nil {
+		return false, xerrors.Errorf("get latest workspace build: %w", err)
+	}
+	resources, err := api.Database.GetWorkspaceResourcesByJobID(r.Context(), build.JobID)
+	if err != nil {
+		return false, xerrors.Errorf("get workspace resources: %w", err)
+	}
+	resourceIDs := make([]uuid.UUID, 0, len(resources))
+	for _, resource := range resources {
+		resourceIDs = append(resourceIDs, resource.ID)
+	}
+	agents, err := api.Database.GetWorkspaceAgentsByResourceIDs(r.Context(), resourceIDs)
+	if err != nil {
+		return false, xerrors.Errorf("get workspace agents: %w", err)
+	}
+	agentIDs := make([]uuid.UUID, 0, len(agents))
+	for _, workspaceAgent := range agents {
+		agentIDs = append(agentIDs, workspaceAgent.ID)
+	}
+	apps, err := api.Database.GetWorkspaceAppsByAgentIDs(r.Context(), agentIDs)
+	if err != nil {
+		return false, xerrors.Errorf("get workspace apps: %w", err)
+	}
+	for _, app := range apps {
+		if app.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
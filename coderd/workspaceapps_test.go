@@ -164,4 +164,75 @@ func TestWorkspaceAppsProxyPath(t *testing.T) {
 		defer resp.Body.Close()
 		require.Equal(t, http.StatusOK, resp.StatusCode)
 	})
+
+	t.Run("AppToken", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+
+		_, err := client.CreateWorkspaceAppToken(ctx, workspace.ID, codersdk.CreateWorkspaceAppTokenRequest{
+			AppName: "missing",
+		})
+		var apiErr *codersdk.Error
+		require.ErrorAs(t, err, &apiErr)
+		require.Equal(t, http.StatusBadRequest, apiErr.StatusCode())
+
+		owner, err := client.User(ctx, codersdk.Me)
+		require.NoError(t, err)
+		token, err := client.CreateWorkspaceAppToken(ctx, workspace.ID, codersdk.CreateWorkspaceAppTokenRequest{
+			AppName: "example",
+		})
+		require.NoError(t, err)
+
+		// Whoever the token is shared with doesn't have a session.
+		anonymous := codersdk.New(client.URL)
+		anonymous.HTTPClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		appPath := "/@" + owner.Username + "/" + workspace.Name + "/apps/"
+
+		resp, err := anonymous.Request(ctx, http.MethodGet, appPath+"example/?query=true&"+codersdk.WorkspaceAppTokenKey+"="+token.Token, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+		location, err := resp.Location()
+		require.NoError(t, err)
+		require.Equal(t, "query=true", location.RawQuery)
+		var tokenCookie *http.Cookie
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == codersdk.WorkspaceAppTokenKey {
+				tokenCookie = cookie
+			}
+		}
+		require.NotNil(t, tokenCookie)
+		withTokenCookie := func(r *http.Request) {
+			r.AddCookie(tokenCookie)
+		}
+
+		resp, err = anonymous.Request(ctx, http.MethodGet, appPath+"example/?query=true", nil, withTokenCookie)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// The token doesn't grant access to other apps.
+		resp, err = anonymous.Request(ctx, http.MethodGet, appPath+"fake/", nil, withTokenCookie)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		expiring, err := client.CreateWorkspaceAppToken(ctx, workspace.ID, codersdk.CreateWorkspaceAppTokenRequest{
+			AppName:         "example",
+			LifetimeSeconds: 1,
+		})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			resp, err := anonymous.Request(ctx, http.MethodGet, appPath+"example/?query=true&"+codersdk.WorkspaceAppTokenKey+"="+expiring.Token, nil)
+			if !assert.NoError(t, err) {
+				return false
+			}
+			defer resp.Body.Close()
+			return resp.StatusCode == http.StatusUnauthorized
+		}, testutil.WaitShort, testutil.IntervalFast)
+	})
 }
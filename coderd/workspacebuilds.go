@@ -584,7 +584,15 @@ func (api *API) workspaceBuildResources(rw http.ResponseWriter, r *http.Request)
 		})
 		return
 	}
-	api.provisionerJobResources(rw, r, job)
+	inactiveTimeout, err := api.agentInactiveDisconnectTimeout(r.Context(), workspace)
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error fetching workspace template.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+	api.provisionerJobResources(rw, r, job, inactiveTimeout)
 }
 
 func (api *API) workspaceBuildLogs(rw http.ResponseWriter, r *http.Request) {
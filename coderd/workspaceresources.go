@@ -61,6 +61,22 @@ func (api *API) workspaceResource(rw http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	phases, err := api.Database.GetWorkspaceAgentStartupPhasesByAgentIDs(r.Context(), agentIDs)
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error fetching workspace agent startup phases.",
+			Detail:  err.Error(),
+		})
+		return
+	}
+	inactiveTimeout, err := api.agentInactiveDisconnectTimeout(r.Context(), workspace)
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
+			Message: "Internal error fetching workspace template.",
+			Detail:  err.Error(),
+		})
+		return
+	}
 	apiAgents := make([]codersdk.WorkspaceAgent, 0)
 	for _, agent := range agents {
 		dbApps := make([]database.WorkspaceApp, 0)
@@ -70,7 +86,7 @@ func (api *API) workspaceResource(rw http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		convertedAgent, err := convertWorkspaceAgent(agent, convertApps(dbApps), api.AgentInactiveDisconnectTimeout)
+		convertedAgent, err := convertWorkspaceAgent(agent, convertApps(dbApps), convertStartupPhases(phases, agent.ID), inactiveTimeout)
 		if err != nil {
 			httpapi.Write(rw, http.StatusInternalServerError, codersdk.Response{
 				Message: "Internal error reading workspace agent.",
@@ -108,6 +108,9 @@ func TestWorkspaceResource(t *testing.T) {
 		require.Equal(t, app.Command, got.Command)
 		require.Equal(t, app.Icon, got.Icon)
 		require.Equal(t, app.Name, got.Name)
+		require.Equal(t, app.Url, got.URL)
+		require.EqualValues(t, 3000, got.Port)
+		require.Equal(t, codersdk.WorkspaceAppRoutingPort, got.Routing)
 	})
 
 	t.Run("Metadata", func(t *testing.T) {
@@ -1,7 +1,9 @@
 package coderd_test
 
 import (
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
@@ -1288,3 +1290,50 @@ func mustLocation(t *testing.T, location string) *time.Location {
 
 	return loc
 }
+
+func TestWorkspacesCompressed(t *testing.T) {
+	t.Parallel()
+	client := coderdtest.New(t, &coderdtest.Options{IncludeProvisionerD: true})
+	user := coderdtest.CreateFirstUser(t, client)
+	version := coderdtest.CreateTemplateVersion(t, client, user.OrganizationID, nil)
+	coderdtest.AwaitTemplateVersionJob(t, client, version.ID)
+	template := coderdtest.CreateTemplate(t, client, user.OrganizationID, version.ID)
+	for i := 0; i < 3; i++ {
+		coderdtest.CreateWorkspace(t, client, user.OrganizationID, template.ID)
+	}
+
+	list := func(t *testing.T, query string) *http.Response {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+		defer cancel()
+		// Setting the header stops the transport from decompressing for us.
+		res, err := client.Request(ctx, http.MethodGet, "/api/v2/workspaces"+query, nil, func(r *http.Request) {
+			r.Header.Set("Accept-Encoding", "gzip")
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = res.Body.Close() })
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Contains(t, res.Header.Values("Vary"), "Accept-Encoding")
+		return res
+	}
+
+	t.Run("BelowThreshold", func(t *testing.T) {
+		t.Parallel()
+		res := list(t, "?q=name:missing")
+		require.Empty(t, res.Header.Get("Content-Encoding"))
+		var workspaces []codersdk.Workspace
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&workspaces))
+		require.Empty(t, workspaces)
+	})
+
+	t.Run("AboveThreshold", func(t *testing.T) {
+		t.Parallel()
+		res := list(t, "")
+		require.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+		body, err := gzip.NewReader(res.Body)
+		require.NoError(t, err)
+		var workspaces []codersdk.Workspace
+		require.NoError(t, json.NewDecoder(body).Decode(&workspaces))
+		require.Len(t, workspaces, 3)
+	})
+}
@@ -0,0 +1,29 @@
+package codersdk
+
+// BatchResponse is returned by endpoints that act on many items at once.
+// Each item succeeds or fails on its own, so one invalid item doesn't fail
+// the rest. Results are in the order the items were requested.
+// @typescript-ignore BatchResponse, BatchResult
+type BatchResponse[T any] struct {
+	Results []BatchResult[T] `json:"results"`
+}
+
+// BatchResult is the outcome of a single item in a batch. Status is the
+// HTTP status the item would have had on its own. Error is set when the
+// item failed, and Result when it succeeded.
+type BatchResult[T any] struct {
+	Status int       `json:"status"`
+	Error  *Response `json:"error,omitempty"`
+	Result *T        `json:"result,omitempty"`
+}
+
+// Failed returns the results of items that didn't succeed.
+func (b BatchResponse[T]) Failed() []BatchResult[T] {
+	failed := make([]BatchResult[T], 0)
+	for _, result := range b.Results {
+		if result.Error != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
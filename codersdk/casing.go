@@ -0,0 +1,17 @@
+package codersdk
+
+const (
+	// AcceptCasingHeader requests the top-level field names of a response
+	// in a casing other than the default snake_case. CasingQueryParam may
+	// be used instead where headers can't be set.
+	AcceptCasingHeader = "Accept-Casing"
+	CasingQueryParam   = "casing"
+)
+
+// Casing is a naming convention for the field names of a response.
+type Casing string
+
+const (
+	CasingSnake Casing = "snake_case"
+	CasingCamel Casing = "camelCase"
+)
@@ -10,9 +10,13 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/xerrors"
 	"nhooyr.io/websocket"
+
+	"cdr.dev/slog"
 )
 
 // These cookies are Coder-specific. If a new one is added or changed, the name
@@ -39,6 +43,17 @@ type Client struct {
 	HTTPClient   *http.Client
 	SessionToken string
 	URL          *url.URL
+
+	// Logger is optional and receives warnings about responses, such as
+	// requests made to deprecated endpoints.
+	Logger slog.Logger
+
+	// RoleCacheTTL is how long assignable role lists are reused before
+	// asking the server again. Zero disables caching.
+	RoleCacheTTL time.Duration
+
+	roleCacheMutex sync.Mutex
+	roleCache      map[roleCacheKey]roleCacheEntry
 }
 
 type requestOption func(*http.Request)
@@ -85,9 +100,28 @@ func (c *Client) Request(ctx context.Context, method, path string, body interfac
 	if err != nil {
 		return nil, xerrors.Errorf("do: %w", err)
 	}
+	c.warnDeprecated(ctx, method, path, resp.Header)
 	return resp, err
 }
 
+// warnDeprecated logs a warning if the response headers mark the endpoint
+// as deprecated.
+func (c *Client) warnDeprecated(ctx context.Context, method, path string, header http.Header) {
+	deprecation, ok := DeprecationFromHeader(header)
+	if !ok {
+		return
+	}
+	fields := []slog.Field{
+		slog.F("method", method),
+		slog.F("path", path),
+		slog.F("message", deprecation.Message),
+	}
+	if deprecation.Sunset != nil {
+		fields = append(fields, slog.F("sunset", *deprecation.Sunset))
+	}
+	c.Logger.Warn(ctx, "request to deprecated endpoint", fields...)
+}
+
 // dialWebsocket opens a dialWebsocket connection on that path provided.
 // The caller is responsible for closing the dialWebsocket.Conn.
 func (c *Client) dialWebsocket(ctx context.Context, path string) (*websocket.Conn, error) {
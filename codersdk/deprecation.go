@@ -0,0 +1,41 @@
+package codersdk
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// DeprecationHeader is set on responses from deprecated endpoints.
+	// See: https://datatracker.ietf.org/doc/html/draft-ietf-httpapi-deprecation-header
+	DeprecationHeader = "Deprecation"
+	// SunsetHeader is the date a deprecated endpoint will be removed.
+	// See: https://datatracker.ietf.org/doc/html/rfc8594
+	SunsetHeader = "Sunset"
+	// DeprecationMessageHeader describes what replaces a deprecated endpoint.
+	DeprecationMessageHeader = "Coder-Deprecation-Message"
+)
+
+// Deprecation describes an endpoint that is scheduled for removal.
+type Deprecation struct {
+	// Message is a human-readable explanation of what to use instead.
+	Message string `json:"message"`
+	// Sunset is when the endpoint will be removed, if it's known.
+	Sunset *time.Time `json:"sunset,omitempty"`
+}
+
+// DeprecationFromHeader parses the deprecation headers of a response.
+// False is returned if the response isn't from a deprecated endpoint.
+func DeprecationFromHeader(header http.Header) (Deprecation, bool) {
+	if header.Get(DeprecationHeader) == "" {
+		return Deprecation{}, false
+	}
+	deprecation := Deprecation{
+		Message: header.Get(DeprecationMessageHeader),
+	}
+	sunset, err := http.ParseTime(header.Get(SunsetHeader))
+	if err == nil {
+		deprecation.Sunset = &sunset
+	}
+	return deprecation, true
+}
@@ -0,0 +1,45 @@
+package codersdk_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/coder/codersdk"
+)
+
+func TestDeprecationFromHeader(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NotDeprecated", func(t *testing.T) {
+		t.Parallel()
+		_, ok := codersdk.DeprecationFromHeader(http.Header{})
+		require.False(t, ok)
+	})
+
+	t.Run("Sunset", func(t *testing.T) {
+		t.Parallel()
+		sunset := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+		header := http.Header{}
+		header.Set(codersdk.DeprecationHeader, "true")
+		header.Set(codersdk.DeprecationMessageHeader, "Use /new instead.")
+		header.Set(codersdk.SunsetHeader, sunset.Format(http.TimeFormat))
+		deprecation, ok := codersdk.DeprecationFromHeader(header)
+		require.True(t, ok)
+		require.Equal(t, "Use /new instead.", deprecation.Message)
+		require.NotNil(t, deprecation.Sunset)
+		require.True(t, sunset.Equal(*deprecation.Sunset))
+	})
+
+	t.Run("InvalidSunset", func(t *testing.T) {
+		t.Parallel()
+		header := http.Header{}
+		header.Set(codersdk.DeprecationHeader, "true")
+		header.Set(codersdk.SunsetHeader, "soon")
+		deprecation, ok := codersdk.DeprecationFromHeader(header)
+		require.True(t, ok)
+		require.Nil(t, deprecation.Sunset)
+	})
+}
@@ -0,0 +1,36 @@
+package codersdk
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// DERPRegionLatency is the result of probing a DERP region from coderd.
+type DERPRegionLatency struct {
+	RegionID   int    `json:"region_id"`
+	RegionCode string `json:"region_code"`
+	RegionName string `json:"region_name"`
+	Reachable  bool   `json:"reachable"`
+	// LatencyMS is the round trip of the probe request. It's zero when
+	// the region is unreachable.
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// DERPLatency probes every DERP region configured on the server. Reachable
+// regions are returned first, fastest to slowest.
+func (c *Client) DERPLatency(ctx context.Context) ([]DERPRegionLatency, error) {
+	res, err := c.Request(ctx, http.MethodGet, "/api/v2/derp/latency", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, readBodyAsError(res)
+	}
+
+	var latencies []DERPRegionLatency
+	return latencies, json.NewDecoder(res.Body).Decode(&latencies)
+}
@@ -3,6 +3,7 @@ package codersdk
 import (
 	"fmt"
 	"net"
+	"net/http"
 
 	"golang.org/x/xerrors"
 )
@@ -25,6 +26,8 @@ type Response struct {
 	// shown on a form field in the UI. These can also be used to add additional
 	// context if there is a set of errors in the primary 'Message'.
 	Validations []ValidationError `json:"validations,omitempty"`
+	// Deprecation is set when the endpoint that responded is deprecated.
+	Deprecation *Deprecation `json:"deprecation,omitempty"`
 }
 
 // ValidationError represents a scoped error to a user input.
@@ -51,3 +54,38 @@ func IsConnectionErr(err error) bool {
 
 	return xerrors.As(err, &dnsErr) || xerrors.As(err, &opErr)
 }
+
+// IsNotFound returns whether err is an API error because the resource doesn't
+// exist, or the caller isn't allowed to know that it does.
+func IsNotFound(err error) bool {
+	return isStatusCode(err, http.StatusNotFound)
+}
+
+// IsUnauthorized returns whether err is an API error because the caller
+// isn't authenticated.
+func IsUnauthorized(err error) bool {
+	return isStatusCode(err, http.StatusUnauthorized)
+}
+
+// IsForbidden returns whether err is an API error because the caller isn't
+// allowed to perform the action.
+func IsForbidden(err error) bool {
+	return isStatusCode(err, http.StatusForbidden)
+}
+
+// IsValidation returns whether err is an API error because the request was
+// invalid. The Validations of the error say which fields were at fault.
+func IsValidation(err error) bool {
+	return isStatusCode(err, http.StatusBadRequest)
+}
+
+// IsRateLimited returns whether err is an API error because the caller made
+// too many requests.
+func IsRateLimited(err error) bool {
+	return isStatusCode(err, http.StatusTooManyRequests)
+}
+
+func isStatusCode(err error, statusCode int) bool {
+	var apiErr *Error
+	return xerrors.As(err, &apiErr) && apiErr.StatusCode() == statusCode
+}
@@ -1,14 +1,20 @@
 package codersdk_test
 
 import (
+	"context"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 	"golang.org/x/xerrors"
 
+	"github.com/coder/coder/coderd/httpapi"
 	"github.com/coder/coder/codersdk"
+	"github.com/coder/coder/testutil"
 )
 
 func TestIsConnectionErr(t *testing.T) {
@@ -63,3 +69,62 @@ func TestIsConnectionErr(t *testing.T) {
 		})
 	}
 }
+
+func TestErrorStatusHelpers(t *testing.T) {
+	t.Parallel()
+
+	helpers := map[string]func(error) bool{
+		"IsNotFound":     codersdk.IsNotFound,
+		"IsUnauthorized": codersdk.IsUnauthorized,
+		"IsForbidden":    codersdk.IsForbidden,
+		"IsValidation":   codersdk.IsValidation,
+		"IsRateLimited":  codersdk.IsRateLimited,
+	}
+	cases := []struct {
+		status int
+		helper string
+	}{
+		{status: http.StatusNotFound, helper: "IsNotFound"},
+		{status: http.StatusUnauthorized, helper: "IsUnauthorized"},
+		{status: http.StatusForbidden, helper: "IsForbidden"},
+		{status: http.StatusBadRequest, helper: "IsValidation"},
+		{status: http.StatusTooManyRequests, helper: "IsRateLimited"},
+		{status: http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(http.StatusText(c.status), func(t *testing.T) {
+			t.Parallel()
+
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				httpapi.Write(rw, c.status, codersdk.Response{
+					Message: "Something went wrong.",
+					Validations: []codersdk.ValidationError{
+						{Field: "name", Detail: "invalid"},
+					},
+				})
+			}))
+			t.Cleanup(srv.Close)
+			serverURL, err := url.Parse(srv.URL)
+			require.NoError(t, err)
+			client := codersdk.New(serverURL)
+
+			ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitShort)
+			defer cancel()
+			_, err = client.BuildInfo(ctx)
+			require.Error(t, err)
+
+			// The structured response is available to callers too.
+			var apiErr *codersdk.Error
+			require.ErrorAs(t, err, &apiErr)
+			require.Equal(t, c.status, apiErr.StatusCode())
+			require.Equal(t, "Something went wrong.", apiErr.Message)
+			require.Len(t, apiErr.Validations, 1)
+
+			for name, helper := range helpers {
+				require.Equal(t, name == c.helper, helper(xerrors.Errorf("wrapped: %w", err)), name)
+			}
+			require.False(t, codersdk.IsNotFound(xerrors.New("not an api error")))
+		})
+	}
+}
@@ -73,6 +73,16 @@ type CreateTemplateRequest struct {
 	// allowable duration between autostarts for all workspaces created from
 	// this template.
 	MinAutostartIntervalMillis *int64 `json:"min_autostart_interval_ms,omitempty"`
+
+	// AgentInactiveDisconnectTimeoutMillis allows optionally overriding how
+	// long agents of workspaces created from this template may go without a
+	// heartbeat before they're considered disconnected.
+	AgentInactiveDisconnectTimeoutMillis *int64 `json:"agent_inactive_disconnect_timeout_ms,omitempty"`
+
+	// TerminalIdleTimeoutMillis allows optionally overriding how long web
+	// terminals of workspaces created from this template may go without
+	// input before they're closed.
+	TerminalIdleTimeoutMillis *int64 `json:"terminal_idle_timeout_ms,omitempty"`
 }
 
 // CreateWorkspaceRequest provides options for creating a new workspace.
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -19,9 +20,116 @@ type AssignableRoles struct {
 	Assignable bool `json:"assignable"`
 }
 
+// RoleScope is the level a role permission applies at.
+type RoleScope string
+
+const (
+	// RoleScopeSite permissions apply everywhere.
+	RoleScopeSite RoleScope = "site"
+	// RoleScopeOrganization permissions apply to everything in an organization.
+	RoleScopeOrganization RoleScope = "organization"
+	// RoleScopeUser permissions apply to resources the user owns.
+	RoleScopeUser RoleScope = "user"
+)
+
+// RolePermission is a single action on a resource type that a role allows,
+// or denies if Negate is set. "*" is a wildcard for either.
+type RolePermission struct {
+	Negate       bool      `json:"negate"`
+	ResourceType string    `json:"resource_type"`
+	Action       string    `json:"action"`
+	Scope        RoleScope `json:"scope"`
+}
+
+// RoleWithPermissions is a role and everything it grants.
+type RoleWithPermissions struct {
+	Role
+	Permissions []RolePermission `json:"permissions"`
+}
+
 // ListSiteRoles lists all assignable site wide roles.
 func (c *Client) ListSiteRoles(ctx context.Context) ([]AssignableRoles, error) {
-	res, err := c.Request(ctx, http.MethodGet, "/api/v2/users/roles", nil)
+	return c.listAssignableRoles(ctx, "/api/v2/users/roles")
+}
+
+// ListOrganizationRoles lists all assignable roles for a given organization.
+func (c *Client) ListOrganizationRoles(ctx context.Context, org uuid.UUID) ([]AssignableRoles, error) {
+	return c.listAssignableRoles(ctx, fmt.Sprintf("/api/v2/organizations/%s/members/roles", org.String()))
+}
+
+// roleCacheKey identifies a cached role list. Lists depend on who's asking,
+// so the session token is part of the key.
+type roleCacheKey struct {
+	sessionToken string
+	path         string
+}
+
+// roleCacheEntry is an assignable role list kept by the client while
+// RoleCacheTTL is set.
+type roleCacheEntry struct {
+	roles   []AssignableRoles
+	etag    string
+	fetched time.Time
+}
+
+// listAssignableRoles fetches the assignable roles at path. With
+// RoleCacheTTL set, a fresh cached list is returned without a request, and
+// a stale one is revalidated with its ETag.
+func (c *Client) listAssignableRoles(ctx context.Context, path string) ([]AssignableRoles, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key := roleCacheKey{sessionToken: c.SessionToken, path: path}
+	c.roleCacheMutex.Lock()
+	cached, ok := c.roleCache[key]
+	c.roleCacheMutex.Unlock()
+	if c.RoleCacheTTL <= 0 {
+		ok = false
+	}
+	if ok && time.Since(cached.fetched) < c.RoleCacheTTL {
+		return append([]AssignableRoles(nil), cached.roles...), nil
+	}
+
+	res, err := c.Request(ctx, http.MethodGet, path, nil, func(r *http.Request) {
+		if ok && cached.etag != "" {
+			r.Header.Set("If-None-Match", cached.etag)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var roles []AssignableRoles
+	switch {
+	case res.StatusCode == http.StatusNotModified && ok:
+		roles = cached.roles
+	case res.StatusCode == http.StatusOK:
+		err = json.NewDecoder(res.Body).Decode(&roles)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, readBodyAsError(res)
+	}
+
+	if c.RoleCacheTTL > 0 {
+		c.roleCacheMutex.Lock()
+		if c.roleCache == nil {
+			c.roleCache = map[roleCacheKey]roleCacheEntry{}
+		}
+		c.roleCache[key] = roleCacheEntry{
+			roles:   roles,
+			etag:    res.Header.Get("ETag"),
+			fetched: time.Now(),
+		}
+		c.roleCacheMutex.Unlock()
+	}
+	return append([]AssignableRoles(nil), roles...), nil
+}
+
+// ListSiteRolePermissions lists all site wide roles with what they grant.
+func (c *Client) ListSiteRolePermissions(ctx context.Context) ([]RoleWithPermissions, error) {
+	res, err := c.Request(ctx, http.MethodGet, "/api/v2/users/roles/permissions", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -29,13 +137,14 @@ func (c *Client) ListSiteRoles(ctx context.Context) ([]AssignableRoles, error) {
 	if res.StatusCode != http.StatusOK {
 		return nil, readBodyAsError(res)
 	}
-	var roles []AssignableRoles
+	var roles []RoleWithPermissions
 	return roles, json.NewDecoder(res.Body).Decode(&roles)
 }
 
-// ListOrganizationRoles lists all assignable roles for a given organization.
-func (c *Client) ListOrganizationRoles(ctx context.Context, org uuid.UUID) ([]AssignableRoles, error) {
-	res, err := c.Request(ctx, http.MethodGet, fmt.Sprintf("/api/v2/organizations/%s/members/roles", org.String()), nil)
+// ListOrganizationRolePermissions lists all roles for a given organization
+// with what they grant.
+func (c *Client) ListOrganizationRolePermissions(ctx context.Context, org uuid.UUID) ([]RoleWithPermissions, error) {
+	res, err := c.Request(ctx, http.MethodGet, fmt.Sprintf("/api/v2/organizations/%s/members/roles/permissions", org.String()), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -43,7 +152,7 @@ func (c *Client) ListOrganizationRoles(ctx context.Context, org uuid.UUID) ([]As
 	if res.StatusCode != http.StatusOK {
 		return nil, readBodyAsError(res)
 	}
-	var roles []AssignableRoles
+	var roles []RoleWithPermissions
 	return roles, json.NewDecoder(res.Body).Decode(&roles)
 }
 
@@ -0,0 +1,80 @@
+package codersdk_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+
+	"github.com/coder/coder/coderd/httpapi"
+	"github.com/coder/coder/codersdk"
+	"github.com/coder/coder/testutil"
+)
+
+func TestListSiteRolesCache(t *testing.T) {
+	t.Parallel()
+	var (
+		requests    atomic.Int64
+		notModified atomic.Int64
+		roleName    atomic.String
+	)
+	roleName.Store("admin")
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests.Inc()
+		if r.Header.Get("If-None-Match") != "" {
+			notModified.Inc()
+		}
+		httpapi.WriteCacheable(rw, r, http.StatusOK, []codersdk.AssignableRoles{{
+			Role:       codersdk.Role{Name: roleName.Load()},
+			Assignable: true,
+		}})
+	}))
+	t.Cleanup(srv.Close)
+	serverURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	client := codersdk.New(serverURL)
+	client.RoleCacheTTL = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	roles, err := client.ListSiteRoles(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "admin", roles[0].Name)
+	roles, err = client.ListSiteRoles(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "admin", roles[0].Name)
+	require.EqualValues(t, 1, requests.Load(), "second call within the TTL must not re-fetch")
+
+	// Once the TTL has passed the cached list is revalidated by ETag, and
+	// a changed role set replaces it.
+	client.RoleCacheTTL = time.Nanosecond
+	roles, err = client.ListSiteRoles(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "admin", roles[0].Name)
+	require.EqualValues(t, 2, requests.Load())
+	require.EqualValues(t, 1, notModified.Load())
+
+	roleName.Store("auditor")
+	roles, err = client.ListSiteRoles(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "auditor", roles[0].Name)
+	require.EqualValues(t, 3, requests.Load())
+
+	// Lists aren't shared between session tokens.
+	client.RoleCacheTTL = time.Hour
+	client.SessionToken = "other"
+	_, err = client.ListSiteRoles(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 4, requests.Load())
+	require.EqualValues(t, 2, notModified.Load(), "another token's list must not be revalidated")
+
+	cancel()
+	_, err = client.ListSiteRoles(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
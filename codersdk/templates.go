@@ -28,6 +28,14 @@ type Template struct {
 	MinAutostartIntervalMillis int64           `json:"min_autostart_interval_ms"`
 	CreatedByID                uuid.UUID       `json:"created_by_id"`
 	CreatedByName              string          `json:"created_by_name"`
+	// AgentInactiveDisconnectTimeoutMillis is how long agents may go without
+	// a heartbeat before they're considered disconnected. Zero means the
+	// deployment-wide timeout applies.
+	AgentInactiveDisconnectTimeoutMillis int64 `json:"agent_inactive_disconnect_timeout_ms"`
+	// TerminalIdleTimeoutMillis is how long web terminals may go without
+	// input before they're closed. Zero means the deployment-wide timeout
+	// applies.
+	TerminalIdleTimeoutMillis int64 `json:"terminal_idle_timeout_ms"`
 }
 
 type UpdateActiveTemplateVersion struct {
@@ -35,11 +43,13 @@ type UpdateActiveTemplateVersion struct {
 }
 
 type UpdateTemplateMeta struct {
-	Name                       string `json:"name,omitempty" validate:"omitempty,username"`
-	Description                string `json:"description,omitempty"`
-	Icon                       string `json:"icon,omitempty"`
-	MaxTTLMillis               int64  `json:"max_ttl_ms,omitempty"`
-	MinAutostartIntervalMillis int64  `json:"min_autostart_interval_ms,omitempty"`
+	Name                                 string `json:"name,omitempty" validate:"omitempty,username"`
+	Description                          string `json:"description,omitempty"`
+	Icon                                 string `json:"icon,omitempty"`
+	MaxTTLMillis                         int64  `json:"max_ttl_ms,omitempty"`
+	MinAutostartIntervalMillis           int64  `json:"min_autostart_interval_ms,omitempty"`
+	AgentInactiveDisconnectTimeoutMillis int64  `json:"agent_inactive_disconnect_timeout_ms,omitempty"`
+	TerminalIdleTimeoutMillis            int64  `json:"terminal_idle_timeout_ms,omitempty"`
 }
 
 // Template returns a single template.
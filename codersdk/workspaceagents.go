@@ -8,6 +8,8 @@ import (
 	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"strconv"
+	"time"
 
 	"cloud.google.com/go/compute/metadata"
 	"github.com/google/uuid"
@@ -192,7 +194,8 @@ func (c *Client) ListenWorkspaceAgent(ctx context.Context, logger slog.Logger) (
 		Value: c.SessionToken,
 	}})
 	httpClient := &http.Client{
-		Jar: jar,
+		Jar:       jar,
+		Transport: c.HTTPClient.Transport,
 	}
 	conn, res, err := websocket.Dial(ctx, serverURL.String(), &websocket.DialOptions{
 		HTTPClient: httpClient,
@@ -288,7 +291,8 @@ func (c *Client) WireguardPeerListener(ctx context.Context, logger slog.Logger)
 		Value: c.SessionToken,
 	}})
 	httpClient := &http.Client{
-		Jar: jar,
+		Jar:       jar,
+		Transport: c.HTTPClient.Transport,
 	}
 
 	conn, res, err := websocket.Dial(ctx, serverURL.String(), &websocket.DialOptions{
@@ -358,7 +362,8 @@ func (c *Client) DialWorkspaceAgent(ctx context.Context, agentID uuid.UUID, opti
 		Value: c.SessionToken,
 	}})
 	httpClient := &http.Client{
-		Jar: jar,
+		Jar:       jar,
+		Transport: c.HTTPClient.Transport,
 	}
 	conn, res, err := websocket.Dial(ctx, serverURL.String(), &websocket.DialOptions{
 		HTTPClient: httpClient,
@@ -371,6 +376,8 @@ func (c *Client) DialWorkspaceAgent(ctx context.Context, agentID uuid.UUID, opti
 		}
 		return nil, readBodyAsError(res)
 	}
+	c.warnDeprecated(ctx, http.MethodGet, serverURL.Path, res.Header)
+	sessionID := res.Header.Get(AgentSessionIDHeader)
 	config := yamux.DefaultConfig()
 	config.LogOutput = io.Discard
 	session, err := yamux.Client(websocket.NetConn(ctx, conn, websocket.MessageBinary), config)
@@ -411,10 +418,101 @@ func (c *Client) DialWorkspaceAgent(ctx context.Context, agentID uuid.UUID, opti
 	}
 	return &agent.Conn{
 		Negotiator: client,
+		SessionID:  sessionID,
 		Conn:       peerConn,
 	}, nil
 }
 
+type PostWorkspaceAgentStartupPhaseRequest struct {
+	Phase agent.StartupPhase `json:"phase" validate:"required"`
+}
+
+// PostWorkspaceAgentStartupPhase records that the agent has begun a
+// startup phase.
+func (c *Client) PostWorkspaceAgentStartupPhase(ctx context.Context, phase agent.StartupPhase) error {
+	res, err := c.Request(ctx, http.MethodPost, "/api/v2/workspaceagents/me/startupphase", PostWorkspaceAgentStartupPhaseRequest{
+		Phase: phase,
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return readBodyAsError(res)
+	}
+	return nil
+}
+
+type PostWorkspaceAgentDirectoryRequest struct {
+	Exists bool `json:"exists"`
+}
+
+// PostWorkspaceAgentDirectory reports whether the agent's directory
+// exists.
+func (c *Client) PostWorkspaceAgentDirectory(ctx context.Context, exists bool) error {
+	res, err := c.Request(ctx, http.MethodPost, "/api/v2/workspaceagents/me/directory", PostWorkspaceAgentDirectoryRequest{
+		Exists: exists,
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return readBodyAsError(res)
+	}
+	return nil
+}
+
+type PostWorkspaceAgentNetworkRequest struct {
+	PublicIP string        `json:"public_ip" validate:"required,ip"`
+	NATType  agent.NATType `json:"nat_type" validate:"required"`
+}
+
+// PostWorkspaceAgentNetwork reports the public IP and NAT type the agent's
+// STUN servers observe.
+func (c *Client) PostWorkspaceAgentNetwork(ctx context.Context, report agent.NetworkReport) error {
+	res, err := c.Request(ctx, http.MethodPost, "/api/v2/workspaceagents/me/network", PostWorkspaceAgentNetworkRequest{
+		PublicIP: report.PublicIP,
+		NATType:  report.NATType,
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return readBodyAsError(res)
+	}
+	return nil
+}
+
+type PostWorkspaceAgentDiagnosticsRequest struct {
+	UptimeSeconds int64                            `json:"uptime_seconds" validate:"gte=0"`
+	ClockSync     agent.ClockSync                  `json:"clock_sync" validate:"required"`
+	Interfaces    []WorkspaceAgentNetworkInterface `json:"interfaces"`
+}
+
+// PostWorkspaceAgentDiagnostics reports how long the agent process has been
+// running, whether its clock is synchronized and its network interfaces.
+func (c *Client) PostWorkspaceAgentDiagnostics(ctx context.Context, diagnostics agent.Diagnostics) error {
+	interfaces := make([]WorkspaceAgentNetworkInterface, 0, len(diagnostics.Interfaces))
+	for _, iface := range diagnostics.Interfaces {
+		interfaces = append(interfaces, WorkspaceAgentNetworkInterface(iface))
+	}
+	res, err := c.Request(ctx, http.MethodPost, "/api/v2/workspaceagents/me/diagnostics", PostWorkspaceAgentDiagnosticsRequest{
+		UptimeSeconds: int64(diagnostics.Uptime.Seconds()),
+		ClockSync:     diagnostics.ClockSync,
+		Interfaces:    interfaces,
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return readBodyAsError(res)
+	}
+	return nil
+}
+
 // WorkspaceAgent returns an agent by ID.
 func (c *Client) WorkspaceAgent(ctx context.Context, id uuid.UUID) (WorkspaceAgent, error) {
 	res, err := c.Request(ctx, http.MethodGet, fmt.Sprintf("/api/v2/workspaceagents/%s", id), nil)
@@ -429,14 +527,190 @@ func (c *Client) WorkspaceAgent(ctx context.Context, id uuid.UUID) (WorkspaceAge
 	return workspaceAgent, json.NewDecoder(res.Body).Decode(&workspaceAgent)
 }
 
+// UpdateWorkspaceAgentMaintenanceRequest flags an agent as expected to be
+// down. The flag is cleared when the agent reconnects.
+type UpdateWorkspaceAgentMaintenanceRequest struct {
+	Maintenance bool `json:"maintenance"`
+}
+
+// UpdateWorkspaceAgentMaintenance sets whether an agent is down for
+// maintenance.
+func (c *Client) UpdateWorkspaceAgentMaintenance(ctx context.Context, id uuid.UUID, req UpdateWorkspaceAgentMaintenanceRequest) error {
+	res, err := c.Request(ctx, http.MethodPut, fmt.Sprintf("/api/v2/workspaceagents/%s/maintenance", id), req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return readBodyAsError(res)
+	}
+	return nil
+}
+
+// WorkspaceAgentCandidate is one end of the ICE candidate pair selected
+// for a connection.
+type WorkspaceAgentCandidate struct {
+	// Type is one of "host", "srflx", "prflx", or "relay". A "relay"
+	// candidate means traffic is flowing through a TURN server.
+	Type     string `json:"type"`
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Port     uint16 `json:"port"`
+}
+
+// WorkspaceAgentCandidatePair describes whether the connection between
+// coderd and a workspace agent is peer-to-peer or relayed.
+type WorkspaceAgentCandidatePair struct {
+	Local  WorkspaceAgentCandidate `json:"local"`
+	Remote WorkspaceAgentCandidate `json:"remote"`
+}
+
+// WorkspaceAgentCandidatePair returns the ICE candidate pair coderd selected
+// when connecting to the agent. Connections dialed with DialWorkspaceAgent
+// can be inspected with SelectedCandidatePair on the returned connection.
+func (c *Client) WorkspaceAgentCandidatePair(ctx context.Context, id uuid.UUID) (WorkspaceAgentCandidatePair, error) {
+	res, err := c.Request(ctx, http.MethodGet, fmt.Sprintf("/api/v2/workspaceagents/%s/candidatepair", id), nil)
+	if err != nil {
+		return WorkspaceAgentCandidatePair{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return WorkspaceAgentCandidatePair{}, readBodyAsError(res)
+	}
+	var pair WorkspaceAgentCandidatePair
+	return pair, json.NewDecoder(res.Body).Decode(&pair)
+}
+
+// WorkspaceAgentCapabilities describe what a connected workspace agent
+// supports.
+type WorkspaceAgentCapabilities struct {
+	// Version is incremented whenever agents gain a capability.
+	Version int `json:"version"`
+	// Protocols are the connection protocols the agent serves, like
+	// "reconnecting-pty" for the web terminal. Disabled protocols are
+	// excluded.
+	Protocols []string `json:"protocols"`
+	// Operations are what clients can do with the agent, like "copy" for
+	// file transfers. Operations of disabled protocols are excluded.
+	Operations []string `json:"operations"`
+}
+
+// WorkspaceAgentCapabilities asks a connected agent what it supports, so
+// features can be checked for before they're used.
+func (c *Client) WorkspaceAgentCapabilities(ctx context.Context, id uuid.UUID) (WorkspaceAgentCapabilities, error) {
+	res, err := c.Request(ctx, http.MethodGet, fmt.Sprintf("/api/v2/workspaceagents/%s/capabilities", id), nil)
+	if err != nil {
+		return WorkspaceAgentCapabilities{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return WorkspaceAgentCapabilities{}, readBodyAsError(res)
+	}
+	var capabilities WorkspaceAgentCapabilities
+	return capabilities, json.NewDecoder(res.Body).Decode(&capabilities)
+}
+
+// WorkspaceAgentDiagnosticsBundle gathers what's known about an agent's
+// connectivity, so it can be attached to a bug report in one piece. Sections
+// that couldn't be collected are empty, and Errors explains why, keyed by
+// the section's JSON name.
+type WorkspaceAgentDiagnosticsBundle struct {
+	AgentID     uuid.UUID                       `json:"agent_id"`
+	CollectedAt time.Time                       `json:"collected_at"`
+	Connection  WorkspaceAgentConnectionHistory `json:"connection"`
+	Network     *WorkspaceAgentNetwork          `json:"network,omitempty"`
+	Diagnostics *WorkspaceAgentDiagnostics      `json:"diagnostics,omitempty"`
+	// CandidatePair requires permission to connect to the workspace.
+	CandidatePair *WorkspaceAgentCandidatePair `json:"candidate_pair,omitempty"`
+	// DERPLatency is probed from coderd, and requires site-wide read
+	// permission.
+	DERPLatency []DERPRegionLatency `json:"derp_latency,omitempty"`
+	Errors      map[string]string   `json:"errors,omitempty"`
+}
+
+// WorkspaceAgentConnectionHistory describes an agent's recent connections to
+// coderd.
+type WorkspaceAgentConnectionHistory struct {
+	Status           WorkspaceAgentStatus         `json:"status"`
+	FirstConnectedAt *time.Time                   `json:"first_connected_at,omitempty"`
+	LastConnectedAt  *time.Time                   `json:"last_connected_at,omitempty"`
+	DisconnectedAt   *time.Time                   `json:"disconnected_at,omitempty"`
+	StartupPhases    []WorkspaceAgentStartupPhase `json:"startup_phases"`
+}
+
+// WorkspaceAgentDiagnosticsBundle collects an agent's diagnostics.
+func (c *Client) WorkspaceAgentDiagnosticsBundle(ctx context.Context, id uuid.UUID) (WorkspaceAgentDiagnosticsBundle, error) {
+	res, err := c.Request(ctx, http.MethodGet, fmt.Sprintf("/api/v2/workspaceagents/%s/diagnostics", id), nil)
+	if err != nil {
+		return WorkspaceAgentDiagnosticsBundle{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return WorkspaceAgentDiagnosticsBundle{}, readBodyAsError(res)
+	}
+	var bundle WorkspaceAgentDiagnosticsBundle
+	return bundle, json.NewDecoder(res.Body).Decode(&bundle)
+}
+
+// AgentSessionIDHeader is set by coderd on workspace agent dial and
+// reconnecting PTY websocket responses. The agent logs the same ID for SSH
+// connections over the dial and for the PTY.
+const AgentSessionIDHeader = "Coder-Agent-Session-Id"
+
+// ReconnectingPTYTakenOverReason is the reason reconnecting PTY websockets
+// are closed with when another client takes over the session.
+const ReconnectingPTYTakenOverReason = "Session taken over elsewhere."
+
+// ReconnectingPTYConn is a connection to a reconnecting PTY in a workspace.
+type ReconnectingPTYConn struct {
+	net.Conn
+	// SessionID correlates the connection with coderd and agent logs, so
+	// it's useful in bug reports. It's empty for versions of coderd that
+	// don't return one.
+	SessionID string
+}
+
+// WorkspaceAgentReconnectingPTYOpts configures a connection to a
+// reconnecting PTY.
+type WorkspaceAgentReconnectingPTYOpts struct {
+	AgentID uuid.UUID `json:"agent_id"`
+	// Reconnect identifies the session, so it can be reconnected to.
+	Reconnect uuid.UUID `json:"reconnect"`
+	Height    uint16    `json:"height"`
+	Width     uint16    `json:"width"`
+	// ReplayLimit caps how many bytes of recent output are replayed on
+	// reconnect. Zero replays as much as the agent allows.
+	ReplayLimit int `json:"replay_limit,omitempty"`
+	// Takeover detaches the session's other clients, closing their
+	// websockets with ReconnectingPTYTakenOverReason, and replays all of
+	// the output.
+	Takeover bool `json:"takeover,omitempty"`
+	// SessionID is optional and must be a UUID. coderd generates one if
+	// it's empty.
+	SessionID string `json:"session_id,omitempty"`
+	// Command is optional and defaults to start a shell.
+	Command string `json:"command,omitempty"`
+}
+
 // WorkspaceAgentReconnectingPTY spawns a PTY that reconnects using the token provided.
 // It communicates using `agent.ReconnectingPTYRequest` marshaled as JSON.
 // Responses are PTY output that can be rendered.
-func (c *Client) WorkspaceAgentReconnectingPTY(ctx context.Context, agentID, reconnect uuid.UUID, height, width int, command string) (net.Conn, error) {
-	serverURL, err := c.URL.Parse(fmt.Sprintf("/api/v2/workspaceagents/%s/pty?reconnect=%s&height=%d&width=%d&command=%s", agentID, reconnect, height, width, command))
+func (c *Client) WorkspaceAgentReconnectingPTY(ctx context.Context, opts WorkspaceAgentReconnectingPTYOpts) (*ReconnectingPTYConn, error) {
+	serverURL, err := c.URL.Parse(fmt.Sprintf("/api/v2/workspaceagents/%s/pty", opts.AgentID))
 	if err != nil {
 		return nil, xerrors.Errorf("parse url: %w", err)
 	}
+	q := serverURL.Query()
+	q.Set("reconnect", opts.Reconnect.String())
+	q.Set("height", strconv.Itoa(int(opts.Height)))
+	q.Set("width", strconv.Itoa(int(opts.Width)))
+	q.Set("replay_limit", strconv.Itoa(opts.ReplayLimit))
+	q.Set("takeover", strconv.FormatBool(opts.Takeover))
+	if opts.SessionID != "" {
+		q.Set("session_id", opts.SessionID)
+	}
+	q.Set("command", opts.Command)
+	serverURL.RawQuery = q.Encode()
 	jar, err := cookiejar.New(nil)
 	if err != nil {
 		return nil, xerrors.Errorf("create cookie jar: %w", err)
@@ -446,7 +720,8 @@ func (c *Client) WorkspaceAgentReconnectingPTY(ctx context.Context, agentID, rec
 		Value: c.SessionToken,
 	}})
 	httpClient := &http.Client{
-		Jar: jar,
+		Jar:       jar,
+		Transport: c.HTTPClient.Transport,
 	}
 	conn, res, err := websocket.Dial(ctx, serverURL.String(), &websocket.DialOptions{
 		HTTPClient: httpClient,
@@ -457,7 +732,65 @@ func (c *Client) WorkspaceAgentReconnectingPTY(ctx context.Context, agentID, rec
 		}
 		return nil, readBodyAsError(res)
 	}
-	return websocket.NetConn(ctx, conn, websocket.MessageBinary), nil
+	return &ReconnectingPTYConn{
+		Conn:      websocket.NetConn(ctx, conn, websocket.MessageBinary),
+		SessionID: res.Header.Get(AgentSessionIDHeader),
+	}, nil
+}
+
+// WorkspaceAgentProcess is a process spawned by a reconnecting PTY session.
+type WorkspaceAgentProcess struct {
+	PID  int `json:"pid"`
+	PPID int `json:"ppid"`
+	// State is the single character state reported by the kernel, like
+	// "R" for running or "S" for sleeping.
+	State string `json:"state"`
+	// Command omits arguments when the deployment redacts them.
+	Command []string `json:"command"`
+}
+
+// WorkspaceAgentReconnectingPTYProcesses returns the process tree of the
+// reconnecting PTY session with the reconnect ID. The process the session
+// started is first, and every other process comes after its parent.
+// Process trees are only supported by agents on Linux.
+func (c *Client) WorkspaceAgentReconnectingPTYProcesses(ctx context.Context, agentID, reconnect uuid.UUID) ([]WorkspaceAgentProcess, error) {
+	res, err := c.Request(ctx, http.MethodGet, fmt.Sprintf("/api/v2/workspaceagents/%s/pty/%s/processes", agentID, reconnect), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, readBodyAsError(res)
+	}
+	var processes []WorkspaceAgentProcess
+	return processes, json.NewDecoder(res.Body).Decode(&processes)
+}
+
+// WorkspaceAgentListeningPort is a TCP port a process in the workspace
+// listens on.
+type WorkspaceAgentListeningPort struct {
+	Port uint16 `json:"port"`
+	// ProcessName and PID are empty when the agent can't read the owner of
+	// the socket.
+	ProcessName string `json:"process_name"`
+	PID         int    `json:"pid"`
+}
+
+// WorkspaceAgentListeningPorts returns the TCP ports listened on in the
+// workspace, sorted by port. Privileged ports below 1024 and the agent's own
+// ports are omitted unless includeAll is set. Listening ports are only
+// supported by agents on Linux.
+func (c *Client) WorkspaceAgentListeningPorts(ctx context.Context, agentID uuid.UUID, includeAll bool) ([]WorkspaceAgentListeningPort, error) {
+	res, err := c.Request(ctx, http.MethodGet, fmt.Sprintf("/api/v2/workspaceagents/%s/listening-ports?include_all=%t", agentID, includeAll), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, readBodyAsError(res)
+	}
+	var ports []WorkspaceAgentListeningPort
+	return ports, json.NewDecoder(res.Body).Decode(&ports)
 }
 
 func (c *Client) turnProxyDialer(ctx context.Context, httpClient *http.Client, path string) proxy.Dialer {
@@ -0,0 +1,94 @@
+package codersdk_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"nhooyr.io/websocket"
+
+	"github.com/coder/coder/codersdk"
+	"github.com/coder/coder/testutil"
+)
+
+func TestWorkspaceAgentProxy(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		_ = conn.Write(r.Context(), websocket.MessageBinary, []byte("hello"))
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+	}))
+	t.Cleanup(srv.Close)
+
+	// The proxy only tunnels, so a websocket to the TLS server must
+	// be established with CONNECT.
+	var tunneled atomic.Bool
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := hijacker.Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tunneled.Store(true)
+		go func() {
+			_, _ = io.Copy(upstream, conn)
+		}()
+		_, _ = io.Copy(conn, upstream)
+	}))
+	t.Cleanup(proxy.Close)
+
+	serverURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+	transport, ok := srv.Client().Transport.(*http.Transport)
+	require.True(t, ok)
+	transport = transport.Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+
+	client := codersdk.New(serverURL)
+	client.HTTPClient.Transport = transport
+
+	ctx, cancel := context.WithTimeout(context.Background(), testutil.WaitLong)
+	defer cancel()
+
+	conn, err := client.WorkspaceAgentReconnectingPTY(ctx, codersdk.WorkspaceAgentReconnectingPTYOpts{
+		AgentID:   uuid.New(),
+		Reconnect: uuid.New(),
+		Height:    80,
+		Width:     80,
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	data := make([]byte, 5)
+	_, err = io.ReadFull(conn, data)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+	require.True(t, tunneled.Load())
+}
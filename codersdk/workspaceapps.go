@@ -1,7 +1,33 @@
 package codersdk
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
 	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+// WorkspaceAppTokenKey is the name of the query parameter or cookie a
+// workspace app token is passed in. The token is moved to a cookie scoped
+// to the app on first use.
+const WorkspaceAppTokenKey = "coder_app_token"
+
+// WorkspaceAppRouting describes how requests are proxied to an app.
+type WorkspaceAppRouting string
+
+const (
+	// WorkspaceAppRoutingPath serves the app beneath a path on the access
+	// URL.
+	WorkspaceAppRoutingPath WorkspaceAppRouting = "path"
+	// WorkspaceAppRoutingSubdomain serves the app at the root of its own
+	// subdomain, for apps that can't run beneath a path.
+	WorkspaceAppRoutingSubdomain WorkspaceAppRouting = "subdomain"
+	// WorkspaceAppRoutingPort forwards an entire port in the workspace.
+	WorkspaceAppRoutingPort WorkspaceAppRouting = "port"
 )
 
 type WorkspaceApp struct {
@@ -12,4 +38,42 @@ type WorkspaceApp struct {
 	// Icon is a relative path or external URL that specifies
 	// an icon to be displayed in the dashboard.
 	Icon string `json:"icon,omitempty"`
+	// URL is the internal address the app is served at inside the
+	// workspace. Apps that only run a command don't have one.
+	URL string `json:"url,omitempty"`
+	// Port is the port of URL, defaulting by scheme if it's omitted.
+	Port uint16 `json:"port,omitempty"`
+	// Routing is empty when the app doesn't have a URL.
+	Routing WorkspaceAppRouting `json:"routing,omitempty"`
+}
+
+// CreateWorkspaceAppTokenRequest mints a token granting access to a single
+// app of a workspace, for sharing the app with people who can't otherwise
+// access the workspace.
+type CreateWorkspaceAppTokenRequest struct {
+	AppName string `json:"app_name" validate:"required"`
+	// LifetimeSeconds defaults to an hour when zero.
+	LifetimeSeconds int64 `json:"lifetime_seconds,omitempty"`
+}
+
+type WorkspaceAppToken struct {
+	// Token is passed to the app in the "coder_app_token" query parameter.
+	Token     string    `json:"token"`
+	AppName   string    `json:"app_name"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateWorkspaceAppToken mints a token granting access to one app of the
+// workspace until it expires.
+func (c *Client) CreateWorkspaceAppToken(ctx context.Context, workspaceID uuid.UUID, req CreateWorkspaceAppTokenRequest) (WorkspaceAppToken, error) {
+	res, err := c.Request(ctx, http.MethodPost, fmt.Sprintf("/api/v2/workspaces/%s/apptokens", workspaceID), req)
+	if err != nil {
+		return WorkspaceAppToken{}, xerrors.Errorf("create workspace app token: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		return WorkspaceAppToken{}, readBodyAsError(res)
+	}
+	var token WorkspaceAppToken
+	return token, json.NewDecoder(res.Body).Decode(&token)
 }
@@ -18,6 +18,9 @@ const (
 	WorkspaceAgentConnecting   WorkspaceAgentStatus = "connecting"
 	WorkspaceAgentConnected    WorkspaceAgentStatus = "connected"
 	WorkspaceAgentDisconnected WorkspaceAgentStatus = "disconnected"
+	// WorkspaceAgentMaintenance is a disconnected agent that was flagged
+	// as expected to be down, so alerting can ignore it.
+	WorkspaceAgentMaintenance WorkspaceAgentStatus = "maintenance"
 )
 
 type WorkspaceResource struct {
@@ -53,10 +56,65 @@ type WorkspaceAgent struct {
 	OperatingSystem      string               `json:"operating_system"`
 	StartupScript        string               `json:"startup_script,omitempty"`
 	Directory            string               `json:"directory,omitempty"`
-	Apps                 []WorkspaceApp       `json:"apps"`
-	WireguardPublicKey   key.NodePublic       `json:"wireguard_public_key"`
-	DiscoPublicKey       key.DiscoPublic      `json:"disco_public_key"`
-	IPv6                 netaddr.IPPrefix     `json:"ipv6"`
+	// ShellPrompt is the default PS1 of shells in the workspace. Users'
+	// shell configuration takes precedence.
+	ShellPrompt        string           `json:"shell_prompt,omitempty"`
+	Apps               []WorkspaceApp   `json:"apps"`
+	WireguardPublicKey key.NodePublic   `json:"wireguard_public_key"`
+	DiscoPublicKey     key.DiscoPublic  `json:"disco_public_key"`
+	IPv6               netaddr.IPPrefix `json:"ipv6"`
+
+	// StartupPhases are the steps the agent has gone through while
+	// starting, in the order they began.
+	StartupPhases []WorkspaceAgentStartupPhase `json:"startup_phases"`
+	// DirectoryExists is reported by the agent once it connects. It's
+	// nil until then.
+	DirectoryExists *bool `json:"directory_exists,omitempty"`
+	// Network is reported by the agent from STUN once it connects. It's
+	// nil until then.
+	Network *WorkspaceAgentNetwork `json:"network,omitempty"`
+	// Diagnostics is reported by the agent once it connects. It's nil
+	// until then.
+	Diagnostics *WorkspaceAgentDiagnostics `json:"diagnostics,omitempty"`
+}
+
+// WorkspaceAgentDiagnostics describes the agent process. Its start time
+// survives reconnects, unlike FirstConnectedAt and LastConnectedAt, which
+// describe the agent's connection to coderd.
+type WorkspaceAgentDiagnostics struct {
+	// ProcessStartedAt is on coderd's clock, derived from the uptime the
+	// agent reported.
+	ProcessStartedAt time.Time                        `json:"process_started_at"`
+	ClockSync        string                           `json:"clock_sync"`
+	Interfaces       []WorkspaceAgentNetworkInterface `json:"interfaces"`
+	ReportedAt       time.Time                        `json:"reported_at"`
+}
+
+// WorkspaceAgentNetworkInterface is a network interface of the workspace,
+// as seen by the agent.
+type WorkspaceAgentNetworkInterface struct {
+	Name string `json:"name"`
+	// Addresses are in CIDR notation, like "10.0.0.2/24".
+	Addresses []string `json:"addresses"`
+	MTU       int      `json:"mtu"`
+	// Flags are names like "up" or "loopback".
+	Flags []string `json:"flags"`
+}
+
+// WorkspaceAgentNetwork explains how reachable an agent is, which is why
+// connections to it may relay.
+type WorkspaceAgentNetwork struct {
+	PublicIP   string    `json:"public_ip"`
+	NATType    string    `json:"nat_type"`
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+type WorkspaceAgentStartupPhase struct {
+	Phase     string    `json:"phase"`
+	StartedAt time.Time `json:"started_at"`
+	// DurationMillis is the time until the next phase began. It's zero
+	// for the latest phase.
+	DurationMillis int64 `json:"duration_ms"`
 }
 
 type WorkspaceAgentResourceMetadata struct {
@@ -547,6 +547,27 @@ func (c *Conn) Ping() (time.Duration, error) {
 	return end.Sub(start), nil
 }
 
+// SelectedCandidatePair returns the local and remote ICE candidates the
+// connection is using. The candidate types show whether the connection is
+// peer-to-peer or relayed through TURN. An error is returned if a pair
+// hasn't been selected yet.
+func (c *Conn) SelectedCandidatePair() (*webrtc.ICECandidatePair, error) {
+	var pair *webrtc.ICECandidatePair
+	if sctp := c.rtc.SCTP(); sctp != nil {
+		if dtls := sctp.Transport(); dtls != nil {
+			var err error
+			pair, err = dtls.ICETransport().GetSelectedCandidatePair()
+			if err != nil {
+				return nil, xerrors.Errorf("get selected candidate pair: %w", err)
+			}
+		}
+	}
+	if pair == nil {
+		return nil, xerrors.New("no candidate pair has been selected")
+	}
+	return pair, nil
+}
+
 func (c *Conn) Closed() <-chan struct{} {
 	return c.closed
 }
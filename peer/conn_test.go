@@ -71,6 +71,21 @@ func TestConn(t *testing.T) {
 		require.NoError(t, err)
 	})
 
+	t.Run("SelectedCandidatePair", func(t *testing.T) {
+		t.Parallel()
+		client, server, _ := createPair(t)
+		_, err := client.SelectedCandidatePair()
+		require.Error(t, err)
+		exchange(t, client, server)
+		_, err = client.Ping()
+		require.NoError(t, err)
+		pair, err := client.SelectedCandidatePair()
+		require.NoError(t, err)
+		// The virtual network has no NAT, so the peers connect directly.
+		require.Equal(t, webrtc.ICECandidateTypeHost, pair.Local.Typ)
+		require.NotEqual(t, webrtc.ICECandidateTypeRelay, pair.Remote.Typ)
+	})
+
 	t.Run("PingNetworkOffline", func(t *testing.T) {
 		t.Parallel()
 		client, server, wan := createPair(t)
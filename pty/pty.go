@@ -41,6 +41,29 @@ type Process interface {
 
 	// Kill the command process.  Returned error is as for os.Process.Kill()
 	Kill() error
+
+	// Signal sends a signal to the command.  On Unix, the signal is sent to the command's process
+	// group, which includes the processes it spawned unless they moved to a group of their own, like
+	// the jobs of an interactive shell.  On Windows, only os.Kill is supported.  Signaling a command
+	// that has exited returns os.ErrProcessDone.
+	Signal(sig os.Signal) error
+
+	// Pid is the process ID of the command.
+	Pid() int
+
+	// ExitStatus waits for the command to complete and returns how it exited.  An error is only
+	// returned if the command couldn't be waited on.
+	ExitStatus() (ExitStatus, error)
+}
+
+// ExitStatus is how a process in a PTY exited.
+type ExitStatus struct {
+	// Code is the exit code of the process.  Like in shells, processes killed by a signal report 128
+	// plus the signal number.
+	Code int
+	// Signal is the signal that killed the process, like "killed".  It's empty if the process exited
+	// on its own, and always on Windows.
+	Signal string
 }
 
 // WithFlags represents a PTY whose flags can be inspected, in particular
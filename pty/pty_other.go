@@ -8,6 +8,7 @@ import (
 	"os/exec"
 	"runtime"
 	"sync"
+	"syscall"
 
 	"github.com/creack/pty"
 	"golang.org/x/xerrors"
@@ -100,6 +101,41 @@ func (p *otherProcess) Kill() error {
 	return p.cmd.Process.Kill()
 }
 
+func (p *otherProcess) Signal(sig os.Signal) error {
+	select {
+	case <-p.cmdDone:
+		return os.ErrProcessDone
+	default:
+	}
+	signal, ok := sig.(syscall.Signal)
+	if !ok {
+		return xerrors.Errorf("unsupported signal %v", sig)
+	}
+	// The command leads its own session, so its process group ID is its PID.
+	return syscall.Kill(-p.cmd.Process.Pid, signal)
+}
+
+func (p *otherProcess) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+func (p *otherProcess) ExitStatus() (ExitStatus, error) {
+	<-p.cmdDone
+	state := p.cmd.ProcessState
+	if state == nil {
+		return ExitStatus{}, p.cmdErr
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return ExitStatus{
+			Code:   128 + int(status.Signal()),
+			Signal: status.Signal().String(),
+		}, nil
+	}
+	return ExitStatus{
+		Code: state.ExitCode(),
+	}, nil
+}
+
 func (p *otherProcess) waitInternal() {
 	// The GC can garbage collect the TTY FD before the command
 	// has finished running. See:
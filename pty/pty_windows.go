@@ -71,6 +71,7 @@ type windowsProcess struct {
 	// cmdDone protects access to cmdErr: anything reading cmdErr should read from cmdDone first.
 	cmdDone chan any
 	cmdErr  error
+	state   *os.ProcessState
 	proc    *os.Process
 }
 
@@ -127,6 +128,7 @@ func (p *windowsProcess) waitInternal() {
 		p.cmdErr = err
 		return
 	}
+	p.state = state
 	if !state.Success() {
 		p.cmdErr = &exec.ExitError{ProcessState: state}
 		return
@@ -141,3 +143,21 @@ func (p *windowsProcess) Wait() error {
 func (p *windowsProcess) Kill() error {
 	return p.proc.Kill()
 }
+
+func (p *windowsProcess) Signal(sig os.Signal) error {
+	return p.proc.Signal(sig)
+}
+
+func (p *windowsProcess) Pid() int {
+	return p.proc.Pid
+}
+
+func (p *windowsProcess) ExitStatus() (ExitStatus, error) {
+	<-p.cmdDone
+	if p.state == nil {
+		return ExitStatus{}, p.cmdErr
+	}
+	return ExitStatus{
+		Code: p.state.ExitCode(),
+	}, nil
+}
@@ -42,6 +42,30 @@ func Start(t *testing.T, cmd *exec.Cmd) (*PTY, pty.Process) {
 	return create(t, ptty, cmd.Args[0]), ps
 }
 
+func StartWithOptions(t *testing.T, cmd *exec.Cmd, opts pty.StartOptions) (*PTY, pty.Process) {
+	t.Helper()
+
+	ptty, ps, err := pty.StartWithOptions(cmd, opts)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = ps.Kill()
+		_ = ps.Wait()
+	})
+	return create(t, ptty, cmd.Args[0]), ps
+}
+
+func StartContext(ctx context.Context, t *testing.T, cmd *exec.Cmd) (*PTY, pty.Process) {
+	t.Helper()
+
+	ptty, ps, err := pty.StartContext(ctx, cmd)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = ps.Kill()
+		_ = ps.Wait()
+	})
+	return create(t, ptty, cmd.Args[0]), ps
+}
+
 func create(t *testing.T, ptty pty.PTY, name string) *PTY {
 	// Use pipe for logging.
 	logDone := make(chan struct{})
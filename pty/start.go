@@ -1,11 +1,77 @@
 package pty
 
 import (
+	"context"
+	"os"
 	"os/exec"
+
+	"golang.org/x/xerrors"
 )
 
+// StartOptions configure the command started by StartWithOptions.
+type StartOptions struct {
+	// Dir is the working directory of the command. It defaults to cmd.Dir.
+	Dir string
+	// Env is added to the environment of the command. A command without an
+	// environment inherits the current process's first, as it would when
+	// started without Env.
+	Env []string
+}
+
 // Start the command in a TTY.  The calling code must not use cmd after passing it to the PTY, and
 // instead rely on the returned Process to manage the command/process.
 func Start(cmd *exec.Cmd) (PTY, Process, error) {
-	return startPty(cmd)
+	return startPty(context.Background(), cmd)
+}
+
+// StartContext is like Start, but ties the command to the context.  When the context is done, the
+// process group of the command is killed and the PTY is closed, so processes that hang, like a wedged
+// login shell, don't outlive their caller.
+func StartContext(ctx context.Context, cmd *exec.Cmd) (PTY, Process, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	ptty, process, err := startPty(ctx, cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	go func() {
+		exited := make(chan struct{})
+		go func() {
+			_ = process.Wait()
+			close(exited)
+		}()
+		select {
+		case <-ctx.Done():
+			_ = process.Signal(os.Kill)
+			_ = ptty.Close()
+		case <-exited:
+		}
+	}()
+	return ptty, process, nil
+}
+
+// StartWithOptions is like Start, but sets the working directory and environment of the command
+// first.  The working directory is checked before the command starts, so one that doesn't exist is
+// an error rather than a command that exits immediately.
+func StartWithOptions(cmd *exec.Cmd, opts StartOptions) (PTY, Process, error) {
+	if opts.Dir != "" {
+		cmd.Dir = opts.Dir
+	}
+	if cmd.Dir != "" {
+		info, err := os.Stat(cmd.Dir)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("stat working directory: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, nil, xerrors.Errorf("working directory %q is not a directory", cmd.Dir)
+		}
+	}
+	if len(opts.Env) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, opts.Env...)
+	}
+	return startPty(context.Background(), cmd)
 }
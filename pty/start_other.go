@@ -4,6 +4,7 @@
 package pty
 
 import (
+	"context"
 	"fmt"
 	"os/exec"
 	"runtime"
@@ -14,7 +15,7 @@ import (
 	"golang.org/x/xerrors"
 )
 
-func startPty(cmd *exec.Cmd) (PTY, Process, error) {
+func startPty(ctx context.Context, cmd *exec.Cmd) (PTY, Process, error) {
 	ptty, tty, err := pty.Open()
 	if err != nil {
 		return nil, nil, xerrors.Errorf("open: %w", err)
@@ -36,7 +37,10 @@ func startPty(cmd *exec.Cmd) (PTY, Process, error) {
 			// macOS has an obscure issue where the PTY occasionally closes
 			// before it's used. It's unknown why this is, but creating a new
 			// TTY resolves it.
-			return startPty(cmd)
+			if ctx.Err() != nil {
+				return nil, nil, xerrors.Errorf("start: %w", ctx.Err())
+			}
+			return startPty(ctx, cmd)
 		}
 		return nil, nil, xerrors.Errorf("start: %w", err)
 	}
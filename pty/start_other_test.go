@@ -3,7 +3,11 @@
 package pty_test
 
 import (
+	"context"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"syscall"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -12,6 +16,7 @@ import (
 
 	"go.uber.org/goleak"
 
+	"github.com/coder/coder/pty"
 	"github.com/coder/coder/pty/ptytest"
 )
 
@@ -38,6 +43,89 @@ func TestStart(t *testing.T) {
 		var exitErr *exec.ExitError
 		require.True(t, xerrors.As(err, &exitErr))
 		assert.NotEqual(t, 0, exitErr.ExitCode())
+		status, err := ps.ExitStatus()
+		require.NoError(t, err)
+		assert.Equal(t, pty.ExitStatus{Code: 137, Signal: "killed"}, status)
+	})
+
+	t.Run("ExitStatus", func(t *testing.T) {
+		t.Parallel()
+		_, ps := ptytest.Start(t, exec.Command("sh", "-c", "exit 3"))
+		status, err := ps.ExitStatus()
+		require.NoError(t, err)
+		assert.Equal(t, pty.ExitStatus{Code: 3}, status)
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		ptty, ps := ptytest.StartWithOptions(t, exec.Command("sh", "-c", "pwd; echo $FOO"), pty.StartOptions{
+			Dir: dir,
+			Env: []string{"FOO=bar"},
+		})
+		ptty.ExpectMatch(dir)
+		ptty.ExpectMatch("bar")
+		err := ps.Wait()
+		require.NoError(t, err)
+	})
+
+	t.Run("OptionsInheritEnvironment", func(t *testing.T) {
+		t.Parallel()
+		cmd := exec.Command("sh", "-c", "echo FOO=$FOO PATH=$PATH")
+		require.Nil(t, cmd.Env)
+		ptty, ps := ptytest.StartWithOptions(t, cmd, pty.StartOptions{
+			Env: []string{"FOO=bar"},
+		})
+		ptty.ExpectMatch("FOO=bar PATH=" + os.Getenv("PATH"))
+		err := ps.Wait()
+		require.NoError(t, err)
+	})
+
+	t.Run("MissingDirectory", func(t *testing.T) {
+		t.Parallel()
+		_, _, err := pty.StartWithOptions(exec.Command("pwd"), pty.StartOptions{
+			Dir: filepath.Join(t.TempDir(), "missing"),
+		})
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("Signal", func(t *testing.T) {
+		t.Parallel()
+		ptty, ps := ptytest.Start(t, exec.Command("sh", "-c", "echo started; sleep 30"))
+		ptty.ExpectMatch("started")
+		err := ps.Signal(syscall.SIGTERM)
+		require.NoError(t, err)
+		status, err := ps.ExitStatus()
+		require.NoError(t, err)
+		assert.Equal(t, pty.ExitStatus{Code: 143, Signal: "terminated"}, status)
+	})
+
+	t.Run("SignalExited", func(t *testing.T) {
+		t.Parallel()
+		_, ps := ptytest.Start(t, exec.Command("echo", "test"))
+		err := ps.Wait()
+		require.NoError(t, err)
+		err = ps.Signal(syscall.SIGTERM)
+		require.ErrorIs(t, err, os.ErrProcessDone)
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		ptty, ps := ptytest.StartContext(ctx, t, exec.Command("sh", "-c", "echo started; sleep 30"))
+		ptty.ExpectMatch("started")
+		cancel()
+		status, err := ps.ExitStatus()
+		require.NoError(t, err)
+		assert.Equal(t, pty.ExitStatus{Code: 137, Signal: "killed"}, status)
+	})
+
+	t.Run("ContextDone", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, _, err := pty.StartContext(ctx, exec.Command("echo", "test"))
+		require.ErrorIs(t, err, context.Canceled)
 	})
 
 	t.Run("SSH_PTY", func(t *testing.T) {
@@ -4,6 +4,7 @@
 package pty
 
 import (
+	"context"
 	"os"
 	"os/exec"
 	"strings"
@@ -16,7 +17,7 @@ import (
 
 // Allocates a PTY and starts the specified command attached to it.
 // See: https://docs.microsoft.com/en-us/windows/console/creating-a-pseudoconsole-session#creating-the-hosted-process
-func startPty(cmd *exec.Cmd) (PTY, Process, error) {
+func startPty(_ context.Context, cmd *exec.Cmd) (PTY, Process, error) {
 	fullPath, err := exec.LookPath(cmd.Path)
 	if err != nil {
 		return nil, nil, err
@@ -4,9 +4,11 @@
 package pty_test
 
 import (
+	"os"
 	"os/exec"
 	"testing"
 
+	"github.com/coder/coder/pty"
 	"github.com/coder/coder/pty/ptytest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -43,4 +45,21 @@ func TestStart(t *testing.T) {
 		require.True(t, xerrors.As(err, &exitErr))
 		assert.NotEqual(t, 0, exitErr.ExitCode())
 	})
+	t.Run("Signal", func(t *testing.T) {
+		t.Parallel()
+		_, ps := ptytest.Start(t, exec.Command("cmd.exe"))
+		err := ps.Signal(os.Kill)
+		assert.NoError(t, err)
+		err = ps.Wait()
+		var exitErr *exec.ExitError
+		require.True(t, xerrors.As(err, &exitErr))
+		assert.NotEqual(t, 0, exitErr.ExitCode())
+	})
+	t.Run("ExitStatus", func(t *testing.T) {
+		t.Parallel()
+		_, ps := ptytest.Start(t, exec.Command("cmd.exe", "/c", "exit", "3"))
+		status, err := ps.ExitStatus()
+		require.NoError(t, err)
+		assert.Equal(t, pty.ExitStatus{Code: 3}, status)
+	})
 }
@@ -0,0 +1,89 @@
+package pty
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// TeeOutput returns a PTY that duplicates all output of ptty to w, while
+// still serving it live from Output(). This is useful to capture a
+// session to a file for debugging. Input and resizing are unaffected.
+//
+// Output is written to w before it's readable from Output(), so anything
+// read live has already been captured. Errors writing to w stop the
+// capture, but never interrupt the live stream. Closing the returned PTY
+// closes ptty.
+func TeeOutput(ptty PTY, w io.Writer) (PTY, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, xerrors.Errorf("create pipe: %w", err)
+	}
+	tee := &teePty{
+		PTY:    ptty,
+		reader: reader,
+		writer: writer,
+		done:   make(chan struct{}),
+	}
+	go tee.copy(w)
+	return tee, nil
+}
+
+type teePty struct {
+	PTY
+
+	reader *os.File
+	writer *os.File
+	// done is closed once output has stopped being copied.
+	done chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (p *teePty) Output() ReadWriter {
+	return ReadWriter{
+		Reader: p.reader,
+		Writer: p.PTY.Output().Writer,
+	}
+}
+
+func (p *teePty) Close() error {
+	p.closeOnce.Do(func() {
+		p.closeErr = p.PTY.Close()
+		// Closing the reader unblocks the copy if nothing is
+		// reading the live stream anymore.
+		_ = p.reader.Close()
+		<-p.done
+	})
+	return p.closeErr
+}
+
+// copy is the only goroutine that writes to w, so w doesn't need to be
+// safe for concurrent use.
+func (p *teePty) copy(w io.Writer) {
+	defer close(p.done)
+	defer p.writer.Close()
+
+	buffer := make([]byte, 32<<10)
+	for {
+		read, err := p.PTY.Output().Read(buffer)
+		if read > 0 {
+			if w != nil {
+				_, writeErr := w.Write(buffer[:read])
+				if writeErr != nil {
+					w = nil
+				}
+			}
+			_, writeErr := p.writer.Write(buffer[:read])
+			if writeErr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
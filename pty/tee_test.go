@@ -0,0 +1,35 @@
+//go:build !windows
+
+package pty_test
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/coder/pty"
+)
+
+func TestTeeOutput(t *testing.T) {
+	t.Parallel()
+	ptty, ps, err := pty.Start(exec.Command("echo", "test"))
+	require.NoError(t, err)
+	var captured bytes.Buffer
+	tee, err := pty.TeeOutput(ptty, &captured)
+	require.NoError(t, err)
+
+	// The terminal translates the newline.
+	live := make([]byte, len("test\r\n"))
+	_, err = io.ReadFull(tee.Output(), live)
+	require.NoError(t, err)
+	require.Equal(t, "test\r\n", string(live))
+	err = ps.Wait()
+	require.NoError(t, err)
+
+	err = tee.Close()
+	require.NoError(t, err)
+	require.Equal(t, live, captured.Bytes())
+}
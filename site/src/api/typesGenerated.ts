@@ -97,6 +97,8 @@ export interface CreateTemplateRequest {
   readonly parameter_values?: CreateParameterRequest[]
   readonly max_ttl_ms?: number
   readonly min_autostart_interval_ms?: number
+  readonly agent_inactive_disconnect_timeout_ms?: number
+  readonly terminal_idle_timeout_ms?: number
 }
 
 // From codersdk/templateversions.go
@@ -122,6 +124,12 @@ export interface CreateUserRequest {
   readonly organization_id: string
 }
 
+// From codersdk/workspaceapps.go
+export interface CreateWorkspaceAppTokenRequest {
+  readonly app_name: string
+  readonly lifetime_seconds?: number
+}
+
 // From codersdk/workspaces.go
 export interface CreateWorkspaceBuildRequest {
   readonly template_version_id?: string
@@ -140,6 +148,22 @@ export interface CreateWorkspaceRequest {
   readonly parameter_values?: CreateParameterRequest[]
 }
 
+// From codersdk/derp.go
+export interface DERPRegionLatency {
+  readonly region_id: number
+  readonly region_code: string
+  readonly region_name: string
+  readonly reachable: boolean
+  readonly latency_ms: number
+  readonly error?: string
+}
+
+// From codersdk/deprecation.go
+export interface Deprecation {
+  readonly message: string
+  readonly sunset?: string
+}
+
 // From codersdk/features.go
 export interface Entitlements {
   readonly features: Record<string, Feature>
@@ -249,6 +273,35 @@ export interface ParameterSchema {
   readonly validation_contains?: string[]
 }
 
+// From codersdk/workspaceagents.go
+export interface PostWorkspaceAgentDiagnosticsRequest {
+  readonly uptime_seconds: number
+  // Named type "github.com/coder/coder/agent.ClockSync" unknown, using "any"
+  // eslint-disable-next-line @typescript-eslint/no-explicit-any
+  readonly clock_sync: any
+  readonly interfaces: WorkspaceAgentNetworkInterface[]
+}
+
+// From codersdk/workspaceagents.go
+export interface PostWorkspaceAgentDirectoryRequest {
+  readonly exists: boolean
+}
+
+// From codersdk/workspaceagents.go
+export interface PostWorkspaceAgentNetworkRequest {
+  readonly public_ip: string
+  // Named type "github.com/coder/coder/agent.NATType" unknown, using "any"
+  // eslint-disable-next-line @typescript-eslint/no-explicit-any
+  readonly nat_type: any
+}
+
+// From codersdk/workspaceagents.go
+export interface PostWorkspaceAgentStartupPhaseRequest {
+  // Named type "github.com/coder/coder/agent.StartupPhase" unknown, using "any"
+  // eslint-disable-next-line @typescript-eslint/no-explicit-any
+  readonly phase: any
+}
+
 // From codersdk/provisionerdaemons.go
 export interface ProvisionerDaemon {
   readonly id: string
@@ -290,6 +343,7 @@ export interface Response {
   readonly message: string
   readonly detail?: string
   readonly validations?: ValidationError[]
+  readonly deprecation?: Deprecation
 }
 
 // From codersdk/roles.go
@@ -298,6 +352,19 @@ export interface Role {
   readonly display_name: string
 }
 
+// From codersdk/roles.go
+export interface RolePermission {
+  readonly negate: boolean
+  readonly resource_type: string
+  readonly action: string
+  readonly scope: RoleScope
+}
+
+// From codersdk/roles.go
+export interface RoleWithPermissions extends Role {
+  readonly permissions: RolePermission[]
+}
+
 // From codersdk/templates.go
 export interface Template {
   readonly id: string
@@ -314,6 +381,8 @@ export interface Template {
   readonly min_autostart_interval_ms: number
   readonly created_by_id: string
   readonly created_by_name: string
+  readonly agent_inactive_disconnect_timeout_ms: number
+  readonly terminal_idle_timeout_ms: number
 }
 
 // From codersdk/templateversions.go
@@ -352,6 +421,8 @@ export interface UpdateTemplateMeta {
   readonly icon?: string
   readonly max_ttl_ms?: number
   readonly min_autostart_interval_ms?: number
+  readonly agent_inactive_disconnect_timeout_ms?: number
+  readonly terminal_idle_timeout_ms?: number
 }
 
 // From codersdk/users.go
@@ -365,6 +436,11 @@ export interface UpdateUserProfileRequest {
   readonly username: string
 }
 
+// From codersdk/workspaceagents.go
+export interface UpdateWorkspaceAgentMaintenanceRequest {
+  readonly maintenance: boolean
+}
+
 // From codersdk/workspaces.go
 export interface UpdateWorkspaceAutostartRequest {
   readonly schedule?: string
@@ -452,6 +528,36 @@ export interface Workspace {
   readonly ttl_ms?: number
 }
 
+// From codersdk/workspaceagents.go
+export interface WorkspaceAgentCandidate {
+  readonly type: string
+  readonly protocol: string
+  readonly address: string
+  readonly port: number
+}
+
+// From codersdk/workspaceagents.go
+export interface WorkspaceAgentCandidatePair {
+  readonly local: WorkspaceAgentCandidate
+  readonly remote: WorkspaceAgentCandidate
+}
+
+// From codersdk/workspaceagents.go
+export interface WorkspaceAgentCapabilities {
+  readonly version: number
+  readonly protocols: string[]
+  readonly operations: string[]
+}
+
+// From codersdk/workspaceagents.go
+export interface WorkspaceAgentConnectionHistory {
+  readonly status: WorkspaceAgentStatus
+  readonly first_connected_at?: string
+  readonly last_connected_at?: string
+  readonly disconnected_at?: string
+  readonly startup_phases: WorkspaceAgentStartupPhase[]
+}
+
 // From codersdk/workspaceresources.go
 export interface WorkspaceAgent {
   readonly id: string
@@ -469,6 +575,7 @@ export interface WorkspaceAgent {
   readonly operating_system: string
   readonly startup_script?: string
   readonly directory?: string
+  readonly shell_prompt?: string
   readonly apps: WorkspaceApp[]
   // Named type "tailscale.com/types/key.NodePublic" unknown, using "any"
   // eslint-disable-next-line @typescript-eslint/no-explicit-any
@@ -479,6 +586,10 @@ export interface WorkspaceAgent {
   // Named type "inet.af/netaddr.IPPrefix" unknown, using "any"
   // eslint-disable-next-line @typescript-eslint/no-explicit-any
   readonly ipv6: any
+  readonly startup_phases: WorkspaceAgentStartupPhase[]
+  readonly directory_exists?: boolean
+  readonly network?: WorkspaceAgentNetwork
+  readonly diagnostics?: WorkspaceAgentDiagnostics
 }
 
 // From codersdk/workspaceagents.go
@@ -486,6 +597,26 @@ export interface WorkspaceAgentAuthenticateResponse {
   readonly session_token: string
 }
 
+// From codersdk/workspaceresources.go
+export interface WorkspaceAgentDiagnostics {
+  readonly process_started_at: string
+  readonly clock_sync: string
+  readonly interfaces: WorkspaceAgentNetworkInterface[]
+  readonly reported_at: string
+}
+
+// From codersdk/workspaceagents.go
+export interface WorkspaceAgentDiagnosticsBundle {
+  readonly agent_id: string
+  readonly collected_at: string
+  readonly connection: WorkspaceAgentConnectionHistory
+  readonly network?: WorkspaceAgentNetwork
+  readonly diagnostics?: WorkspaceAgentDiagnostics
+  readonly candidate_pair?: WorkspaceAgentCandidatePair
+  readonly derp_latency?: DERPRegionLatency[]
+  readonly errors?: Record<string, string>
+}
+
 // From codersdk/workspaceresources.go
 export interface WorkspaceAgentInstanceMetadata {
   readonly jail_orchestrator: string
@@ -499,6 +630,48 @@ export interface WorkspaceAgentInstanceMetadata {
   readonly vnc: boolean
 }
 
+// From codersdk/workspaceagents.go
+export interface WorkspaceAgentListeningPort {
+  readonly port: number
+  readonly process_name: string
+  readonly pid: number
+}
+
+// From codersdk/workspaceresources.go
+export interface WorkspaceAgentNetwork {
+  readonly public_ip: string
+  readonly nat_type: string
+  readonly reported_at: string
+}
+
+// From codersdk/workspaceresources.go
+export interface WorkspaceAgentNetworkInterface {
+  readonly name: string
+  readonly addresses: string[]
+  readonly mtu: number
+  readonly flags: string[]
+}
+
+// From codersdk/workspaceagents.go
+export interface WorkspaceAgentProcess {
+  readonly pid: number
+  readonly ppid: number
+  readonly state: string
+  readonly command: string[]
+}
+
+// From codersdk/workspaceagents.go
+export interface WorkspaceAgentReconnectingPTYOpts {
+  readonly agent_id: string
+  readonly reconnect: string
+  readonly height: number
+  readonly width: number
+  readonly replay_limit?: number
+  readonly takeover?: boolean
+  readonly session_id?: string
+  readonly command?: string
+}
+
 // From codersdk/workspaceresources.go
 export interface WorkspaceAgentResourceMetadata {
   readonly memory_total: number
@@ -508,12 +681,29 @@ export interface WorkspaceAgentResourceMetadata {
   readonly cpu_mhz: number
 }
 
+// From codersdk/workspaceresources.go
+export interface WorkspaceAgentStartupPhase {
+  readonly phase: string
+  readonly started_at: string
+  readonly duration_ms: number
+}
+
 // From codersdk/workspaceapps.go
 export interface WorkspaceApp {
   readonly id: string
   readonly name: string
   readonly command?: string
   readonly icon?: string
+  readonly url?: string
+  readonly port?: number
+  readonly routing?: WorkspaceAppRouting
+}
+
+// From codersdk/workspaceapps.go
+export interface WorkspaceAppToken {
+  readonly token: string
+  readonly app_name: string
+  readonly expires_at: string
 }
 
 // From codersdk/workspacebuilds.go
@@ -612,11 +802,17 @@ export type ProvisionerStorageMethod = "file"
 // From codersdk/organizations.go
 export type ProvisionerType = "echo" | "terraform"
 
+// From codersdk/roles.go
+export type RoleScope = "organization" | "site" | "user"
+
 // From codersdk/users.go
 export type UserStatus = "active" | "suspended"
 
 // From codersdk/workspaceresources.go
-export type WorkspaceAgentStatus = "connected" | "connecting" | "disconnected"
+export type WorkspaceAgentStatus = "connected" | "connecting" | "disconnected" | "maintenance"
+
+// From codersdk/workspaceapps.go
+export type WorkspaceAppRouting = "path" | "port" | "subdomain"
 
 // From codersdk/workspacebuilds.go
 export type WorkspaceTransition = "delete" | "start" | "stop"
@@ -31,7 +31,12 @@ const fillAndSubmitForm = async ({
   description,
   max_ttl_ms,
   icon,
-}: Omit<Required<UpdateTemplateMeta>, "min_autostart_interval_ms">) => {
+}: Omit<
+  Required<UpdateTemplateMeta>,
+  | "min_autostart_interval_ms"
+  | "agent_inactive_disconnect_timeout_ms"
+  | "terminal_idle_timeout_ms"
+>) => {
   const nameField = await screen.findByLabelText(FormLanguage.nameLabel)
   await userEvent.clear(nameField)
   await userEvent.type(nameField, name)
@@ -131,6 +131,19 @@ describe("TerminalPage", () => {
     server.close()
   })
 
+  it("shows the session ID", async () => {
+    // Given
+    const server = new WS("ws://localhost/api/v2/workspaceagents/" + MockWorkspaceAgent.id + "/pty")
+
+    // When
+    const { getByText } = renderTerminal()
+
+    // Then
+    await server.connected
+    expect(getByText(new RegExp(Language.sessionIdPrefix))).toBeDefined()
+    server.close()
+  })
+
   it("supports workspace.agent syntax", async () => {
     // Given
     const server = new WS("ws://localhost/api/v2/workspaceagents/" + MockWorkspaceAgent.id + "/pty")
@@ -17,6 +17,7 @@ export const Language = {
   workspaceErrorMessagePrefix: "Unable to fetch workspace: ",
   workspaceAgentErrorMessagePrefix: "Unable to fetch workspace agent: ",
   websocketErrorMessagePrefix: "WebSocket failed: ",
+  sessionIdPrefix: "Session ID: ",
 }
 
 const TerminalPage: FC<
@@ -38,6 +39,9 @@ const TerminalPage: FC<
     const search = new URLSearchParams(location.search)
     return search.get("reconnect") ?? uuidv4()
   })
+  // The session ID identifies this page's connections in coderd and agent
+  // logs. It's shown so users can reference it in bug reports.
+  const [sessionId] = useState<string>(() => uuidv4())
   // The workspace name is in the format:
   // <workspace name>[.<agent name>]
   const workspaceNameParts = workspace?.split(".")
@@ -45,6 +49,7 @@ const TerminalPage: FC<
     context: {
       agentName: workspaceNameParts?.[1],
       reconnection: reconnectionToken,
+      sessionId: sessionId,
       workspaceName: workspaceNameParts?.[0],
       username: username,
     },
@@ -207,6 +212,10 @@ const TerminalPage: FC<
         <span className={styles.overlayText}>Disconnected</span>
       </div>
       <div className={styles.terminal} ref={xtermRef} data-testid="terminal" />
+      <div className={styles.sessionId}>
+        {Language.sessionIdPrefix}
+        {sessionId}
+      </div>
     </>
   )
 }
@@ -238,6 +247,15 @@ const useStyles = makeStyles(() => ({
     fontSize: 24,
     backgroundColor: "#000",
   },
+  sessionId: {
+    position: "absolute",
+    bottom: 4,
+    right: 16,
+    zIndex: 2,
+    color: "rgba(255, 255, 255, 0.4)",
+    fontFamily: MONOSPACE_FONT_FAMILY,
+    fontSize: 12,
+  },
   terminal: {
     width: "100vw",
     height: "100vh",
@@ -170,6 +170,8 @@ export const MockTemplate: TypesGen.Template = {
   created_by_id: "test-creator-id",
   created_by_name: "test_creator",
   icon: "/icon/code.svg",
+  agent_inactive_disconnect_timeout_ms: 0,
+  terminal_idle_timeout_ms: 0,
 }
 
 export const MockWorkspaceAutostartDisabled: TypesGen.UpdateWorkspaceAutostartRequest = {
@@ -305,6 +307,7 @@ export const MockWorkspaceAgent: TypesGen.WorkspaceAgent = {
   wireguard_public_key: "",
   disco_public_key: "",
   ipv6: "",
+  startup_phases: [],
 }
 
 export const MockWorkspaceAgentDisconnected: TypesGen.WorkspaceAgent = {
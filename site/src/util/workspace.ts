@@ -178,6 +178,7 @@ export const DisplayAgentStatusLanguage = {
   connected: "⦿ Connected",
   connecting: "⦿ Connecting",
   disconnected: "◍ Disconnected",
+  maintenance: "◍ Maintenance",
 }
 
 export const getDisplayAgentStatus = (
@@ -208,6 +209,11 @@ export const getDisplayAgentStatus = (
         color: theme.palette.text.secondary,
         status: DisplayAgentStatusLanguage["disconnected"],
       }
+    case "maintenance":
+      return {
+        color: theme.palette.text.secondary,
+        status: DisplayAgentStatusLanguage["maintenance"],
+      }
   }
 }
 
@@ -18,6 +18,9 @@ export interface TerminalContext {
   username?: string
   workspaceName?: string
   reconnection?: string
+  // The session ID is logged by coderd and the agent for this terminal,
+  // so users can reference it in bug reports.
+  sessionId?: string
 }
 
 export type TerminalEvent =
@@ -170,9 +173,11 @@ export const terminalMachine =
               return reject("workspace agent is not set")
             }
             const proto = location.protocol === "https:" ? "wss:" : "ws:"
-            const socket = new WebSocket(
-              `${proto}//${location.host}/api/v2/workspaceagents/${context.workspaceAgent.id}/pty?reconnect=${context.reconnection}`,
-            )
+            let url = `${proto}//${location.host}/api/v2/workspaceagents/${context.workspaceAgent.id}/pty?reconnect=${context.reconnection}`
+            if (context.sessionId) {
+              url += `&session_id=${context.sessionId}`
+            }
+            const socket = new WebSocket(url)
             socket.binaryType = "arraybuffer"
             socket.addEventListener("open", () => {
               resolve(socket)